@@ -1,73 +1,141 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/gob"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/config"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/driver"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/handlers"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/helpers"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/mailer"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/render"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/sessionstore"
 	"github.com/alexedwards/scs/v2"
 )
 
-const portNumber = ":8080"
-
 var app config.AppConfig
 var infoLog *log.Logger
 var errorLog *log.Logger
+var mailSender mailer.Mailer
+var sessionStore *sessionstore.PostgresStore
+
+// Exit codes of the application
+const (
+	exitOK    = 0
+	exitError = 1
+)
 
 // main is the main application function
 func main() {
 	db, err := run()
 	if err != nil {
-		log.Fatalf("Error setting up application: %q", err)
+		log.Fatalf("Error setting up application: %v", err)
+	}
+	os.Exit(serve(db))
+}
+
+// serve runs the web server and the mail workers until SIGINT or SIGTERM. Then it stops accepting
+// connections, waits for in-flight requests, delivers the due mail and closes the DB pool, all within
+// the shutdown timeout. It returns exitError if the server fails or the shutdown does not complete in time
+func serve(db *driver.DB) int {
+	exitCode := exitOK
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Println("Starting mail workers...")
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	background := startMailWorkers(backgroundCtx, handlers.Repo.DB, mailSender, app.MailWorkers)
+	if sessionStore != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			sessionStore.Cleanup(backgroundCtx, app.SessionCleanup)
+		}()
 	}
-	defer db.SQL.Close()
-	defer close(app.MailChan)
-	log.Println("Starting mail listener...")
-	listenForMail()
 
 	//	Start server
-	fmt.Printf("Starting Web Server on port %s\n", portNumber)
+	fmt.Printf("Starting Web Server on %s\n", app.Addr)
 	srv := &http.Server{
-		Addr:    portNumber,
-		Handler: routes(&app),
+		Addr:    app.Addr,
+		Handler: handler(&app),
 	}
-	err = srv.ListenAndServe()
-	if err != nil {
-		log.Fatalf("error starting server: %q", err)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		errorLog.Printf("Error running server: %q", err)
+		exitCode = exitError
+	case <-ctx.Done():
+		infoLog.Println("Shutting down...")
 	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		errorLog.Printf("Error waiting for in-flight requests: %q", err)
+		srv.Close()
+		exitCode = exitError
+	}
+
+	stopBackground()
+	background.Wait()
+	if err := drainMail(shutdownCtx, handlers.Repo.DB, mailSender); err != nil {
+		errorLog.Printf("Error delivering mail on shutdown: %q", err)
+		exitCode = exitError
+	}
+
+	if db != nil {
+		if err := db.SQL.Close(); err != nil {
+			errorLog.Printf("Error closing the database: %q", err)
+			exitCode = exitError
+		}
+	}
+	infoLog.Println("Stopped")
+	return exitCode
 }
 
 func run() (*driver.DB, error) {
-	// Read flags
-	inProduction := flag.Bool("production", true, "Application is in production")
-	useCache := flag.Bool("cache", true, "Use template cache")
-	dbName := flag.String("dbname", "", "Database name")
-	dbUser := flag.String("dbuser", "", "Database user")
-	dbPassword := flag.String("dbpwd", "", "Database password")
-	dbHost := flag.String("dbhost", "localhost", "Database host")
-	dbPort := flag.String("dbport", "5432", "Database port")
-	dbSSL := flag.String("dbssl", "disable", "Database SSL settings (disable, prefer, require)")
-	flag.Parse()
-
-	// Configure application
-	// change it to true when in production
-	app.InProduction = *inProduction
+	// Read configuration
+	printConfig := flag.Bool("printconfig", false, "Print the effective configuration with secrets redacted and exit")
+	if err := app.Load(flag.CommandLine, os.Args[1:], os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if *printConfig {
+		if err := app.WriteYAML(os.Stdout); err != nil {
+			return nil, err
+		}
+		os.Exit(exitOK)
+	}
 
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
 	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 	app.ErrorLog = errorLog
 
+	if app.SigningKey == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		app.SigningKey = hex.EncodeToString(key)
+		infoLog.Println("signing_key is not set, password reset links will stop working on restart")
+	}
+
 	// Registering what we actually store in session
 	gob.Register(models.Reservation{})
 	gob.Register(models.User{})
@@ -75,40 +143,80 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
 
-	// Create mail channel
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
 	// Creating a session instance
 	session := scs.New()
-	session.Lifetime = 24 * time.Hour
+	session.Lifetime = app.SessionLifetime
 	session.Cookie.Persist = true
 	session.Cookie.SameSite = http.SameSiteLaxMode
 	session.Cookie.Secure = app.InProduction
 	app.Session = session
 
-	// connect to database
-	log.Println("Connecting to the database")
-	connStr := os.Getenv("POSTGRESS_BOOKINGS_URL")
-	if connStr == "" {
-		connStr = fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s",
-			*dbHost, *dbPort, *dbName, *dbUser, *dbPassword, *dbSSL)
-	}
-	db, err := driver.ConnectSQL(connStr)
-	if err != nil {
-		log.Fatal(fmt.Errorf("cannot connect to the database: %w. Dying", err))
+	var db *driver.DB
+	var repo *handlers.Repository
+	switch app.DBType {
+	case "memory":
+		log.Println("Using in-memory database; all data will be lost on exit")
+		repo = handlers.NewMemoryRepo(&app)
+	case "postgres":
+		// connect to database
+		log.Println("Connecting to the database")
+		connStr := app.DBURL
+		if connStr == "" {
+			connStr = fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=%s",
+				app.DBHost, app.DBPort, app.DBName, app.DBUser, app.DBPassword, app.DBSSLMode)
+		}
+		var err error
+		db, err = driver.ConnectSQL(connStr, driver.PoolConfig{
+			MaxOpenConns:    app.DBMaxOpenConns,
+			MaxIdleConns:    app.DBMaxIdleConns,
+			ConnMaxLifetime: app.DBConnMaxLifetime,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to the database: %w", err)
+		}
+		log.Println("Connected to the DB!")
+		repo = handlers.NewRepo(&app, db)
+		if app.SessionStore == "postgres" {
+			log.Println("Keeping sessions in the database")
+			sessionStore = sessionstore.NewPostgres(db.SQL)
+			session.Store = sessionStore
+		}
+	default:
+		return nil, fmt.Errorf("unknown database type %q", app.DBType)
 	}
-	log.Println("Connected to the DB!")
 
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
 		return nil, fmt.Errorf("error creating template cache: %w", err)
 	}
 	app.TemplateCache = tc
-	app.UseCache = *useCache
 	render.NewRenderer(&app)
-	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
 	helpers.NewHelpers(&app)
 
+	mailCfg := mailer.Config{From: app.MailFrom, TemplateDir: "./email-templates"}
+	switch app.Mailer {
+	case "smtp":
+		mailSender, err = mailer.NewSMTP(mailer.SMTPConfig{
+			Config:     mailCfg,
+			Host:       app.SMTPHost,
+			Port:       app.SMTPPort,
+			Username:   app.SMTPUsername,
+			Password:   app.SMTPPassword,
+			Encryption: app.SMTPEncryption,
+		})
+		if err != nil {
+			return nil, err
+		}
+	case "file":
+		log.Printf("Mail is written to %s instead of being sent", app.MailDir)
+		mailSender, err = mailer.NewFileDrop(mailCfg, app.MailDir)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", app.Mailer)
+	}
+
 	return db, nil
 }
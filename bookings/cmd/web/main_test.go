@@ -3,6 +3,7 @@ package main
 import "testing"
 
 func TestRun(t *testing.T) {
+	t.Setenv("BOOKINGS_DB_TYPE", "memory")
 	_, err := run()
 	if err != nil {
 		t.Errorf("Failed run: %q", err)
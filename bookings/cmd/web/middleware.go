@@ -1,12 +1,23 @@
 package main
 
 import (
+	"crypto/subtle"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/handlers"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/helpers"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository"
+	"github.com/alexedwards/scs/v2"
 	"github.com/justinas/nosurf"
 )
 
+// errSessionNotSaved is returned by the writes of a response whose session could not be saved
+var errSessionNotSaved = errors.New("the session could not be saved")
+
 // NoSurf adds CSRF protection to all POST requests
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
@@ -24,6 +35,81 @@ func SessionLoad(next http.Handler) http.Handler {
 	return app.Session.LoadAndSave(next)
 }
 
+// SessionStream loads the session like SessionLoad, but saves it as soon as the response starts instead of
+// after the handler returns. LoadAndSave keeps the whole response in memory until then, so that it can still
+// set the cookie, which downloads streamed to the client cannot afford. Changes made to the session after
+// the response has started are lost
+func SessionStream(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if cookie, err := r.Cookie(app.Session.Cookie.Name); err == nil {
+			token = cookie.Value
+		}
+		ctx, err := app.Session.Load(r.Context(), token)
+		if err != nil {
+			app.Session.ErrorFunc(w, r, err)
+			return
+		}
+		sw := &sessionWriter{ResponseWriter: w, r: r.WithContext(ctx)}
+		next.ServeHTTP(sw, sw.r)
+		sw.save()
+	})
+}
+
+// sessionWriter saves the session of its request right before the response starts
+type sessionWriter struct {
+	http.ResponseWriter
+	r      *http.Request
+	saved  bool
+	failed bool
+}
+
+// save commits the session and sets its cookie, the first time it is called. If the session cannot be
+// committed, the error page is written and the rest of the response is dropped
+func (sw *sessionWriter) save() {
+	if sw.saved {
+		return
+	}
+	sw.saved = true
+	ctx := sw.r.Context()
+	switch app.Session.Status(ctx) {
+	case scs.Modified:
+		token, expiry, err := app.Session.Commit(ctx)
+		if err != nil {
+			sw.failed = true
+			app.Session.ErrorFunc(sw.ResponseWriter, sw.r, err)
+			return
+		}
+		app.Session.WriteSessionCookie(ctx, sw.ResponseWriter, token, expiry)
+	case scs.Destroyed:
+		app.Session.WriteSessionCookie(ctx, sw.ResponseWriter, "", time.Time{})
+	}
+	sw.Header().Add("Vary", "Cookie")
+}
+
+func (sw *sessionWriter) WriteHeader(code int) {
+	if sw.save(); !sw.failed {
+		sw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (sw *sessionWriter) Write(b []byte) (int, error) {
+	if sw.save(); sw.failed {
+		return 0, errSessionNotSaved
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been written so far to the client, if the underlying writer supports it
+func (sw *sessionWriter) Flush() {
+	if sw.save(); sw.failed {
+		return
+	}
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !helpers.IsAuthenticated(r) {
@@ -31,6 +117,75 @@ func Auth(next http.Handler) http.Handler {
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 			return
 		}
+		// the user is read again on every request, so deactivation, deletion and role changes
+		// take effect immediately instead of when the session expires
+		user, err := handlers.Repo.DB.GetUserById(r.Context(), app.Session.GetInt(r.Context(), "user_id"))
+		if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+			helpers.ServerError(w, err)
+			return
+		}
+		if err != nil || !user.Active || user.MustResetPassword {
+			_ = app.Session.Destroy(r.Context())
+			app.Session.Put(r.Context(), "error", "Your account is not available anymore. Log in again")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.Session.Put(r.Context(), "access_level", user.AccessLevel)
+		// users whose role requires two-factor authentication can do nothing else until they set it up
+		if handlers.Repo.TwoFactorRequired(user.Role()) && !user.TwoFactorEnabled() &&
+			!strings.HasPrefix(r.URL.Path, "/admin/two-factor") {
+			app.Session.Put(r.Context(), "warning", "Your role requires two-factor authentication. Set it up to continue")
+			http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+			return
+		}
+		// changes made by the user are written to the audit log together with the request they come with
+		r = r.WithContext(repository.WithActor(r.Context(), models.Actor{
+			UserID:    user.ID,
+			IP:        helpers.ClientIP(r),
+			UserAgent: r.UserAgent(),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+		}))
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequirePermission lets through only the users whose role is granted the permission. The others get
+// the 403 page. It goes after Auth, which makes sure there is a user at all
+func RequirePermission(p models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !helpers.CurrentRole(r).Can(p) {
+				handlers.Repo.Forbidden(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIAuth lets through only the API requests carrying one of the configured API keys as a bearer token.
+// The API neither reads the session nor sets cookies, so a browser cannot be tricked into sending an
+// authenticated request and the API is served outside of NoSurf
+func APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || !validAPIKey(strings.TrimPrefix(auth, "Bearer ")) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			helpers.JSONError(w, http.StatusUnauthorized, "Missing or invalid API key", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validAPIKey reports whether key is one of the configured API keys
+func validAPIKey(key string) bool {
+	valid := false
+	for _, k := range app.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			valid = true
+		}
+	}
+	return valid && key != ""
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -30,3 +31,33 @@ func TestSessionLoad(t *testing.T) {
 		}
 	}
 }
+
+func TestAPIAuth(t *testing.T) {
+	app.APIKeys = []string{"first-key", "second-key"}
+	defer func() { app.APIKeys = nil }()
+	h := APIAuth(&myHandler{})
+
+	tests := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+	}{
+		{"no key", "", http.StatusUnauthorized},
+		{"wrong key", "Bearer other-key", http.StatusUnauthorized},
+		{"empty key", "Bearer ", http.StatusUnauthorized},
+		{"not bearer", "Basic second-key", http.StatusUnauthorized},
+		{"first key", "Bearer first-key", http.StatusOK},
+		{"second key", "Bearer second-key", http.StatusOK},
+	}
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/api/v1/rooms", nil)
+		if e.authorization != "" {
+			req.Header.Set("Authorization", e.authorization)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
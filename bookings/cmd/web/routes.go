@@ -5,10 +5,41 @@ import (
 
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/config"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/handlers"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// handler serves the JSON API under /api/v1 and the web site everywhere else. The reservation export
+// is streamed, so it is served with SessionStream instead of the buffering SessionLoad of the web site
+func handler(app *config.AppConfig) http.Handler {
+	mux := chi.NewRouter()
+	mux.Mount("/api/v1", apiRoutes(app))
+	mux.With(middleware.Recoverer, NoSurf, SessionStream, Auth, RequirePermission(models.PermExportReservations)).
+		Get("/admin/reservations-export", handlers.Repo.AdminExportReservations)
+	mux.Mount("/", routes(app))
+	return mux
+}
+
+// apiRoutes returns the router of the JSON API. It uses API keys instead of the session and CSRF tokens
+func apiRoutes(app *config.AppConfig) http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Use(middleware.Recoverer)
+	mux.Use(APIAuth)
+	mux.NotFound(handlers.Repo.APINotFound)
+	mux.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+
+	mux.Get("/rooms", handlers.Repo.APIRooms)
+	mux.Get("/availability", handlers.Repo.APIAvailability)
+	mux.Post("/reservations", handlers.Repo.APICreateReservation)
+	mux.Get("/reservations/{reference}", handlers.Repo.APIReservation)
+	mux.Post("/reservations/{reference}/cancel", handlers.Repo.APICancelReservation)
+
+	return mux
+}
+
+// routes returns the router of the web site
 func routes(app *config.AppConfig) http.Handler {
 	mux := chi.NewRouter()
 
@@ -18,8 +49,10 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quoters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	mux.Get("/generals-quoters", handlers.Repo.RoomRedirect("generals-quoters"))
+	mux.Get("/majors-suite", handlers.Repo.RoomRedirect("majors-suite"))
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
@@ -29,22 +62,58 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+	mux.Get("/my-booking", handlers.Repo.MyBooking)
+	mux.Post("/my-booking", handlers.Repo.PostMyBooking)
+	mux.Get("/my-booking/reservation", handlers.Repo.ShowMyBooking)
+	mux.Post("/my-booking/contact", handlers.Repo.PostMyBookingContact)
+	mux.Post("/my-booking/cancel", handlers.Repo.PostMyBookingCancel)
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
+	mux.Get("/user/forgot-password", handlers.Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/user/reset-password", handlers.Repo.ShowResetPassword)
+	mux.Post("/user/reset-password", handlers.Repo.PostResetPassword)
+	mux.Get("/user/two-factor", handlers.Repo.ShowTwoFactor)
+	mux.Post("/user/two-factor", handlers.Repo.PostTwoFactor)
 
 	mux.Route("/admin", func(mux chi.Router) {
 		mux.Use(Auth)
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
-		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
-		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
-		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
-		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
-		mux.Get("/process-reservation/{src}/{id}", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}", handlers.Repo.AdminDeleteReservation)
-		mux.Get("/reservations/{src}/{id}", handlers.Repo.AdminShowReservation)
-		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		mux.Get("/change-password", handlers.Repo.AdminChangePassword)
+		mux.Post("/change-password", handlers.Repo.AdminPostChangePassword)
+		mux.Get("/two-factor", handlers.Repo.AdminTwoFactor)
+		mux.Post("/two-factor", handlers.Repo.AdminPostTwoFactor)
+		mux.Post("/two-factor/disable", handlers.Repo.AdminPostDisableTwoFactor)
+		mux.With(RequirePermission(models.PermViewReservations)).Get("/reservations-new", handlers.Repo.AdminNewReservations)
+		mux.With(RequirePermission(models.PermViewReservations)).Get("/reservations-all", handlers.Repo.AdminAllReservations)
+		mux.With(RequirePermission(models.PermImportReservations)).Get("/import", handlers.Repo.AdminImport)
+		mux.With(RequirePermission(models.PermImportReservations)).Post("/import", handlers.Repo.AdminPostImport)
+		mux.With(RequirePermission(models.PermViewReservations)).Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+		mux.With(RequirePermission(models.PermBlockRooms)).Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+		mux.With(RequirePermission(models.PermBlockRooms)).Post("/blocks", handlers.Repo.AdminPostBlockRooms)
+		mux.With(RequirePermission(models.PermBlockRooms)).Post("/blocks/remove", handlers.Repo.AdminPostUnblockRooms)
+		mux.With(RequirePermission(models.PermEditReservations)).Post("/reservations/{src}/{id}/status", handlers.Repo.AdminPostReservationStatus)
+		mux.With(RequirePermission(models.PermDeleteReservations)).Post("/reservations/{src}/{id}/delete", handlers.Repo.AdminPostDeleteReservation)
+		mux.With(RequirePermission(models.PermViewReservations)).Get("/reservations/{src}/{id}", handlers.Repo.AdminShowReservation)
+		mux.With(RequirePermission(models.PermEditReservations)).Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		mux.With(RequirePermission(models.PermViewRooms)).Get("/rooms", handlers.Repo.AdminRooms)
+		mux.With(RequirePermission(models.PermViewRooms)).Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+		mux.With(RequirePermission(models.PermManageRooms)).Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+		mux.With(RequirePermission(models.PermManageRooms)).Post("/rooms/{id}/delete", handlers.Repo.AdminPostDeleteRoom)
+		mux.With(RequirePermission(models.PermViewReports)).Get("/reports", handlers.Repo.AdminReports)
+		mux.With(RequirePermission(models.PermViewReports)).Get("/reports/csv", handlers.Repo.AdminReportsCSV)
+		mux.With(RequirePermission(models.PermViewMail)).Get("/mail", handlers.Repo.AdminMail)
+		mux.With(RequirePermission(models.PermResendMail)).Post("/mail/{id}/resend", handlers.Repo.AdminPostResendMail)
+		mux.With(RequirePermission(models.PermManageUsers)).Get("/users", handlers.Repo.AdminUsers)
+		mux.With(RequirePermission(models.PermManageUsers)).Get("/users/{id}", handlers.Repo.AdminShowUser)
+		mux.With(RequirePermission(models.PermManageUsers)).Post("/users/{id}", handlers.Repo.AdminPostUser)
+		mux.With(RequirePermission(models.PermManageUsers)).Post("/users/{id}/force-reset", handlers.Repo.AdminPostForcePasswordReset)
+		mux.With(RequirePermission(models.PermManageUsers)).Post("/users/{id}/unlock", handlers.Repo.AdminPostUnlockUser)
+		mux.With(RequirePermission(models.PermManageUsers)).Post("/users/{id}/reset-2fa", handlers.Repo.AdminPostResetTwoFactor)
+		mux.With(RequirePermission(models.PermManageUsers)).Post("/users/{id}/delete", handlers.Repo.AdminPostDeleteUser)
+		mux.With(RequirePermission(models.PermViewAudit)).Get("/audit", handlers.Repo.AdminAuditLog)
 	})
 
 	fileServer := http.FileServer(http.Dir("./static/"))
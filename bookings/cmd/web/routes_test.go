@@ -1,9 +1,18 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/config"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/handlers"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/helpers"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -17,3 +26,54 @@ func TestRoutes(t *testing.T) {
 		t.Errorf("Type returned is not *chi.Mux; it is %T instead", v)
 	}
 }
+
+// TestHandler_ReservationExport runs the export through the middleware of the real routes, which must
+// neither buffer the download nor lose the session
+func TestHandler_ReservationExport(t *testing.T) {
+	session, twoFactorRoles := app.Session, app.TwoFactorRoles
+	defer func() { app.Session, app.TwoFactorRoles = session, twoFactorRoles }()
+	app.Session, app.TwoFactorRoles = scs.New(), nil
+	handlers.NewHandlers(handlers.NewMemoryRepo(&app))
+	helpers.NewHelpers(&app)
+	day := func(d int) time.Time { return time.Date(2060, 1, d, 0, 0, 0, 0, time.UTC) }
+	for i, name := range []string{"Smith", "Jones"} {
+		res := models.Reservation{FirstName: "John", LastName: name, Email: "john@here.ca", RoomId: i + 1,
+			StartDate: day(10), EndDate: day(12)}
+		if _, err := handlers.Repo.DB.CreateReservation(context.Background(), res, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, _ := app.Session.Load(context.Background(), "")
+	app.Session.Put(ctx, "user_id", 1)
+	token, _, _ := app.Session.Commit(ctx)
+	mux := handler(&app)
+
+	req := httptest.NewRequest("GET", "/admin/reservations-export?format=csv&col=id&col=last_name&sort=id", nil)
+	req.AddCookie(&http.Cookie{Name: app.Session.Cookie.Name, Value: token})
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !rr.Flushed {
+		t.Error("the export was not flushed to the client")
+	}
+	if expected := "ID,Last name\n1,Smith\n2,Jones\n"; rr.Body.String() != expected {
+		t.Errorf("expected %q but got %q", expected, rr.Body.String())
+	}
+	// Auth keeps the access level in the session, which is saved before the download starts
+	if cookies := strings.Join(rr.Header()["Set-Cookie"], "\n"); !strings.Contains(cookies, app.Session.Cookie.Name+"="+token) {
+		t.Errorf("the session was not saved: %q", cookies)
+	}
+
+	// a visitor is sent to log in, with the error kept in a new session
+	req = httptest.NewRequest("GET", "/admin/reservations-export?format=csv", nil)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if location := rr.Header().Get("Location"); rr.Code != http.StatusSeeOther || location != "/user/login" {
+		t.Errorf("expected a redirect to log in but got %d to %q", rr.Code, location)
+	}
+	if cookies := strings.Join(rr.Header()["Set-Cookie"], "\n"); !strings.Contains(cookies, app.Session.Cookie.Name+"=") {
+		t.Errorf("the session was not saved: %q", cookies)
+	}
+}
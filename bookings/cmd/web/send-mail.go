@@ -1,54 +1,112 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
-	"log"
-	"strings"
+	"context"
+	"sync"
 	"time"
 
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/mailer"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
-	mail "github.com/xhit/go-simple-mail/v2"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository"
 )
 
-func listenForMail() {
-	go func() {
-		for {
-			msg := <-app.MailChan
-			sendMessage(msg)
+// mailPollInterval is how often idle mail workers look for due messages in the outbox
+const mailPollInterval = 2 * time.Second
+
+// mailLease is how long a claimed message is reserved for the worker delivering it
+const mailLease = 5 * time.Minute
+
+// mailRetryBase and mailRetryMax bound the exponential backoff between delivery attempts
+const (
+	mailRetryBase = 30 * time.Second
+	mailRetryMax  = time.Hour
+)
+
+// startMailWorkers starts n workers delivering messages from the mail outbox with the mailer until ctx
+// is cancelled. The returned WaitGroup is done when all the workers have stopped
+func startMailWorkers(ctx context.Context, db repository.DatabaseRepo, m mailer.Mailer, n int) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mailWorker(ctx, db, m)
+		}()
+	}
+	return &wg
+}
+
+// mailWorker delivers due messages one by one and waits for the next poll when there are none
+func mailWorker(ctx context.Context, db repository.DatabaseRepo, m mailer.Mailer) {
+	for {
+		messages, err := db.ClaimMail(ctx, 1, mailLease)
+		if err != nil && ctx.Err() == nil {
+			errorLog.Println(err)
+		}
+		for _, msg := range messages {
+			deliverMail(ctx, db, m, msg)
+		}
+		if len(messages) > 0 {
+			continue
 		}
-	}()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(mailPollInterval):
+		}
+	}
 }
 
-func sendMessage(m models.MailData) {
-	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
+// drainMail delivers the mail that is due until there is none left or ctx is done. Messages that fail
+// are rescheduled as usual and left for the next start
+func drainMail(ctx context.Context, db repository.DatabaseRepo, m mailer.Mailer) error {
+	for {
+		messages, err := db.ClaimMail(ctx, 1, mailLease)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		deliverMail(ctx, db, m, messages[0])
+	}
+}
 
-	client, err := server.Connect()
+// deliverMail sends the claimed message within ctx and records the outcome in the outbox. A failed message
+// is retried with exponential backoff until it runs out of attempts, but one cut short by ctx is due again
+// at once. The outcome is recorded even if ctx is done, otherwise a sent message would be sent again after
+// the lease expires
+func deliverMail(ctx context.Context, db repository.DatabaseRepo, m mailer.Mailer, msg models.OutboxMessage) {
+	sendErr := m.Send(ctx, msg.MailData)
+	recordCtx := context.Background()
+	var err error
+	switch {
+	case sendErr == nil:
+		infoLog.Printf("Email with subject %q is sent from %q to %q", msg.Subject, msg.From, msg.To)
+		err = db.MarkMailSent(recordCtx, msg.ID)
+	case ctx.Err() != nil:
+		errorLog.Printf("Sending email %d to %q is interrupted: %s", msg.ID, msg.To, sendErr)
+		err = db.RescheduleMail(recordCtx, msg.ID, sendErr.Error(), time.Now())
+	case msg.Attempts >= app.MailMaxAttempts:
+		errorLog.Printf("Giving up on email %d to %q after %d attempts: %s", msg.ID, msg.To, msg.Attempts, sendErr)
+		err = db.MarkMailFailed(recordCtx, msg.ID, sendErr.Error())
+	default:
+		errorLog.Printf("Error sending email %d to %q: %s", msg.ID, msg.To, sendErr)
+		err = db.RescheduleMail(recordCtx, msg.ID, sendErr.Error(), time.Now().Add(mailBackoff(msg.Attempts)))
+	}
 	if err != nil {
 		errorLog.Println(err)
 	}
+}
 
-	email := mail.NewMSG()
-	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
-	if m.Template == "" {
-		email.SetBody(mail.TextHTML, m.Content)
-	} else {
-		data, err := ioutil.ReadFile(fmt.Sprintf("./email-templates/%s", m.Template))
-		if err != nil {
-			app.ErrorLog.Fatalf("Error reading template from disk: %s", err)
-		}
-		mailTemplate := string(data)
-		email.SetBody(mail.TextHTML, strings.Replace(mailTemplate, "[%body%]", m.Content, 1))
+// mailBackoff returns the delay before the next delivery attempt after the given number of attempts
+func mailBackoff(attempts int) time.Duration {
+	delay := mailRetryBase
+	for i := 1; i < attempts && delay < mailRetryMax; i++ {
+		delay *= 2
 	}
-	err = email.Send(client)
-	if err != nil {
-		log.Println(err)
-	} else {
-		log.Printf("Email with subject %q is sent from %q to %q", m.Subject, m.From, m.To)
+	if delay > mailRetryMax {
+		delay = mailRetryMax
 	}
+	return delay
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/mailer"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository/dbrepo"
+)
+
+func TestMailBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{8, time.Hour},
+		{100, time.Hour},
+	}
+	for _, e := range tests {
+		if delay := mailBackoff(e.attempts); delay != e.expected {
+			t.Errorf("attempt %d: expected delay %s, but got %s", e.attempts, e.expected, delay)
+		}
+	}
+}
+
+func TestDeliverMail(t *testing.T) {
+	app.MailMaxAttempts = 2
+	db := dbrepo.NewMemoryRepo(&app)
+	m := mailer.NewMemory(mailer.Config{From: "hotel@here.ca"})
+	ctx := context.Background()
+
+	_ = db.QueueMail(ctx, models.MailData{To: "john@smith.com", Subject: "Confirmation"})
+	claimed, _ := db.ClaimMail(ctx, 1, time.Minute)
+	deliverMail(ctx, db, m, claimed[0])
+	if sent := m.Messages(); len(sent) != 1 || sent[0].To != "john@smith.com" || sent[0].From != "hotel@here.ca" {
+		t.Errorf("unexpected sent messages %+v", sent)
+	}
+	if pending, _ := db.PendingMail(ctx); len(pending) != 0 {
+		t.Errorf("sent message is still pending: %+v", pending)
+	}
+
+	m.Err = errors.New("connection refused")
+	_ = db.QueueMail(ctx, models.MailData{To: "jane@smith.com", Subject: "Confirmation"})
+	claimed, _ = db.ClaimMail(ctx, 1, time.Minute)
+	deliverMail(ctx, db, m, claimed[0])
+	pending, _ := db.PendingMail(ctx)
+	if len(pending) != 1 || pending[0].Status != models.MailQueued || pending[0].LastError != "connection refused" ||
+		pending[0].NextAttemptAt.Before(time.Now().Add(mailRetryBase-time.Second)) {
+		t.Fatalf("failed message is not rescheduled: %+v", pending)
+	}
+
+	// the second attempt is the last one
+	claimed[0].Attempts = 2
+	deliverMail(ctx, db, m, claimed[0])
+	pending, _ = db.PendingMail(ctx)
+	if len(pending) != 1 || pending[0].Status != models.MailFailed {
+		t.Errorf("message is not marked as failed after the last attempt: %+v", pending)
+	}
+
+	// a delivery cut short by shutdown is not held against the message
+	m.Err = nil
+	_ = db.QueueMail(ctx, models.MailData{To: "jim@smith.com", Subject: "Confirmation"})
+	claimed, _ = db.ClaimMail(ctx, 1, time.Minute)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	deliverMail(cancelled, db, m, claimed[0])
+	if due, _ := db.ClaimMail(ctx, 1, time.Minute); len(due) != 1 || due[0].To != "jim@smith.com" {
+		t.Errorf("interrupted message is not due again: %+v", due)
+	}
+	if sent := m.Messages(); len(sent) != 1 {
+		t.Errorf("interrupted message is sent: %+v", sent)
+	}
+}
+
+func TestDrainMail(t *testing.T) {
+	app.MailMaxAttempts = 5
+	db := dbrepo.NewMemoryRepo(&app)
+	m := mailer.NewMemory(mailer.Config{From: "hotel@here.ca"})
+	ctx := context.Background()
+
+	_ = db.QueueMail(ctx, models.MailData{To: "john@smith.com"}, models.MailData{To: "jane@smith.com"})
+	if err := drainMail(ctx, db, m); err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if sent := m.Messages(); len(sent) != 2 {
+		t.Errorf("expected 2 messages to be sent, but got %d", len(sent))
+	}
+
+	// failed messages are rescheduled and do not keep the drain going
+	m.Err = errors.New("connection refused")
+	_ = db.QueueMail(ctx, models.MailData{To: "john@smith.com"})
+	if err := drainMail(ctx, db, m); err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if pending, _ := db.PendingMail(ctx); len(pending) != 1 || pending[0].Attempts != 1 {
+		t.Errorf("failed message is not left queued: %+v", pending)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := drainMail(cancelled, db, m); err == nil {
+		t.Error("expected an error when the context is done")
+	}
+}
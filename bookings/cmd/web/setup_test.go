@@ -1,6 +1,8 @@
 package main
 
 import (
+	"io"
+	"log"
 	"net/http"
 	"os"
 	"testing"
@@ -13,5 +15,7 @@ func (mh *myHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func TestMain(m *testing.M) {
+	infoLog = log.New(io.Discard, "", 0)
+	errorLog = log.New(io.Discard, "", 0)
 	os.Exit(m.Run())
 }
@@ -3,18 +3,58 @@ package config
 import (
 	"html/template"
 	"log"
+	"time"
 
-	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
 	"github.com/alexedwards/scs/v2"
 )
 
 // AppConfig holds whole an application configuration
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	UseCache           bool
+	TemplateCache      map[string]*template.Template
+	InfoLog            *log.Logger
+	ErrorLog           *log.Logger
+	InProduction       bool
+	Session            *scs.SessionManager
+	Addr               string
+	SessionLifetime    time.Duration
+	SessionStore       string
+	SessionCleanup     time.Duration
+	ShutdownTimeout    time.Duration
+	CancellationWindow time.Duration
+	APIKeys            []string
+	TrustedProxies     []string
+	BaseURL            string
+	SigningKey         string
+	PasswordResetTTL   time.Duration
+
+	LoginFreeAttempts      int
+	LoginLockoutAttempts   int
+	LoginIPLockoutAttempts int
+	LoginLockout           time.Duration
+	TwoFactorRoles         []string
+
+	DBType            string
+	DBURL             string
+	DBHost            string
+	DBPort            int
+	DBName            string
+	DBUser            string
+	DBPassword        string
+	DBSSLMode         string
+	DBTimeout         time.Duration
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+
+	Mailer          string
+	MailDir         string
+	MailFrom        string
+	MailWorkers     int
+	MailMaxAttempts int
+	SMTPHost        string
+	SMTPPort        int
+	SMTPUsername    string
+	SMTPPassword    string
+	SMTPEncryption  string
 }
@@ -0,0 +1,428 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv is the environment variable with the path of the config file, unless set by -config flag
+const ConfigFileEnv = "BOOKINGS_CONFIG"
+
+// redacted replaces the values of secrets when the configuration is printed
+const redacted = "******"
+
+// setting is a single configuration value. It is read from the config file by key, from the environment
+// variable env (BOOKINGS_ followed by the upper case key if not set) and from the command line flag
+type setting struct {
+	key    string
+	flag   string
+	env    string
+	usage  string
+	secret bool
+	// value points to the field of AppConfig
+	value any
+}
+
+// settings describes all the values that can be configured
+func (c *AppConfig) settings() []setting {
+	return []setting{
+		{key: "addr", flag: "addr", usage: "Address the web server listens on", value: &c.Addr},
+		{key: "production", flag: "production", usage: "Application is in production", value: &c.InProduction},
+		{key: "use_cache", flag: "cache", usage: "Use template cache", value: &c.UseCache},
+		{key: "session_lifetime", flag: "sessionlifetime", usage: "How long a session lasts", value: &c.SessionLifetime},
+		{key: "session_store", flag: "sessionstore", usage: "Where sessions are kept (postgres, memory), the same as db_type if not set", value: &c.SessionStore},
+		{key: "session_cleanup", flag: "sessioncleanup", usage: "How often expired sessions are deleted from the postgres store", value: &c.SessionCleanup},
+		{key: "shutdown_timeout", flag: "shutdowntimeout", usage: "How long to wait for in-flight requests and mail delivery on shutdown", value: &c.ShutdownTimeout},
+		{key: "cancellation_window", flag: "cancelwindow", usage: "How long before arrival guests can still cancel their reservation online", value: &c.CancellationWindow},
+		{key: "api_keys", flag: "apikeys", usage: "Comma separated list of keys accepted by the JSON API", secret: true, value: &c.APIKeys},
+		{key: "trusted_proxies", flag: "trustedproxies", usage: "Comma separated list of addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For and X-Real-IP headers are trusted", value: &c.TrustedProxies},
+		{key: "base_url", flag: "baseurl", usage: "Public URL of the site, used in the links sent by email", value: &c.BaseURL},
+		{key: "signing_key", flag: "signingkey", usage: "Key signing password reset links, a random one on every start if empty", secret: true, value: &c.SigningKey},
+		{key: "password_reset_ttl", flag: "resetttl", usage: "How long a password reset link is valid", value: &c.PasswordResetTTL},
+		{key: "login_free_attempts", flag: "loginfree", usage: "Failed logins to an account before each next one is delayed", value: &c.LoginFreeAttempts},
+		{key: "login_lockout_attempts", flag: "loginlockout", usage: "Failed logins to an account before it is locked out", value: &c.LoginLockoutAttempts},
+		{key: "login_ip_lockout_attempts", flag: "loginiplockout", usage: "Failed logins from an IP address before it is locked out", value: &c.LoginIPLockoutAttempts},
+		{key: "login_lockout", flag: "loginlockoutfor", usage: "How long an account or an IP address is locked out", value: &c.LoginLockout},
+		{key: "two_factor_roles", flag: "twofactorroles", usage: "Comma separated list of roles that must use two-factor authentication", value: &c.TwoFactorRoles},
+
+		{key: "db_type", flag: "dbtype", usage: "Database type (postgres, memory)", value: &c.DBType},
+		{key: "db_url", flag: "dburl", env: "POSTGRESS_BOOKINGS_URL", usage: "Database connection string, overrides the other db settings", secret: true, value: &c.DBURL},
+		{key: "db_host", flag: "dbhost", usage: "Database host", value: &c.DBHost},
+		{key: "db_port", flag: "dbport", usage: "Database port", value: &c.DBPort},
+		{key: "db_name", flag: "dbname", usage: "Database name", value: &c.DBName},
+		{key: "db_user", flag: "dbuser", usage: "Database user", value: &c.DBUser},
+		{key: "db_password", flag: "dbpwd", usage: "Database password", secret: true, value: &c.DBPassword},
+		{key: "db_sslmode", flag: "dbssl", usage: "Database SSL settings (disable, prefer, require)", value: &c.DBSSLMode},
+		{key: "db_timeout", flag: "dbtimeout", usage: "Timeout of a single database query", value: &c.DBTimeout},
+		{key: "db_max_open_conns", flag: "dbmaxopen", usage: "Maximum number of open database connections", value: &c.DBMaxOpenConns},
+		{key: "db_max_idle_conns", flag: "dbmaxidle", usage: "Maximum number of idle database connections", value: &c.DBMaxIdleConns},
+		{key: "db_conn_max_lifetime", flag: "dbmaxlifetime", usage: "How long a database connection is reused", value: &c.DBConnMaxLifetime},
+
+		{key: "mailer", flag: "mailer", env: "MAILER", usage: "How mail is delivered (smtp, file)", value: &c.Mailer},
+		{key: "mail_dir", flag: "maildir", env: "MAIL_DIR", usage: "Directory the file mailer writes .eml files to", value: &c.MailDir},
+		{key: "mail_from", flag: "mailfrom", env: "MAIL_FROM", usage: "Sender address of the mail", value: &c.MailFrom},
+		{key: "mail_workers", flag: "mailworkers", usage: "Number of workers delivering mail from the outbox", value: &c.MailWorkers},
+		{key: "mail_max_attempts", flag: "mailattempts", usage: "How many times delivery of a message is attempted before it is marked as failed", value: &c.MailMaxAttempts},
+		{key: "smtp_host", flag: "smtphost", env: "SMTP_HOST", usage: "SMTP server host", value: &c.SMTPHost},
+		{key: "smtp_port", flag: "smtpport", env: "SMTP_PORT", usage: "SMTP server port", value: &c.SMTPPort},
+		{key: "smtp_username", flag: "smtpuser", env: "SMTP_USERNAME", usage: "SMTP user name, no authentication if empty", value: &c.SMTPUsername},
+		{key: "smtp_password", flag: "smtppwd", env: "SMTP_PASSWORD", usage: "SMTP password", secret: true, value: &c.SMTPPassword},
+		{key: "smtp_encryption", flag: "smtpencryption", env: "SMTP_ENCRYPTION", usage: "SMTP encryption (none, starttls, tls)", value: &c.SMTPEncryption},
+	}
+}
+
+// setDefaults sets the values used unless the config file, the environment or the flags set them
+func (c *AppConfig) setDefaults() {
+	c.Addr = ":8080"
+	c.InProduction = true
+	c.UseCache = true
+	c.SessionLifetime = 24 * time.Hour
+	c.SessionCleanup = 5 * time.Minute
+	c.ShutdownTimeout = 15 * time.Second
+	c.CancellationWindow = 48 * time.Hour
+	c.BaseURL = "http://localhost:8080"
+	c.PasswordResetTTL = time.Hour
+	c.LoginFreeAttempts = 3
+	c.LoginLockoutAttempts = 10
+	c.LoginIPLockoutAttempts = 100
+	c.LoginLockout = 15 * time.Minute
+	c.TwoFactorRoles = []string{"manager", "owner"}
+
+	c.DBType = "postgres"
+	c.DBHost = "localhost"
+	c.DBPort = 5432
+	c.DBSSLMode = "disable"
+	c.DBTimeout = 3 * time.Second
+	c.DBMaxOpenConns = 10
+	c.DBMaxIdleConns = 5
+	c.DBConnMaxLifetime = 5 * time.Minute
+
+	c.Mailer = "smtp"
+	c.MailDir = "./mail"
+	c.MailFrom = "admin@room&breakfast.com"
+	c.MailWorkers = 2
+	c.MailMaxAttempts = 5
+	c.SMTPHost = "localhost"
+	c.SMTPPort = 1025
+	c.SMTPEncryption = "none"
+}
+
+// Load fills the configuration from the defaults, the config file, the environment and the command line,
+// each one overriding the previous. The flags are registered on fs and parsed from args. The config file
+// is set by -config flag or BOOKINGS_CONFIG environment variable. The result is validated
+func (c *AppConfig) Load(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) error {
+	c.setDefaults()
+	settings := c.settings()
+
+	configFile, _ := lookupEnv(ConfigFileEnv)
+	fs.StringVar(&configFile, "config", configFile, "YAML config file")
+	// the flags are parsed into their own variables, as they are applied after the config file and the environment
+	for _, s := range settings {
+		switch p := s.value.(type) {
+		case *string:
+			fs.String(s.flag, *p, s.usage)
+		case *bool:
+			fs.Bool(s.flag, *p, s.usage)
+		case *int:
+			fs.Int(s.flag, *p, s.usage)
+		case *time.Duration:
+			fs.Duration(s.flag, *p, s.usage)
+		case *[]string:
+			fs.String(s.flag, strings.Join(*p, ","), s.usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if configFile != "" {
+		if err := loadFile(configFile, settings); err != nil {
+			return err
+		}
+	}
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.envName()); ok {
+			if err := s.set(value); err != nil {
+				return fmt.Errorf("environment variable %s: %w", s.envName(), err)
+			}
+		}
+	}
+	byFlag := map[string]setting{}
+	for _, s := range settings {
+		byFlag[s.flag] = s
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if s, ok := byFlag[f.Name]; ok && err == nil {
+			if setErr := s.set(f.Value.String()); setErr != nil {
+				err = fmt.Errorf("flag -%s: %w", s.flag, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.SessionStore == "" {
+		c.SessionStore = c.DBType
+	}
+
+	return c.Validate()
+}
+
+// loadFile reads the settings from YAML config file
+func loadFile(path string, settings []setting) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %s: expected a mapping of settings", path)
+	}
+
+	byKey := map[string]setting{}
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		s, ok := byKey[key.Value]
+		if !ok {
+			return fmt.Errorf("config file %s, line %d: unknown setting %q", path, key.Line, key.Value)
+		}
+		if err := s.setNode(value); err != nil {
+			return fmt.Errorf("config file %s, line %d: %s: %w", path, value.Line, key.Value, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the configuration and reports all the problems found at once
+func (c *AppConfig) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Addr != "", "addr is required")
+	check(c.SessionLifetime > 0, "session_lifetime must be positive")
+	switch c.SessionStore {
+	case "memory":
+	case "postgres":
+		check(c.DBType == "postgres", "session_store postgres requires db_type postgres")
+		check(c.SessionCleanup > 0, "session_cleanup must be positive")
+	default:
+		problems = append(problems, fmt.Sprintf("session_store must be postgres or memory, not %q", c.SessionStore))
+	}
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+	check(c.CancellationWindow >= 0, "cancellation_window cannot be negative")
+	for _, p := range c.TrustedProxies {
+		_, _, err := net.ParseCIDR(p)
+		check(err == nil || net.ParseIP(p) != nil, "trusted_proxies: %q is neither an address nor a CIDR range", p)
+	}
+	baseURL, err := url.Parse(c.BaseURL)
+	check(err == nil && oneOf(baseURL.Scheme, "http", "https") && baseURL.Host != "",
+		"base_url must be an absolute http or https URL, not %q", c.BaseURL)
+	check(c.SigningKey == "" || len(c.SigningKey) >= 32, "signing_key must be at least 32 characters long")
+	check(c.PasswordResetTTL > 0, "password_reset_ttl must be positive")
+	check(c.LoginFreeAttempts >= 0, "login_free_attempts cannot be negative")
+	check(c.LoginLockoutAttempts > c.LoginFreeAttempts, "login_lockout_attempts must be greater than login_free_attempts")
+	check(c.LoginIPLockoutAttempts > c.LoginLockoutAttempts, "login_ip_lockout_attempts must be greater than login_lockout_attempts")
+	check(c.LoginLockout > 0, "login_lockout must be positive")
+	for _, name := range c.TwoFactorRoles {
+		_, ok := models.RoleByName(name)
+		check(ok, "two_factor_roles: unknown role %q", name)
+	}
+
+	switch c.DBType {
+	case "memory":
+	case "postgres":
+		if c.DBURL == "" {
+			check(c.DBHost != "", "db_host is required unless db_url is set")
+			check(validPort(c.DBPort), "db_port must be between 1 and 65535")
+			check(c.DBName != "", "db_name is required unless db_url is set")
+			check(c.DBUser != "", "db_user is required unless db_url is set")
+			check(oneOf(c.DBSSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+				"db_sslmode %q is not supported", c.DBSSLMode)
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("db_type must be postgres or memory, not %q", c.DBType))
+	}
+	check(c.DBTimeout > 0, "db_timeout must be positive")
+	check(c.DBMaxOpenConns > 0, "db_max_open_conns must be positive")
+	check(c.DBMaxIdleConns >= 0 && c.DBMaxIdleConns <= c.DBMaxOpenConns, "db_max_idle_conns must be between 0 and db_max_open_conns")
+	check(c.DBConnMaxLifetime >= 0, "db_conn_max_lifetime cannot be negative")
+
+	switch c.Mailer {
+	case "smtp":
+		check(c.SMTPHost != "", "smtp_host is required")
+		check(validPort(c.SMTPPort), "smtp_port must be between 1 and 65535")
+		check(oneOf(c.SMTPEncryption, "none", "starttls", "tls"), "smtp_encryption must be none, starttls or tls, not %q", c.SMTPEncryption)
+	case "file":
+		check(c.MailDir != "", "mail_dir is required")
+	default:
+		problems = append(problems, fmt.Sprintf("mailer must be smtp or file, not %q", c.Mailer))
+	}
+	check(c.MailFrom != "", "mail_from is required")
+	check(c.MailWorkers > 0, "mail_workers must be positive")
+	check(c.MailMaxAttempts > 0, "mail_max_attempts must be positive")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// WriteYAML writes the configuration in the config file format with the secrets redacted
+func (c *AppConfig) WriteYAML(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, s := range c.settings() {
+		value := s.node()
+		if s.secret {
+			redact(value)
+		}
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: s.key}, value)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// redact replaces non empty values of the node
+func redact(n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode && n.Value != "" {
+		n.Value = redacted
+	}
+	for _, item := range n.Content {
+		redact(item)
+	}
+}
+
+func (s setting) envName() string {
+	if s.env != "" {
+		return s.env
+	}
+	return "BOOKINGS_" + strings.ToUpper(s.key)
+}
+
+// set parses the value from string into the field
+func (s setting) set(value string) error {
+	switch p := s.value.(type) {
+	case *string:
+		*p = value
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*p = b
+	case *int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*p = i
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*p = d
+	case *[]string:
+		*p = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+	}
+	return nil
+}
+
+// setNode sets the field from YAML node. Lists are accepted either as sequences or comma separated strings
+func (s setting) setNode(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return s.set(n.Value)
+	}
+	if p, ok := s.value.(*[]string); ok && n.Kind == yaml.SequenceNode {
+		*p = nil
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("expected a list of strings")
+			}
+			*p = append(*p, item.Value)
+		}
+		return nil
+	}
+	return fmt.Errorf("expected a single value")
+}
+
+// String formats the value the way set parses it
+func (s setting) String() string {
+	switch p := s.value.(type) {
+	case *string:
+		return *p
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *int:
+		return strconv.Itoa(*p)
+	case *time.Duration:
+		return p.String()
+	case *[]string:
+		return strings.Join(*p, ",")
+	}
+	return ""
+}
+
+// node converts the value to YAML node
+func (s setting) node() *yaml.Node {
+	switch p := s.value.(type) {
+	case *bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: s.String()}
+	case *int:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: s.String()}
+	case *[]string:
+		n := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range *p {
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item})
+		}
+		return n
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s.String()}
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
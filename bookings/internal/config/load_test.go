@@ -0,0 +1,181 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// load runs Load with the config file content, the environment and the command line args
+func load(t *testing.T, file string, env map[string]string, args ...string) (*AppConfig, error) {
+	t.Helper()
+	if file != "" {
+		path := filepath.Join(t.TempDir(), "bookings.yaml")
+		if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"-config", path}, args...)
+	}
+	fs := flag.NewFlagSet("bookings", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var c AppConfig
+	err := c.Load(fs, args, func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	})
+	return &c, err
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	file := `
+db_type: memory
+db_timeout: 5s
+mail_workers: 4
+smtp_port: 2000
+api_keys: [abc, def]
+`
+	env := map[string]string{"BOOKINGS_MAIL_WORKERS": "6", "SMTP_PORT": "3000"}
+	c, err := load(t, file, env, "-smtpport", "4000", "-production=false")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+
+	if c.Addr != ":8080" {
+		t.Errorf("default is not applied, addr is %q", c.Addr)
+	}
+	if c.DBType != "memory" || c.DBTimeout != 5*time.Second {
+		t.Errorf("config file is not applied: %q, %s", c.DBType, c.DBTimeout)
+	}
+	if len(c.APIKeys) != 2 || c.APIKeys[1] != "def" {
+		t.Errorf("list is not read from config file: %v", c.APIKeys)
+	}
+	if c.MailWorkers != 6 {
+		t.Errorf("environment does not override config file, mail_workers is %d", c.MailWorkers)
+	}
+	if c.SMTPPort != 4000 || c.InProduction {
+		t.Errorf("flags do not override the environment: smtp_port is %d, production is %t", c.SMTPPort, c.InProduction)
+	}
+}
+
+func TestLoad_ConfigFileFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bookings.yaml")
+	_ = os.WriteFile(path, []byte("db_type: memory\napi_keys: abc, def\n"), 0o600)
+	c, err := load(t, "", map[string]string{ConfigFileEnv: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	if c.DBType != "memory" || len(c.APIKeys) != 2 {
+		t.Errorf("config file is not read: %q, %v", c.DBType, c.APIKeys)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		args     []string
+		expected string
+	}{
+		{"unknown setting", "db_tipe: memory", nil, nil, `line 1: unknown setting "db_tipe"`},
+		{"invalid value in file", "db_type: memory\ndb_timeout: soon", nil, nil, `line 2: db_timeout: invalid duration "soon"`},
+		{"nested value in file", "db_type: {name: memory}", nil, nil, "db_type: expected a single value"},
+		{"not a mapping", "- memory", nil, nil, "expected a mapping of settings"},
+		{"invalid yaml", "db_type: [memory", nil, nil, "config file"},
+		{"invalid env", "", map[string]string{"BOOKINGS_DB_TYPE": "memory", "SMTP_PORT": "smtp"}, nil, `environment variable SMTP_PORT: invalid number "smtp"`},
+		{"invalid flag", "", nil, []string{"-dbtype=memory", "-mailworkers=many"}, `invalid value "many" for flag -mailworkers`},
+		{"missing file", "", map[string]string{ConfigFileEnv: "/no/such/file.yaml"}, nil, "cannot read config file"},
+	}
+
+	for _, e := range tests {
+		_, err := load(t, e.file, e.env, e.args...)
+		if err == nil || !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("%s: expected error with %q, but got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, err := load(t, "", nil, "-dbmaxidle=20", "-mailer=pigeon", "-sessionlifetime=0s")
+	if err == nil {
+		t.Fatal("expected invalid configuration")
+	}
+	// all the problems are reported at once
+	for _, expected := range []string{
+		"db_name is required unless db_url is set",
+		"db_user is required unless db_url is set",
+		"db_max_idle_conns must be between 0 and db_max_open_conns",
+		`mailer must be smtp or file, not "pigeon"`,
+		"session_lifetime must be positive",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %q", expected, err)
+		}
+	}
+
+	c, err := load(t, "", nil, "-dbtype=memory")
+	if err != nil || c.SessionStore != "memory" {
+		t.Errorf("session store should follow db_type, but got %q, %v", c.SessionStore, err)
+	}
+	_, err = load(t, "", nil, "-dbtype=memory", "-sessionstore=postgres")
+	if err == nil || !strings.Contains(err.Error(), "session_store postgres requires db_type postgres") {
+		t.Errorf("postgres session store without postgres database is not reported: %v", err)
+	}
+
+	_, err = load(t, "", map[string]string{"POSTGRESS_BOOKINGS_URL": "postgres://localhost/bookings"})
+	if err != nil {
+		t.Errorf("db_url should replace the other db settings, but got %q", err)
+	}
+	_, err = load(t, "", nil, "-dbtype=memory", "-baseurl=localhost:8080", "-signingkey=short")
+	if err == nil || !strings.Contains(err.Error(), "base_url") || !strings.Contains(err.Error(), "signing_key") {
+		t.Errorf("invalid password reset settings are not reported: %v", err)
+	}
+	_, err = load(t, "", nil, "-dbtype=memory", "-twofactorroles=owner,janitor")
+	if err == nil || !strings.Contains(err.Error(), `two_factor_roles: unknown role "janitor"`) {
+		t.Errorf("unknown two-factor role is not reported: %v", err)
+	}
+	_, err = load(t, "", nil, "-dbtype=memory", "-trustedproxies=10.0.0.1,192.168.0.0/16,proxy.local")
+	if err == nil || !strings.Contains(err.Error(), `trusted_proxies: "proxy.local" is neither an address nor a CIDR range`) ||
+		strings.Contains(err.Error(), "10.0.0.1") || strings.Contains(err.Error(), "192.168.0.0/16") {
+		t.Errorf("invalid trusted proxy is not reported: %v", err)
+	}
+	_, err = load(t, "", nil, "-dbtype=memory", "-smtpencryption=ssl", "-smtpport=70000")
+	if err == nil || !strings.Contains(err.Error(), "smtp_encryption") || !strings.Contains(err.Error(), "smtp_port") {
+		t.Errorf("invalid SMTP settings are not reported: %v", err)
+	}
+}
+
+func TestWriteYAML(t *testing.T) {
+	c, err := load(t, "", nil, "-dbtype=memory", "-dbpwd=topsecret", "-apikeys=key1,key2", "-mailfrom=hotel@here.ca")
+	if err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	var buf bytes.Buffer
+	if err := c.WriteYAML(&buf); err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+	out := buf.String()
+
+	for _, secret := range []string{"topsecret", "key1", "key2"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("secret %q is not redacted:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "db_password: '******'") || !strings.Contains(out, "smtp_password: \"\"") {
+		t.Errorf("unexpected redaction:\n%s", out)
+	}
+
+	// the output is a valid config file
+	printed, err := load(t, out, nil)
+	if err != nil {
+		t.Fatalf("printed configuration cannot be loaded: %q", err)
+	}
+	if printed.DBType != "memory" || printed.MailFrom != "hotel@here.ca" || printed.DBTimeout != c.DBTimeout {
+		t.Errorf("printed configuration differs: %+v", printed)
+	}
+}
@@ -15,20 +15,23 @@ type DB struct {
 
 var dbConn = &DB{}
 
-const maxOpenDbConn = 10
-const maxIdleDbConn = 5
-const maxDbLifeTime = 5 * time.Minute
+// PoolConfig holds the limits of the connection pool
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
 
 // ConnectSQL creates database pool for Postgres
-func ConnectSQL(dsn string) (*DB, error) {
+func ConnectSQL(dsn string, pool PoolConfig) (*DB, error) {
 	d, err := NewDatabase(dsn)
 	if err != nil {
 		panic(err)
 	}
 
-	d.SetMaxOpenConns(maxOpenDbConn)
-	d.SetMaxIdleConns(maxIdleDbConn)
-	d.SetConnMaxLifetime(maxDbLifeTime)
+	d.SetMaxOpenConns(pool.MaxOpenConns)
+	d.SetMaxIdleConns(pool.MaxIdleConns)
+	d.SetConnMaxLifetime(pool.ConnMaxLifetime)
 
 	dbConn.SQL = d
 
@@ -3,7 +3,11 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/asaskevich/govalidator"
 )
@@ -58,3 +62,71 @@ func (f *Form) IsEmail(field string) bool {
 	}
 	return true
 }
+
+var slugRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+var priceRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,2})?$`)
+
+// IsSlug checks that field consists of lowercase letters and digits separated by single hyphens
+func (f *Form) IsSlug(field string) bool {
+	if !slugRegexp.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Only lowercase letters, digits and hyphens are allowed.")
+		return false
+	}
+	return true
+}
+
+// MinInt checks that field is an integer not less than min
+func (f *Form) MinInt(field string, min int) bool {
+	n, err := strconv.Atoi(f.Get(field))
+	if err != nil || n < min {
+		f.Errors.Add(field, fmt.Sprintf("This field must be a whole number not less than %d.", min))
+		return false
+	}
+	return true
+}
+
+// IsPrice checks that field is a non-negative amount with at most two decimal places
+func (f *Form) IsPrice(field string) bool {
+	if !priceRegexp.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Invalid price")
+		return false
+	}
+	return true
+}
+
+// MinPasswordLength is the least number of characters in a password accepted by IsStrongPassword
+const MinPasswordLength = 10
+
+// IsStrongPassword checks that field is at least MinPasswordLength characters long and has at least three
+// kinds of characters out of lowercase letters, uppercase letters, digits and the others
+func (f *Form) IsStrongPassword(field string) bool {
+	password := f.Get(field)
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if utf8.RuneCountInString(password) < MinPasswordLength || lower+upper+digit+other < 3 {
+		f.Errors.Add(field, fmt.Sprintf("Use at least %d characters with three of these: lowercase letters, "+
+			"uppercase letters, digits and symbols.", MinPasswordLength))
+		return false
+	}
+	return true
+}
+
+// Matches checks that field has the same value as the other one, e.g. password confirmation
+func (f *Form) Matches(field, other string) bool {
+	if f.Get(field) != f.Get(other) {
+		f.Errors.Add(field, "The values do not match.")
+		return false
+	}
+	return true
+}
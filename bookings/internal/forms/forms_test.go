@@ -123,3 +123,96 @@ func TestForm_IsEmail(t *testing.T) {
 		}
 	}
 }
+
+func TestForm_IsSlug(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"generals-quoters", true},
+		{"room2", true},
+		{"Generals", false},
+		{"double--hyphen", false},
+		{"-leading", false},
+		{"with space", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		form := New(url.Values{"slug": {test.value}})
+		if form.IsSlug("slug") != test.expected || form.Valid() != test.expected {
+			t.Errorf("%q: expected slug validity %t", test.value, test.expected)
+		}
+	}
+}
+
+func TestForm_MinInt(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"1", true},
+		{"12", true},
+		{"0", false},
+		{"1.5", false},
+		{"abc", false},
+	}
+	for _, test := range tests {
+		form := New(url.Values{"capacity": {test.value}})
+		if form.MinInt("capacity", 1) != test.expected || form.Valid() != test.expected {
+			t.Errorf("%q: expected validity %t", test.value, test.expected)
+		}
+	}
+}
+
+func TestForm_IsPrice(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"129", true},
+		{"129.5", true},
+		{"129.99", true},
+		{"129.999", false},
+		{"-1", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		form := New(url.Values{"price": {test.value}})
+		if form.IsPrice("price") != test.expected || form.Valid() != test.expected {
+			t.Errorf("%q: expected price validity %t", test.value, test.expected)
+		}
+	}
+}
+
+func TestForm_IsStrongPassword(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"Correct-Horse", true},
+		{"correct horse 42", true},
+		{"correcthorse42", false},
+		{"Ünïcödé-pass", true},
+		{"Short-1", false},
+		{"correcthorsebattery", false},
+		{"CORRECT HORSE", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		form := New(url.Values{"password": {test.value}})
+		if form.IsStrongPassword("password") != test.expected || form.Valid() != test.expected {
+			t.Errorf("%q: expected password strength %t", test.value, test.expected)
+		}
+	}
+}
+
+func TestForm_Matches(t *testing.T) {
+	form := New(url.Values{"password": {"Correct-Horse"}, "confirm_password": {"Correct-Horse"}})
+	if !form.Matches("confirm_password", "password") || !form.Valid() {
+		t.Error("same values do not match")
+	}
+	form = New(url.Values{"password": {"Correct-Horse"}, "confirm_password": {"correct-horse"}})
+	if form.Matches("confirm_password", "password") || form.Errors.Get("confirm_password") == "" {
+		t.Error("different values match")
+	}
+}
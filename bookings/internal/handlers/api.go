@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/forms"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/helpers"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// apiDateLayout is the format of dates in the JSON API
+const apiDateLayout = "2006-01-02"
+
+// maxAPIBodySize limits the size of JSON request bodies
+const maxAPIBodySize = 1 << 20
+
+// apiRoom is a room as returned by the JSON API
+type apiRoom struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Slug        string   `json:"slug"`
+	Description string   `json:"description"`
+	Capacity    int      `json:"capacity"`
+	Amenities   []string `json:"amenities"`
+	// PricePerNight is in cents
+	PricePerNight int `json:"price_per_night"`
+}
+
+func newAPIRoom(r models.Room) apiRoom {
+	amenities := r.Amenities
+	if amenities == nil {
+		amenities = []string{}
+	}
+	return apiRoom{
+		ID:            r.ID,
+		Name:          r.RoomName,
+		Slug:          r.Slug,
+		Description:   r.Description,
+		Capacity:      r.Capacity,
+		Amenities:     amenities,
+		PricePerNight: r.BasePrice,
+	}
+}
+
+// apiAvailability is the response of the availability query
+type apiAvailability struct {
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+	Rooms     []apiRoom `json:"rooms"`
+}
+
+// apiReservation is a reservation as returned by the JSON API
+type apiReservation struct {
+	Reference        string `json:"reference"`
+	ConfirmationCode string `json:"confirmation_code"`
+	Status           string `json:"status"`
+	RoomID           int    `json:"room_id"`
+	RoomName         string `json:"room_name"`
+	StartDate        string `json:"start_date"`
+	EndDate          string `json:"end_date"`
+	FirstName        string `json:"first_name"`
+	LastName         string `json:"last_name"`
+	Email            string `json:"email"`
+	Phone            string `json:"phone"`
+	CanCancel        bool   `json:"can_cancel"`
+}
+
+func (m *Repository) newAPIReservation(res models.Reservation) apiReservation {
+	return apiReservation{
+		Reference:        res.Reference,
+		ConfirmationCode: res.ConfirmationCode,
+		Status:           string(res.Status),
+		RoomID:           res.RoomId,
+		RoomName:         res.Room.RoomName,
+		StartDate:        res.StartDate.Format(apiDateLayout),
+		EndDate:          res.EndDate.Format(apiDateLayout),
+		FirstName:        res.FirstName,
+		LastName:         res.LastName,
+		Email:            res.Email,
+		Phone:            res.Phone,
+		CanCancel:        m.guestCanCancel(res),
+	}
+}
+
+// apiReservationRequest is the body of the request creating a reservation
+type apiReservationRequest struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+}
+
+// apiServerError logs the error and responds with the generic internal server error
+func apiServerError(w http.ResponseWriter, err error) {
+	log.Println(err)
+	helpers.JSONError(w, http.StatusInternalServerError, "Internal server error", nil)
+}
+
+// parseAPIStay parses the dates of a stay adding the errors to the form. The stay must be at least one night long
+func parseAPIStay(form *forms.Form, startField, endField string) (time.Time, time.Time) {
+	start, err := time.Parse(apiDateLayout, form.Get(startField))
+	if err != nil {
+		form.Errors.Add(startField, "Invalid date, use YYYY-MM-DD format")
+	}
+	end, err := time.Parse(apiDateLayout, form.Get(endField))
+	if err != nil {
+		form.Errors.Add(endField, "Invalid date, use YYYY-MM-DD format")
+	}
+	if form.Valid() && !end.After(start) {
+		form.Errors.Add(endField, "The end date must be after the start date")
+	}
+	return start, end
+}
+
+// APINotFound responds to requests for unknown API endpoints
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	helpers.JSONError(w, http.StatusNotFound, "Not found", nil)
+}
+
+// APIMethodNotAllowed responds to requests with a method the API endpoint does not support
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	helpers.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+}
+
+// APIRooms lists all rooms
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+	result := []apiRoom{}
+	for _, room := range rooms {
+		result = append(result, newAPIRoom(room))
+	}
+	helpers.WriteJSON(w, http.StatusOK, result)
+}
+
+// APIAvailability lists rooms available from the "start" till the "end" date. Optional "room_id"
+// narrows the result down to one room
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+	start, end := parseAPIStay(form, "start", "end")
+	roomID := 0
+	if form.Has("room_id") {
+		var err error
+		roomID, err = strconv.Atoi(form.Get("room_id"))
+		if err != nil {
+			form.Errors.Add("room_id", "Invalid room id")
+		}
+	}
+	if !form.Valid() {
+		helpers.JSONError(w, http.StatusUnprocessableEntity, "Invalid query", form.Errors)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), start, end)
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+	result := apiAvailability{
+		StartDate: start.Format(apiDateLayout),
+		EndDate:   end.Format(apiDateLayout),
+		Rooms:     []apiRoom{},
+	}
+	for _, room := range available {
+		if roomID == 0 || room.ID == roomID {
+			result.Rooms = append(result.Rooms, newAPIRoom(room))
+		}
+	}
+	helpers.WriteJSON(w, http.StatusOK, result)
+}
+
+// APICreateReservation books a room. The response holds the reservation together with
+// the reference by which the client fetches or cancels it later
+func (m *Repository) APICreateReservation(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		helpers.JSONError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json", nil)
+		return
+	}
+	var in apiReservationRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		helpers.JSONError(w, http.StatusBadRequest, "Invalid JSON body", nil)
+		return
+	}
+
+	form := forms.New(url.Values{
+		"start_date": {in.StartDate},
+		"end_date":   {in.EndDate},
+		"first_name": {strings.TrimSpace(in.FirstName)},
+		"last_name":  {strings.TrimSpace(in.LastName)},
+		"email":      {strings.TrimSpace(in.Email)},
+		"phone":      {strings.TrimSpace(in.Phone)},
+	})
+	start, end := parseAPIStay(form, "start_date", "end_date")
+	if form.Valid() && start.Before(time.Now().Truncate(24*time.Hour)) {
+		form.Errors.Add("start_date", "The start date cannot be in the past")
+	}
+	form.Required("first_name", "last_name", "email", "phone")
+	form.MinLength("first_name", 3)
+	form.MinLength("last_name", 3)
+	form.IsEmail("email")
+	form.MinLength("phone", 8)
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+	var room models.Room
+	for _, rm := range rooms {
+		if rm.ID == in.RoomID {
+			room = rm
+		}
+	}
+	if room.ID == 0 {
+		form.Errors.Add("room_id", "Unknown room")
+	}
+	if !form.Valid() {
+		helpers.JSONError(w, http.StatusUnprocessableEntity, "Invalid reservation", form.Errors)
+		return
+	}
+
+	res := models.Reservation{
+		FirstName: form.Get("first_name"),
+		LastName:  form.Get("last_name"),
+		Email:     form.Get("email"),
+		Phone:     form.Get("phone"),
+		StartDate: start,
+		EndDate:   end,
+		RoomId:    room.ID,
+		Room:      room,
+		Status:    models.StatusPending,
+	}
+	res.ConfirmationCode, err = helpers.NewConfirmationCode()
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+	res.Reference, err = helpers.NewReference()
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+
+	res.ID, err = m.DB.CreateReservation(r.Context(), res, reservationMails(res))
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		helpers.JSONError(w, http.StatusConflict, "The room is not available for the requested dates", nil)
+		return
+	}
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/reservations/"+res.Reference)
+	helpers.WriteJSON(w, http.StatusCreated, m.newAPIReservation(res))
+}
+
+// apiReservation gets the reservation by the reference from URL. If there is no such reservation,
+// it writes the error response and returns false
+func (m *Repository) apiReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	res, err := m.DB.GetReservationByReference(r.Context(), chi.URLParam(r, "reference"))
+	if errors.Is(err, repository.ErrReservationNotFound) {
+		helpers.JSONError(w, http.StatusNotFound, "Reservation not found", nil)
+		return res, false
+	}
+	if err != nil {
+		apiServerError(w, err)
+		return res, false
+	}
+	return res, true
+}
+
+// APIReservation returns the reservation with the reference from URL
+func (m *Repository) APIReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservation(w, r)
+	if !ok {
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, m.newAPIReservation(res))
+}
+
+// APICancelReservation cancels the reservation with the reference from URL. Like guests on the
+// "Manage Booking" page, API clients cannot cancel within the cancellation window before arrival
+func (m *Repository) APICancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservation(w, r)
+	if !ok {
+		return
+	}
+	const errMsg = "The reservation can no longer be cancelled"
+	if !m.guestCanCancel(res) {
+		helpers.JSONError(w, http.StatusConflict, errMsg, nil)
+		return
+	}
+
+	err := m.DB.UpdateReservationStatus(r.Context(), res.ID, models.StatusCancelled, 0, cancellationMails(res)...)
+	if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		helpers.JSONError(w, http.StatusConflict, errMsg, nil)
+		return
+	}
+	if err != nil {
+		apiServerError(w, err)
+		return
+	}
+
+	res.Status = models.StatusCancelled
+	helpers.WriteJSON(w, http.StatusOK, m.newAPIReservation(res))
+}
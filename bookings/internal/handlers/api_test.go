@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPI(t *testing.T) {
+	ts := httptest.NewTLSServer(getRoutes())
+	defer ts.Close()
+
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	nextWeek := time.Now().AddDate(0, 0, 7).Format("2006-01-02")
+	reservation := func(roomID, lastName string) string {
+		return `{"room_id": ` + roomID + `, "start_date": "` + tomorrow + `", "end_date": "` + nextWeek + `",
+			"first_name": "John", "last_name": "` + lastName + `", "email": "john@smith.com", "phone": "1234567890"}`
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		contentType    string
+		body           string
+		fetchError     bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"rooms", "GET", "/api/v1/rooms", "", "", false, http.StatusOK, `"slug"`},
+		{"rooms db error", "GET", "/api/v1/rooms", "", "", true, http.StatusInternalServerError, `"error": "Internal server error"`},
+		{"availability", "GET", "/api/v1/availability?start=2060-01-05&end=2060-01-06", "", "", false, http.StatusOK, `"name": "General's Quarters"`},
+		{"availability of room", "GET", "/api/v1/availability?start=2060-01-05&end=2060-01-06&room_id=2", "", "", false, http.StatusOK, `"rooms": []`},
+		{"availability invalid dates", "GET", "/api/v1/availability?start=2060-01-05&end=2060-01-05", "", "", false, http.StatusUnprocessableEntity, "The end date must be after the start date"},
+		{"availability invalid start", "GET", "/api/v1/availability?start=x&end=2060-01-05", "", "", false, http.StatusUnprocessableEntity, `"start"`},
+		{"availability invalid room", "GET", "/api/v1/availability?start=2060-01-05&end=2060-01-06&room_id=x", "", "", false, http.StatusUnprocessableEntity, "Invalid room id"},
+		{"availability db error", "GET", "/api/v1/availability?start=2023-01-01&end=2023-01-02", "", "", false, http.StatusInternalServerError, "Internal server error"},
+		{"create", "POST", "/api/v1/reservations", "application/json; charset=utf-8", reservation("1", "Smith"), false, http.StatusCreated, `"status": "pending"`},
+		{"create not json", "POST", "/api/v1/reservations", "application/x-www-form-urlencoded", "room_id=1", false, http.StatusUnsupportedMediaType, "Content-Type must be application/json"},
+		{"create invalid json", "POST", "/api/v1/reservations", "application/json", `{"room_id": "one"}`, false, http.StatusBadRequest, "Invalid JSON body"},
+		{"create unknown field", "POST", "/api/v1/reservations", "application/json", `{"room": 1}`, false, http.StatusBadRequest, "Invalid JSON body"},
+		{"create invalid", "POST", "/api/v1/reservations", "application/json", `{"room_id": 5, "start_date": "2020-01-01", "end_date": "2020-01-05"}`, false, http.StatusUnprocessableEntity, `"room_id": [`},
+		{"create in the past", "POST", "/api/v1/reservations", "application/json", `{"room_id": 1, "start_date": "2020-01-01", "end_date": "2020-01-05"}`, false, http.StatusUnprocessableEntity, "The start date cannot be in the past"},
+		{"create room taken", "POST", "/api/v1/reservations", "application/json", reservation("1", "Taken"), false, http.StatusConflict, "The room is not available for the requested dates"},
+		{"create db error", "POST", "/api/v1/reservations", "application/json", reservation("2", "Smith"), false, http.StatusInternalServerError, "Internal server error"},
+		{"get", "GET", "/api/v1/reservations/abc", "", "", false, http.StatusOK, `"reference": "abc"`},
+		{"get missing", "GET", "/api/v1/reservations/missing", "", "", false, http.StatusNotFound, "Reservation not found"},
+		{"get db error", "GET", "/api/v1/reservations/error", "", "", false, http.StatusInternalServerError, "Internal server error"},
+		{"cancel", "POST", "/api/v1/reservations/abc/cancel", "", "", false, http.StatusOK, `"status": "cancelled"`},
+		{"cancel missing", "POST", "/api/v1/reservations/missing/cancel", "", "", false, http.StatusNotFound, "Reservation not found"},
+		{"cancel within window", "POST", "/api/v1/reservations/tomorrow/cancel", "", "", false, http.StatusConflict, "The reservation can no longer be cancelled"},
+		{"cancel cancelled", "POST", "/api/v1/reservations/cancelled/cancel", "", "", false, http.StatusConflict, "The reservation can no longer be cancelled"},
+		{"cancel invalid transition", "POST", "/api/v1/reservations/invalid-transition/cancel", "", "", false, http.StatusConflict, "The reservation can no longer be cancelled"},
+		{"cancel db error", "POST", "/api/v1/reservations/cancel-error/cancel", "", "", false, http.StatusInternalServerError, "Internal server error"},
+		{"unknown endpoint", "GET", "/api/v1/eggs", "", "", false, http.StatusNotFound, `"error": "Not found"`},
+		{"wrong method", "DELETE", "/api/v1/rooms", "", "", false, http.StatusMethodNotAllowed, `"error": "Method not allowed"`},
+	}
+
+	for _, e := range tests {
+		fetchError = e.fetchError
+		req, _ := http.NewRequest(e.method, ts.URL+e.url, strings.NewReader(e.body))
+		if e.contentType != "" {
+			req.Header.Set("Content-Type", e.contentType)
+		}
+		resp, err := ts.Client().Do(req)
+		fetchError = false
+		if err != nil {
+			t.Errorf("%s: error running request: %q", e.name, err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != e.expectedStatus {
+			t.Errorf("%s: bad status code. Expected %d, but got %d", e.name, e.expectedStatus, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: bad content type %q", e.name, ct)
+		}
+		if !strings.Contains(string(body), e.expectedBody) {
+			t.Errorf("%s: expected %q in the body, but got %s", e.name, e.expectedBody, body)
+		}
+	}
+}
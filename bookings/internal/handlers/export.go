@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/xlsx"
+)
+
+// exportFlushRows is the number of rows after which an export is flushed to the client
+const exportFlushRows = 200
+
+// exportColumn is a column that can be chosen for a reservation export. Value returns a string, an int
+// or a time.Time, which is written as a date
+type exportColumn struct {
+	Name  string
+	Title string
+	Value func(r models.Reservation) any
+}
+
+// exportColumns returns the columns of a reservation export in the order they are written
+func exportColumns() []exportColumn {
+	return []exportColumn{
+		{"id", "ID", func(r models.Reservation) any { return r.ID }},
+		{"confirmation_code", "Confirmation code", func(r models.Reservation) any { return r.ConfirmationCode }},
+		{"first_name", "First name", func(r models.Reservation) any { return r.FirstName }},
+		{"last_name", "Last name", func(r models.Reservation) any { return r.LastName }},
+		{"email", "Email", func(r models.Reservation) any { return r.Email }},
+		{"phone", "Phone", func(r models.Reservation) any { return r.Phone }},
+		{"room", "Room", func(r models.Reservation) any { return r.Room.RoomName }},
+		{"arrival", "Arrival", func(r models.Reservation) any { return r.StartDate }},
+		{"departure", "Departure", func(r models.Reservation) any { return r.EndDate }},
+		{"nights", "Nights", func(r models.Reservation) any { return r.Nights() }},
+		{"status", "Status", func(r models.Reservation) any { return r.Status.Title() }},
+		{"booked", "Booked", func(r models.Reservation) any { return r.CreatedAt }},
+	}
+}
+
+// rowWriter writes the rows of an export in one of the file formats
+type rowWriter interface {
+	WriteHeader(titles ...string) error
+	WriteRow(cells ...any) error
+	Flush() error
+	Close() error
+}
+
+// exportFormats holds the content type, the file extension and the row writer of each export format
+var exportFormats = map[string]struct {
+	contentType string
+	newWriter   func(w io.Writer) (rowWriter, error)
+}{
+	"csv": {"text/csv", func(w io.Writer) (rowWriter, error) {
+		return &csvRowWriter{csv.NewWriter(w)}, nil
+	}},
+	"xlsx": {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", func(w io.Writer) (rowWriter, error) {
+		return xlsx.NewWriter(w, "Reservations")
+	}},
+}
+
+// csvRowWriter writes the rows of an export as CSV
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvRowWriter) WriteHeader(titles ...string) error {
+	return c.w.Write(titles)
+}
+
+func (c *csvRowWriter) WriteRow(cells ...any) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		switch v := cell.(type) {
+		case int:
+			record[i] = strconv.Itoa(v)
+		case time.Time:
+			record[i] = v.Format("2006-01-02")
+		case string:
+			// spreadsheets run cells starting like a formula, which guests could fill in on the booking form
+			if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+				v = "'" + v
+			}
+			record[i] = v
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvRowWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvRowWriter) Close() error {
+	return c.Flush()
+}
+
+// AdminExportReservations downloads the reservations selected by the filter of the reservation lists
+// (see adminReservationList, paging aside) as CSV or XLSX, chosen by the format query parameter.
+// The col parameters choose the columns, all of them by default. The rows are streamed as they are
+// read from the database, so an export of any size is never held in memory. That takes a response writer
+// that is not buffered by the session middleware and is an http.Flusher, which the route is given
+func (m *Repository) AdminExportReservations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format, ok := exportFormats[query.Get("format")]
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Invalid export format")
+		http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+		return
+	}
+	filter, errMsg := reservationFilter(query)
+	if errMsg != "" {
+		m.App.Session.Put(r.Context(), "error", errMsg)
+		http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+		return
+	}
+	filter.Page, filter.PageSize = 1, 0
+
+	columns := exportColumns()
+	if names := query["col"]; len(names) > 0 {
+		chosen := map[string]bool{}
+		for _, name := range names {
+			chosen[name] = true
+		}
+		var selected []exportColumn
+		for _, c := range columns {
+			if chosen[c.Name] {
+				selected = append(selected, c)
+				delete(chosen, c.Name)
+			}
+		}
+		if len(chosen) > 0 {
+			m.App.Session.Put(r.Context(), "error", "Invalid export column")
+			http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+			return
+		}
+		columns = selected
+	}
+
+	// the response is started with the first row, so that a failing query can still redirect
+	var rw rowWriter
+	rows := 0
+	start := func() error {
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="reservations-%s.%s"`,
+			time.Now().Format("2006-01-02"), query.Get("format")))
+		var err error
+		if rw, err = format.newWriter(w); err != nil {
+			return err
+		}
+		titles := make([]string, len(columns))
+		for i, c := range columns {
+			titles[i] = c.Title
+		}
+		return rw.WriteHeader(titles...)
+	}
+	err := m.DB.ExportReservations(r.Context(), filter, func(res models.Reservation) error {
+		if rw == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		cells := make([]any, len(columns))
+		for i, c := range columns {
+			cells[i] = c.Value(res)
+		}
+		if err := rw.WriteRow(cells...); err != nil {
+			return err
+		}
+		// the first row goes out at once, so that the download starts without waiting for the next ones
+		if rows++; rows == 1 || rows%exportFlushRows == 0 {
+			if err := rw.Flush(); err != nil {
+				return err
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		if rw == nil {
+			m.App.Session.Put(r.Context(), "error", "Error exporting reservations from DB")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+			return
+		}
+		// the download has started: abort the connection, so that the file is not taken for a complete one
+		panic(http.ErrAbortHandler)
+	}
+	if rw == nil {
+		if err = start(); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	if err = rw.Close(); err != nil {
+		log.Println(err)
+	}
+}
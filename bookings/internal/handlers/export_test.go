@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepository_AdminExportReservations(t *testing.T) {
+	tests := []struct {
+		name             string
+		query            string
+		dbFetchError     bool
+		expectedStatus   int
+		expectedLocation string
+		expectedError    string
+	}{
+		{"csv", "?format=csv", false, http.StatusOK, "", ""},
+		{"xlsx", "?format=xlsx", false, http.StatusOK, "", ""},
+		{"no-format", "", false, http.StatusSeeOther, "/admin/reservations-all", "Invalid export format"},
+		{"bad-format", "?format=pdf", false, http.StatusSeeOther, "/admin/reservations-all", "Invalid export format"},
+		{"bad-filter", "?format=csv&room=x", false, http.StatusSeeOther, "/admin/reservations-all", "Invalid room id"},
+		{"bad-column", "?format=csv&col=id&col=price", false, http.StatusSeeOther, "/admin/reservations-all",
+			"Invalid export column"},
+		{"db-error", "?format=csv", true, http.StatusTemporaryRedirect, "/admin/dashboard",
+			"Error exporting reservations from DB"},
+	}
+
+	for _, e := range tests {
+		fetchError = e.dbFetchError
+		req, _ := http.NewRequest("GET", "/admin/reservations-export"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminExportReservations).ServeHTTP(rr, req)
+		fetchError = false
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		} else if cd := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="reservations-`) {
+			t.Errorf("%s: unexpected Content-Disposition %q", e.name, cd)
+		}
+		if errStr := session.PopString(ctx, "error"); errStr != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, errStr)
+		}
+	}
+}
+
+func TestRepository_AdminExportReservationsCSV(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-export?format=csv&sort=id&col=nights&col=id&col=room&col=arrival", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminExportReservations).ServeHTTP(rr, req)
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %q", err)
+	}
+	// the columns are in their usual order, whatever the order of the parameters
+	expected := [][]string{
+		{"ID", "Room", "Arrival", "Nights"},
+		{"2", "Major's Suite", "2060-01-20", "2"},
+		{"1", "General's Quoters", "2060-01-10", "2"},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected %v but got %v", expected, records)
+	}
+
+	// the filter applies, but paging does not
+	req, _ = http.NewRequest("GET", "/admin/reservations-export?format=csv&status=pending&size=10&page=5", nil)
+	req = req.WithContext(getCtx(req))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminExportReservations).ServeHTTP(rr, req)
+	records, _ = csv.NewReader(rr.Body).ReadAll()
+	if len(records) != 2 || records[1][2] != "John" || len(records[0]) != len(exportColumns()) {
+		t.Errorf("expected the header of all columns and John's reservation but got %v", records)
+	}
+}
+
+func TestRepository_AdminExportReservationsXLSX(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-export?format=xlsx&q=jane", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminExportReservations).ServeHTTP(rr, req)
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	body := rr.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("invalid XLSX: %q", err)
+	}
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			r, _ := f.Open()
+			b, _ := io.ReadAll(r)
+			sheet = string(b)
+		}
+	}
+	for _, expected := range []string{">Confirmation code<", ">jane@smith.com<", `<c r="A2"><v>2</v></c>`} {
+		if !strings.Contains(sheet, expected) {
+			t.Errorf("expected %s in the sheet %s", expected, sheet)
+		}
+	}
+	if strings.Contains(sheet, "john@smith.com") {
+		t.Error("the sheet has a reservation the filter does not select")
+	}
+}
+
+func TestCSVRowWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &csvRowWriter{csv.NewWriter(&buf)}
+	_ = w.WriteRow("=HYPERLINK(\"x\")", "+1 555", "-", "@me", "Smith", 3, time.Date(2060, 1, 2, 0, 0, 0, 0, time.UTC))
+	_ = w.Close()
+
+	expected := `"'=HYPERLINK(""x"")",'+1 555,'-,'@me,Smith,3,2060-01-02` + "\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q but got %q", expected, buf.String())
+	}
+}
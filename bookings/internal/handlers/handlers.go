@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -14,10 +20,13 @@ import (
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/forms"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/helpers"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/passwordreset"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/render"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository/dbrepo"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/twofactor"
 	"github.com/go-chi/chi/v5"
+	"github.com/skip2/go-qrcode"
 )
 
 // Repo is the repository used by the handlers
@@ -37,6 +46,14 @@ func NewRepo(ac *config.AppConfig, db *driver.DB) *Repository {
 	}
 }
 
+// NewMemoryRepo creates a new repository backed by the in-memory database
+func NewMemoryRepo(ac *config.AppConfig) *Repository {
+	return &Repository{
+		App: ac,
+		DB:  dbrepo.NewMemoryRepo(ac),
+	}
+}
+
 // NewHandlers sets the repository for the handlers
 func NewHandlers(r *Repository) {
 	Repo = r
@@ -52,14 +69,41 @@ func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "about.page.gohtml", &models.TemplateData{})
 }
 
-// Generals is Generals' Quoters page handler
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.gohtml", &models.TemplateData{})
+// Rooms is the room catalog page handler
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error getting rooms from DB")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	data := map[string]any{}
+	data["rooms"] = rooms
+	render.Template(w, r, "rooms.page.gohtml", &models.TemplateData{Data: data})
+}
+
+// Room shows the room with the slug from URL
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if errors.Is(err, repository.ErrRoomNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error getting room from DB")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	data := map[string]any{}
+	data["room"] = room
+	render.Template(w, r, "room.page.gohtml", &models.TemplateData{Data: data})
 }
 
-// Majors is Majors' Suite page handler
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.gohtml", &models.TemplateData{})
+// RoomRedirect permanently redirects former hand-written room pages to the room catalog
+func (m *Repository) RoomRedirect(slug string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/rooms/"+slug, http.StatusMovedPermanently)
+	}
 }
 
 // Availability is Search Availability page hander
@@ -97,7 +141,7 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	available, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	available, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Error searching availability in DB")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -172,7 +216,7 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 		jsonError(err, "Error parsing room id")
 		return
 	}
-	available, err := m.DB.SearchAvailabilityByDatesAndRoomID(startDate, endDate, roomID)
+	available, err := m.DB.SearchAvailabilityByDatesAndRoomID(r.Context(), startDate, endDate, roomID)
 	if err != nil {
 		jsonError(err, "Error searching availability")
 		return
@@ -209,7 +253,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	strMap["end_date"] = ed
 
 	var err error
-	reservation.Room, err = m.DB.GetRoomByID(reservation.RoomId)
+	reservation.Room, err = m.DB.GetRoomByID(r.Context(), reservation.RoomId)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot find room in DB")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -261,7 +305,7 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 			errStr = fmt.Sprintf("%s – %s <br>", k, v)
 		}
 		m.App.Session.Put(r.Context(), "error", errStr)
-		//http.Error(w, "Invalid form!", http.StatusBadRequest)
+		w.WriteHeader(http.StatusBadRequest)
 		render.Template(w, r, "make-reservation.page.gohtml", &models.TemplateData{
 			Form: form,
 			Data: data,
@@ -269,29 +313,38 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	reservation.ConfirmationCode, err = helpers.NewConfirmationCode()
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Error inserting reservation to DB")
+		m.App.Session.Put(r.Context(), "error", "Error generating confirmation code")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomId,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+	reservation.Reference, err = helpers.NewReference()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error generating booking reference")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	_, err = m.DB.CreateReservation(r.Context(), reservation, reservationMails(reservation))
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room has just been booked for some of the dates you chose. Please search for other dates or rooms.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Error inserting room restriction to DB")
+		m.App.Session.Put(r.Context(), "error", "Error inserting reservation to DB")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	// Send an email notification to guest
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+// reservationMails returns the confirmation of a new reservation to the guest and the notification to the owner
+func reservationMails(res models.Reservation) []models.MailData {
+	// Email notification to guest
 	htmlMessage := fmt.Sprintf(`
 		<strong>Reservation Confirmation</strong>
 		<br>
@@ -299,21 +352,22 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		<br><br>	
 		This is to confirm your reservation from %s to %s of %s room in our fantastic Room&Breakfast hotel.
 		<br><br>
+		Your confirmation code is <strong>%s</strong>. You can view, change or cancel your reservation
+		on the "Manage Booking" page of our site using this code and your email address.
+		<br><br>
 		Sincerely,<br>
 		Honel's administration<br>
 		admin@room&breakfast.com
-	`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"),
-		reservation.Room.RoomName)
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "admin@room&breakfast.com",
+	`, res.FirstName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"),
+		res.Room.RoomName, res.ConfirmationCode)
+	guestMsg := models.MailData{
+		To:       res.Email,
 		Subject:  "Room reservation confirmation",
 		Content:  htmlMessage,
 		Template: "basic.html",
 	}
-	m.App.MailChan <- msg
 
-	// Send email notifictaion to hotel's owner
+	// Email notification to hotel's owner
 	htmlMessage = fmt.Sprintf(`
 		<strong>Reservation Confirmation</strong>
 		<br><br>	
@@ -325,19 +379,15 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		<br><br>
 		Please do the necessary preparations,<br>
 		admin@room&breakfast.com
-	`, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"),
-		reservation.Room.RoomName, reservation.FirstName, reservation.LastName,
-		reservation.Email, reservation.Phone)
-	msg = models.MailData{
+	`, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"),
+		res.Room.RoomName, res.FirstName, res.LastName,
+		res.Email, res.Phone)
+	ownerMsg := models.MailData{
 		To:      "admin@room&breakfast.com",
-		From:    "admin@room&breakfast.com",
 		Subject: "Room reservation has been made",
 		Content: htmlMessage,
 	}
-	m.App.MailChan <- msg
-
-	m.App.Session.Put(r.Context(), "reservation", reservation)
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	return []models.MailData{guestMsg, ownerMsg}
 }
 
 // Contact is Contact page handler
@@ -368,6 +418,253 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// MyBooking shows the form where guests enter their confirmation code and email to find their reservation
+func (m *Repository) MyBooking(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "my-booking.page.gohtml", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostMyBooking looks up the guest's reservation by confirmation code and email
+// and remembers it in the session for the "manage my booking" pages
+func (m *Repository) PostMyBooking(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.RenewToken(r.Context())
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error parsing form.")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("confirmation_code", "email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Template(w, r, "my-booking.page.gohtml", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	// wrong lookups count as failed logins from the IP address, so the codes cannot be guessed
+	now := time.Now()
+	attempt := models.LoginAttempt{
+		Email:     strings.ToLower(strings.TrimSpace(form.Get("email"))),
+		IP:        helpers.ClientIP(r),
+		CreatedAt: now,
+	}
+	_, ipPolicy := m.loginPolicies()
+	failures, last, err := m.DB.LoginFailuresFromIP(r.Context(), attempt.IP, now.Add(-m.App.LoginLockout))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if now.Before(last.Add(ipPolicy.Wait(failures))) {
+		attempt.Reason = models.LoginFailedThrottled
+		m.failBookingLookup(w, r, attempt, "Too many failed attempts. Please try again later")
+		return
+	}
+
+	code := helpers.NormalizeConfirmationCode(form.Get("confirmation_code"))
+	res, err := m.DB.GetReservationByConfirmationCode(r.Context(), code, form.Get("email"))
+	if errors.Is(err, repository.ErrReservationNotFound) {
+		attempt.Reason = models.LoginFailedBookingLookup
+		m.failBookingLookup(w, r, attempt, "No reservation found for this confirmation code and email")
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error getting reservation from DB")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "booking_id", res.ID)
+	http.Redirect(w, r, "/my-booking/reservation", http.StatusSeeOther)
+}
+
+// failBookingLookup records the failed lookup for the throttling and sends the guest back to the form
+// with the message
+func (m *Repository) failBookingLookup(w http.ResponseWriter, r *http.Request, attempt models.LoginAttempt, message string) {
+	// the lookup has no account to lock out, so the account policy does not matter
+	if err := m.DB.RecordFailedLogin(r.Context(), attempt, models.LoginPolicy{}); err != nil {
+		log.Println(err)
+	}
+	m.App.Session.Put(r.Context(), "error", message)
+	http.Redirect(w, r, "/my-booking", http.StatusSeeOther)
+}
+
+// guestReservation gets the reservation the guest has looked up before from the DB. If there is none,
+// it puts an error into the session, redirects and returns false
+func (m *Repository) guestReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id := m.App.Session.GetInt(r.Context(), "booking_id")
+	if id == 0 {
+		m.App.Session.Put(r.Context(), "error", "Please enter your confirmation code and email first")
+		http.Redirect(w, r, "/my-booking", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error getting reservation from DB")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return models.Reservation{}, false
+	}
+	return res, true
+}
+
+// guestCanChange reports whether the guest may still change contact details of the reservation
+func guestCanChange(res models.Reservation) bool {
+	return res.Status == models.StatusPending || res.Status == models.StatusConfirmed
+}
+
+// guestCanCancel reports whether the guest may still cancel the reservation online,
+// i.e. it can be cancelled at all and arrival is not within the cancellation window
+func (m *Repository) guestCanCancel(res models.Reservation) bool {
+	return res.Status.CanTransitionTo(models.StatusCancelled) &&
+		!time.Now().Add(m.App.CancellationWindow).After(res.StartDate)
+}
+
+// renderMyBooking renders the guest's reservation together with the contact details form
+func (m *Repository) renderMyBooking(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := map[string]any{}
+	data["reservation"] = res
+	data["can_change"] = guestCanChange(res)
+	data["can_cancel"] = m.guestCanCancel(res)
+	strMap := map[string]string{}
+	strMap["start_date"] = res.StartDate.Format("2006-01-02")
+	strMap["end_date"] = res.EndDate.Format("2006-01-02")
+	strMap["cancel_until"] = res.StartDate.Add(-m.App.CancellationWindow).Format("2006-01-02 15:04")
+	render.Template(w, r, "my-booking-show.page.gohtml", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: strMap,
+	})
+}
+
+// ShowMyBooking shows the guest's reservation
+func (m *Repository) ShowMyBooking(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+	m.renderMyBooking(w, r, res, forms.New(nil))
+}
+
+// PostMyBookingContact updates contact details of the guest's reservation
+func (m *Repository) PostMyBookingContact(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+	if !guestCanChange(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be changed")
+		http.Redirect(w, r, "/my-booking/reservation", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error parsing form.")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Email = r.Form.Get("email")
+	res.Phone = r.Form.Get("phone")
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "phone")
+	form.MinLength("first_name", 3)
+	form.MinLength("last_name", 3)
+	form.IsEmail("email")
+	form.MinLength("phone", 8)
+	if !form.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		m.renderMyBooking(w, r, res, form)
+		return
+	}
+
+	err = m.DB.UpdateReservation(r.Context(), res)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error updating reservation in DB")
+		http.Redirect(w, r, "/my-booking/reservation", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your contact details have been updated")
+	http.Redirect(w, r, "/my-booking/reservation", http.StatusSeeOther)
+}
+
+// PostMyBookingCancel cancels the guest's reservation if arrival is not within the cancellation window
+func (m *Repository) PostMyBookingCancel(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+	const errMsg = "This reservation can no longer be cancelled online. Please contact us."
+	if !m.guestCanCancel(res) {
+		m.App.Session.Put(r.Context(), "error", errMsg)
+		http.Redirect(w, r, "/my-booking/reservation", http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.UpdateReservationStatus(r.Context(), res.ID, models.StatusCancelled, 0, cancellationMails(res)...)
+	if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		m.App.Session.Put(r.Context(), "error", errMsg)
+		http.Redirect(w, r, "/my-booking/reservation", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error cancelling reservation")
+		http.Redirect(w, r, "/my-booking/reservation", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+	http.Redirect(w, r, "/my-booking/reservation", http.StatusSeeOther)
+}
+
+// cancellationMails returns the confirmation of the cancellation to the guest and the notification to the owner
+func cancellationMails(res models.Reservation) []models.MailData {
+	// Email confirmation to guest
+	htmlMessage := fmt.Sprintf(`
+		<strong>Reservation Cancellation</strong>
+		<br>
+		Dear %s,
+		<br><br>
+		This is to confirm that your reservation %s from %s to %s of %s room has been cancelled.
+		<br><br>
+		Sincerely,<br>
+		Honel's administration<br>
+		admin@room&breakfast.com
+	`, res.FirstName, res.ConfirmationCode, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"),
+		res.Room.RoomName)
+	guestMsg := models.MailData{
+		To:       res.Email,
+		Subject:  "Room reservation cancellation",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+
+	// Email notification to hotel's owner
+	htmlMessage = fmt.Sprintf(`
+		<strong>Reservation Cancellation</strong>
+		<br><br>
+		This is to inform your that reservation %s from %s to %s of %s room has been cancelled by the guest %s %s.
+		<br><br>
+		admin@room&breakfast.com
+	`, res.ConfirmationCode, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"),
+		res.Room.RoomName, res.FirstName, res.LastName)
+	ownerMsg := models.MailData{
+		To:      "admin@room&breakfast.com",
+		Subject: "Room reservation has been cancelled",
+		Content: htmlMessage,
+	}
+	return []models.MailData{guestMsg, ownerMsg}
+}
+
 // ChooseRoom takes "id" parameter from URL, gets Reservation from the Session,
 // fill in RoomID with parameter's value, put it back to the Session and redirect
 // to make-reservation page
@@ -416,7 +713,7 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Error getting room from DB")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -457,107 +754,1469 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	email := form.Get("email")
-	password := form.Get("password")
-	id, _, err := m.DB.Authenticate(email, password)
+	now := time.Now()
+	attempt := models.LoginAttempt{
+		Email:     strings.ToLower(strings.TrimSpace(form.Get("email"))),
+		IP:        helpers.ClientIP(r),
+		CreatedAt: now,
+	}
+	accountPolicy, ipPolicy := m.loginPolicies()
+
+	failures, last, err := m.DB.LoginFailuresFromIP(r.Context(), attempt.IP, now.Add(-m.App.LoginLockout))
 	if err != nil {
-		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Invalid login!")
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		helpers.ServerError(w, err)
+		return
+	}
+	if now.Before(last.Add(ipPolicy.Wait(failures))) {
+		attempt.Reason = models.LoginFailedThrottled
+		m.failLogin(w, r, attempt, accountPolicy, "Too many failed logins. Please try again later")
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Successful login!")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	id, _, err := m.DB.Authenticate(r.Context(), attempt.Email, form.Get("password"))
+	switch {
+	case errors.Is(err, repository.ErrInvalidCredentials):
+		attempt.Reason = models.LoginFailedCredentials
+		m.failLogin(w, r, attempt, accountPolicy, "Invalid login!")
+		return
+	case errors.Is(err, repository.ErrAccountLocked):
+		attempt.Reason = models.LoginFailedLocked
+		m.failLogin(w, r, attempt, accountPolicy, "Too many failed logins. Please try again later")
+		return
+	case errors.Is(err, repository.ErrUserInactive):
+		m.App.Session.Put(r.Context(), "error", "Your account has been deactivated")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	case errors.Is(err, repository.ErrPasswordResetRequired):
+		m.App.Session.Put(r.Context(), "error", "Your password has to be reset before you can log in. Use \"Forgot password?\" to get a link by email")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	}
+	user, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if user.TwoFactorEnabled() {
+		// the password is right, but the user is not logged in before the second step
+		m.App.Session.Put(r.Context(), "two_factor_user_id", id)
+		m.App.Session.Put(r.Context(), "two_factor_expires", now.Add(twoFactorLoginTimeout).Unix())
+		http.Redirect(w, r, "/user/two-factor", http.StatusSeeOther)
+		return
+	}
+	m.completeLogin(w, r, user, "Successful login!")
 }
 
-// Logout handles logging the user out
-func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.Destroy(r.Context())
+// completeLogin logs the user in once all the login steps have passed. The failed logins are only
+// forgotten here, so guessing one-time passwords does not restart the count with every right password
+func (m *Repository) completeLogin(w http.ResponseWriter, r *http.Request, user models.User, flash string) {
+	if user.FailedLogins > 0 {
+		if err := m.DB.UnlockUser(r.Context(), user.ID); err != nil {
+			log.Println(err)
+		}
+	}
+
 	_ = m.App.Session.RenewToken(r.Context())
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "user_id", user.ID)
+	m.App.Session.Put(r.Context(), "access_level", user.AccessLevel)
+	m.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.gohtml", &models.TemplateData{})
+// twoFactorLoginTimeout is how long the user has to enter the one-time password after the password
+const twoFactorLoginTimeout = 5 * time.Minute
+
+// twoFactorUser returns the user who has entered the right password and has to pass the second login step.
+// If there is none or the time is up, it sends the user back to the login form and returns false
+func (m *Repository) twoFactorUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	id := m.App.Session.GetInt(r.Context(), "two_factor_user_id")
+	expires := time.Unix(m.App.Session.GetInt64(r.Context(), "two_factor_expires"), 0)
+	if id == 0 || time.Now().After(expires) {
+		m.App.Session.Remove(r.Context(), "two_factor_user_id")
+		m.App.Session.Remove(r.Context(), "two_factor_expires")
+		m.App.Session.Put(r.Context(), "error", "Log in first!")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return models.User{}, false
+	}
+	user, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		helpers.ServerError(w, err)
+		return user, false
+	}
+	if err != nil || !user.Active || !user.TwoFactorEnabled() {
+		m.App.Session.Remove(r.Context(), "two_factor_user_id")
+		m.App.Session.Put(r.Context(), "error", "Your account is not available anymore. Log in again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return user, false
+	}
+	return user, true
 }
 
-// AdminNewReservations shows all new reservations in admin tool
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.NewReservations()
-	if err != nil {
-		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Error getting reservations from DB")
-		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+// ShowTwoFactor shows the form asking for the one-time password after the password has been accepted
+func (m *Repository) ShowTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if _, ok := m.twoFactorUser(w, r); !ok {
 		return
 	}
-	data := map[string]any{}
-	data["reservations"] = reservations
-	render.Template(w, r, "admin-new-reservations.page.gohtml", &models.TemplateData{
-		Data: data,
+	render.Template(w, r, "two-factor.page.gohtml", &models.TemplateData{
+		Form: forms.New(nil),
 	})
 }
 
-// AdminAllReservations shows all reservations in admin tool
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+// PostTwoFactor checks the one-time password or the recovery code and logs the user in. Wrong codes
+// count as failed logins of the account, so they are throttled and lock it out like wrong passwords
+func (m *Repository) PostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
 		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Error getting reservations from DB")
-		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+	}
+	user, ok := m.twoFactorUser(w, r)
+	if !ok {
 		return
 	}
-	data := map[string]any{}
-	data["reservations"] = reservations
-	render.Template(w, r, "admin-all-reservations.page.gohtml", &models.TemplateData{
-		Data: data,
-	})
-}
 
-// AdminShowReservation shows one reservation in admin tool
-func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
-	src := chi.URLParam(r, "src")
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Invalid reservation id")
-		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Template(w, r, "two-factor.page.gohtml", &models.TemplateData{
+			Form: form,
+		})
 		return
 	}
 
-	var year string
-	var month string
-	if src == "cal" { // year and month are actual only for calendar
-		year = r.URL.Query().Get("y")
-		month = r.URL.Query().Get("m")
+	now := time.Now()
+	attempt := models.LoginAttempt{
+		Email:     user.Email,
+		IP:        helpers.ClientIP(r),
+		CreatedAt: now,
+	}
+	accountPolicy, _ := m.loginPolicies()
+	if user.Locked() {
+		m.App.Session.Remove(r.Context(), "two_factor_user_id")
+		attempt.Reason = models.LoginFailedLocked
+		m.failLogin(w, r, attempt, accountPolicy, "Too many failed logins. Please try again later")
+		return
 	}
 
-	reservation, err := m.DB.GetReservationByID(id)
-	if err != nil {
+	code := form.Get("code")
+	recovery := twofactor.LooksLikeRecoveryCode(code)
+	if recovery {
+		err = m.DB.UseRecoveryCode(r.Context(), user.ID, twofactor.HashRecoveryCode(code))
+	} else if step, valid := twofactor.Verify(user.TwoFactorSecret, code, now); valid {
+		err = m.DB.UseTOTPStep(r.Context(), user.ID, step)
+	} else {
+		err = repository.ErrCodeAlreadyUsed
+	}
+	if errors.Is(err, repository.ErrCodeAlreadyUsed) || errors.Is(err, repository.ErrInvalidRecoveryCode) {
+		attempt.Reason = models.LoginFailedSecondStep
+		if err := m.DB.RecordFailedLogin(r.Context(), attempt, accountPolicy); err != nil {
+			log.Println(err)
+		}
+		m.App.Session.Put(r.Context(), "error", "Invalid code!")
+		http.Redirect(w, r, "/user/two-factor", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Remove(r.Context(), "two_factor_user_id")
+	m.App.Session.Remove(r.Context(), "two_factor_expires")
+	flash := "Successful login!"
+	if recovery {
+		left, err := m.DB.RecoveryCodesLeft(r.Context(), user.ID)
+		if err != nil {
+			log.Println(err)
+		}
+		flash = fmt.Sprintf("Successful login! You have %d recovery codes left", left)
+	}
+	m.completeLogin(w, r, user, flash)
+}
+
+// loginPolicies returns how failed logins are throttled per account and per IP address. An IP address
+// is only slowed down after as many failures as lock an account out, as many users can share it
+func (m *Repository) loginPolicies() (account, ip models.LoginPolicy) {
+	account = models.LoginPolicy{
+		FreeAttempts:    m.App.LoginFreeAttempts,
+		LockoutAttempts: m.App.LoginLockoutAttempts,
+		Lockout:         m.App.LoginLockout,
+	}
+	ip = models.LoginPolicy{
+		FreeAttempts:    m.App.LoginLockoutAttempts,
+		LockoutAttempts: m.App.LoginIPLockoutAttempts,
+		Lockout:         m.App.LoginLockout,
+	}
+	return account, ip
+}
+
+// failLogin records the failed login for the audit and the throttling, and sends the user back
+// to the login form with the message
+func (m *Repository) failLogin(w http.ResponseWriter, r *http.Request, attempt models.LoginAttempt,
+	policy models.LoginPolicy, message string) {
+	if err := m.DB.RecordFailedLogin(r.Context(), attempt, policy); err != nil {
+		log.Println(err)
+	}
+	m.App.Session.Put(r.Context(), "error", message)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Logout handles logging the user out
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// ShowForgotPassword shows the form asking for the email to send the password reset link to
+func (m *Repository) ShowForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgot-password.page.gohtml", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostForgotPassword queues the mail with the password reset link. The answer is the same whether there
+// is a user with the email or not, so the form cannot be used to find out who has an account
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error parsing form.")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Set("email", strings.ToLower(strings.TrimSpace(form.Get("email"))))
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Template(w, r, "forgot-password.page.gohtml", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	user, err := m.DB.GetUserByEmail(r.Context(), form.Get("email"))
+	if err == nil && user.Active {
+		err = m.DB.QueueMail(r.Context(), m.passwordResetMail(user))
+	}
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error sending password reset link")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If there is an account with this email, a link to reset the password has been sent to it")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// passwordResetMail returns the message with the link letting the user set a new password
+func (m *Repository) passwordResetMail(user models.User) models.MailData {
+	expires := time.Now().Add(m.App.PasswordResetTTL)
+	link := fmt.Sprintf("%s/user/reset-password?token=%s", strings.TrimSuffix(m.App.BaseURL, "/"),
+		url.QueryEscape(passwordreset.NewToken([]byte(m.App.SigningKey), user, expires)))
+	htmlMessage := fmt.Sprintf(`
+		<strong>Password Reset</strong>
+		<br>
+		Dear %s,
+		<br><br>
+		Somebody, hopefully you, has asked to reset your password. Follow <a href="%s">this link</a> to set
+		a new one. The link can be used once and works until %s.
+		<br><br>
+		If you have not asked for it, just ignore this message: your password stays the same.
+		<br><br>
+		admin@room&breakfast.com
+	`, html.EscapeString(user.FirstName), link, expires.Format("2006-01-02 15:04 MST"))
+	return models.MailData{
+		To:       user.Email,
+		Subject:  "Password reset",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+}
+
+// passwordResetUser returns the user the password reset token has been issued for. It returns
+// passwordreset.ErrInvalidToken or passwordreset.ErrExpiredToken if the token cannot be used
+func (m *Repository) passwordResetUser(ctx context.Context, token string) (models.User, error) {
+	id, err := passwordreset.UserID(token)
+	if err != nil {
+		return models.User{}, err
+	}
+	user, err := m.DB.GetUserById(ctx, id)
+	if errors.Is(err, repository.ErrUserNotFound) || err == nil && !user.Active {
+		return models.User{}, passwordreset.ErrInvalidToken
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, passwordreset.Verify([]byte(m.App.SigningKey), token, user, time.Now())
+}
+
+// checkPasswordResetToken gets the user of the token. If the token cannot be used, it puts an error into
+// the session, redirects and returns false
+func (m *Repository) checkPasswordResetToken(w http.ResponseWriter, r *http.Request, token string) (models.User, bool) {
+	user, err := m.passwordResetUser(r.Context(), token)
+	if errors.Is(err, passwordreset.ErrInvalidToken) || errors.Is(err, passwordreset.ErrExpiredToken) {
+		m.App.Session.Put(r.Context(), "error", "The password reset link is invalid or has expired. Please ask for a new one")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return user, false
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting user from DB")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return user, false
+	}
+	return user, true
+}
+
+// ShowResetPassword shows the form setting a new password if the reset link is valid
+func (m *Repository) ShowResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if _, ok := m.checkPasswordResetToken(w, r, token); !ok {
+		return
+	}
+	render.Template(w, r, "reset-password.page.gohtml", &models.TemplateData{
+		StringMap: map[string]string{"token": token},
+		Form:      forms.New(nil),
+	})
+}
+
+// PostResetPassword sets the new password of the user the reset link has been sent to
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error parsing form.")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	token := r.PostForm.Get("token")
+	user, ok := m.checkPasswordResetToken(w, r, token)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password", "confirm_password")
+	form.IsStrongPassword("password")
+	form.Matches("confirm_password", "password")
+	if !form.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Template(w, r, "reset-password.page.gohtml", &models.TemplateData{
+			StringMap: map[string]string{"token": token},
+			Form:      form,
+		})
+		return
+	}
+
+	if err := m.DB.UpdatePassword(r.Context(), user.ID, form.Get("password")); err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error saving password in DB")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "flash", "Your password has been changed. Log in with the new one")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// AdminChangePassword shows the form changing the password of the user logged in
+func (m *Repository) AdminChangePassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "admin-change-password.page.gohtml", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// AdminPostChangePassword changes the password of the user logged in after checking the current one.
+// The session token is renewed, as after logging in
+func (m *Repository) AdminPostChangePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error parsing form")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("current_password", "password", "confirm_password")
+	form.IsStrongPassword("password")
+	form.Matches("confirm_password", "password")
+	if !form.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Template(w, r, "admin-change-password.page.gohtml", &models.TemplateData{Form: form})
+		return
+	}
+
+	user, err := m.DB.GetUserById(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err == nil {
+		err = m.checkCurrentPassword(r, user, form)
+	}
+	if err == nil && !form.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		render.Template(w, r, "admin-change-password.page.gohtml", &models.TemplateData{Form: form})
+		return
+	}
+	if err == nil {
+		err = m.DB.UpdatePassword(r.Context(), user.ID, form.Get("password"))
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error changing password")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "flash", "Your password has been changed")
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// checkCurrentPassword checks the current_password field of the form, which the user logged in types to
+// confirm a change of the account. A wrong password counts as a failed login of the account, so guessing
+// it is throttled and locks the account out like on the login form. The problem is added to the form
+func (m *Repository) checkCurrentPassword(r *http.Request, user models.User, form *forms.Form) error {
+	_, _, err := m.DB.Authenticate(r.Context(), user.Email, form.Get("current_password"))
+	attempt := models.LoginAttempt{
+		Email:     user.Email,
+		IP:        helpers.ClientIP(r),
+		CreatedAt: time.Now(),
+	}
+	switch {
+	case errors.Is(err, repository.ErrInvalidCredentials):
+		attempt.Reason = models.LoginFailedCredentials
+		form.Errors.Add("current_password", "The current password is wrong")
+	case errors.Is(err, repository.ErrAccountLocked):
+		attempt.Reason = models.LoginFailedLocked
+		form.Errors.Add("current_password", "Too many failed attempts. Please try again later")
+	default:
+		return err
+	}
+	accountPolicy, _ := m.loginPolicies()
+	if err := m.DB.RecordFailedLogin(r.Context(), attempt, accountPolicy); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// TwoFactorRequired returns true if users of the role cannot log in without two-factor authentication
+func (m *Repository) TwoFactorRequired(role models.Role) bool {
+	for _, name := range m.App.TwoFactorRoles {
+		if name == role.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminTwoFactor shows whether two-factor authentication is enabled for the user logged in. If it is not,
+// a new secret is kept in the session until the user confirms it with a code from the authenticator app
+func (m *Repository) AdminTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserById(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting user from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	m.renderAdminTwoFactor(w, r, user, forms.New(nil))
+}
+
+// renderAdminTwoFactor renders the two-factor authentication page with the QR code to scan
+// or with the number of recovery codes left
+func (m *Repository) renderAdminTwoFactor(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	data := map[string]any{
+		"required": m.TwoFactorRequired(user.Role()),
+		"enabled":  user.TwoFactorEnabled(),
+	}
+	if user.TwoFactorEnabled() {
+		left, err := m.DB.RecoveryCodesLeft(r.Context(), user.ID)
+		if err != nil {
+			log.Println(err)
+			m.App.Session.Put(r.Context(), "error", "Error getting recovery codes from DB")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+			return
+		}
+		data["recovery_codes_left"] = left
+	} else {
+		secret := m.App.Session.GetString(r.Context(), "two_factor_secret")
+		if secret == "" {
+			var err error
+			if secret, err = twofactor.NewSecret(); err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			m.App.Session.Put(r.Context(), "two_factor_secret", secret)
+		}
+		png, err := qrcode.Encode(twofactor.URL(secret, twoFactorIssuer, user.Email), qrcode.Medium, 256)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["secret"] = secret
+		// a data URL is not trusted by html/template unless typed so
+		data["qr_code"] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	}
+	render.Template(w, r, "admin-two-factor.page.gohtml", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// twoFactorIssuer is the name authenticator apps show next to the account
+const twoFactorIssuer = "Room & Breakfast"
+
+// AdminPostTwoFactor enables two-factor authentication once the user has entered a code generated from
+// the secret. The recovery codes are shown only this once, the database keeps just their hashes
+func (m *Repository) AdminPostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error parsing form")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	user, err := m.DB.GetUserById(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting user from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	secret := m.App.Session.GetString(r.Context(), "two_factor_secret")
+	if user.TwoFactorEnabled() || secret == "" {
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	step, valid := twofactor.Verify(secret, form.Get("code"), time.Now())
+	if form.Has("code") && !valid {
+		form.Errors.Add("code", "The code is wrong. Check the clock of your phone and try the next one")
+	}
+	if !form.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		m.renderAdminTwoFactor(w, r, user, form)
+		return
+	}
+
+	codes, err := twofactor.NewRecoveryCodes()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = twofactor.HashRecoveryCode(code)
+	}
+	err = m.DB.EnableTwoFactor(r.Context(), user.ID, secret, hashes)
+	if err == nil {
+		// the code used to confirm cannot be used to log in again
+		err = m.DB.UseTOTPStep(r.Context(), user.ID, step)
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error enabling two-factor authentication")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	m.App.Session.Remove(r.Context(), "two_factor_secret")
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication has been enabled")
+	render.Template(w, r, "admin-two-factor.page.gohtml", &models.TemplateData{
+		Data: map[string]any{
+			"enabled":        true,
+			"required":       m.TwoFactorRequired(user.Role()),
+			"recovery_codes": codes,
+		},
+		Form: forms.New(nil),
+	})
+}
+
+// AdminPostDisableTwoFactor disables two-factor authentication of the user logged in after checking
+// the password. Users whose role requires it cannot disable it
+func (m *Repository) AdminPostDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error parsing form")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	user, err := m.DB.GetUserById(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting user from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	if m.TwoFactorRequired(user.Role()) {
+		m.App.Session.Put(r.Context(), "error", "Your role requires two-factor authentication")
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("current_password")
+	if form.Valid() {
+		err = m.checkCurrentPassword(r, user, form)
+	}
+	if err == nil && !form.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		m.renderAdminTwoFactor(w, r, user, form)
+		return
+	}
+	if err == nil {
+		err = m.DB.DisableTwoFactor(r.Context(), user.ID)
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error disabling two-factor authentication")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication has been disabled")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// Forbidden shows the page telling users that their role does not allow what they tried to do
+func (m *Repository) Forbidden(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusForbidden)
+	render.Template(w, r, "403.page.gohtml", &models.TemplateData{})
+}
+
+// dashboardActivityLimit is the number of the latest reservation status changes shown on the dashboard
+const dashboardActivityLimit = 10
+
+// AdminDashboard shows today's arrivals and departures, the guests in house, the number of reservations
+// waiting to be processed, the occupancy of the next 7 and 30 nights and the latest reservation activity
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	// reservation dates are calendar days kept at UTC midnight
+	year, month, day := time.Now().Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+
+	data := map[string]any{}
+	data["today"] = today
+	err := func() error {
+		counts, err := m.DB.DashboardCounts(r.Context(), today)
+		if err != nil {
+			return err
+		}
+		data["counts"] = counts
+		for _, days := range []int{7, 30} {
+			occupancy, err := m.DB.Occupancy(r.Context(), today, today.AddDate(0, 0, days))
+			if err != nil {
+				return err
+			}
+			data[fmt.Sprintf("occupancy%d", days)] = occupancy
+		}
+		activity, err := m.DB.RecentStatusChanges(r.Context(), dashboardActivityLimit)
+		if err != nil {
+			return err
+		}
+		data["activity"] = activity
+		return nil
+	}()
+	if err != nil {
+		// the other admin pages redirect here on errors, so the dashboard shows what it has got instead
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting dashboard figures from DB")
+	}
+	render.Template(w, r, "admin-dashboard.page.gohtml", &models.TemplateData{Data: data})
+}
+
+// AdminNewReservations shows the pending reservations in admin tool
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	m.adminReservationList(w, r, "/admin/reservations-new", "admin-new-reservations.page.gohtml", models.StatusPending)
+}
+
+// AdminAllReservations shows all reservations in admin tool
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	m.adminReservationList(w, r, "/admin/reservations-all", "admin-all-reservations.page.gohtml", "")
+}
+
+// listColumn is a column header of a reservation list. URL sorts the list by the column and is empty
+// for the columns the list cannot be sorted by; Arrow shows the direction the list is sorted by it
+type listColumn struct {
+	Title string
+	URL   string
+	Arrow string
+}
+
+// adminReservationList shows a page of the reservations selected by the query parameters: q (searched in
+// the guest's name, email and phone), room, from and to (inclusive), status, sort and dir, page and size.
+// A non-empty status is always applied, whatever the query says
+func (m *Repository) adminReservationList(w http.ResponseWriter, r *http.Request, path, tmpl string, status models.ReservationStatus) {
+	query := r.URL.Query()
+	if status != "" {
+		query.Del("status")
+	}
+	filter, errMsg := reservationFilter(query)
+	if errMsg != "" {
+		m.App.Session.Put(r.Context(), "error", errMsg)
+		http.Redirect(w, r, path, http.StatusSeeOther)
+		return
+	}
+	if status != "" {
+		filter.Status = status
+	}
+
+	page, err := m.DB.SearchReservations(r.Context(), filter)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting reservations from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting rooms from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	var columns []listColumn
+	for _, c := range []struct{ title, sort string }{
+		{"ID", models.SortByID}, {"Guest", models.SortByName}, {"Room", models.SortByRoom},
+		{"Arrival", models.SortByArrival}, {"Departure", models.SortByDeparture},
+		{"Email", ""}, {"Phone", ""}, {"Status", models.SortByStatus}, {"Booked", models.SortByCreated},
+	} {
+		if c.sort == models.SortByStatus && status != "" {
+			continue
+		}
+		column := listColumn{Title: c.title}
+		if c.sort != "" {
+			dir := "asc"
+			if c.sort == filter.Sort {
+				column.Arrow = "\u25b2"
+				if filter.Desc {
+					column.Arrow = "\u25bc"
+				} else {
+					dir = "desc"
+				}
+			}
+			column.URL = listURL(path, query, "sort", c.sort, "dir", dir, "page", "")
+		}
+		columns = append(columns, column)
+	}
+
+	data := map[string]any{}
+	data["page"] = page
+	data["reservations"] = page.Reservations
+	data["columns"] = columns
+	data["rooms"] = rooms
+	data["page_sizes"] = models.ReservationPageSizes()
+	if status == "" {
+		data["statuses"] = models.AllReservationStatuses()
+		data["export_columns"] = exportColumns()
+	}
+	stringMap := map[string]string{"path": path}
+	if filter.Page > 1 {
+		stringMap["previous"] = listURL(path, query, "page", strconv.Itoa(filter.Page-1))
+	}
+	if filter.Page < page.Pages() {
+		stringMap["next"] = listURL(path, query, "page", strconv.Itoa(filter.Page+1))
+	}
+	render.Template(w, r, tmpl, &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(query),
+	})
+}
+
+// reservationFilter reads the filter of a reservation list from the query parameters described at
+// adminReservationList. errMsg is not empty if they are invalid
+func reservationFilter(query url.Values) (filter models.ReservationFilter, errMsg string) {
+	filter = models.ReservationFilter{
+		Search:   strings.TrimSpace(query.Get("q")),
+		Status:   models.ReservationStatus(query.Get("status")),
+		Sort:     models.SortByArrival,
+		Desc:     true,
+		Page:     1,
+		PageSize: models.DefaultReservationPageSize,
+	}
+	var err error
+	if query.Get("room") != "" {
+		if filter.RoomID, err = strconv.Atoi(query.Get("room")); err != nil {
+			return filter, "Invalid room id"
+		}
+	}
+	const layout = "2006-01-02"
+	if query.Get("from") != "" {
+		if filter.From, err = time.Parse(layout, query.Get("from")); err != nil {
+			return filter, "Error parsing start date"
+		}
+	}
+	if query.Get("to") != "" {
+		last, err := time.Parse(layout, query.Get("to"))
+		if err != nil {
+			return filter, "Error parsing end date"
+		}
+		if last.Before(filter.From) {
+			return filter, "Error: the end date cannot be before the start date"
+		}
+		filter.To = last.AddDate(0, 0, 1)
+	}
+	if filter.Status != "" && !filter.Status.Valid() {
+		return filter, "Invalid reservation status"
+	}
+	if sort := query.Get("sort"); sort != "" {
+		valid := false
+		for _, column := range models.ReservationSortColumns() {
+			valid = valid || sort == column
+		}
+		if !valid {
+			return filter, "Invalid sort column"
+		}
+		// the arrival date is sorted newest first by default, the other columns in ascending order
+		filter.Sort, filter.Desc = sort, false
+	}
+	switch query.Get("dir") {
+	case "":
+	case "asc":
+		filter.Desc = false
+	case "desc":
+		filter.Desc = true
+	default:
+		return filter, "Invalid sort direction"
+	}
+	if query.Get("page") != "" {
+		if filter.Page, err = strconv.Atoi(query.Get("page")); err != nil || filter.Page < 1 {
+			return filter, "Invalid page number"
+		}
+	}
+	if query.Get("size") != "" {
+		size, err := strconv.Atoi(query.Get("size"))
+		valid := false
+		for _, s := range models.ReservationPageSizes() {
+			valid = valid || size == s
+		}
+		if err != nil || !valid {
+			return filter, "Invalid page size"
+		}
+		filter.PageSize = size
+	}
+	return filter, ""
+}
+
+// listURL returns the URL of the list at path with the query changed by the pairs of keys and values.
+// Empty values are left out
+func listURL(path string, query url.Values, keyValues ...string) string {
+	q := url.Values{}
+	for k, v := range query {
+		if len(v) > 0 && v[0] != "" {
+			q.Set(k, v[0])
+		}
+	}
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		if keyValues[i+1] == "" {
+			q.Del(keyValues[i])
+		} else {
+			q.Set(keyValues[i], keyValues[i+1])
+		}
+	}
+	if len(q) == 0 {
+		return path
+	}
+	return path + "?" + q.Encode()
+}
+
+// AdminShowReservation shows one reservation in admin tool
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	src := chi.URLParam(r, "src")
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid reservation id")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	var year string
+	var month string
+	if src == "cal" { // year and month are actual only for calendar
+		year = r.URL.Query().Get("y")
+		month = r.URL.Query().Get("m")
+	}
+
+	reservation, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting reservation from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	history, err := m.DB.GetReservationStatusHistory(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting reservation status history from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting rooms from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	data := map[string]any{}
+	data["reservation"] = reservation
+	data["history"] = history
+	data["rooms"] = rooms
+	stringMap := map[string]string{}
+	stringMap["src"] = src
+	stringMap["start_date"] = reservation.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = reservation.EndDate.Format("2006-01-02")
+	if year != "" {
+		stringMap["year"] = year
+		stringMap["month"] = month
+	}
+
+	render.Template(w, r, "admin-reservation-show.page.gohtml", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostShowReservation saves changes in reservation to the database
+func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error parsing form")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Invalid reservation id")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting reservation from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Email = r.Form.Get("email")
+	res.Phone = r.Form.Get("phone")
+
+	year := r.Form.Get("year")
+	month := r.Form.Get("month")
+	showURL := fmt.Sprintf("/admin/reservations/%s/%d", src, id)
+	if src == "cal" && year != "" {
+		showURL = fmt.Sprintf("%s?y=%s&m=%s", showURL, year, month)
+	}
+
+	const layout = "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error parsing start date")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Error parsing end date")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	if !endDate.After(startDate) {
+		m.App.Session.Put(r.Context(), "error", "Error: the departure date must be after the arrival date")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid room id")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	res.StartDate = startDate
+	res.EndDate = endDate
+	res.RoomId = roomID
+
+	err = m.DB.UpdateReservation(r.Context(), res)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "The room is not available for the chosen dates")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error updating reservation in DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes successfully saved")
+	if src == "cal" {
+		url := "/admin/reservations-calendar"
+		if year != "" {
+			url = fmt.Sprintf("%s?y=%s&m=%s", url, year, month)
+		}
+		http.Redirect(w, r, url, http.StatusSeeOther)
+
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	}
+}
+
+// AdminReservationsCalendar shows the reservations' calendar in admin tool
+func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	// assume there is not month or year specified
+	time.LoadLocation("UTC")
+	now := time.Now()
+	if r.URL.Query().Get("y") != "" {
+		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
+		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	data := map[string]any{
+		"now": now,
+	}
+
+	next := now.AddDate(0, 1, 0)
+	last := now.AddDate(0, -1, 0)
+	nextMonth := next.Format("01")
+	nextMonthYear := next.Format("2006")
+	lastMonth := last.Format("01")
+	lastMonthYear := last.Format("2006")
+
+	stringMap := map[string]string{
+		"next_month":      nextMonth,
+		"next_month_year": nextMonthYear,
+		"last_month":      lastMonth,
+		"last_month_year": lastMonthYear,
+		"this_month":      now.Format("01"),
+		"this_month_year": now.Format("2006"),
+	}
+
+	// Count a number of days in the current month
+	currYear, currMonth, _ := now.Date()
+	currLocation := now.Location()
+	firstOfMonth := time.Date(currYear, currMonth, 1, 0, 0, 0, 0, currLocation)
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+	intMap := map[string]int{
+		"days_in_month": lastOfMonth.Day(),
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error fetching rooms from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	data["rooms"] = rooms
+
+	restrictions, err := m.DB.BlockRestrictions(r.Context())
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error fetching restrictions from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	data["restrictions"] = restrictions
+
+	for _, room := range rooms {
+		roomRestrictions, err := m.DB.GetRestrictionsForRoomByDates(r.Context(), room.ID, firstOfMonth, lastOfMonth)
+		if err != nil {
+			log.Println(err)
+			m.App.Session.Put(r.Context(), "error", "Error fetching room restrictions from DB")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+			return
+		}
+		data[fmt.Sprintf("cells_%d", room.ID)] = calendarCells(roomRestrictions, firstOfMonth, lastOfMonth.Day())
+	}
+
+	render.Template(w, r, "admin-reservations-calendar.page.gohtml", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+	})
+}
+
+// calendarCell is a cell in the room's row of the reservations calendar. A free day takes
+// one cell, while a reservation or a block spans all the days of the month it covers
+type calendarCell struct {
+	Day           int
+	Span          int
+	ReservationID int
+	BlockID       int
+	Title         string
+	Note          string
+}
+
+// calendarCells splits the month into cells by the room restrictions. A restriction covers its nights,
+// i.e. the days from its start date up to (not including) its end date. Legacy blocks ending on the day
+// they start, which the migration to nights could not widen, cover that one day
+func calendarCells(restrictions []models.RoomRestriction, firstOfMonth time.Time, daysInMonth int) []calendarCell {
+	monthIndex := func(t time.Time) int { return t.Year()*12 + int(t.Month()) }
+	thisMonth := monthIndex(firstOfMonth)
+
+	// owners[day] is the index of the restriction covering the day plus one, or zero for a free day
+	owners := make([]int, daysInMonth+2)
+	for i, rr := range restrictions {
+		from, to := 1, daysInMonth+1
+		if mi := monthIndex(rr.StartDate); mi > thisMonth {
+			continue
+		} else if mi == thisMonth {
+			from = rr.StartDate.Day()
+		}
+		if mi := monthIndex(rr.EndDate); mi < thisMonth {
+			continue
+		} else if mi == thisMonth {
+			to = rr.EndDate.Day()
+		}
+		if !rr.EndDate.After(rr.StartDate) {
+			to = from + 1
+		}
+		for d := from; d < to; d++ {
+			owners[d] = i + 1
+		}
+	}
+
+	var cells []calendarCell
+	for d := 1; d <= daysInMonth; {
+		cell := calendarCell{Day: d, Span: 1}
+		if owner := owners[d]; owner != 0 {
+			for d+cell.Span <= daysInMonth && owners[d+cell.Span] == owner {
+				cell.Span++
+			}
+			rr := restrictions[owner-1]
+			if rr.ReservationID != 0 {
+				cell.ReservationID = rr.ReservationID
+				cell.Title = "Reservation"
+			} else {
+				cell.BlockID = rr.ID
+				cell.Title = rr.Restriction.RestrictionName
+				cell.Note = rr.Note
+			}
+		}
+		cells = append(cells, cell)
+		d += cell.Span
+	}
+	return cells
+}
+
+// AdminPostReservationStatus moves reservation to the status of the form, another one of its lifecycle
+func (m *Repository) AdminPostReservationStatus(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error parsing form")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	src := chi.URLParam(r, "src")
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Invalid reservation id")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	status := models.ReservationStatus(r.Form.Get("status"))
+	if !status.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Invalid reservation status")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	year := r.Form.Get("y")
+	month := r.Form.Get("m")
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	err = m.DB.UpdateReservationStatus(r.Context(), id, status, userID)
+	if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Reservation cannot be marked as %s", strings.ToLower(status.Title())))
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error updating reservation status")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation is marked as %s", strings.ToLower(status.Title())))
+	if src == "cal" {
+		url := "/admin/reservations-calendar"
+		if year != "" {
+			url = fmt.Sprintf("%s?y=%s&m=%s", url, year, month)
+		}
+		http.Redirect(w, r, url, http.StatusSeeOther)
+
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	}
+}
+
+// AdminPostDeleteReservation deletes reservation from the database
+func (m *Repository) AdminPostDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error parsing form")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	src := chi.URLParam(r, "src")
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Invalid reservation id")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	err = m.DB.DeleteReservation(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error deleting reservation")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	year := r.Form.Get("y")
+	month := r.Form.Get("m")
+
+	m.App.Session.Put(r.Context(), "flash", "Successfully deleted reservation")
+	if src == "cal" {
+		url := "/admin/reservations-calendar"
+		if year != "" {
+			url = fmt.Sprintf("%s?y=%s&m=%s", url, year, month)
+		}
+		http.Redirect(w, r, url, http.StatusSeeOther)
+
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	}
+}
+
+// AdminRooms lists all rooms in admin tool
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting rooms from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	data := map[string]any{}
+	data["rooms"] = rooms
+	render.Template(w, r, "admin-rooms.page.gohtml", &models.TemplateData{Data: data})
+}
+
+// AdminShowRoom shows the room form in admin tool; "new" id shows an empty form for a new room
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	room := models.Room{Capacity: 2}
+	if idParam := chi.URLParam(r, "id"); idParam != "new" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Invalid room id")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+			return
+		}
+		room, err = m.DB.GetRoomByID(r.Context(), id)
+		if err != nil {
+			log.Println(err)
+			m.App.Session.Put(r.Context(), "error", "Error getting room from DB")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+			return
+		}
+	}
+	m.renderAdminRoom(w, r, room, forms.New(nil))
+}
+
+// renderAdminRoom renders the room form in admin tool
+func (m *Repository) renderAdminRoom(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	data := map[string]any{}
+	data["room"] = room
+	stringMap := map[string]string{}
+	stringMap["amenities"] = strings.Join(room.Amenities, "\n")
+	stringMap["photos"] = strings.Join(room.Photos, "\n")
+	render.Template(w, r, "admin-room-show.page.gohtml", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// AdminPostRoom creates a new room or saves changes of an existing one
+func (m *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error parsing form")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	var room models.Room
+	if idParam := chi.URLParam(r, "id"); idParam != "new" {
+		room.ID, err = strconv.Atoi(idParam)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Invalid room id")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
+	room.RoomName = r.Form.Get("room_name")
+	room.Slug = r.Form.Get("slug")
+	room.Description = r.Form.Get("description")
+	room.Amenities = splitLines(r.Form.Get("amenities"))
+	room.Photos = splitLines(r.Form.Get("photos"))
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name", "slug")
+	form.IsSlug("slug")
+	form.MinInt("capacity", 1)
+	form.IsPrice("base_price")
+	if !form.Valid() {
+		room.Capacity, _ = strconv.Atoi(form.Get("capacity"))
+		w.WriteHeader(http.StatusBadRequest)
+		m.renderAdminRoom(w, r, room, form)
+		return
+	}
+	room.Capacity, _ = strconv.Atoi(form.Get("capacity"))
+	room.BasePrice = parseCents(form.Get("base_price"))
+
+	if room.ID == 0 {
+		room.ID, err = m.DB.InsertRoom(r.Context(), room)
+	} else {
+		err = m.DB.UpdateRoom(r.Context(), room)
+	}
+	if errors.Is(err, repository.ErrDuplicateRoomSlug) {
+		form.Errors.Add("slug", "Another room already has this slug.")
+		w.WriteHeader(http.StatusBadRequest)
+		m.renderAdminRoom(w, r, room, form)
+		return
+	}
+	if errors.Is(err, repository.ErrRoomNotFound) {
+		m.App.Session.Put(r.Context(), "error", "Room not found")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error saving room in DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes successfully saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminPostDeleteRoom deletes a room unless it has reservations
+func (m *Repository) AdminPostDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid room id")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	err = m.DB.DeleteRoom(r.Context(), id)
+	if errors.Is(err, repository.ErrRoomInUse) {
+		m.App.Session.Put(r.Context(), "error", "Room has reservations and cannot be deleted")
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error deleting room")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Successfully deleted room")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminUsers lists the back office users
+func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := m.DB.AllUsers(r.Context())
+	if err != nil {
 		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Error getting reservation from DB")
+		m.App.Session.Put(r.Context(), "error", "Error getting users from DB")
 		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
 		return
 	}
-
 	data := map[string]any{}
-	data["reservation"] = reservation
-	stringMap := map[string]string{}
-	stringMap["src"] = src
-	if year != "" {
-		stringMap["year"] = year
-		stringMap["month"] = month
+	data["users"] = users
+	render.Template(w, r, "admin-users.page.gohtml", &models.TemplateData{Data: data})
+}
+
+// AdminShowUser shows the user form in admin tool; "new" id shows an empty form for a new user
+func (m *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	user := models.User{AccessLevel: int(models.RoleViewer), Active: true}
+	if idParam := chi.URLParam(r, "id"); idParam != "new" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Invalid user id")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+			return
+		}
+		user, err = m.DB.GetUserById(r.Context(), id)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			m.App.Session.Put(r.Context(), "error", "User not found")
+			http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			m.App.Session.Put(r.Context(), "error", "Error getting user from DB")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+			return
+		}
 	}
+	m.renderAdminUser(w, r, user, forms.New(nil))
+}
 
-	render.Template(w, r, "admin-reservation-show.page.gohtml", &models.TemplateData{
-		Data:      data,
-		StringMap: stringMap,
-		Form:      forms.New(nil),
+// renderAdminUser renders the user form in admin tool
+func (m *Repository) renderAdminUser(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	data := map[string]any{}
+	data["user"] = user
+	data["roles"] = models.AllRoles()
+	data["self"] = user.ID != 0 && user.ID == m.App.Session.GetInt(r.Context(), "user_id")
+	render.Template(w, r, "admin-user-show.page.gohtml", &models.TemplateData{
+		Data: data,
+		Form: form,
 	})
 }
 
-// AdminPostShowReservation saves changes in reservation to the database
-func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+// AdminPostUser creates a new user or saves changes of an existing one. Owners cannot deactivate
+// themselves or change their own role, so there is always somebody to manage the users
+func (m *Repository) AdminPostUser(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		log.Println(err)
@@ -566,211 +2225,318 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	src := chi.URLParam(r, "src")
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
-	if err != nil {
-		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Invalid reservation id")
-		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+	var user models.User
+	if idParam := chi.URLParam(r, "id"); idParam != "new" {
+		user.ID, err = strconv.Atoi(idParam)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Invalid user id")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	user.FirstName = strings.TrimSpace(form.Get("first_name"))
+	user.LastName = strings.TrimSpace(form.Get("last_name"))
+	user.Email = strings.ToLower(strings.TrimSpace(form.Get("email")))
+	form.Set("email", user.Email)
+	user.AccessLevel, _ = strconv.Atoi(form.Get("access_level"))
+	user.Active = user.ID == 0 || form.Has("active")
+
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+	if !user.Role().Valid() {
+		form.Errors.Add("access_level", "Choose a role")
+	}
+	if user.ID == 0 {
+		form.Required("password")
+		form.IsStrongPassword("password")
+	}
+	if user.ID != 0 && user.ID == m.App.Session.GetInt(r.Context(), "user_id") {
+		if !user.Active {
+			form.Errors.Add("active", "You cannot deactivate your own account")
+		}
+		if user.Role() != helpers.CurrentRole(r) {
+			form.Errors.Add("access_level", "You cannot change your own role")
+		}
+	}
+	if !form.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		m.renderAdminUser(w, r, user, form)
 		return
 	}
 
-	res, err := m.DB.GetReservationByID(id)
+	if user.ID == 0 {
+		user.ID, err = m.DB.InsertUser(r.Context(), user, form.Get("password"))
+	} else {
+		err = m.DB.UpdateUser(r.Context(), user)
+	}
+	if errors.Is(err, repository.ErrDuplicateEmail) {
+		form.Errors.Add("email", "Another user already has this email.")
+		w.WriteHeader(http.StatusBadRequest)
+		m.renderAdminUser(w, r, user, form)
+		return
+	}
+	if errors.Is(err, repository.ErrUserNotFound) {
+		m.App.Session.Put(r.Context(), "error", "User not found")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Error getting reservation from DB")
+		m.App.Session.Put(r.Context(), "error", "Error saving user in DB")
 		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
 		return
 	}
 
-	res.FirstName = r.Form.Get("first_name")
-	res.LastName = r.Form.Get("last_name")
-	res.Email = r.Form.Get("email")
-	res.Phone = r.Form.Get("phone")
+	m.App.Session.Put(r.Context(), "flash", "Changes successfully saved")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
 
-	year := r.Form.Get("year")
-	month := r.Form.Get("month")
+// adminUserAction runs an action on the user from URL and redirects back with the outcome. Owners
+// cannot run it on themselves
+func (m *Repository) adminUserAction(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, id int) error,
+	success, failure string) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid user id")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+	if id == m.App.Session.GetInt(r.Context(), "user_id") {
+		m.App.Session.Put(r.Context(), "error", "You cannot do this to your own account")
+		http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", id), http.StatusSeeOther)
+		return
+	}
 
-	err = m.DB.UpdateReservation(res)
+	err = action(r.Context(), id)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		m.App.Session.Put(r.Context(), "error", "User not found")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Error updating reservation in DB")
+		m.App.Session.Put(r.Context(), "error", failure)
 		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes successfully saved")
-	if src == "cal" {
-		url := "/admin/reservations-calendar"
-		if year != "" {
-			url = fmt.Sprintf("%s?y=%s&m=%s", url, year, month)
-		}
-		http.Redirect(w, r, url, http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "flash", success)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
 
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	}
+// AdminPostDeleteUser deletes a user
+func (m *Repository) AdminPostDeleteUser(w http.ResponseWriter, r *http.Request) {
+	m.adminUserAction(w, r, m.DB.DeleteUser, "Successfully deleted user", "Error deleting user")
 }
 
-// AdminReservationsCalendar shows the reservations' calendar in admin tool
-func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	// assume there is not month or year specified
-	time.LoadLocation("UTC")
-	now := time.Now()
-	if r.URL.Query().Get("y") != "" {
-		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
-		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
-		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	}
+// AdminPostUnlockUser lets the user locked out after failed logins log in again
+func (m *Repository) AdminPostUnlockUser(w http.ResponseWriter, r *http.Request) {
+	m.adminUserAction(w, r, m.DB.UnlockUser, "The user can log in again", "Error unlocking user")
+}
 
-	data := map[string]any{
-		"now": now,
-	}
+// AdminPostForcePasswordReset makes the user set a new password before logging in again
+func (m *Repository) AdminPostForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	m.adminUserAction(w, r, m.DB.ForcePasswordReset, "The user has to reset the password now", "Error forcing password reset")
+}
 
-	next := now.AddDate(0, 1, 0)
-	last := now.AddDate(0, -1, 0)
-	nextMonth := next.Format("01")
-	nextMonthYear := next.Format("2006")
-	lastMonth := last.Format("01")
-	lastMonthYear := last.Format("2006")
+// AdminPostResetTwoFactor disables two-factor authentication of the user who has lost both the phone
+// and the recovery codes. Users whose role requires it have to enroll again on their next login
+func (m *Repository) AdminPostResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	m.adminUserAction(w, r, m.DB.DisableTwoFactor, "Two-factor authentication of the user has been reset",
+		"Error resetting two-factor authentication")
+}
 
-	stringMap := map[string]string{
-		"next_month":      nextMonth,
-		"next_month_year": nextMonthYear,
-		"last_month":      lastMonth,
-		"last_month_year": lastMonthYear,
-		"this_month":      now.Format("01"),
-		"this_month_year": now.Format("2006"),
+// AdminMail lists queued and failed messages of the mail outbox
+func (m *Repository) AdminMail(w http.ResponseWriter, r *http.Request) {
+	messages, err := m.DB.PendingMail(r.Context())
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting mail from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
 	}
+	data := map[string]any{}
+	data["messages"] = messages
+	render.Template(w, r, "admin-mail.page.gohtml", &models.TemplateData{Data: data})
+}
 
-	// Count a number of days in the current month
-	currYear, currMonth, _ := now.Date()
-	currLocation := now.Location()
-	firstOfMonth := time.Date(currYear, currMonth, 1, 0, 0, 0, 0, currLocation)
-	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
-	intMap := map[string]int{
-		"days_in_month": lastOfMonth.Day(),
+// AdminPostResendMail queues the unsent message for immediate delivery
+func (m *Repository) AdminPostResendMail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid message id")
+		http.Redirect(w, r, "/admin/mail", http.StatusSeeOther)
+		return
 	}
 
-	rooms, err := m.DB.AllRooms()
+	err = m.DB.ResendMail(r.Context(), id)
+	if errors.Is(err, repository.ErrMailNotFound) {
+		m.App.Session.Put(r.Context(), "error", "Message is not found or has already been sent")
+		http.Redirect(w, r, "/admin/mail", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Error fetching rooms from DB")
+		m.App.Session.Put(r.Context(), "error", "Error resending message")
 		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
 		return
 	}
-	data["rooms"] = rooms
 
-	for _, room := range rooms {
-		reservationMap := map[string]int{}
-		blockMap := map[string]int{}
-		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
-			dateStr := d.Format("2006-01-2")
-			reservationMap[dateStr] = 0
-			blockMap[dateStr] = 0
-		}
+	m.App.Session.Put(r.Context(), "flash", "Message is queued for delivery")
+	http.Redirect(w, r, "/admin/mail", http.StatusSeeOther)
+}
 
-		roomRestrictions, err := m.DB.GetRestrictionsForRoomByDates(room.ID, firstOfMonth, lastOfMonth)
+// auditLogLimit is the number of the latest entries shown on the audit log page
+const auditLogLimit = 500
+
+// AdminAuditLog shows the audit log filtered by user, entity and an inclusive range of dates
+func (m *Repository) AdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{Entity: query.Get("entity"), Limit: auditLogLimit}
+	var err error
+	if query.Get("user") != "" {
+		filter.UserID, err = strconv.Atoi(query.Get("user"))
 		if err != nil {
-			log.Println(err)
-			m.App.Session.Put(r.Context(), "error", "Error fetching room restrictions from DB")
-			http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+			m.App.Session.Put(r.Context(), "error", "Invalid user id")
+			http.Redirect(w, r, "/admin/audit", http.StatusSeeOther)
+			return
 		}
-
-		for _, rr := range roomRestrictions {
-			for rd := rr.StartDate; !rd.After(rr.EndDate); rd = rd.AddDate(0, 0, 1) {
-				dateStr := rd.Format("2006-01-2")
-				if rr.ReservationID == 0 {
-					blockMap[dateStr] = rr.ID
-				} else {
-					reservationMap[dateStr] = rr.ReservationID
-				}
-			}
+	}
+	const layout = "2006-01-02"
+	if query.Get("from") != "" {
+		filter.From, err = time.ParseInLocation(layout, query.Get("from"), time.Local)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Error parsing start date")
+			http.Redirect(w, r, "/admin/audit", http.StatusSeeOther)
+			return
 		}
-		data[fmt.Sprintf("reservation_map_%d", room.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", room.ID)] = blockMap
-
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", room.ID), blockMap)
+	}
+	if query.Get("to") != "" {
+		last, err := time.ParseInLocation(layout, query.Get("to"), time.Local)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Error parsing end date")
+			http.Redirect(w, r, "/admin/audit", http.StatusSeeOther)
+			return
+		}
+		filter.To = last.AddDate(0, 0, 1)
 	}
 
-	render.Template(w, r, "admin-reservations-calendar.page.gohtml", &models.TemplateData{
-		Data:      data,
-		StringMap: stringMap,
-		IntMap:    intMap,
-	})
-}
-
-// AdminProcessReservation marks reservation as processed in database
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
-	src := chi.URLParam(r, "src")
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	entries, err := m.DB.AuditLog(r.Context(), filter)
 	if err != nil {
 		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Invalid reservation id")
+		m.App.Session.Put(r.Context(), "error", "Error getting audit log from DB")
 		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
 		return
 	}
-
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
-
-	err = m.DB.UpdateProcessedForReservation(id, 1)
+	users, err := m.DB.AllUsers(r.Context())
 	if err != nil {
 		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Error marking reservation as processed")
+		m.App.Session.Put(r.Context(), "error", "Error getting users from DB")
 		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
 		return
 	}
-	m.App.Session.Put(r.Context(), "flash", "Successfully marked as processed")
-	if src == "cal" {
-		url := "/admin/reservations-calendar"
-		if year != "" {
-			url = fmt.Sprintf("%s?y=%s&m=%s", url, year, month)
-		}
-		http.Redirect(w, r, url, http.StatusSeeOther)
 
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	data := map[string]any{}
+	data["entries"] = entries
+	data["users"] = users
+	data["entities"] = models.AuditEntities()
+	data["limited"] = len(entries) == auditLogLimit
+	render.Template(w, r, "admin-audit.page.gohtml", &models.TemplateData{
+		Data: data,
+		Form: forms.New(query),
+	})
+}
+
+// splitLines splits the text of a textarea into trimmed non-empty lines
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
 	}
+	return lines
 }
 
-// AdminDeleteReservation deletes reservation from the database
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
-	src := chi.URLParam(r, "src")
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+// parseCents converts a price validated by forms.IsPrice to cents
+func parseCents(price string) int {
+	whole, fraction, _ := strings.Cut(price, ".")
+	cents, _ := strconv.Atoi(whole)
+	fraction = (fraction + "00")[:2]
+	f, _ := strconv.Atoi(fraction)
+	return cents*100 + f
+}
+
+// AdminPostReservationsCalendar handles post of reservation calendar, i.e. removes the blocks checked on it
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Invalid reservation id")
+		log.Print(err)
+		m.App.Session.Put(r.Context(), "error", "Error parsing the form")
 		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
 		return
 	}
 
-	err = m.DB.DeleteReservation(id)
-	if err != nil {
-		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Error deleting reservation")
-		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
-		return
+	for _, value := range r.PostForm["remove_block"] {
+		blockID, err := strconv.Atoi(value)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Invalid block id")
+			http.Redirect(w, r, calendarURL(r), http.StatusSeeOther)
+			return
+		}
+		err = m.DB.DeleteBlockByID(r.Context(), blockID)
+		if err != nil {
+			log.Println(err)
+			m.App.Session.Put(r.Context(), "error", "Error removing room restriction from DB")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+			return
+		}
 	}
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	m.App.Session.Put(r.Context(), "flash", "Changes saved!")
+	http.Redirect(w, r, calendarURL(r), http.StatusSeeOther)
+}
 
-	m.App.Session.Put(r.Context(), "flash", "Successfully deleted reservation")
-	if src == "cal" {
-		url := "/admin/reservations-calendar"
-		if year != "" {
-			url = fmt.Sprintf("%s?y=%s&m=%s", url, year, month)
+// calendarURL returns the URL of the reservations calendar for the month posted in the form
+func calendarURL(r *http.Request) string {
+	return fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", r.Form.Get("y"), r.Form.Get("m"))
+}
+
+// parseBlockForm reads the rooms and the range of nights of the block form. The form asks for the first
+// and the last night, so the returned end is the day after the last night. If the form is invalid,
+// it returns the error message
+func parseBlockForm(r *http.Request) ([]int, time.Time, time.Time, string) {
+	var roomIDs []int
+	for _, value := range r.PostForm["room_id"] {
+		roomID, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, time.Time{}, time.Time{}, "Invalid room id"
 		}
-		http.Redirect(w, r, url, http.StatusSeeOther)
+		roomIDs = append(roomIDs, roomID)
+	}
+	if len(roomIDs) == 0 {
+		return nil, time.Time{}, time.Time{}, "Choose at least one room"
+	}
 
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	const layout = "2006-01-02"
+	start, err := time.Parse(layout, r.Form.Get("start"))
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, "Error parsing start date"
+	}
+	last, err := time.Parse(layout, r.Form.Get("end"))
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, "Error parsing end date"
 	}
+	if last.Before(start) {
+		return nil, time.Time{}, time.Time{}, "Error: the end date cannot be before the start date"
+	}
+	return roomIDs, start, last.AddDate(0, 0, 1), ""
 }
 
-// AdminPostReservationsCalendar handles post of reservation calendar
-func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+// AdminPostBlockRooms blocks the chosen rooms for the range of nights with the chosen restriction
+func (m *Repository) AdminPostBlockRooms(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		log.Print(err)
@@ -779,51 +2545,70 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 		return
 	}
 
-	year, _ := strconv.Atoi(r.Form.Get("y"))
-	month, _ := strconv.Atoi(r.Form.Get("m"))
+	roomIDs, start, end, errMsg := parseBlockForm(r)
+	if errMsg != "" {
+		m.App.Session.Put(r.Context(), "error", errMsg)
+		http.Redirect(w, r, calendarURL(r), http.StatusSeeOther)
+		return
+	}
+	restrictionID, err := strconv.Atoi(r.Form.Get("restriction_id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid restriction")
+		http.Redirect(w, r, calendarURL(r), http.StatusSeeOther)
+		return
+	}
+
+	var blocks []models.RoomRestriction
+	for _, roomID := range roomIDs {
+		blocks = append(blocks, models.RoomRestriction{
+			StartDate:     start,
+			EndDate:       end,
+			RoomID:        roomID,
+			RestrictionID: restrictionID,
+			Note:          strings.TrimSpace(r.Form.Get("note")),
+		})
+	}
+	err = m.DB.InsertBlocks(r.Context(), blocks)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Some of the rooms are already reserved or blocked for these dates")
+		http.Redirect(w, r, calendarURL(r), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error adding room restriction to DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rooms are blocked")
+	http.Redirect(w, r, calendarURL(r), http.StatusSeeOther)
+}
 
-	rooms, err := m.DB.AllRooms()
+// AdminPostUnblockRooms removes blocks of the chosen rooms for the range of nights
+func (m *Repository) AdminPostUnblockRooms(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
 		log.Print(err)
-		m.App.Session.Put(r.Context(), "error", "Error getting rooms from DB")
+		m.App.Session.Put(r.Context(), "error", "Error parsing the form")
 		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
 		return
 	}
 
-	form := forms.New(r.PostForm)
-	for _, room := range rooms {
-		// get the block map from the session (state previous to form posting)
-		oldMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", room.ID)).(map[string]int)
-		// loop though map, compare with posted data and remove unchecked blocks from DB
-		for name, value := range oldMap {
-			if value > 0 && !form.Has(fmt.Sprintf("remove_block_%d_%s", room.ID, name)) {
-				// remove appropriate restriction from DB
-				err := m.DB.DeleteBlockByID(value)
-				if err != nil {
-					log.Println(err)
-					m.App.Session.Put(r.Context(), "error", "Error removing room restriction from DB")
-					http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
-				}
-			}
-		}
+	roomIDs, start, end, errMsg := parseBlockForm(r)
+	if errMsg != "" {
+		m.App.Session.Put(r.Context(), "error", errMsg)
+		http.Redirect(w, r, calendarURL(r), http.StatusSeeOther)
+		return
 	}
-
-	// loop through the posted form, compare with map and add checked blocks
-	for name, _ := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			splitted := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(splitted[2])
-			startDate, _ := time.Parse("2006-01-2", splitted[3])
-			err := m.DB.InsertBlockForRoom(roomID, startDate)
-			if err != nil {
-				log.Println(err)
-				m.App.Session.Put(r.Context(), "error", "Error adding room restriction to DB")
-				http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
-				return
-			}
-		}
+	err = m.DB.RemoveBlocks(r.Context(), roomIDs, start, end)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error removing room restriction from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved!")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "flash", "Rooms are unblocked")
+	http.Redirect(w, r, calendarURL(r), http.StatusSeeOther)
 }
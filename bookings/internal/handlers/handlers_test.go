@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -14,6 +15,9 @@ import (
 	"time"
 
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/passwordreset"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository/dbrepo"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/twofactor"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -27,12 +31,21 @@ var theTests = []struct {
 }{
 	{"home", "/", http.StatusOK, false, false, false},
 	{"about", "/about", http.StatusOK, false, false, false},
-	{"gq", "/generals-quoters", http.StatusOK, false, false, false},
-	{"ms", "/majors-suite", http.StatusOK, false, false, false},
+	{"gq", "/generals-quoters", http.StatusMovedPermanently, false, true, false},
+	{"ms", "/majors-suite", http.StatusMovedPermanently, false, true, false},
+	{"rooms", "/rooms", http.StatusOK, false, false, false},
+	{"room", "/rooms/generals-quoters", http.StatusOK, false, false, false},
+	{"room-not-found", "/rooms/missing", http.StatusNotFound, false, false, false},
+	{"admin-rooms", "/admin/rooms", http.StatusOK, true, false, false},
+	{"admin-new-room", "/admin/rooms/new", http.StatusOK, true, false, false},
+	{"admin-show-room", "/admin/rooms/1", http.StatusOK, true, false, false},
+	{"admin-show-room-bad-id", "/admin/rooms/badid", http.StatusTemporaryRedirect, true, true, false},
 	{"sa", "/search-availability", http.StatusOK, false, false, false},
 	{"contact", "/contact", http.StatusOK, false, false, false},
 	{"non-existent", "/eggs/and/ham", http.StatusNotFound, false, false, false},
 	{"login", "/user/login", http.StatusOK, false, false, false},
+	{"my-booking", "/my-booking", http.StatusOK, false, false, false},
+	{"my-booking-not-found-yet", "/my-booking/reservation", http.StatusSeeOther, false, true, false},
 	{"logout", "/user/logout", http.StatusSeeOther, true, true, false},
 	{"dashboard", "/admin/dashboard", http.StatusOK, true, false, false},
 	{"dashboard-denied", "/admin/dashboard", http.StatusSeeOther, false, true, false},
@@ -203,7 +216,7 @@ func TestRepository_PostReservation(t *testing.T) {
 			RoomId:    2, // inserting reservation in testDBRepo fails when RoomId = 2
 		}, http.StatusTemporaryRedirect,
 		},
-		{"error-inserting-restriction", map[string]string{
+		{"room-no-longer-available", map[string]string{
 			"first_name": "John",
 			"last_name":  "Smith",
 			"email":      "john.smith@email.com",
@@ -211,8 +224,8 @@ func TestRepository_PostReservation(t *testing.T) {
 		}, &models.Reservation{
 			StartDate: time.Date(2060, 11, 11, 0, 0, 0, 0, time.UTC),
 			EndDate:   time.Date(2060, 11, 15, 0, 0, 0, 0, time.UTC),
-			RoomId:    1000, // inserting restriction in testDBRepo fails when RoomId = 1000
-		}, http.StatusTemporaryRedirect,
+			RoomId:    1000, // room in testDBRepo is taken when RoomId = 1000
+		}, http.StatusSeeOther,
 		},
 	}
 
@@ -448,24 +461,34 @@ func TestRepository_BookRoom(t *testing.T) {
 }
 
 func TestRepository_Login(t *testing.T) {
+	const tooMany = "Too many failed logins. Please try again later"
 	tests := []struct {
 		name               string
 		email              string
+		password           string
+		remoteAddr         string
 		expectedStatusCode int
 		expectedHtml       string
 		expectedLocation   string
+		expectedError      string
 	}{
-		{"valid-creds", "me@here.ca", http.StatusSeeOther, "", "/"},
-		{"invalid-creds", "jack@nimble.com", http.StatusSeeOther, "", "/user/login"},
-		{"validation-error", "this.is.not.an.email", http.StatusOK, `action="/user/login`, ""},
+		{"valid-creds", "me@here.ca", "password", "198.51.100.7:1234", http.StatusSeeOther, "", "/", ""},
+		{"two-factor", "2fa@here.ca", "password", "198.51.100.7:1234", http.StatusSeeOther, "", "/user/two-factor", ""},
+		{"invalid-creds", "jack@nimble.com", "password", "198.51.100.7:1234", http.StatusSeeOther, "", "/user/login", "Invalid login!"},
+		{"wrong-password", "me@here.ca", "wrong", "198.51.100.7:1234", http.StatusSeeOther, "", "/user/login", "Invalid login!"},
+		{"locked-account", "locked@here.ca", "password", "198.51.100.7:1234", http.StatusSeeOther, "", "/user/login", tooMany},
+		{"throttled-ip", "me@here.ca", "password", "203.0.113.1:1234", http.StatusSeeOther, "", "/user/login", tooMany},
+		{"ip-db-error", "me@here.ca", "password", "203.0.113.2:1234", http.StatusInternalServerError, "", "", ""},
+		{"validation-error", "this.is.not.an.email", "password", "198.51.100.7:1234", http.StatusOK, `action="/user/login`, "", ""},
 	}
 
 	for _, e := range tests {
 		postedData := url.Values{
 			"email":    {e.email},
-			"password": {"password"},
+			"password": {e.password},
 		}
 		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		req.RemoteAddr = e.remoteAddr
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -488,6 +511,320 @@ func TestRepository_Login(t *testing.T) {
 				t.Errorf("%s: expected to find %q in result but did not; actual result is %q", e.name, e.expectedHtml, actualHTML)
 			}
 		}
+		if message := app.Session.GetString(ctx, "error"); message != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, message)
+		}
+		if e.name == "valid-creds" {
+			if level := app.Session.GetInt(ctx, "access_level"); level != int(models.RoleOwner) {
+				t.Errorf("%s: expected access level %d in session, but got %d", e.name, models.RoleOwner, level)
+			}
+		}
+		if e.name == "two-factor" {
+			if id := app.Session.GetInt(ctx, "user_id"); id != 0 {
+				t.Errorf("%s: logged in as user %d before the second step", e.name, id)
+			}
+			if id := app.Session.GetInt(ctx, "two_factor_user_id"); id != 3 {
+				t.Errorf("%s: expected user 3 to pass the second step, but got %d", e.name, id)
+			}
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	ts := httptest.NewTLSServer(getRoutes())
+	defer ts.Close()
+	IsAuthenticated = true
+	defer func() {
+		IsAuthenticated = false
+		CurrentRole = models.RoleOwner
+	}()
+
+	tests := []struct {
+		name           string
+		role           models.Role
+		method         string
+		url            string
+		expectedStatus int
+	}{
+		{"viewer sees reservations", models.RoleViewer, "GET", "/admin/reservations-all", http.StatusOK},
+		{"viewer sees rooms", models.RoleViewer, "GET", "/admin/rooms", http.StatusOK},
+		{"viewer cannot edit reservation", models.RoleViewer, "POST", "/admin/reservations/all/1", http.StatusForbidden},
+		{"viewer cannot block rooms", models.RoleViewer, "POST", "/admin/blocks", http.StatusForbidden},
+		{"viewer cannot see mail", models.RoleViewer, "GET", "/admin/mail", http.StatusForbidden},
+		{"front desk cannot delete reservation", models.RoleFrontDesk, "POST", "/admin/reservations/all/1/delete", http.StatusForbidden},
+		{"reservation is not deleted by a link", models.RoleOwner, "GET", "/admin/reservations/all/1/delete", http.StatusMethodNotAllowed},
+		{"viewer cannot change reservation status", models.RoleViewer, "POST", "/admin/reservations/all/1/status", http.StatusForbidden},
+		{"front desk cannot edit room", models.RoleFrontDesk, "POST", "/admin/rooms/1", http.StatusForbidden},
+		{"front desk cannot delete room", models.RoleFrontDesk, "POST", "/admin/rooms/1/delete", http.StatusForbidden},
+		{"front desk sees mail", models.RoleFrontDesk, "GET", "/admin/mail", http.StatusOK},
+		{"front desk cannot delete user", models.RoleFrontDesk, "POST", "/admin/users/2/delete", http.StatusForbidden},
+		{"user is not deleted by a link", models.RoleOwner, "GET", "/admin/users/2/delete", http.StatusMethodNotAllowed},
+		{"no role", models.Role(0), "GET", "/admin/reservations-all", http.StatusForbidden},
+	}
+
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	for _, e := range tests {
+		CurrentRole = e.role
+		req, _ := http.NewRequest(e.method, ts.URL+e.url, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("%s: error running request: %q", e.name, err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != e.expectedStatus {
+			t.Errorf("%s: bad status code. Expected %d, but got %d", e.name, e.expectedStatus, resp.StatusCode)
+		}
+		if e.expectedStatus == http.StatusForbidden && !strings.Contains(string(body), "Access denied") {
+			t.Errorf("%s: 403 page is not rendered", e.name)
+		}
+	}
+}
+
+func TestRepository_PostMyBooking(t *testing.T) {
+	tests := []struct {
+		name               string
+		code               string
+		email              string
+		remoteAddr         string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedBookingID  int
+		expectedError      string
+	}{
+		{"success", "abcde-12345", "john@smith.com", "198.51.100.7:1234", http.StatusSeeOther, "/my-booking/reservation", 1, ""},
+		{"not-found", "NOTFOUND00", "john@smith.com", "198.51.100.7:1234", http.StatusSeeOther, "/my-booking", 0,
+			"No reservation found for this confirmation code and email"},
+		{"db-error", "ERROR00000", "john@smith.com", "198.51.100.7:1234", http.StatusTemporaryRedirect, "/", 0,
+			"Error getting reservation from DB"},
+		{"validation-error", "", "this.is.not.an.email", "198.51.100.7:1234", http.StatusBadRequest, "", 0, ""},
+		{"throttled-ip", "abcde-12345", "john@smith.com", "203.0.113.1:1234", http.StatusSeeOther, "/my-booking", 0,
+			"Too many failed attempts. Please try again later"},
+		{"attempts-db-error", "abcde-12345", "john@smith.com", "203.0.113.2:1234", http.StatusInternalServerError, "", 0, ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{
+			"confirmation_code": {e.code},
+			"email":             {e.email},
+		}
+		req, _ := http.NewRequest("POST", "/my-booking", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = e.remoteAddr
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostMyBooking)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		if id := app.Session.GetInt(ctx, "booking_id"); id != e.expectedBookingID {
+			t.Errorf("%s: expected booking id %d in session but got %d", e.name, e.expectedBookingID, id)
+		}
+		if value := app.Session.PopString(ctx, "error"); value != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, value)
+		}
+	}
+}
+
+func TestRepository_MyBooking(t *testing.T) {
+	validContact := map[string]string{
+		"first_name": "John",
+		"last_name":  "Smith",
+		"email":      "john@smith.com",
+		"phone":      "1234567890",
+	}
+	tests := []struct {
+		name                 string
+		handler              http.HandlerFunc
+		bookingID            int
+		formFields           map[string]string
+		expectedStatusCode   int
+		expectedLocation     string
+		expectedSessionKey   string
+		expectedSessionValue string
+		expectedHtml         string
+	}{
+		{"show-no-booking", Repo.ShowMyBooking, 0, nil, http.StatusSeeOther, "/my-booking",
+			"error", "Please enter your confirmation code and email first", ""},
+		{"show-cancellable", Repo.ShowMyBooking, 1, nil, http.StatusOK, "", "", "", `action="/my-booking/cancel"`},
+		{"show-within-window", Repo.ShowMyBooking, 3, nil, http.StatusOK, "", "", "", "can no longer be cancelled online"},
+		{"contact-success", Repo.PostMyBookingContact, 1, validContact, http.StatusSeeOther, "/my-booking/reservation",
+			"flash", "Your contact details have been updated", ""},
+		{"contact-invalid", Repo.PostMyBookingContact, 1, map[string]string{"email": "not.an.email"}, http.StatusBadRequest, "",
+			"", "", `action="/my-booking/contact"`},
+		{"contact-cancelled", Repo.PostMyBookingContact, 4, validContact, http.StatusSeeOther, "/my-booking/reservation",
+			"error", "This reservation can no longer be changed", ""},
+		{"cancel-success", Repo.PostMyBookingCancel, 1, nil, http.StatusSeeOther, "/my-booking/reservation",
+			"flash", "Your reservation has been cancelled", ""},
+		{"cancel-within-window", Repo.PostMyBookingCancel, 3, nil, http.StatusSeeOther, "/my-booking/reservation",
+			"error", "This reservation can no longer be cancelled online. Please contact us.", ""},
+		{"cancel-already-cancelled", Repo.PostMyBookingCancel, 4, nil, http.StatusSeeOther, "/my-booking/reservation",
+			"error", "This reservation can no longer be cancelled online. Please contact us.", ""},
+		{"cancel-db-error", Repo.PostMyBookingCancel, 100, nil, http.StatusSeeOther, "/my-booking/reservation",
+			"error", "Error cancelling reservation", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/my-booking", strings.NewReader(composeUrlParams(e.formFields)))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if e.bookingID != 0 {
+			app.Session.Put(ctx, "booking_id", e.bookingID)
+		}
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		if e.expectedSessionKey != "" {
+			value := app.Session.Pop(ctx, e.expectedSessionKey)
+			if e.expectedSessionValue != value {
+				t.Errorf("%s: got an unexpected %q value from session; expected %q but got %q", e.name, e.expectedSessionKey, e.expectedSessionValue, value)
+			}
+		}
+		if e.expectedHtml != "" && !strings.Contains(rr.Body.String(), e.expectedHtml) {
+			t.Errorf("%s: expected to find %q in result but did not", e.name, e.expectedHtml)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoom(t *testing.T) {
+	validRoom := map[string]string{
+		"room_name":  "Colonel's Cabin",
+		"slug":       "colonels-cabin",
+		"capacity":   "3",
+		"base_price": "150.50",
+		"amenities":  "Ocean view\r\nFree Wi-Fi",
+	}
+	withFields := func(fields map[string]string) map[string]string {
+		form := map[string]string{}
+		for k, v := range validRoom {
+			form[k] = v
+		}
+		for k, v := range fields {
+			form[k] = v
+		}
+		return form
+	}
+	tests := []struct {
+		name                 string
+		id                   string
+		formFields           map[string]string
+		expectedStatusCode   int
+		expectedLocation     string
+		expectedSessionKey   string
+		expectedSessionValue string
+		expectedHtml         string
+	}{
+		{"insert", "new", validRoom, http.StatusSeeOther, "/admin/rooms", "flash", "Changes successfully saved", ""},
+		{"update", "1", validRoom, http.StatusSeeOther, "/admin/rooms", "flash", "Changes successfully saved", ""},
+		{"bad id", "badid", validRoom, http.StatusTemporaryRedirect, "/admin/dashboard", "error", "Invalid room id", ""},
+		{"invalid slug", "1", withFields(map[string]string{"slug": "Colonel's Cabin"}), http.StatusBadRequest, "", "", "",
+			"Only lowercase letters, digits and hyphens are allowed."},
+		{"invalid capacity", "1", withFields(map[string]string{"capacity": "0"}), http.StatusBadRequest, "", "", "",
+			"This field must be a whole number not less than 1."},
+		{"invalid price", "new", withFields(map[string]string{"base_price": "free"}), http.StatusBadRequest, "", "", "",
+			"Invalid price"},
+		{"duplicate slug", "new", withFields(map[string]string{"slug": "duplicate"}), http.StatusBadRequest, "", "", "",
+			"Another room already has this slug."},
+		{"db error", "1", withFields(map[string]string{"room_name": "error"}), http.StatusTemporaryRedirect, "/admin/dashboard",
+			"error", "Error saving room in DB", ""},
+		{"not found", "404", validRoom, http.StatusSeeOther, "/admin/rooms", "error", "Room not found", ""},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/{id}", strings.NewReader(composeUrlParams(e.formFields)))
+		ctx := getCtx(req)
+		ctx = addParamsToChiContext(ctx, map[string]string{"id": e.id})
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		if e.expectedSessionKey != "" {
+			value := app.Session.Pop(ctx, e.expectedSessionKey)
+			if e.expectedSessionValue != value {
+				t.Errorf("%s: got an unexpected %q value from session; expected %q but got %q", e.name, e.expectedSessionKey, e.expectedSessionValue, value)
+			}
+		}
+		if e.expectedHtml != "" && !strings.Contains(rr.Body.String(), e.expectedHtml) {
+			t.Errorf("%s: expected to find %q in result but did not", e.name, e.expectedHtml)
+		}
+	}
+}
+
+func TestRepository_AdminPostDeleteRoom(t *testing.T) {
+	tests := []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedSessionKey string
+		expectedSessionVal string
+	}{
+		{"success", "3", http.StatusSeeOther, "/admin/rooms", "flash", "Successfully deleted room"},
+		{"bad id", "badid", http.StatusTemporaryRedirect, "/admin/dashboard", "error", "Invalid room id"},
+		{"in use", "200", http.StatusSeeOther, "/admin/rooms/200", "error", "Room has reservations and cannot be deleted"},
+		{"db error", "100", http.StatusTemporaryRedirect, "/admin/dashboard", "error", "Error deleting room"},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/{id}/delete", nil)
+		ctx := getCtx(req)
+		ctx = addParamsToChiContext(ctx, map[string]string{"id": e.id})
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostDeleteRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		actualLocation, _ := rr.Result().Location()
+		if actualLocation.String() != e.expectedLocation {
+			t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+		}
+		if value := app.Session.Pop(ctx, e.expectedSessionKey); value != e.expectedSessionVal {
+			t.Errorf("%s: got an unexpected %q value from session; expected %q but got %q", e.name, e.expectedSessionKey, e.expectedSessionVal, value)
+		}
+	}
+}
+
+func TestParseCents(t *testing.T) {
+	tests := map[string]int{"0": 0, "129": 12900, "129.5": 12950, "129.05": 12905}
+	for price, expected := range tests {
+		if cents := parseCents(price); cents != expected {
+			t.Errorf("%q: expected %d cents but got %d", price, expected, cents)
+		}
 	}
 }
 
@@ -496,9 +833,22 @@ func TestRepository_PostShowReservation(t *testing.T) {
 		"first_name": "John",
 		"last_name":  "Smith",
 		"email":      "john.smith@email.com",
+		"start_date": "2050-01-01",
+		"end_date":   "2050-01-03",
+		"room_id":    "1",
 		"year":       "2023",
 		"month":      "06",
 	}
+	withFields := func(fields map[string]string) map[string]string {
+		form := map[string]string{}
+		for k, v := range myForm {
+			form[k] = v
+		}
+		for k, v := range fields {
+			form[k] = v
+		}
+		return form
+	}
 	tests := []struct {
 		name                 string
 		url                  string
@@ -515,8 +865,18 @@ func TestRepository_PostShowReservation(t *testing.T) {
 			http.StatusTemporaryRedirect, "/admin/dashboard", "error", "Invalid reservation id", false},
 		{"error fetching reservation", "/admin/reservations/{src}/{id}", myForm, "10", "all",
 			http.StatusTemporaryRedirect, "/admin/dashboard", "error", "Error getting reservation from DB", true},
-		{"error updating reservation", "/admin/reservations/{src}/{id}", map[string]string{"first_name": "error"}, "10", "all",
+		{"error updating reservation", "/admin/reservations/{src}/{id}", withFields(map[string]string{"first_name": "error"}), "10", "all",
 			http.StatusTemporaryRedirect, "/admin/dashboard", "error", "Error updating reservation in DB", false},
+		{"bad start date", "/admin/reservations/{src}/{id}", withFields(map[string]string{"start_date": "invalid"}), "10", "all",
+			http.StatusSeeOther, "/admin/reservations/all/10", "error", "Error parsing start date", false},
+		{"bad end date", "/admin/reservations/{src}/{id}", withFields(map[string]string{"end_date": "invalid"}), "10", "new",
+			http.StatusSeeOther, "/admin/reservations/new/10", "error", "Error parsing end date", false},
+		{"end before start", "/admin/reservations/{src}/{id}", withFields(map[string]string{"end_date": "2049-12-31"}), "10", "cal",
+			http.StatusSeeOther, "/admin/reservations/cal/10?y=2023&m=06", "error", "Error: the departure date must be after the arrival date", false},
+		{"bad room id", "/admin/reservations/{src}/{id}", withFields(map[string]string{"room_id": "invalid"}), "10", "all",
+			http.StatusSeeOther, "/admin/reservations/all/10", "error", "Invalid room id", false},
+		{"room not available", "/admin/reservations/{src}/{id}", withFields(map[string]string{"room_id": "1000"}), "10", "all",
+			http.StatusSeeOther, "/admin/reservations/all/10", "error", "The room is not available for the chosen dates", false},
 		{"success all", "/admin/reservations/{src}/{id}", myForm, "10", "all",
 			http.StatusSeeOther, "/admin/reservations-all", "flash", "Changes successfully saved", false},
 		{"success new", "/admin/reservations/{src}/{id}", myForm, "10", "new",
@@ -570,6 +930,7 @@ func TestRepository_ReservationCalendar(t *testing.T) {
 	}{
 		{"now", "", "", http.StatusOK, "", fmt.Sprintf("%s %s", nowMonthName, nowYear), false, map[string]string{}},
 		{"2024-05", "2024", "05", http.StatusOK, "", "May 2024", false, map[string]string{}},
+		{"blocks", "2024", "05", http.StatusOK, "", "Maintenance: Broken boiler", false, map[string]string{}},
 		{"error fetching rooms", "", "", http.StatusTemporaryRedirect, "/admin/dashboard", "", true, map[string]string{"error": "Error fetching rooms from DB"}},
 	}
 
@@ -613,37 +974,41 @@ func TestRepository_ReservationCalendar(t *testing.T) {
 	}
 }
 
-func TestRepository_ProcessReservation(t *testing.T) {
+func TestRepository_UpdateReservationStatus(t *testing.T) {
 	tests := []struct {
 		name                  string
 		id                    string
 		source                string
-		queryParams           map[string]string
+		formFields            map[string]string
 		expectedStatusCode    int
 		expectedLocation      string
 		expectedSessionValues map[string]string
 	}{
-		{"bad id", "badid", "all", map[string]string{}, http.StatusTemporaryRedirect, "/admin/dashboard",
+		{"bad id", "badid", "all", map[string]string{"status": "confirmed"}, http.StatusTemporaryRedirect, "/admin/dashboard",
 			map[string]string{"error": "Invalid reservation id"}},
-		{"db-error", "100", "all", map[string]string{}, http.StatusTemporaryRedirect, "/admin/dashboard",
-			map[string]string{"error": "Error marking reservation as processed"}},
-		{"success-all", "10", "all", map[string]string{}, http.StatusSeeOther, "/admin/reservations-all",
-			map[string]string{"flash": "Successfully marked as processed"}},
-		{"success-new", "11", "new", map[string]string{}, http.StatusSeeOther, "/admin/reservations-new",
-			map[string]string{"flash": "Successfully marked as processed"}},
-		{"success-cal", "11", "cal", map[string]string{"y": "2025", "m": "04"}, http.StatusSeeOther, "/admin/reservations-calendar?y=2025&m=04",
-			map[string]string{"flash": "Successfully marked as processed"}},
+		{"bad status", "10", "all", map[string]string{"status": "processed"}, http.StatusTemporaryRedirect, "/admin/dashboard",
+			map[string]string{"error": "Invalid reservation status"}},
+		{"db-error", "100", "all", map[string]string{"status": "confirmed"}, http.StatusTemporaryRedirect, "/admin/dashboard",
+			map[string]string{"error": "Error updating reservation status"}},
+		{"transition-not-allowed", "200", "all", map[string]string{"status": "checked_out"}, http.StatusTemporaryRedirect, "/admin/dashboard",
+			map[string]string{"error": "Reservation cannot be marked as checked out"}},
+		{"success-all", "10", "all", map[string]string{"status": "confirmed"}, http.StatusSeeOther, "/admin/reservations-all",
+			map[string]string{"flash": "Reservation is marked as confirmed"}},
+		{"success-new", "11", "new", map[string]string{"status": "cancelled"}, http.StatusSeeOther, "/admin/reservations-new",
+			map[string]string{"flash": "Reservation is marked as cancelled"}},
+		{"success-cal", "11", "cal", map[string]string{"status": "no_show", "y": "2025", "m": "04"}, http.StatusSeeOther, "/admin/reservations-calendar?y=2025&m=04",
+			map[string]string{"flash": "Reservation is marked as no show"}},
 	}
 
 	for _, e := range tests {
 		var req *http.Request
-		req, _ = http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/{src}/{id}?%s", composeUrlParams(e.queryParams)), nil)
+		req, _ = http.NewRequest("POST", "/admin/reservations/{src}/{id}/status", strings.NewReader(composeUrlParams(e.formFields)))
 		ctx := getCtx(req)
 		ctx = addParamsToChiContext(ctx, map[string]string{"id": e.id, "src": e.source})
 		req = req.WithContext(ctx)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(Repo.AdminProcessReservation)
+		handler := http.HandlerFunc(Repo.AdminPostReservationStatus)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
@@ -669,7 +1034,7 @@ func TestRepository_DeleteReservation(t *testing.T) {
 		name                  string
 		id                    string
 		source                string
-		queryParams           map[string]string
+		formFields            map[string]string
 		expectedStatusCode    int
 		expectedLocation      string
 		expectedSessionValues map[string]string
@@ -688,13 +1053,13 @@ func TestRepository_DeleteReservation(t *testing.T) {
 
 	for _, e := range tests {
 		var req *http.Request
-		req, _ = http.NewRequest("GET", fmt.Sprintf("/admin/delete-reservation/{src}/{id}?%s", composeUrlParams(e.queryParams)), nil)
+		req, _ = http.NewRequest("POST", "/admin/reservations/{src}/{id}/delete", strings.NewReader(composeUrlParams(e.formFields)))
 		ctx := getCtx(req)
 		ctx = addParamsToChiContext(ctx, map[string]string{"id": e.id, "src": e.source})
 		req = req.WithContext(ctx)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+		handler := http.HandlerFunc(Repo.AdminPostDeleteReservation)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
@@ -721,81 +1086,206 @@ func TestPostReservationCalendar(t *testing.T) {
 		postedData           url.Values
 		expectedResponseCode int
 		expectedLocation     string
-		expectedHTML         string
-		blocks               int
-		reservations         int
-	}{
-		{
-			name: "cal",
-			postedData: url.Values{
-				"year":  {time.Now().Format("2006")},
-				"month": {time.Now().Format("01")},
-				fmt.Sprintf("add_block_1_%s", time.Now().AddDate(0, 0, 2).Format("2006-01-2")): {"1"},
-			},
-			expectedResponseCode: http.StatusSeeOther,
-		},
-		{
-			name:                 "cal-blocks",
-			postedData:           url.Values{},
-			expectedResponseCode: http.StatusSeeOther,
-			blocks:               1,
-		},
-		{
-			name:                 "cal-res",
-			postedData:           url.Values{},
-			expectedResponseCode: http.StatusSeeOther,
-			reservations:         1,
-		},
+		expectedMessage      string
+	}{
+		{"no blocks", url.Values{"y": {"2024"}, "m": {"05"}},
+			http.StatusSeeOther, "/admin/reservations-calendar?y=2024&m=05", "Changes saved!"},
+		{"remove blocks", url.Values{"y": {"2024"}, "m": {"05"}, "remove_block": {"1", "2"}},
+			http.StatusSeeOther, "/admin/reservations-calendar?y=2024&m=05", "Changes saved!"},
+		{"invalid block id", url.Values{"y": {"2024"}, "m": {"05"}, "remove_block": {"x"}},
+			http.StatusSeeOther, "/admin/reservations-calendar?y=2024&m=05", "Invalid block id"},
+		{"error removing block", url.Values{"y": {"2024"}, "m": {"05"}, "remove_block": {"100"}},
+			http.StatusTemporaryRedirect, "/admin/dashboard", "Error removing room restriction from DB"},
 	}
 
 	for _, e := range tests {
-		var req *http.Request
-		if e.postedData != nil {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
-		} else {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", nil)
-		}
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
 
-		now := time.Now()
-		bm := make(map[string]int)
-		rm := make(map[string]int)
-
-		currentYear, currentMonth, _ := now.Date()
-		currentLocation := now.Location()
-
-		firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-		lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		handler.ServeHTTP(rr, req)
 
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			rm[d.Format("2006-01-2")] = 0
-			bm[d.Format("2006-01-2")] = 0
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
-
-		if e.blocks > 0 {
-			bm[firstOfMonth.Format("2006-01-2")] = e.blocks
+		actualLocation, _ := rr.Result().Location()
+		if actualLocation.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+		}
+		message := session.GetString(ctx, "flash") + session.GetString(ctx, "error")
+		if message != e.expectedMessage {
+			t.Errorf("failed %s: expected message %q, but got %q", e.name, e.expectedMessage, message)
 		}
+	}
+}
 
-		if e.reservations > 0 {
-			rm[lastOfMonth.Format("2006-01-2")] = e.reservations
+func TestPostBlockRooms(t *testing.T) {
+	valid := func(changes map[string][]string) url.Values {
+		values := url.Values{
+			"y":              {"2024"},
+			"m":              {"05"},
+			"room_id":        {"1", "2"},
+			"start":          {"2024-05-10"},
+			"end":            {"2024-05-12"},
+			"restriction_id": {"3"},
+			"note":           {"Painting"},
 		}
+		for k, v := range changes {
+			values[k] = v
+		}
+		return values
+	}
+	calendar := "/admin/reservations-calendar?y=2024&m=05"
 
-		session.Put(ctx, "block_map_1", bm)
-		session.Put(ctx, "reservation_map_1", rm)
+	var tests = []struct {
+		name                 string
+		handler              http.HandlerFunc
+		postedData           url.Values
+		expectedResponseCode int
+		expectedLocation     string
+		expectedMessage      string
+	}{
+		{"block", Repo.AdminPostBlockRooms, valid(nil),
+			http.StatusSeeOther, calendar, "Rooms are blocked"},
+		{"block single night", Repo.AdminPostBlockRooms, valid(map[string][]string{"end": {"2024-05-10"}}),
+			http.StatusSeeOther, calendar, "Rooms are blocked"},
+		{"block no rooms", Repo.AdminPostBlockRooms, valid(map[string][]string{"room_id": nil}),
+			http.StatusSeeOther, calendar, "Choose at least one room"},
+		{"block invalid room", Repo.AdminPostBlockRooms, valid(map[string][]string{"room_id": {"x"}}),
+			http.StatusSeeOther, calendar, "Invalid room id"},
+		{"block invalid start", Repo.AdminPostBlockRooms, valid(map[string][]string{"start": {"invalid"}}),
+			http.StatusSeeOther, calendar, "Error parsing start date"},
+		{"block invalid end", Repo.AdminPostBlockRooms, valid(map[string][]string{"end": {"invalid"}}),
+			http.StatusSeeOther, calendar, "Error parsing end date"},
+		{"block end before start", Repo.AdminPostBlockRooms, valid(map[string][]string{"end": {"2024-05-09"}}),
+			http.StatusSeeOther, calendar, "Error: the end date cannot be before the start date"},
+		{"block invalid restriction", Repo.AdminPostBlockRooms, valid(map[string][]string{"restriction_id": {"x"}}),
+			http.StatusSeeOther, calendar, "Invalid restriction"},
+		{"block room taken", Repo.AdminPostBlockRooms, valid(map[string][]string{"note": {"taken"}}),
+			http.StatusSeeOther, calendar, "Some of the rooms are already reserved or blocked for these dates"},
+		{"block db error", Repo.AdminPostBlockRooms, valid(map[string][]string{"note": {"error"}}),
+			http.StatusTemporaryRedirect, "/admin/dashboard", "Error adding room restriction to DB"},
+		{"unblock", Repo.AdminPostUnblockRooms, valid(nil),
+			http.StatusSeeOther, calendar, "Rooms are unblocked"},
+		{"unblock no rooms", Repo.AdminPostUnblockRooms, valid(map[string][]string{"room_id": nil}),
+			http.StatusSeeOther, calendar, "Choose at least one room"},
+		{"unblock end before start", Repo.AdminPostUnblockRooms, valid(map[string][]string{"end": {"2024-05-09"}}),
+			http.StatusSeeOther, calendar, "Error: the end date cannot be before the start date"},
+		{"unblock db error", Repo.AdminPostUnblockRooms, valid(map[string][]string{"room_id": {"1", "100"}}),
+			http.StatusTemporaryRedirect, "/admin/dashboard", "Error removing room restriction from DB"},
+	}
 
-		// set the header
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/blocks", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
 
-		// call the handler
-		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
-		handler.ServeHTTP(rr, req)
+		e.handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
+		actualLocation, _ := rr.Result().Location()
+		if actualLocation.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+		}
+		message := session.GetString(ctx, "flash") + session.GetString(ctx, "error")
+		if message != e.expectedMessage {
+			t.Errorf("failed %s: expected message %q, but got %q", e.name, e.expectedMessage, message)
+		}
+	}
+}
+
+func TestCalendarCells(t *testing.T) {
+	first := time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)
+	restrictions := []models.RoomRestriction{
+		// reservation started in April and leaves on May 3rd
+		{ID: 1, ReservationID: 7, StartDate: first.AddDate(0, 0, -3), EndDate: first.AddDate(0, 0, 2)},
+		// block of the nights from May 10th to 11th
+		{ID: 2, StartDate: first.AddDate(0, 0, 9), EndDate: first.AddDate(0, 0, 11),
+			Note: "Broken boiler", Restriction: models.Restriction{RestrictionName: "Maintenance"}},
+		// block lasting until June
+		{ID: 3, StartDate: first.AddDate(0, 0, 29), EndDate: first.AddDate(0, 1, 5)},
+		// legacy block of May 20th, ending on the day it starts
+		{ID: 4, StartDate: first.AddDate(0, 0, 19), EndDate: first.AddDate(0, 0, 19)},
+	}
+
+	cells := calendarCells(restrictions, first, 31)
+
+	days := 0
+	for _, c := range cells {
+		days += c.Span
+	}
+	if days != 31 {
+		t.Errorf("cells cover %d days instead of 31", days)
+	}
+	expected := []calendarCell{
+		{Day: 1, Span: 2, ReservationID: 7, Title: "Reservation"},
+		{Day: 10, Span: 2, BlockID: 2, Title: "Maintenance", Note: "Broken boiler"},
+		{Day: 20, Span: 1, BlockID: 4},
+		{Day: 30, Span: 2, BlockID: 3},
+	}
+	for _, exp := range expected {
+		found := false
+		for _, c := range cells {
+			if c.Day == exp.Day {
+				found = true
+				if c != exp {
+					t.Errorf("day %d: expected cell %+v, but got %+v", exp.Day, exp, c)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("no cell starts on day %d", exp.Day)
+		}
+	}
+	// three spans of two days, the one-day block and a cell for each of the 24 free days
+	if len(cells) != 28 {
+		t.Errorf("expected %d cells, but got %d", 28, len(cells))
+	}
+}
+
+func TestRepository_CancelledRequest(t *testing.T) {
+	tests := []struct {
+		name            string
+		method          string
+		url             string
+		body            string
+		handler         http.HandlerFunc
+		expectedStatus  int
+		expectedMessage string
+	}{
+		{"search-availability", "POST", "/search-availability",
+			composeUrlParams(map[string]string{"start": "2060-01-05", "end": "2060-01-06"}),
+			Repo.PostAvailability, http.StatusTemporaryRedirect, "Error searching availability in DB"},
+		{"book-room", "GET", "/book-room?" + composeUrlParams(map[string]string{"id": "1", "start": "2060-01-01", "end": "2060-01-10"}),
+			"", Repo.BookRoom, http.StatusTemporaryRedirect, "Error getting room from DB"},
+		{"all-reservations", "GET", "/admin/reservations-all", "",
+			Repo.AdminAllReservations, http.StatusTemporaryRedirect, "Error getting reservations from DB"},
+	}
 
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		// the client has gone away before the handler queries the database
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		actualMessage := session.PopString(ctx, "error")
+		if actualMessage != e.expectedMessage {
+			t.Errorf("%s: bad error message; expected %q but got %q", e.name, e.expectedMessage, actualMessage)
+		}
 	}
 }
 
@@ -823,3 +1313,715 @@ func addParamsToChiContext(parentCtx context.Context, params map[string]string)
 	}
 	return context.WithValue(parentCtx, chi.RouteCtxKey, chiCtx)
 }
+
+func TestRepository_AdminDashboard(t *testing.T) {
+	tests := []struct {
+		name          string
+		dbFetchError  bool
+		expectedHTML  []string
+		expectedError string
+	}{
+		{"success", false, []string{"<h3>2</h3>", "<h3>4</h3>", "<h3>50%</h3>", "7 of 14 room nights", "John Smith", "Pending &rarr; Confirmed"}, ""},
+		{"db-error", true, []string{"Dashboard"}, "Error getting dashboard figures from DB"},
+	}
+
+	for _, e := range tests {
+		fetchError = e.dbFetchError
+		req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		// the error is shown on the dashboard itself, as the other pages redirect here on errors
+		http.HandlerFunc(Repo.AdminDashboard).ServeHTTP(rr, req)
+		fetchError = false
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, http.StatusOK, rr.Code)
+		}
+		for _, html := range e.expectedHTML {
+			if !strings.Contains(rr.Body.String(), html) {
+				t.Errorf("%s: expected %q in the page", e.name, html)
+			}
+		}
+		if e.expectedError != "" && !strings.Contains(rr.Body.String(), e.expectedError) {
+			t.Errorf("%s: expected error %q in the page", e.name, e.expectedError)
+		}
+	}
+}
+
+func TestRepository_AdminReservationLists(t *testing.T) {
+	tests := []struct {
+		name             string
+		url              string
+		expectedStatus   int
+		expectedLocation string
+		expectedHTML     []string
+		unexpectedHTML   []string
+		expectedError    string
+	}{
+		{"all", "/admin/reservations-all", http.StatusOK, "",
+			[]string{"Smith, John", "Smith, Jane", "1-2 of 2 reservations"}, []string{"Previous", "Next"}, ""},
+		{"search", "/admin/reservations-all?q=JANE", http.StatusOK, "",
+			[]string{"Smith, Jane", "1-1 of 1 reservations"}, []string{"Smith, John"}, ""},
+		{"room", "/admin/reservations-all?room=1", http.StatusOK, "", []string{"Smith, John"}, []string{"Smith, Jane"}, ""},
+		{"dates", "/admin/reservations-all?from=2060-01-15&to=2060-01-20", http.StatusOK, "",
+			[]string{"Smith, Jane"}, []string{"Smith, John"}, ""},
+		{"status", "/admin/reservations-all?status=confirmed", http.StatusOK, "",
+			[]string{"Smith, Jane"}, []string{"Smith, John"}, ""},
+		{"new-only-pending", "/admin/reservations-new?status=confirmed", http.StatusOK, "",
+			[]string{"Smith, John"}, []string{"Smith, Jane", `name="status"`}, ""},
+		{"first-page", "/admin/reservations-all?size=10&q=smith", http.StatusOK, "", []string{"page 1 of 1"}, []string{"Next"}, ""},
+		{"sorted", "/admin/reservations-all?sort=name", http.StatusOK, "",
+			[]string{`href="/admin/reservations-all?dir=desc&amp;sort=name"`, "\u25b2"}, nil, ""},
+		{"empty-page", "/admin/reservations-all?page=3&size=10", http.StatusOK, "",
+			[]string{`href="/admin/reservations-all?page=2&amp;size=10"`}, []string{"Smith", "Next"}, ""},
+		{"bad-room", "/admin/reservations-all?room=x", http.StatusSeeOther, "/admin/reservations-all", nil, nil, "Invalid room id"},
+		{"bad-from", "/admin/reservations-all?from=x", http.StatusSeeOther, "/admin/reservations-all", nil, nil, "Error parsing start date"},
+		{"bad-to", "/admin/reservations-new?to=x", http.StatusSeeOther, "/admin/reservations-new", nil, nil, "Error parsing end date"},
+		{"reversed", "/admin/reservations-all?from=2060-01-02&to=2060-01-01", http.StatusSeeOther, "/admin/reservations-all", nil, nil,
+			"Error: the end date cannot be before the start date"},
+		{"bad-status", "/admin/reservations-all?status=x", http.StatusSeeOther, "/admin/reservations-all", nil, nil, "Invalid reservation status"},
+		{"bad-sort", "/admin/reservations-all?sort=email", http.StatusSeeOther, "/admin/reservations-all", nil, nil, "Invalid sort column"},
+		{"bad-dir", "/admin/reservations-all?dir=up", http.StatusSeeOther, "/admin/reservations-all", nil, nil, "Invalid sort direction"},
+		{"bad-page", "/admin/reservations-all?page=0", http.StatusSeeOther, "/admin/reservations-all", nil, nil, "Invalid page number"},
+		{"bad-size", "/admin/reservations-all?size=1000", http.StatusSeeOther, "/admin/reservations-all", nil, nil, "Invalid page size"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler := Repo.AdminAllReservations
+		if strings.HasPrefix(e.url, "/admin/reservations-new") {
+			handler = Repo.AdminNewReservations
+		}
+		http.HandlerFunc(handler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		for _, html := range e.expectedHTML {
+			if !strings.Contains(rr.Body.String(), html) {
+				t.Errorf("%s: expected %q in the page", e.name, html)
+			}
+		}
+		for _, html := range e.unexpectedHTML {
+			if strings.Contains(rr.Body.String(), html) {
+				t.Errorf("%s: did not expect %q in the page", e.name, html)
+			}
+		}
+		if errStr := session.PopString(ctx, "error"); errStr != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, errStr)
+		}
+	}
+}
+
+func TestRepository_AdminMail(t *testing.T) {
+	tests := []struct {
+		name             string
+		dbFetchError     bool
+		expectedStatus   int
+		expectedLocation string
+		expectedHTML     string
+		expectedError    string
+	}{
+		{"success", false, http.StatusOK, "", "connection refused", ""},
+		{"db-error", true, http.StatusTemporaryRedirect, "/admin/dashboard", "", "Error getting mail from DB"},
+	}
+
+	for _, e := range tests {
+		fetchError = e.dbFetchError
+		req, _ := http.NewRequest("GET", "/admin/mail", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminMail).ServeHTTP(rr, req)
+		fetchError = false
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected %q in the page", e.name, e.expectedHTML)
+		}
+		if errStr := session.PopString(ctx, "error"); errStr != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, errStr)
+		}
+	}
+}
+
+func TestRepository_AdminAuditLog(t *testing.T) {
+	tests := []struct {
+		name             string
+		query            string
+		dbFetchError     bool
+		expectedStatus   int
+		expectedLocation string
+		expectedHTML     string
+		expectedError    string
+	}{
+		{"all", "", false, http.StatusOK, "", "Test Room", ""},
+		{"entity", "?entity=reservation", false, http.StatusOK, "", "confirmed", ""},
+		{"dates", "?user=1&from=2000-01-01&to=2000-01-31", false, http.StatusOK, "", "No changes match the filter", ""},
+		{"bad-user", "?user=x", false, http.StatusSeeOther, "/admin/audit", "", "Invalid user id"},
+		{"bad-from", "?from=x", false, http.StatusSeeOther, "/admin/audit", "", "Error parsing start date"},
+		{"bad-to", "?to=x", false, http.StatusSeeOther, "/admin/audit", "", "Error parsing end date"},
+		{"db-error", "", true, http.StatusTemporaryRedirect, "/admin/dashboard", "", "Error getting audit log from DB"},
+	}
+
+	for _, e := range tests {
+		fetchError = e.dbFetchError
+		req, _ := http.NewRequest("GET", "/admin/audit"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminAuditLog).ServeHTTP(rr, req)
+		fetchError = false
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected %q in the page", e.name, e.expectedHTML)
+		}
+		if e.name == "entity" && strings.Contains(rr.Body.String(), "Test Room") {
+			t.Errorf("%s: entries of other entities are shown", e.name)
+		}
+		if errStr := session.PopString(ctx, "error"); errStr != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, errStr)
+		}
+	}
+}
+
+func TestRepository_AdminPostResendMail(t *testing.T) {
+	tests := []struct {
+		name             string
+		id               string
+		expectedStatus   int
+		expectedLocation string
+		expectedMessage  string
+	}{
+		{"success", "1", http.StatusSeeOther, "/admin/mail", "Message is queued for delivery"},
+		{"invalid-id", "abc", http.StatusSeeOther, "/admin/mail", "Invalid message id"},
+		{"not-found", "200", http.StatusSeeOther, "/admin/mail", "Message is not found or has already been sent"},
+		{"db-error", "100", http.StatusTemporaryRedirect, "/admin/dashboard", "Error resending message"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/mail/%s/resend", e.id), nil)
+		ctx := getCtx(req)
+		ctx = addParamsToChiContext(ctx, map[string]string{"id": e.id})
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostResendMail).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		actualLocation, _ := rr.Result().Location()
+		if actualLocation.String() != e.expectedLocation {
+			t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+		}
+		message := session.GetString(ctx, "flash") + session.GetString(ctx, "error")
+		if message != e.expectedMessage {
+			t.Errorf("%s: expected message %q but got %q", e.name, e.expectedMessage, message)
+		}
+	}
+}
+
+func TestRepository_AdminPostUser(t *testing.T) {
+	validUser := map[string]string{
+		"first_name":   "Jane",
+		"last_name":    "Doe",
+		"email":        " Jane@Here.ca ",
+		"access_level": "2",
+		"active":       "1",
+		"password":     "Correct-Horse",
+	}
+	withFields := func(fields map[string]string) map[string]string {
+		form := map[string]string{}
+		for k, v := range validUser {
+			form[k] = v
+		}
+		for k, v := range fields {
+			form[k] = v
+		}
+		return form
+	}
+	tests := []struct {
+		name                 string
+		id                   string
+		formFields           map[string]string
+		expectedStatusCode   int
+		expectedLocation     string
+		expectedSessionKey   string
+		expectedSessionValue string
+		expectedHtml         string
+	}{
+		{"insert", "new", validUser, http.StatusSeeOther, "/admin/users", "flash", "Changes successfully saved", ""},
+		{"update", "2", withFields(map[string]string{"password": ""}), http.StatusSeeOther, "/admin/users", "flash",
+			"Changes successfully saved", ""},
+		{"update self", "1", withFields(map[string]string{"access_level": "4"}), http.StatusSeeOther, "/admin/users", "flash",
+			"Changes successfully saved", ""},
+		{"bad id", "badid", validUser, http.StatusTemporaryRedirect, "/admin/dashboard", "error", "Invalid user id", ""},
+		{"missing name", "2", withFields(map[string]string{"last_name": " "}), http.StatusBadRequest, "", "", "",
+			"This field cannot be empty."},
+		{"invalid email", "2", withFields(map[string]string{"email": "jane"}), http.StatusBadRequest, "", "", "",
+			"Invalid email address"},
+		{"invalid role", "2", withFields(map[string]string{"access_level": "9"}), http.StatusBadRequest, "", "", "",
+			"Choose a role"},
+		{"short password", "new", withFields(map[string]string{"password": "secret"}), http.StatusBadRequest, "", "", "",
+			"Use at least 10 characters"},
+		{"deactivate self", "1", withFields(map[string]string{"access_level": "4", "active": ""}), http.StatusBadRequest, "", "", "",
+			"You cannot deactivate your own account"},
+		{"demote self", "1", validUser, http.StatusBadRequest, "", "", "", "You cannot change your own role"},
+		{"duplicate email", "new", withFields(map[string]string{"email": "taken@here.ca"}), http.StatusBadRequest, "", "", "",
+			"Another user already has this email."},
+		{"not found", "404", validUser, http.StatusSeeOther, "/admin/users", "error", "User not found", ""},
+		{"db error", "2", withFields(map[string]string{"first_name": "error"}), http.StatusTemporaryRedirect, "/admin/dashboard",
+			"error", "Error saving user in DB", ""},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/users/{id}", strings.NewReader(composeUrlParams(e.formFields)))
+		ctx := getCtx(req)
+		ctx = addParamsToChiContext(ctx, map[string]string{"id": e.id})
+		app.Session.Put(ctx, "user_id", 1)
+		app.Session.Put(ctx, "access_level", int(models.RoleOwner))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostUser).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		if e.expectedSessionKey != "" {
+			value := app.Session.Pop(ctx, e.expectedSessionKey)
+			if e.expectedSessionValue != value {
+				t.Errorf("%s: got an unexpected %q value from session; expected %q but got %q", e.name, e.expectedSessionKey, e.expectedSessionValue, value)
+			}
+		}
+		if e.expectedHtml != "" && !strings.Contains(rr.Body.String(), e.expectedHtml) {
+			t.Errorf("%s: expected to find %q in result but did not", e.name, e.expectedHtml)
+		}
+	}
+}
+
+func TestRepository_AdminUserActions(t *testing.T) {
+	tests := []struct {
+		name             string
+		handler          http.HandlerFunc
+		id               string
+		expectedStatus   int
+		expectedLocation string
+		expectedMessage  string
+	}{
+		{"delete", Repo.AdminPostDeleteUser, "2", http.StatusSeeOther, "/admin/users", "Successfully deleted user"},
+		{"delete bad id", Repo.AdminPostDeleteUser, "abc", http.StatusTemporaryRedirect, "/admin/dashboard", "Invalid user id"},
+		{"delete self", Repo.AdminPostDeleteUser, "1", http.StatusSeeOther, "/admin/users/1", "You cannot do this to your own account"},
+		{"delete not found", Repo.AdminPostDeleteUser, "404", http.StatusSeeOther, "/admin/users", "User not found"},
+		{"delete db error", Repo.AdminPostDeleteUser, "500", http.StatusTemporaryRedirect, "/admin/dashboard", "Error deleting user"},
+		{"force reset", Repo.AdminPostForcePasswordReset, "2", http.StatusSeeOther, "/admin/users", "The user has to reset the password now"},
+		{"force reset self", Repo.AdminPostForcePasswordReset, "1", http.StatusSeeOther, "/admin/users/1", "You cannot do this to your own account"},
+		{"force reset db error", Repo.AdminPostForcePasswordReset, "500", http.StatusTemporaryRedirect, "/admin/dashboard",
+			"Error forcing password reset"},
+		{"unlock", Repo.AdminPostUnlockUser, "2", http.StatusSeeOther, "/admin/users", "The user can log in again"},
+		{"unlock not found", Repo.AdminPostUnlockUser, "404", http.StatusSeeOther, "/admin/users", "User not found"},
+		{"reset 2fa", Repo.AdminPostResetTwoFactor, "3", http.StatusSeeOther, "/admin/users",
+			"Two-factor authentication of the user has been reset"},
+		{"reset 2fa db error", Repo.AdminPostResetTwoFactor, "500", http.StatusTemporaryRedirect, "/admin/dashboard",
+			"Error resetting two-factor authentication"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/users/{id}", nil)
+		ctx := getCtx(req)
+		ctx = addParamsToChiContext(ctx, map[string]string{"id": e.id})
+		session.Put(ctx, "user_id", 1)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		actualLocation, _ := rr.Result().Location()
+		if actualLocation.String() != e.expectedLocation {
+			t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+		}
+		message := session.GetString(ctx, "flash") + session.GetString(ctx, "error")
+		if message != e.expectedMessage {
+			t.Errorf("%s: expected message %q but got %q", e.name, e.expectedMessage, message)
+		}
+	}
+}
+
+func TestRepository_PostForgotPassword(t *testing.T) {
+	const sent = "If there is an account with this email, a link to reset the password has been sent to it"
+	tests := []struct {
+		name             string
+		email            string
+		expectedStatus   int
+		expectedLocation string
+		expectedMessage  string
+	}{
+		{"known email", " Me@Here.ca", http.StatusSeeOther, "/user/login", sent},
+		{"unknown email", "nobody@here.ca", http.StatusSeeOther, "/user/login", sent},
+		{"invalid email", "nobody", http.StatusBadRequest, "", ""},
+		{"db error", "error@here.ca", http.StatusTemporaryRedirect, "/", "Error sending password reset link"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"email": {e.email}}
+		req, _ := http.NewRequest("POST", "/user/forgot-password", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostForgotPassword).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		message := session.GetString(ctx, "flash") + session.GetString(ctx, "error")
+		if message != e.expectedMessage {
+			t.Errorf("%s: expected message %q but got %q", e.name, e.expectedMessage, message)
+		}
+	}
+}
+
+func TestPasswordResetMail(t *testing.T) {
+	user, _ := Repo.DB.GetUserById(context.Background(), 1)
+	msg := Repo.passwordResetMail(user)
+	if msg.To != "me@here.ca" {
+		t.Errorf("expected the mail to me@here.ca, but got %q", msg.To)
+	}
+	start := strings.Index(msg.Content, "https://bookings.test/user/reset-password?token=")
+	if start < 0 {
+		t.Fatalf("no reset link in %q", msg.Content)
+	}
+	link, err := url.Parse(msg.Content[start : strings.Index(msg.Content[start:], `"`)+start])
+	if err != nil {
+		t.Fatalf("invalid reset link: %q", err)
+	}
+	if _, err := Repo.passwordResetUser(context.Background(), link.Query().Get("token")); err != nil {
+		t.Errorf("the token from the link is not accepted: %q", err)
+	}
+}
+
+func TestRepository_ResetPassword(t *testing.T) {
+	key := []byte(app.SigningKey)
+	owner, _ := Repo.DB.GetUserById(context.Background(), 1)
+	valid := passwordreset.NewToken(key, owner, time.Now().Add(time.Hour))
+	expired := passwordreset.NewToken(key, owner, time.Now().Add(-time.Minute))
+	missing := passwordreset.NewToken(key, models.User{ID: 404}, time.Now().Add(time.Hour))
+	dbError := passwordreset.NewToken(key, models.User{ID: 500}, time.Now().Add(time.Hour))
+	const invalidLink = "The password reset link is invalid or has expired. Please ask for a new one"
+
+	showTests := []struct {
+		name             string
+		token            string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"valid", valid, http.StatusOK, ""},
+		{"expired", expired, http.StatusSeeOther, "/user/forgot-password"},
+		{"forged", valid + "x", http.StatusSeeOther, "/user/forgot-password"},
+		{"missing user", missing, http.StatusSeeOther, "/user/forgot-password"},
+		{"db error", dbError, http.StatusTemporaryRedirect, "/"},
+	}
+	for _, e := range showTests {
+		req, _ := http.NewRequest("GET", "/user/reset-password?token="+url.QueryEscape(e.token), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.ShowResetPassword).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("show %s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("show %s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+	}
+
+	postTests := []struct {
+		name             string
+		token            string
+		password         string
+		confirmation     string
+		expectedStatus   int
+		expectedLocation string
+		expectedMessage  string
+	}{
+		{"success", valid, "Correct-Horse", "Correct-Horse", http.StatusSeeOther, "/user/login",
+			"Your password has been changed. Log in with the new one"},
+		{"weak password", valid, "password", "password", http.StatusBadRequest, "", ""},
+		{"not confirmed", valid, "Correct-Horse", "Correct-Horse!", http.StatusBadRequest, "", ""},
+		{"expired", expired, "Correct-Horse", "Correct-Horse", http.StatusSeeOther, "/user/forgot-password", invalidLink},
+	}
+	for _, e := range postTests {
+		postedData := url.Values{"token": {e.token}, "password": {e.password}, "confirm_password": {e.confirmation}}
+		req, _ := http.NewRequest("POST", "/user/reset-password", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostResetPassword).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("post %s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("post %s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		message := session.GetString(ctx, "flash") + session.GetString(ctx, "error")
+		if message != e.expectedMessage {
+			t.Errorf("post %s: expected message %q but got %q", e.name, e.expectedMessage, message)
+		}
+	}
+}
+
+func TestRepository_AdminPostChangePassword(t *testing.T) {
+	tests := []struct {
+		name             string
+		userID           int
+		current          string
+		password         string
+		expectedStatus   int
+		expectedLocation string
+		expectedMessage  string
+		expectedHtml     string
+	}{
+		{"success", 1, "password", "Correct-Horse", http.StatusSeeOther, "/admin/dashboard", "Your password has been changed", ""},
+		{"wrong current password", 1, "wrong", "Correct-Horse", http.StatusBadRequest, "", "", "The current password is wrong"},
+		{"locked out", 423, "password", "Correct-Horse", http.StatusBadRequest, "", "",
+			"Too many failed attempts. Please try again later"},
+		{"weak password", 1, "password", "horse", http.StatusBadRequest, "", "", "Use at least 10 characters"},
+		{"db error", 500, "password", "Correct-Horse", http.StatusTemporaryRedirect, "/admin/dashboard", "Error changing password", ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"current_password": {e.current}, "password": {e.password}, "confirm_password": {e.password}}
+		req, _ := http.NewRequest("POST", "/admin/change-password", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", e.userID)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostChangePassword).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		message := session.GetString(ctx, "flash") + session.GetString(ctx, "error")
+		if message != e.expectedMessage {
+			t.Errorf("%s: expected message %q but got %q", e.name, e.expectedMessage, message)
+		}
+		if e.expectedHtml != "" && !strings.Contains(rr.Body.String(), e.expectedHtml) {
+			t.Errorf("%s: expected to find %q in result but did not", e.name, e.expectedHtml)
+		}
+	}
+}
+
+func TestRepository_PostTwoFactor(t *testing.T) {
+	now := time.Now()
+	current, _ := twofactor.Code(dbrepo.TestTwoFactorSecret, twofactor.Step(now))
+	used, _ := twofactor.Code(dbrepo.TestTwoFactorSecret, twofactor.Step(now)-1)
+	tests := []struct {
+		name             string
+		pendingID        int
+		expires          time.Time
+		code             string
+		expectedStatus   int
+		expectedLocation string
+		expectedMessage  string
+		expectedUserID   int
+	}{
+		{"one-time password", 3, now.Add(time.Minute), current, http.StatusSeeOther, "/", "Successful login!", 3},
+		{"recovery code", 3, now.Add(time.Minute), strings.ToLower(dbrepo.TestRecoveryCode), http.StatusSeeOther, "/",
+			"Successful login! You have 1 recovery codes left", 3},
+		{"used one-time password", 3, now.Add(time.Minute), used, http.StatusSeeOther, "/user/two-factor", "Invalid code!", 0},
+		{"wrong one-time password", 3, now.Add(time.Minute), "12345", http.StatusSeeOther, "/user/two-factor", "Invalid code!", 0},
+		{"wrong recovery code", 3, now.Add(time.Minute), "AAAAA-AAAAA", http.StatusSeeOther, "/user/two-factor", "Invalid code!", 0},
+		{"missing code", 3, now.Add(time.Minute), "", http.StatusBadRequest, "", "", 0},
+		{"no password yet", 0, now.Add(time.Minute), current, http.StatusSeeOther, "/user/login", "Log in first!", 0},
+		{"time is up", 3, now.Add(-time.Second), current, http.StatusSeeOther, "/user/login", "Log in first!", 0},
+		{"two-factor disabled", 1, now.Add(time.Minute), current, http.StatusSeeOther, "/user/login",
+			"Your account is not available anymore. Log in again", 0},
+		{"user deleted", 404, now.Add(time.Minute), current, http.StatusSeeOther, "/user/login",
+			"Your account is not available anymore. Log in again", 0},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"code": {e.code}}
+		req, _ := http.NewRequest("POST", "/user/two-factor", strings.NewReader(postedData.Encode()))
+		req.RemoteAddr = "198.51.100.7:1234"
+		ctx := getCtx(req)
+		session.Put(ctx, "two_factor_user_id", e.pendingID)
+		session.Put(ctx, "two_factor_expires", e.expires.Unix())
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		message := session.GetString(ctx, "flash") + session.GetString(ctx, "error")
+		if message != e.expectedMessage {
+			t.Errorf("%s: expected message %q but got %q", e.name, e.expectedMessage, message)
+		}
+		if id := session.GetInt(ctx, "user_id"); id != e.expectedUserID {
+			t.Errorf("%s: expected user %d to be logged in, but got %d", e.name, e.expectedUserID, id)
+		}
+	}
+}
+
+func TestRepository_AdminTwoFactor(t *testing.T) {
+	// enrollment of the owner, who has no two-factor authentication yet
+	req, _ := http.NewRequest("GET", "/admin/two-factor", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "user_id", 1)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminTwoFactor).ServeHTTP(rr, req)
+
+	secret := session.GetString(ctx, "two_factor_secret")
+	if rr.Code != http.StatusOK || secret == "" {
+		t.Fatalf("expected a new secret in session, but got status %d and secret %q", rr.Code, secret)
+	}
+	for _, expected := range []string{"data:image/png;base64,", secret, "Your role requires two-factor authentication"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected to find %q in result but did not", expected)
+		}
+	}
+
+	code, _ := twofactor.Code(secret, twofactor.Step(time.Now()))
+	tests := []struct {
+		name           string
+		secret         string
+		code           string
+		expectedStatus int
+		expectedHtml   string
+	}{
+		{"wrong code", secret, "12345", http.StatusBadRequest, "The code is wrong"},
+		{"no secret", "", code, http.StatusSeeOther, ""},
+		{"enabled", secret, code, http.StatusOK, "They are not shown again"},
+	}
+	for _, e := range tests {
+		postedData := url.Values{"code": {e.code}}
+		req, _ := http.NewRequest("POST", "/admin/two-factor", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+		session.Put(ctx, "two_factor_secret", e.secret)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedHtml != "" && !strings.Contains(rr.Body.String(), e.expectedHtml) {
+			t.Errorf("%s: expected to find %q in result but did not", e.name, e.expectedHtml)
+		}
+		if e.name == "enabled" && session.GetString(ctx, "two_factor_secret") != "" {
+			t.Errorf("%s: secret is still kept in session", e.name)
+		}
+	}
+}
+
+func TestRepository_AdminPostDisableTwoFactor(t *testing.T) {
+	tests := []struct {
+		name             string
+		userID           int
+		roles            []string
+		password         string
+		expectedStatus   int
+		expectedLocation string
+		expectedMessage  string
+	}{
+		{"success", 3, []string{"owner"}, "password", http.StatusSeeOther, "/admin/two-factor", "Two-factor authentication has been disabled"},
+		{"wrong password", 3, []string{"owner"}, "wrong", http.StatusBadRequest, "", ""},
+		{"locked out", 423, []string{"owner"}, "password", http.StatusBadRequest, "", ""},
+		{"required by role", 3, []string{"manager", "owner"}, "password", http.StatusSeeOther, "/admin/two-factor",
+			"Your role requires two-factor authentication"},
+	}
+	defer func(roles []string) { app.TwoFactorRoles = roles }(app.TwoFactorRoles)
+
+	for _, e := range tests {
+		app.TwoFactorRoles = e.roles
+		postedData := url.Values{"current_password": {e.password}}
+		req, _ := http.NewRequest("POST", "/admin/two-factor/disable", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", e.userID)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostDisableTwoFactor).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		message := session.GetString(ctx, "flash") + session.GetString(ctx, "error")
+		if message != e.expectedMessage {
+			t.Errorf("%s: expected message %q but got %q", e.name, e.expectedMessage, message)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/helpers"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/render"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository"
+	"github.com/asaskevich/govalidator"
+)
+
+// maxImportBytes is the size of the largest CSV file that can be imported
+const maxImportBytes = 2 << 20
+
+// maxImportRows is the largest number of rows of a CSV file that can be imported
+const maxImportRows = 2000
+
+// importColumns are the columns of a CSV file of reservations and blocks. The first four are required
+var importColumns = []string{"type", "room", "start_date", "end_date", "first_name", "last_name", "email", "phone",
+	"status", "booked_on", "restriction", "note"}
+
+// parseImport reads the rows of a CSV file of reservations and blocks and checks each of them on its own:
+// the room and the restriction must exist, the dates must be valid and the guest must have a name and an
+// email address. The rows are not checked against each other nor the rooms taken. errMsg is not empty
+// if the file cannot be read at all
+func parseImport(in io.Reader, rooms []models.Room, restrictions []models.Restriction) (imp models.Import, errMsg string) {
+	cr := csv.NewReader(in)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return imp, "The file is empty"
+	}
+	if err != nil {
+		return imp, fmt.Sprintf("Error reading the file: %s", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		// spreadsheets often start UTF-8 files with a byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		known := false
+		for _, column := range importColumns {
+			known = known || name == column
+		}
+		if !known {
+			return imp, fmt.Sprintf("Unknown column %q", name)
+		}
+		if _, ok := columns[name]; ok {
+			return imp, fmt.Sprintf("Column %q appears twice", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range importColumns[:4] {
+		if _, ok := columns[name]; !ok {
+			return imp, fmt.Sprintf("Missing column %q", name)
+		}
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imp, fmt.Sprintf("Error reading the file: %s", err)
+		}
+		if len(imp.Rows) == maxImportRows {
+			return imp, fmt.Sprintf("Error: a file cannot have more than %d rows", maxImportRows)
+		}
+		line, _ := cr.FieldPos(0)
+		get := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		imp.Rows = append(imp.Rows, parseImportRow(line, get, rooms, restrictions))
+	}
+	if len(imp.Rows) == 0 {
+		return imp, "The file has no rows"
+	}
+	return imp, ""
+}
+
+// parseImportRow reads the row on the line, whose columns are returned by get
+func parseImportRow(line int, get func(column string) string, rooms []models.Room, restrictions []models.Restriction) models.ImportRow {
+	row := models.ImportRow{Line: line, Kind: strings.ToLower(get("type"))}
+	fail := func(format string, a ...any) {
+		row.Errors = append(row.Errors, fmt.Sprintf(format, a...))
+	}
+
+	var room models.Room
+	for _, rm := range rooms {
+		if get("room") == strconv.Itoa(rm.ID) || strings.EqualFold(get("room"), rm.RoomName) {
+			room = rm
+		}
+	}
+	if room.ID == 0 {
+		fail("Unknown room %q", get("room"))
+	}
+	const layout = "2006-01-02"
+	start, startErr := time.Parse(layout, get("start_date"))
+	if startErr != nil {
+		fail("Invalid start date %q", get("start_date"))
+	}
+	end, endErr := time.Parse(layout, get("end_date"))
+	if endErr != nil {
+		fail("Invalid end date %q", get("end_date"))
+	}
+	if startErr == nil && endErr == nil && !end.After(start) {
+		fail("The end date must be after the start date")
+	}
+
+	switch row.Kind {
+	case models.ImportReservation:
+		res := models.Reservation{
+			FirstName: get("first_name"),
+			LastName:  get("last_name"),
+			Email:     get("email"),
+			Phone:     get("phone"),
+			StartDate: start,
+			EndDate:   end,
+			RoomId:    room.ID,
+			Room:      room,
+			Status:    models.StatusConfirmed,
+		}
+		if res.FirstName == "" || res.LastName == "" {
+			fail("The guest's first and last names are required")
+		}
+		if !govalidator.IsEmail(res.Email) {
+			fail("Invalid email address %q", res.Email)
+		}
+		if get("status") != "" {
+			res.Status = models.ReservationStatus(strings.ToLower(get("status")))
+			if !res.Status.Valid() {
+				fail("Invalid status %q", get("status"))
+			}
+		}
+		// the day of booking gives the lead time of the stay in the reports
+		if get("booked_on") != "" {
+			booked, err := time.Parse(layout, get("booked_on"))
+			switch {
+			case err != nil:
+				fail("Invalid booking date %q", get("booked_on"))
+			case startErr == nil && booked.After(start):
+				fail("The booking date cannot be after the start date")
+			case booked.After(time.Now()):
+				fail("The booking date cannot be in the future")
+			default:
+				res.CreatedAt = booked
+			}
+		}
+		row.Reservation = res
+	case models.ImportBlock:
+		block := models.RoomRestriction{
+			StartDate: start,
+			EndDate:   end,
+			RoomID:    room.ID,
+			Note:      get("note"),
+			Room:      room,
+		}
+		if len(restrictions) > 0 && get("restriction") == "" {
+			block.Restriction = restrictions[0]
+		}
+		for _, r := range restrictions {
+			if get("restriction") == strconv.Itoa(r.ID) || strings.EqualFold(get("restriction"), r.RestrictionName) {
+				block.Restriction = r
+			}
+		}
+		if block.Restriction.ID == 0 {
+			fail("Unknown restriction %q", get("restriction"))
+		}
+		block.RestrictionID = block.Restriction.ID
+		row.Block = block
+	default:
+		fail("Invalid type %q, it must be reservation or block", get("type"))
+	}
+	return row
+}
+
+// checkImport rejects the rows taking a room for nights it is already taken for, either by another row
+// of the file or in the database
+func (m *Repository) checkImport(ctx context.Context, imp models.Import) error {
+	for i := range imp.Rows {
+		row := &imp.Rows[i]
+		if !row.Accepted() || !row.HoldsRoom() {
+			continue
+		}
+		for _, other := range imp.Rows[:i] {
+			if other.Accepted() && row.Overlaps(other) {
+				row.Errors = append(row.Errors, fmt.Sprintf("The room is taken by line %d for some of the nights", other.Line))
+				break
+			}
+		}
+		if !row.Accepted() {
+			continue
+		}
+		available, err := m.DB.SearchAvailabilityByDatesAndRoomID(ctx, row.StartDate(), row.EndDate(), row.RoomID())
+		if err != nil {
+			return err
+		}
+		if !available {
+			row.Errors = append(row.Errors, "The room is already reserved or blocked for some of the nights")
+		}
+	}
+	return nil
+}
+
+// AdminImport shows the form uploading a CSV file of reservations and blocks
+func (m *Repository) AdminImport(w http.ResponseWriter, r *http.Request) {
+	rooms, restrictions, ok := m.importChoices(w, r)
+	if !ok {
+		return
+	}
+	data := map[string]any{}
+	data["rooms"] = rooms
+	data["restrictions"] = restrictions
+	render.Template(w, r, "admin-import.page.gohtml", &models.TemplateData{Data: data})
+}
+
+// importChoices returns the rooms and the restrictions the rows of an import can refer to. If they cannot be
+// read, it redirects to the dashboard and returns false
+func (m *Repository) importChoices(w http.ResponseWriter, r *http.Request) ([]models.Room, []models.Restriction, bool) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting rooms from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return nil, nil, false
+	}
+	restrictions, err := m.DB.BlockRestrictions(r.Context())
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error getting restrictions from DB")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return nil, nil, false
+	}
+	return rooms, restrictions, true
+}
+
+// AdminPostImport checks the reservations and blocks of an uploaded CSV file and shows which rows are
+// accepted and which are rejected, without importing anything. The page posts the file back with commit
+// set, which checks it again and imports all accepted rows at once, unless they have changed meanwhile
+func (m *Repository) AdminPostImport(w http.ResponseWriter, r *http.Request) {
+	content := r.PostFormValue("csv")
+	if content == "" {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Choose a CSV file to import")
+			http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+			return
+		}
+		defer file.Close()
+		if header.Size > maxImportBytes {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Error: the file cannot be larger than %d MB", maxImportBytes>>20))
+			http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+			return
+		}
+		b, err := io.ReadAll(file)
+		if err != nil {
+			log.Println(err)
+			m.App.Session.Put(r.Context(), "error", "Error reading the file")
+			http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+			return
+		}
+		content = string(b)
+	}
+	if len(content) > maxImportBytes {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Error: the file cannot be larger than %d MB", maxImportBytes>>20))
+		http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+		return
+	}
+
+	rooms, restrictions, ok := m.importChoices(w, r)
+	if !ok {
+		return
+	}
+	imp, errMsg := parseImport(strings.NewReader(content), rooms, restrictions)
+	if errMsg != "" {
+		m.App.Session.Put(r.Context(), "error", errMsg)
+		http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+		return
+	}
+	if err := m.checkImport(r.Context(), imp); err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error checking availability of rooms")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	if r.PostFormValue("commit") != "" {
+		if strconv.Itoa(imp.Accepted()) == r.PostFormValue("accepted") && imp.Accepted() > 0 {
+			m.commitImport(w, r, imp)
+			return
+		}
+		m.App.Session.Put(r.Context(), "warning",
+			"The rooms have been reserved or blocked since the dry run. Check the rows again before importing them")
+	}
+
+	data := map[string]any{}
+	data["import"] = imp
+	data["rooms"] = rooms
+	data["restrictions"] = restrictions
+	render.Template(w, r, "admin-import.page.gohtml", &models.TemplateData{
+		Data:      data,
+		StringMap: map[string]string{"csv": content},
+	})
+}
+
+// commitImport imports the accepted rows of the import, giving the reservations their codes
+func (m *Repository) commitImport(w http.ResponseWriter, r *http.Request, imp models.Import) {
+	reservations := imp.Reservations()
+	for i := range reservations {
+		var err error
+		if reservations[i].ConfirmationCode, err = helpers.NewConfirmationCode(); err == nil {
+			reservations[i].Reference, err = helpers.NewReference()
+		}
+		if err != nil {
+			log.Println(err)
+			m.App.Session.Put(r.Context(), "error", "Error generating confirmation codes")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	err := m.DB.ImportBookings(r.Context(), reservations, imp.Blocks(), userID)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error",
+			"Some of the rooms have been reserved or blocked in the meantime, nothing is imported. Run the dry run again")
+		http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error importing reservations and blocks")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Import done. Reservations: %d, blocks: %d",
+		len(reservations), len(imp.Blocks())))
+	http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+}
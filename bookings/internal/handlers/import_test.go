@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// importFile has rows accepted and rejected by the test repository, which has rooms available
+// only from 2060-02-01
+const importFile = "\ufeffType,Room,Start_Date,End_Date,First_Name,Last_Name,Email,Status,Restriction,Note\n" +
+	"reservation,General's Quoters,2060-02-01,2060-02-03,John,Smith,john@smith.com,,,\n" +
+	"block,2,2060-02-01,2060-02-05,,,,,maintenance,Painting\n" +
+	"reservation,1,2060-02-02,2060-02-04,Jane,Smith,jane@smith.com,,,\n" +
+	"reservation,9,2060-13-01,2060-02-01,,Doe,doe,done,,\n" +
+	"reservation,1,2060-03-01,2060-03-04,Ann,Smith,ann@smith.com,checked_out,,\n" +
+	"block,1,2060-02-10,2060-02-11,,,,,party,\n" +
+	"reservation,1,2060-03-01,2060-03-04,Bob,Smith,bob@smith.com,Cancelled,,\n" +
+	"visit,1,2060-02-01,2060-02-02,,,,,,\n"
+
+// importRequest posts the fields and, unless it is empty, the file as a multipart form
+func importRequest(file string, fields map[string]string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		_ = mw.WriteField(k, v)
+	}
+	if file != "" {
+		fw, _ := mw.CreateFormFile("file", "bookings.csv")
+		_, _ = fw.Write([]byte(file))
+	}
+	_ = mw.Close()
+	req, _ := http.NewRequest("POST", "/admin/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestRepository_AdminPostImport(t *testing.T) {
+	commit := func(accepted, csv string) map[string]string {
+		return map[string]string{"commit": "1", "accepted": accepted, "csv": csv}
+	}
+	tests := []struct {
+		name             string
+		file             string
+		fields           map[string]string
+		dbFetchError     bool
+		expectedStatus   int
+		expectedLocation string
+		expectedHTML     []string
+		sessionKey       string
+		expectedMessage  string
+	}{
+		{"dry-run", importFile, nil, false, http.StatusOK, "", []string{
+			"3 of 8 rows are accepted, 5 rejected",
+			"The room is taken by line 2 for some of the nights",
+			"Unknown room &#34;9&#34;", "Invalid start date &#34;2060-13-01&#34;",
+			"The guest&#39;s first and last names are required", "Invalid email address &#34;doe&#34;",
+			"Invalid status &#34;done&#34;",
+			"The room is already reserved or blocked for some of the nights",
+			"Unknown restriction &#34;party&#34;",
+			"Invalid type &#34;visit&#34;, it must be reservation or block",
+			"Maintenance: Painting", "Smith, Bob, bob@smith.com, Cancelled",
+			`value="Import 3 accepted rows"`,
+		}, "", ""},
+		{"commit", "", commit("3", importFile), false, http.StatusSeeOther, "/admin/reservations-all", nil,
+			"flash", "Import done. Reservations: 2, blocks: 1"},
+		{"changed", "", commit("4", importFile), false, http.StatusOK, "", []string{"3 of 8 rows are accepted",
+			"The rooms have been reserved or blocked since the dry run"},
+			"", ""},
+		{"taken", "", commit("1", "type,room,start_date,end_date,first_name,last_name,email\n"+
+			"reservation,1,2060-02-01,2060-02-02,John,taken,john@smith.com\n"), false, http.StatusSeeOther, "/admin/import", nil,
+			"error", "Some of the rooms have been reserved or blocked in the meantime, nothing is imported. Run the dry run again"},
+		{"import-error", "", commit("1", "type,room,start_date,end_date,first_name,last_name,email\n"+
+			"reservation,1,2060-02-01,2060-02-02,John,error,john@smith.com\n"), false, http.StatusTemporaryRedirect, "/admin/dashboard", nil,
+			"error", "Error importing reservations and blocks"},
+		{"nothing-accepted", "type,room,start_date,end_date\nblock,9,2060-02-01,2060-02-02\n", nil, false, http.StatusOK, "",
+			[]string{"There is nothing to import"}, "", ""},
+		{"booked-on", "type,room,start_date,end_date,first_name,last_name,email,booked_on\n" +
+			"reservation,1,2060-02-01,2060-02-03,John,Smith,john@smith.com,2020-01-05\n" +
+			"reservation,2,2060-02-01,2060-02-03,Jane,Smith,jane@smith.com,2060-03-01\n" +
+			"reservation,1,2060-02-10,2060-02-11,Ann,Smith,ann@smith.com,2059-12-01\n" +
+			"reservation,2,2060-02-10,2060-02-11,Bob,Smith,bob@smith.com,yesterday\n", nil, false, http.StatusOK, "", []string{
+			"1 of 4 rows are accepted", "booked on 2020-01-05",
+			"The booking date cannot be after the start date", "The booking date cannot be in the future",
+			"Invalid booking date &#34;yesterday&#34;",
+		}, "", ""},
+		{"no-file", "", nil, false, http.StatusSeeOther, "/admin/import", nil, "error", "Choose a CSV file to import"},
+		{"missing-column", "type,room,start_date\n", nil, false, http.StatusSeeOther, "/admin/import", nil,
+			"error", `Missing column "end_date"`},
+		{"unknown-column", "type,room,start_date,end_date,price\n", nil, false, http.StatusSeeOther, "/admin/import", nil,
+			"error", `Unknown column "price"`},
+		{"twice", "type,room,start_date,end_date,Room\n", nil, false, http.StatusSeeOther, "/admin/import", nil,
+			"error", `Column "room" appears twice`},
+		{"no-rows", "type,room,start_date,end_date\n", nil, false, http.StatusSeeOther, "/admin/import", nil,
+			"error", "The file has no rows"},
+		{"bad-csv", "type,room,start_date,end_date\n\"block,1\n", nil, false, http.StatusSeeOther, "/admin/import", nil,
+			"error", `Error reading the file: parse error on line 2, column 10: extraneous or missing " in quoted-field`},
+		{"db-error", importFile, nil, true, http.StatusTemporaryRedirect, "/admin/dashboard", nil,
+			"error", "Error getting rooms from DB"},
+	}
+
+	for _, e := range tests {
+		fetchError = e.dbFetchError
+		req := importRequest(e.file, e.fields)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostImport).ServeHTTP(rr, req)
+		fetchError = false
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		for _, html := range e.expectedHTML {
+			if !strings.Contains(rr.Body.String(), html) {
+				t.Errorf("%s: expected %q in the page", e.name, html)
+			}
+		}
+		if e.sessionKey != "" {
+			if msg := session.PopString(ctx, e.sessionKey); msg != e.expectedMessage {
+				t.Errorf("%s: expected %s %q but got %q", e.name, e.sessionKey, e.expectedMessage, msg)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminImport(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/import", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminImport).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("bad status code; expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "General&#39;s Quoters, Major&#39;s Suite") {
+		t.Error("expected the rooms to be listed")
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/forms"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/render"
+)
+
+// maxReportDays is the longest range of nights a report can be computed for
+const maxReportDays = 3 * 366
+
+// reportQuery returns the inclusive range of nights and the grouping of the report requested in the URL.
+// Without them, the report covers the last three full months, month by month. The values it has settled
+// on are returned for the filter form; errMsg is not empty if the query is invalid
+func reportQuery(r *http.Request) (from, to time.Time, grouping models.ReportGrouping, values url.Values, errMsg string) {
+	year, month, _ := time.Now().Date()
+	thisMonth := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	from, to, grouping = thisMonth.AddDate(0, -3, 0), thisMonth.AddDate(0, 0, -1), models.GroupByMonth
+
+	const layout = "2006-01-02"
+	query := r.URL.Query()
+	var err error
+	if query.Get("from") != "" {
+		if from, err = time.Parse(layout, query.Get("from")); err != nil {
+			return from, to, grouping, nil, "Error parsing start date"
+		}
+	}
+	if query.Get("to") != "" {
+		if to, err = time.Parse(layout, query.Get("to")); err != nil {
+			return from, to, grouping, nil, "Error parsing end date"
+		}
+	}
+	if query.Get("group") != "" {
+		grouping = models.ReportGrouping(query.Get("group"))
+		if !grouping.Valid() {
+			return from, to, grouping, nil, "Invalid grouping"
+		}
+	}
+	if to.Before(from) {
+		return from, to, grouping, nil, "Error: the end date cannot be before the start date"
+	}
+	if to.Sub(from) >= maxReportDays*24*time.Hour {
+		return from, to, grouping, nil, fmt.Sprintf("Error: a report cannot cover more than %d days", maxReportDays)
+	}
+	values = url.Values{}
+	values.Set("from", from.Format(layout))
+	values.Set("to", to.Format(layout))
+	values.Set("group", string(grouping))
+	return from, to, grouping, values, ""
+}
+
+// AdminReports shows occupancy, length of stay, lead time and cancellations per period and per room
+func (m *Repository) AdminReports(w http.ResponseWriter, r *http.Request) {
+	from, to, grouping, values, errMsg := reportQuery(r)
+	if errMsg != "" {
+		m.App.Session.Put(r.Context(), "error", errMsg)
+		http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
+		return
+	}
+
+	report, err := m.DB.Report(r.Context(), from, to.AddDate(0, 0, 1), grouping)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error computing report")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	data := map[string]any{}
+	data["report"] = report
+	data["groupings"] = models.ReportGroupings()
+	render.Template(w, r, "admin-reports.page.gohtml", &models.TemplateData{
+		Data: data,
+		Form: forms.New(values),
+		StringMap: map[string]string{
+			"csv_query": values.Encode(),
+		},
+	})
+}
+
+// AdminReportsCSV downloads the report shown by AdminReports: one line per room and period
+// followed by one line per period for all rooms together
+func (m *Repository) AdminReportsCSV(w http.ResponseWriter, r *http.Request) {
+	from, to, grouping, _, errMsg := reportQuery(r)
+	if errMsg != "" {
+		m.App.Session.Put(r.Context(), "error", errMsg)
+		http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
+		return
+	}
+
+	report, err := m.DB.Report(r.Context(), from, to.AddDate(0, 0, 1), grouping)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Error computing report")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="report-%s-%s.csv"`,
+		from.Format("2006-01-02"), to.Format("2006-01-02")))
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"period", "room", "room_nights", "blocked_nights", "nights_sold", "occupancy_percent",
+		"bookings", "stays", "average_stay", "average_lead_days", "cancelled", "cancellation_percent",
+		"no_shows", "no_show_percent"})
+	for _, rows := range [][]models.ReportRow{report.Rows, report.ByPeriod()} {
+		for _, row := range rows {
+			room := row.RoomName
+			if row.RoomID == 0 {
+				room = "All rooms"
+			}
+			_ = cw.Write([]string{
+				row.Period.Format("2006-01-02"),
+				room,
+				strconv.Itoa(row.RoomNights),
+				strconv.Itoa(row.BlockedNights),
+				strconv.Itoa(row.NightsSold),
+				strconv.FormatFloat(row.Occupancy(), 'f', 1, 64),
+				strconv.Itoa(row.Bookings),
+				strconv.Itoa(row.Stays()),
+				strconv.FormatFloat(row.AverageStay(), 'f', 2, 64),
+				strconv.FormatFloat(row.AverageLeadTime(), 'f', 1, 64),
+				strconv.Itoa(row.Cancelled),
+				strconv.FormatFloat(row.CancellationRate(), 'f', 1, 64),
+				strconv.Itoa(row.NoShows),
+				strconv.FormatFloat(row.NoShowRate(), 'f', 1, 64),
+			})
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Println(err)
+	}
+}
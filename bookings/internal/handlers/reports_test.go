@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRepository_AdminReports(t *testing.T) {
+	tests := []struct {
+		name             string
+		query            string
+		dbFetchError     bool
+		expectedStatus   int
+		expectedLocation string
+		expectedHTML     string
+		expectedError    string
+	}{
+		{"default", "", false, http.StatusOK, "", "By month", ""},
+		{"week", "?from=2026-01-05&to=2026-01-31&group=week", false, http.StatusOK, "", "2026-01-05", ""},
+		{"figures", "?from=2026-01-01&to=2026-01-31", false, http.StatusOK, "", "<td>38.9%</td>", ""},
+		{"bad-from", "?from=x", false, http.StatusSeeOther, "/admin/reports", "", "Error parsing start date"},
+		{"bad-to", "?to=x", false, http.StatusSeeOther, "/admin/reports", "", "Error parsing end date"},
+		{"bad-group", "?group=year", false, http.StatusSeeOther, "/admin/reports", "", "Invalid grouping"},
+		{"reversed", "?from=2026-02-01&to=2026-01-01", false, http.StatusSeeOther, "/admin/reports", "",
+			"Error: the end date cannot be before the start date"},
+		{"too-long", "?from=2020-01-01&to=2026-01-01", false, http.StatusSeeOther, "/admin/reports", "",
+			"Error: a report cannot cover more than 1098 days"},
+		{"db-error", "", true, http.StatusTemporaryRedirect, "/admin/dashboard", "", "Error computing report"},
+	}
+
+	for _, e := range tests {
+		fetchError = e.dbFetchError
+		req, _ := http.NewRequest("GET", "/admin/reports"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminReports).ServeHTTP(rr, req)
+		fetchError = false
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: bad status code; expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLocation, _ := rr.Result().Location()
+			if actualLocation.String() != e.expectedLocation {
+				t.Errorf("%s: bad location; expected %q, but got %q", e.name, e.expectedLocation, actualLocation.String())
+			}
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected %q in the page", e.name, e.expectedHTML)
+		}
+		if errStr := session.PopString(ctx, "error"); errStr != e.expectedError {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, errStr)
+		}
+	}
+}
+
+func TestRepository_AdminReportsCSV(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reports/csv?from=2026-01-01&to=2026-01-31&group=month", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminReportsCSV).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("bad status code; expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename="report-2026-01-01-2026-01-31.csv"` {
+		t.Errorf("unexpected Content-Disposition %q", cd)
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %q", err)
+	}
+	// the header, two rooms and all rooms together
+	if len(records) != 4 {
+		t.Fatalf("expected 4 lines but got %d", len(records))
+	}
+	expected := []string{"2026-01-01", "General's Quoters", "10", "0", "5", "50.0", "4", "2", "2.50", "10.0", "1", "25.0", "1", "25.0"}
+	if strings.Join(records[1], ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v but got %v", expected, records[1])
+	}
+	if records[3][1] != "All rooms" || records[3][4] != "7" {
+		t.Errorf("expected 7 nights sold in all rooms but got %v", records[3])
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/reports/csv?group=year", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminReportsCSV).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther || session.PopString(ctx, "error") != "Invalid grouping" {
+		t.Errorf("expected invalid grouping to be refused but got %d", rr.Code)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/config"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/helpers"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/render"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository/dbrepo"
@@ -25,6 +26,7 @@ var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
 var IsAuthenticated = false
+var CurrentRole = models.RoleOwner
 var fetchError = false
 
 var functions = template.FuncMap{
@@ -37,6 +39,15 @@ func TestMain(m *testing.M) {
 	// Configure application
 	// change it to true when in production
 	app.InProduction = false
+	app.CancellationWindow = 48 * time.Hour
+	app.BaseURL = "https://bookings.test"
+	app.SigningKey = "0123456789abcdef0123456789abcdef"
+	app.PasswordResetTTL = time.Hour
+	app.LoginFreeAttempts = 3
+	app.LoginLockoutAttempts = 10
+	app.LoginIPLockoutAttempts = 100
+	app.LoginLockout = 15 * time.Minute
+	app.TwoFactorRoles = []string{"manager", "owner"}
 
 	app.InfoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
@@ -56,12 +67,6 @@ func TestMain(m *testing.M) {
 	session.Cookie.Secure = app.InProduction
 	app.Session = session
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
-
-	listenForMail()
-
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
 		log.Println("error creating template cache: %w", err)
@@ -74,17 +79,10 @@ func TestMain(m *testing.M) {
 		DB:  dbrepo.NewTestingRepo(&app, &fetchError),
 	}
 	NewHandlers(repo)
+	helpers.NewHelpers(&app)
 	os.Exit(m.Run())
 }
 
-func listenForMail() {
-	go func() {
-		for {
-			<-app.MailChan
-		}
-	}()
-}
-
 func getRoutes() http.Handler {
 	mux := chi.NewRouter()
 
@@ -94,8 +92,10 @@ func getRoutes() http.Handler {
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quoters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	mux.Get("/generals-quoters", Repo.RoomRedirect("generals-quoters"))
+	mux.Get("/majors-suite", Repo.RoomRedirect("majors-suite"))
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
 	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
@@ -105,22 +105,69 @@ func getRoutes() http.Handler {
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
+	mux.Get("/my-booking", Repo.MyBooking)
+	mux.Post("/my-booking", Repo.PostMyBooking)
+	mux.Get("/my-booking/reservation", Repo.ShowMyBooking)
+	mux.Post("/my-booking/contact", Repo.PostMyBookingContact)
+	mux.Post("/my-booking/cancel", Repo.PostMyBookingCancel)
 
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
 	mux.Get("/user/logout", Repo.Logout)
+	mux.Get("/user/forgot-password", Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/reset-password", Repo.ShowResetPassword)
+	mux.Post("/user/reset-password", Repo.PostResetPassword)
+	mux.Get("/user/two-factor", Repo.ShowTwoFactor)
+	mux.Post("/user/two-factor", Repo.PostTwoFactor)
 
 	mux.Route("/admin", func(mux chi.Router) {
 		mux.Use(Auth)
 		mux.Get("/dashboard", Repo.AdminDashboard)
-		mux.Get("/reservations-new", Repo.AdminNewReservations)
-		mux.Get("/reservations-all", Repo.AdminAllReservations)
-		mux.Get("/reservations-calendar", Repo.AdminReservationsCalendar)
-		mux.Post("/reservations-calendar", Repo.AdminPostReservationsCalendar)
-		mux.Get("/process-reservation/{src}/{id}", Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}", Repo.AdminDeleteReservation)
-		mux.Get("/reservations/{src}/{id}", Repo.AdminShowReservation)
-		mux.Post("/reservations/{src}/{id}", Repo.AdminPostShowReservation)
+		mux.Get("/change-password", Repo.AdminChangePassword)
+		mux.Post("/change-password", Repo.AdminPostChangePassword)
+		mux.Get("/two-factor", Repo.AdminTwoFactor)
+		mux.Post("/two-factor", Repo.AdminPostTwoFactor)
+		mux.Post("/two-factor/disable", Repo.AdminPostDisableTwoFactor)
+		mux.With(RequirePermission(models.PermViewReservations)).Get("/reservations-new", Repo.AdminNewReservations)
+		mux.With(RequirePermission(models.PermViewReservations)).Get("/reservations-all", Repo.AdminAllReservations)
+		mux.With(RequirePermission(models.PermExportReservations)).Get("/reservations-export", Repo.AdminExportReservations)
+		mux.With(RequirePermission(models.PermImportReservations)).Get("/import", Repo.AdminImport)
+		mux.With(RequirePermission(models.PermImportReservations)).Post("/import", Repo.AdminPostImport)
+		mux.With(RequirePermission(models.PermViewReservations)).Get("/reservations-calendar", Repo.AdminReservationsCalendar)
+		mux.With(RequirePermission(models.PermBlockRooms)).Post("/reservations-calendar", Repo.AdminPostReservationsCalendar)
+		mux.With(RequirePermission(models.PermBlockRooms)).Post("/blocks", Repo.AdminPostBlockRooms)
+		mux.With(RequirePermission(models.PermBlockRooms)).Post("/blocks/remove", Repo.AdminPostUnblockRooms)
+		mux.With(RequirePermission(models.PermEditReservations)).Post("/reservations/{src}/{id}/status", Repo.AdminPostReservationStatus)
+		mux.With(RequirePermission(models.PermDeleteReservations)).Post("/reservations/{src}/{id}/delete", Repo.AdminPostDeleteReservation)
+		mux.With(RequirePermission(models.PermViewReservations)).Get("/reservations/{src}/{id}", Repo.AdminShowReservation)
+		mux.With(RequirePermission(models.PermEditReservations)).Post("/reservations/{src}/{id}", Repo.AdminPostShowReservation)
+		mux.With(RequirePermission(models.PermViewRooms)).Get("/rooms", Repo.AdminRooms)
+		mux.With(RequirePermission(models.PermViewRooms)).Get("/rooms/{id}", Repo.AdminShowRoom)
+		mux.With(RequirePermission(models.PermManageRooms)).Post("/rooms/{id}", Repo.AdminPostRoom)
+		mux.With(RequirePermission(models.PermManageRooms)).Post("/rooms/{id}/delete", Repo.AdminPostDeleteRoom)
+		mux.With(RequirePermission(models.PermViewReports)).Get("/reports", Repo.AdminReports)
+		mux.With(RequirePermission(models.PermViewReports)).Get("/reports/csv", Repo.AdminReportsCSV)
+		mux.With(RequirePermission(models.PermViewMail)).Get("/mail", Repo.AdminMail)
+		mux.With(RequirePermission(models.PermResendMail)).Post("/mail/{id}/resend", Repo.AdminPostResendMail)
+		mux.With(RequirePermission(models.PermManageUsers)).Get("/users", Repo.AdminUsers)
+		mux.With(RequirePermission(models.PermManageUsers)).Get("/users/{id}", Repo.AdminShowUser)
+		mux.With(RequirePermission(models.PermManageUsers)).Post("/users/{id}", Repo.AdminPostUser)
+		mux.With(RequirePermission(models.PermManageUsers)).Post("/users/{id}/force-reset", Repo.AdminPostForcePasswordReset)
+		mux.With(RequirePermission(models.PermManageUsers)).Post("/users/{id}/unlock", Repo.AdminPostUnlockUser)
+		mux.With(RequirePermission(models.PermManageUsers)).Post("/users/{id}/reset-2fa", Repo.AdminPostResetTwoFactor)
+		mux.With(RequirePermission(models.PermManageUsers)).Post("/users/{id}/delete", Repo.AdminPostDeleteUser)
+		mux.With(RequirePermission(models.PermViewAudit)).Get("/audit", Repo.AdminAuditLog)
+	})
+
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.NotFound(Repo.APINotFound)
+		mux.MethodNotAllowed(Repo.APIMethodNotAllowed)
+		mux.Get("/rooms", Repo.APIRooms)
+		mux.Get("/availability", Repo.APIAvailability)
+		mux.Post("/reservations", Repo.APICreateReservation)
+		mux.Get("/reservations/{reference}", Repo.APIReservation)
+		mux.Post("/reservations/{reference}/cancel", Repo.APICancelReservation)
 	})
 
 	fileServer := http.FileServer(http.Dir("./static/"))
@@ -175,6 +222,18 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 	return myCache, nil
 }
 
+func RequirePermission(p models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !CurrentRole.Can(p) {
+				Repo.Forbidden(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !IsAuthenticated {
@@ -1,11 +1,18 @@
 package helpers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strings"
 
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/config"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
 )
 
 var app *config.AppConfig
@@ -26,9 +33,124 @@ func ServerError(w http.ResponseWriter, err error) {
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
+// jsonError is the body of every error response of the JSON API
+type jsonError struct {
+	Error  string              `json:"error"`
+	Fields map[string][]string `json:"fields,omitempty"`
+}
+
+// WriteJSON writes v as the JSON body of the response with the given status
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Println(err)
+		status = http.StatusInternalServerError
+		out = []byte(`{"error": "Internal server error"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// JSONError writes the error message of the JSON API with the given status. Fields hold
+// the validation errors of the request fields, if any
+func JSONError(w http.ResponseWriter, status int, message string, fields map[string][]string) {
+	WriteJSON(w, status, jsonError{Error: message, Fields: fields})
+}
+
 // IsAuthenticated returns true if there is some user authenticated
 // in the current session and false otherwise
 func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// CurrentRole returns the role of the user authenticated in the current session
+func CurrentRole(r *http.Request) models.Role {
+	return models.Role(app.Session.GetInt(r.Context(), "access_level"))
+}
+
+// ClientIP returns the IP address of the client. X-Forwarded-For and X-Real-IP are read only from the
+// reverse proxies listed in trusted_proxies, as anybody else can send them. X-Forwarded-For is read from
+// the right, skipping the trusted proxies, so that addresses prepended by the client are not taken
+func ClientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !trustedProxy(peer) {
+		return peer
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		ip := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !trustedProxy(hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return peer
+}
+
+// trustedProxy returns true if the address is one of the trusted_proxies, which are addresses or CIDR ranges
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, p := range app.TrustedProxies {
+		if _, network, err := net.ParseCIDR(p); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmationCodeAlphabet is Crockford's base32 alphabet. It leaves out I, L, O and U,
+// which are easily confused with digits when the code is typed in by a guest
+const confirmationCodeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ConfirmationCodeLength is the number of characters in a reservation confirmation code
+const ConfirmationCodeLength = 10
+
+// NewConfirmationCode returns a random, unguessable code by which guests find their reservation
+func NewConfirmationCode() (string, error) {
+	b := make([]byte, ConfirmationCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		// 256 is a multiple of the alphabet length, so every character is equally likely
+		b[i] = confirmationCodeAlphabet[int(b[i])%len(confirmationCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// NormalizeConfirmationCode turns the code as typed in by a guest into the stored form
+func NormalizeConfirmationCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+// NewReference returns a random, unguessable token by which API clients refer to a reservation
+func NewReference() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
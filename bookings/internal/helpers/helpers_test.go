@@ -0,0 +1,47 @@
+package helpers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/config"
+)
+
+func TestClientIP(t *testing.T) {
+	NewHelpers(&config.AppConfig{TrustedProxies: []string{"10.0.0.1", "192.168.0.0/16"}})
+	defer NewHelpers(nil)
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		realIP       string
+		expectedIP   string
+	}{
+		{"direct", "203.0.113.7:4321", nil, "", "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:4321", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:4321", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"proxy range", "192.168.5.5:4321", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"chain of proxies", "10.0.0.1:4321", []string{"198.51.100.1, 192.168.1.1"}, "", "198.51.100.1"},
+		{"spoofed by client", "10.0.0.1:4321", []string{"1.2.3.4, 198.51.100.1"}, "", "198.51.100.1"},
+		{"several headers", "10.0.0.1:4321", []string{"1.2.3.4", "198.51.100.1"}, "", "198.51.100.1"},
+		{"only proxies", "10.0.0.1:4321", []string{"192.168.1.1"}, "", "192.168.1.1"},
+		{"garbage", "10.0.0.1:4321", []string{"1.2.3.4, unknown"}, "", "10.0.0.1"},
+		{"real ip", "10.0.0.1:4321", nil, "198.51.100.1", "198.51.100.1"},
+		{"no headers", "10.0.0.1:4321", nil, "", "10.0.0.1"},
+		{"ipv6", "[2001:db8::1]:4321", nil, "", "2001:db8::1"},
+	}
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		for _, v := range e.forwardedFor {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		if e.realIP != "" {
+			req.Header.Set("X-Real-IP", e.realIP)
+		}
+		if ip := ClientIP(req); ip != e.expectedIP {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expectedIP, ip)
+		}
+	}
+}
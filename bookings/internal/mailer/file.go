@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+)
+
+// fileMailer writes every message to an .eml file instead of sending it
+type fileMailer struct {
+	cfg Config
+	dir string
+}
+
+// NewFileDrop creates a mailer writing messages as .eml files to the directory. The directory is created if needed
+func NewFileDrop(cfg Config, dir string) (Mailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileMailer{cfg: cfg, dir: dir}, nil
+}
+
+// Send writes the message to a new file named after the current time
+func (m *fileMailer) Send(ctx context.Context, msg models.MailData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	email, err := m.cfg.newEmail(m.cfg.withDefaults(msg))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(m.dir, time.Now().Format("20060102-150405.000000")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(email.GetMessage())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(ctx context.Context, msg models.MailData) error
+}
+
+// Config holds the settings shared by the mailers
+type Config struct {
+	// From is the sender of messages that do not set one
+	From string
+	// TemplateDir is the directory of email templates the messages are wrapped into
+	TemplateDir string
+}
+
+// withDefaults returns the message with the sender set to the default one if it is empty
+func (c Config) withDefaults(msg models.MailData) models.MailData {
+	if msg.From == "" {
+		msg.From = c.From
+	}
+	return msg
+}
+
+// newEmail builds the email of the message. If the message has a template, its content
+// replaces the [%body%] placeholder of the template
+func (c Config) newEmail(msg models.MailData) (*mail.Email, error) {
+	body := msg.Content
+	if msg.Template != "" {
+		data, err := os.ReadFile(filepath.Join(c.TemplateDir, filepath.Base(msg.Template)))
+		if err != nil {
+			return nil, fmt.Errorf("error reading email template: %w", err)
+		}
+		body = strings.Replace(string(data), "[%body%]", msg.Content, 1)
+	}
+
+	email := mail.NewMSG()
+	email.SetFrom(msg.From).AddTo(msg.To).SetSubject(msg.Subject)
+	email.SetBody(mail.TextHTML, body)
+	if email.Error != nil {
+		return nil, email.Error
+	}
+	return email, nil
+}
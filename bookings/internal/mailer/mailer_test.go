@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+)
+
+func TestFileDrop(t *testing.T) {
+	templates := t.TempDir()
+	err := os.WriteFile(filepath.Join(templates, "basic.html"), []byte("<html>[%body%]</html>"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := NewFileDrop(Config{From: "hotel@here.ca", TemplateDir: templates}, dir)
+	if err != nil {
+		t.Fatalf("unexpected error creating mailer: %q", err)
+	}
+	ctx := context.Background()
+
+	err = m.Send(ctx, models.MailData{To: "john@smith.com", Subject: "Confirmation", Content: "<b>Hello</b>", Template: "basic.html"})
+	if err != nil {
+		t.Fatalf("unexpected error sending message: %q", err)
+	}
+	err = m.Send(ctx, models.MailData{To: "jane@smith.com", Subject: "Missing", Content: "Hello", Template: "missing.html"})
+	if err == nil {
+		t.Error("message with a missing template was sent without an error")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected one .eml file, but got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	for _, expected := range []string{"From: <hotel@here.ca>", "To: <john@smith.com>", "Subject: Confirmation", "<html><b>Hello</b></html>"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in the message:\n%s", expected, data)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory(Config{From: "hotel@here.ca"})
+	ctx := context.Background()
+
+	_ = m.Send(ctx, models.MailData{To: "john@smith.com", Subject: "First"})
+	_ = m.Send(ctx, models.MailData{To: "jane@smith.com", From: "owner@here.ca", Subject: "Second"})
+	m.Err = errors.New("connection refused")
+	if err := m.Send(ctx, models.MailData{To: "nobody@here.ca"}); err == nil {
+		t.Error("expected the configured error")
+	}
+
+	messages := m.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, but got %d", len(messages))
+	}
+	if messages[0].From != "hotel@here.ca" || messages[1].From != "owner@here.ca" {
+		t.Errorf("unexpected senders %q and %q", messages[0].From, messages[1].From)
+	}
+}
+
+func TestNewSMTP(t *testing.T) {
+	for _, encryption := range []string{"", EncryptionNone, EncryptionSTARTTLS, EncryptionTLS} {
+		if _, err := NewSMTP(SMTPConfig{Encryption: encryption}); err != nil {
+			t.Errorf("%q: unexpected error: %q", encryption, err)
+		}
+	}
+	if _, err := NewSMTP(SMTPConfig{Encryption: "ssl3"}); err == nil {
+		t.Error("expected error for unknown encryption")
+	}
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+)
+
+// MemoryMailer keeps the messages in memory, so tests can check what has been sent
+type MemoryMailer struct {
+	cfg      Config
+	mu       sync.Mutex
+	messages []models.MailData
+	// Err, if set, is returned by Send instead of capturing the message
+	Err error
+}
+
+// NewMemory creates a mailer capturing messages in memory
+func NewMemory(cfg Config) *MemoryMailer {
+	return &MemoryMailer{cfg: cfg}
+}
+
+// Send captures the message
+func (m *MemoryMailer) Send(ctx context.Context, msg models.MailData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+	m.messages = append(m.messages, m.cfg.withDefaults(msg))
+	return nil
+}
+
+// Messages returns the captured messages, oldest first
+func (m *MemoryMailer) Messages() []models.MailData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]models.MailData(nil), m.messages...)
+}
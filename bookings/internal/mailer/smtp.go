@@ -0,0 +1,89 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// SMTP encryption modes
+const (
+	EncryptionNone     = "none"
+	EncryptionSTARTTLS = "starttls"
+	EncryptionTLS      = "tls"
+)
+
+// SMTPConfig holds the settings of the SMTP server
+type SMTPConfig struct {
+	Config
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Encryption is one of EncryptionNone, EncryptionSTARTTLS or EncryptionTLS (implicit TLS)
+	Encryption string
+	Timeout    time.Duration
+}
+
+// smtpMailer sends messages through an SMTP server
+type smtpMailer struct {
+	cfg        SMTPConfig
+	encryption mail.Encryption
+}
+
+// NewSMTP creates a mailer sending messages through the SMTP server
+func NewSMTP(cfg SMTPConfig) (Mailer, error) {
+	m := &smtpMailer{cfg: cfg}
+	switch cfg.Encryption {
+	case EncryptionNone, "":
+		m.encryption = mail.EncryptionNone
+	case EncryptionSTARTTLS:
+		m.encryption = mail.EncryptionSTARTTLS
+	case EncryptionTLS:
+		m.encryption = mail.EncryptionSSLTLS
+	default:
+		return nil, fmt.Errorf("unknown SMTP encryption %q", cfg.Encryption)
+	}
+	if m.cfg.Timeout <= 0 {
+		m.cfg.Timeout = 10 * time.Second
+	}
+	return m, nil
+}
+
+// Send sends the message through the SMTP server
+func (m *smtpMailer) Send(ctx context.Context, msg models.MailData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	email, err := m.cfg.newEmail(m.cfg.withDefaults(msg))
+	if err != nil {
+		return err
+	}
+
+	server := mail.NewSMTPClient()
+	server.Host = m.cfg.Host
+	server.Port = m.cfg.Port
+	server.Username = m.cfg.Username
+	server.Password = m.cfg.Password
+	server.Encryption = m.encryption
+	if m.cfg.Username == "" {
+		server.Authentication = mail.AuthNone
+	}
+	server.KeepAlive = false
+	// the client knows no context, so the deadline of ctx shortens its timeouts instead
+	timeout := m.cfg.Timeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	server.ConnectTimeout = timeout
+	server.SendTimeout = timeout
+
+	client, err := server.Connect()
+	if err != nil {
+		return err
+	}
+	return email.Send(client)
+}
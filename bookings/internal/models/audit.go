@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// Entities changed in the back office, as kept in AuditEntry
+const (
+	AuditReservation = "reservation"
+	AuditRoom        = "room"
+	AuditBlock       = "block"
+	AuditUser        = "user"
+	AuditMail        = "mail"
+)
+
+// AuditEntities returns the entities the audit log can be filtered by
+func AuditEntities() []string {
+	return []string{AuditReservation, AuditRoom, AuditBlock, AuditUser, AuditMail}
+}
+
+// Actions recorded in AuditEntry
+const (
+	AuditCreate             = "create"
+	AuditUpdate             = "update"
+	AuditDelete             = "delete"
+	AuditChangeStatus       = "change_status"
+	AuditForcePasswordReset = "force_password_reset"
+	AuditChangePassword     = "change_password"
+	AuditUnlock             = "unlock"
+	AuditEnableTwoFactor    = "enable_two_factor"
+	AuditDisableTwoFactor   = "disable_two_factor"
+	AuditResend             = "resend"
+)
+
+// Actor is the back office user making a change, together with the request the change comes with
+type Actor struct {
+	UserID    int
+	IP        string
+	UserAgent string
+	Method    string
+	Path      string
+}
+
+// AuditEntry is a change made in the back office. Before and After hold the JSON of the entity
+// as it was and as it has become, and are empty when there is nothing to show (e.g. on create or delete)
+type AuditEntry struct {
+	ID       int
+	Actor    Actor
+	Action   string
+	Entity   string
+	EntityID int
+	Before   string
+	After    string
+	// User is the author of the change, unless the user has been deleted since
+	User      User
+	CreatedAt time.Time
+}
+
+// AuditFilter selects audit log entries. Zero fields do not filter; To is exclusive
+type AuditFilter struct {
+	UserID int
+	Entity string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// Matches returns true if the entry is selected by the filter, apart from the limit
+func (f AuditFilter) Matches(e AuditEntry) bool {
+	return (f.UserID == 0 || e.Actor.UserID == f.UserID) &&
+		(f.Entity == "" || e.Entity == f.Entity) &&
+		(f.From.IsZero() || !e.CreatedAt.Before(f.From)) &&
+		(f.To.IsZero() || e.CreatedAt.Before(f.To))
+}
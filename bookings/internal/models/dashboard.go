@@ -0,0 +1,32 @@
+package models
+
+// DashboardCounts holds the number of reservations the front desk deals with on a day. Cancelled
+// reservations and no-shows are not counted
+type DashboardCounts struct {
+	// Arrivals is the number of reservations starting on the day
+	Arrivals int
+	// Departures is the number of reservations ending on the day
+	Departures int
+	// InHouse is the number of reservations checked in and not checked out yet
+	InHouse int
+	// Unprocessed is the number of pending reservations, as listed on the new reservations page
+	Unprocessed int
+}
+
+// Occupancy is the share of room nights booked over a range of nights. Nights blocked by the owners
+// or for maintenance cannot be sold, so they are left out
+type Occupancy struct {
+	Nights        int
+	RoomNights    int
+	BookedNights  int
+	BlockedNights int
+}
+
+// Percent returns the share of room nights that can be sold and have been booked, rounded down
+func (o Occupancy) Percent() int {
+	available := o.RoomNights - o.BlockedNights
+	if available <= 0 {
+		return 0
+	}
+	return o.BookedNights * 100 / available
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Columns the reservation lists of the back office can be sorted by
+const (
+	SortByID        = "id"
+	SortByName      = "name"
+	SortByRoom      = "room"
+	SortByArrival   = "arrival"
+	SortByDeparture = "departure"
+	SortByStatus    = "status"
+	SortByCreated   = "created"
+)
+
+// ReservationSortColumns returns all columns the reservations can be sorted by
+func ReservationSortColumns() []string {
+	return []string{SortByID, SortByName, SortByRoom, SortByArrival, SortByDeparture, SortByStatus, SortByCreated}
+}
+
+// DefaultReservationPageSize is the number of reservations on a page unless another one of
+// ReservationPageSizes is chosen
+const DefaultReservationPageSize = 25
+
+// ReservationPageSizes returns the numbers of reservations on a page to choose from
+func ReservationPageSizes() []int {
+	return []int{10, 25, 50, 100}
+}
+
+// ReservationFilter selects, sorts and pages the reservations listed in the back office. Zero fields
+// do not filter
+type ReservationFilter struct {
+	// Search is looked for in the guest's name, email and phone, ignoring case
+	Search string
+	RoomID int
+	// From and To select the stays having nights from From up to (not including) To
+	From   time.Time
+	To     time.Time
+	Status ReservationStatus
+	// Sort is one of ReservationSortColumns, the arrival date by default
+	Sort string
+	Desc bool
+	// Page starts with 1
+	Page     int
+	PageSize int
+}
+
+// Offset returns the number of reservations on the pages before the requested one
+func (f ReservationFilter) Offset() int {
+	if f.Page < 1 {
+		return 0
+	}
+	return (f.Page - 1) * f.PageSize
+}
+
+// Matches returns true if the reservation is selected by the filter, apart from paging
+func (f ReservationFilter) Matches(r Reservation) bool {
+	if f.Search != "" {
+		text := strings.Join([]string{r.FirstName, r.LastName, r.Email, r.Phone}, " ")
+		if !strings.Contains(strings.ToLower(text), strings.ToLower(f.Search)) {
+			return false
+		}
+	}
+	return (f.RoomID == 0 || r.RoomId == f.RoomID) &&
+		(f.From.IsZero() || r.EndDate.After(f.From)) &&
+		(f.To.IsZero() || r.StartDate.Before(f.To)) &&
+		(f.Status == "" || r.Status == f.Status)
+}
+
+// ReservationPage is one page of the reservations selected by a filter
+type ReservationPage struct {
+	Reservations []Reservation
+	// Total is the number of reservations selected by the filter on all pages
+	Total  int
+	Filter ReservationFilter
+}
+
+// Pages returns the number of pages, which is at least one
+func (p ReservationPage) Pages() int {
+	if p.Filter.PageSize <= 0 || p.Total == 0 {
+		return 1
+	}
+	return (p.Total + p.Filter.PageSize - 1) / p.Filter.PageSize
+}
+
+// First returns the number of the first reservation on the page, counting from 1
+func (p ReservationPage) First() int {
+	if len(p.Reservations) == 0 {
+		return 0
+	}
+	return p.Filter.Offset() + 1
+}
+
+// Last returns the number of the last reservation on the page, counting from 1
+func (p ReservationPage) Last() int {
+	return p.Filter.Offset() + len(p.Reservations)
+}
@@ -0,0 +1,103 @@
+package models
+
+import "time"
+
+// Kinds of the rows of an import
+const (
+	ImportReservation = "reservation"
+	ImportBlock       = "block"
+)
+
+// ImportRow is a line of a CSV file of reservations and blocks being imported. Reservation or Block
+// is filled in, depending on Kind. The row is rejected if it has errors
+type ImportRow struct {
+	// Line is the line number in the file, counting the header as line 1
+	Line        int
+	Kind        string
+	Reservation Reservation
+	Block       RoomRestriction
+	Errors      []string
+}
+
+// Accepted returns true if the row has no errors
+func (r ImportRow) Accepted() bool {
+	return len(r.Errors) == 0
+}
+
+// RoomID returns the room of the reservation or block
+func (r ImportRow) RoomID() int {
+	if r.Kind == ImportBlock {
+		return r.Block.RoomID
+	}
+	return r.Reservation.RoomId
+}
+
+// StartDate returns the first night of the reservation or block
+func (r ImportRow) StartDate() time.Time {
+	if r.Kind == ImportBlock {
+		return r.Block.StartDate
+	}
+	return r.Reservation.StartDate
+}
+
+// EndDate returns the day after the last night of the reservation or block
+func (r ImportRow) EndDate() time.Time {
+	if r.Kind == ImportBlock {
+		return r.Block.EndDate
+	}
+	return r.Reservation.EndDate
+}
+
+// HoldsRoom returns true if the row takes its room for the nights, which all do but cancelled reservations
+func (r ImportRow) HoldsRoom() bool {
+	return r.Kind == ImportBlock || r.Reservation.Status != StatusCancelled
+}
+
+// Overlaps returns true if both rows hold the same room for some of the same nights
+func (r ImportRow) Overlaps(other ImportRow) bool {
+	return r.HoldsRoom() && other.HoldsRoom() && r.RoomID() == other.RoomID() &&
+		r.StartDate().Before(other.EndDate()) && r.EndDate().After(other.StartDate())
+}
+
+// Import is the content of a CSV file of reservations and blocks
+type Import struct {
+	Rows []ImportRow
+}
+
+// Accepted returns the number of rows without errors
+func (i Import) Accepted() int {
+	n := 0
+	for _, r := range i.Rows {
+		if r.Accepted() {
+			n++
+		}
+	}
+	return n
+}
+
+// Rejected returns the number of rows with errors
+func (i Import) Rejected() int {
+	return len(i.Rows) - i.Accepted()
+}
+
+// Reservations returns the reservations of the accepted rows
+func (i Import) Reservations() []Reservation {
+	var reservations []Reservation
+	for _, r := range i.Rows {
+		if r.Accepted() && r.Kind == ImportReservation {
+			reservations = append(reservations, r.Reservation)
+		}
+	}
+	return reservations
+}
+
+// Blocks returns the blocks of the accepted rows
+func (i Import) Blocks() []RoomRestriction {
+	var blocks []RoomRestriction
+	for _, r := range i.Rows {
+		if r.Accepted() && r.Kind == ImportBlock {
+			blocks = append(blocks, r.Block)
+		}
+	}
+	return blocks
+}
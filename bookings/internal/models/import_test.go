@@ -0,0 +1,50 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImportRow_Overlaps(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2060, 1, d, 0, 0, 0, 0, time.UTC) }
+	reservation := func(room, start, end int, status ReservationStatus) ImportRow {
+		return ImportRow{Kind: ImportReservation, Reservation: Reservation{RoomId: room, StartDate: day(start), EndDate: day(end), Status: status}}
+	}
+	block := ImportRow{Kind: ImportBlock, Block: RoomRestriction{RoomID: 1, StartDate: day(10), EndDate: day(12)}}
+
+	tests := []struct {
+		name     string
+		row      ImportRow
+		expected bool
+	}{
+		{"same-nights", reservation(1, 10, 12, StatusConfirmed), true},
+		{"one-night", reservation(1, 11, 15, StatusConfirmed), true},
+		{"departure-day", reservation(1, 12, 15, StatusConfirmed), false},
+		{"arrival-day", reservation(1, 5, 10, StatusConfirmed), false},
+		{"other-room", reservation(2, 10, 12, StatusConfirmed), false},
+		{"cancelled", reservation(1, 10, 12, StatusCancelled), false},
+		{"block", block, true},
+	}
+	for _, e := range tests {
+		if actual := e.row.Overlaps(block); actual != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, actual)
+		}
+	}
+}
+
+func TestImport_Rows(t *testing.T) {
+	i := Import{Rows: []ImportRow{
+		{Line: 2, Kind: ImportReservation, Reservation: Reservation{FirstName: "John"}},
+		{Line: 3, Kind: ImportBlock, Block: RoomRestriction{Note: "Painting"}},
+		{Line: 4, Kind: ImportReservation, Errors: []string{"Unknown room"}},
+	}}
+	if i.Accepted() != 2 || i.Rejected() != 1 {
+		t.Errorf("expected 2 rows accepted and 1 rejected but got %d and %d", i.Accepted(), i.Rejected())
+	}
+	if r := i.Reservations(); len(r) != 1 || r[0].FirstName != "John" {
+		t.Errorf("expected John's reservation but got %+v", r)
+	}
+	if b := i.Blocks(); len(b) != 1 || b[0].Note != "Painting" {
+		t.Errorf("expected the painting block but got %+v", b)
+	}
+}
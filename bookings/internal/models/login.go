@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Reasons of failed logins kept in LoginAttempt
+const (
+	LoginFailedCredentials = "invalid_credentials"
+	LoginFailedSecondStep  = "invalid_second_factor"
+	LoginFailedLocked      = "account_locked"
+	LoginFailedThrottled   = "ip_throttled"
+	// LoginFailedBookingLookup is a guest's lookup of a reservation by a wrong confirmation code or email
+	LoginFailedBookingLookup = "booking_lookup"
+)
+
+// LoginAttempt is a failed login or booking lookup. It is kept for the audit and to throttle logins
+// and lookups from the same IP address
+type LoginAttempt struct {
+	ID        int
+	Email     string
+	IP        string
+	Reason    string
+	CreatedAt time.Time
+}
+
+// LoginPolicy tells how long to wait before the next login after a number of failed ones in a row
+type LoginPolicy struct {
+	// FreeAttempts is the number of failures allowed without waiting
+	FreeAttempts int
+	// LockoutAttempts is the number of failures after which the wait is Lockout
+	LockoutAttempts int
+	Lockout         time.Duration
+}
+
+// Wait returns how long to wait after the failures. It doubles from a second with every failure
+// over FreeAttempts and reaches Lockout at LockoutAttempts
+func (p LoginPolicy) Wait(failures int) time.Duration {
+	switch {
+	case failures <= p.FreeAttempts:
+		return 0
+	case failures >= p.LockoutAttempts:
+		return p.Lockout
+	}
+	if shift := failures - p.FreeAttempts - 1; shift < 30 {
+		if wait := time.Second << shift; wait < p.Lockout {
+			return wait
+		}
+	}
+	return p.Lockout
+}
+
+// Locked returns true if the user cannot log in because of too many failed logins
+func (u User) Locked() bool {
+	return u.LockedUntil.After(time.Now())
+}
+
+// TwoFactorEnabled returns true if the user has to enter a one-time password after the password
+func (u User) TwoFactorEnabled() bool {
+	return u.TwoFactorSecret != ""
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginPolicy_Wait(t *testing.T) {
+	p := LoginPolicy{FreeAttempts: 3, LockoutAttempts: 10, Lockout: 15 * time.Minute}
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{9, 32 * time.Second},
+		{10, 15 * time.Minute},
+		{1000, 15 * time.Minute},
+	}
+	for _, e := range tests {
+		if wait := p.Wait(e.failures); wait != e.expected {
+			t.Errorf("%d failures: expected %s but got %s", e.failures, e.expected, wait)
+		}
+	}
+
+	short := LoginPolicy{FreeAttempts: 0, LockoutAttempts: 100, Lockout: 5 * time.Second}
+	if wait := short.Wait(50); wait != 5*time.Second {
+		t.Errorf("wait must not exceed the lockout, but got %s", wait)
+	}
+}
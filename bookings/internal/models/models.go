@@ -1,29 +1,48 @@
 package models
 
 import (
+	"fmt"
 	"time"
 )
 
 // User is the user model
 type User struct {
-	ID          int
-	FirstName   string
-	LastName    string
-	Email       string
-	Password    string
-	AccessLevel int
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID                int
+	FirstName         string
+	LastName          string
+	Email             string
+	Password          string
+	AccessLevel       int
+	Active            bool
+	MustResetPassword bool
+	FailedLogins      int
+	LockedUntil       time.Time
+	TwoFactorSecret   string
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
 }
 
 // Room is the room model
 type Room struct {
-	ID        int
-	RoomName  string
+	ID          int
+	RoomName    string
+	Slug        string
+	Description string
+	Capacity    int
+	Amenities   []string
+	// BasePrice is the price per night in cents
+	BasePrice int
+	// Photos are file names of room images in static/images
+	Photos    []string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// Price returns the base price per night formatted as dollars and cents
+func (r Room) Price() string {
+	return fmt.Sprintf("%d.%02d", r.BasePrice/100, r.BasePrice%100)
+}
+
 // Restriction is a restriction model
 type Restriction struct {
 	ID              int
@@ -34,18 +53,42 @@ type Restriction struct {
 
 // Reservation is a reservation model
 type Reservation struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomId    int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Processed int
-	Room      Room
+	ID               int
+	ConfirmationCode string
+	Reference        string
+	FirstName        string
+	LastName         string
+	Email            string
+	Phone            string
+	StartDate        time.Time
+	EndDate          time.Time
+	RoomId           int
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Status           ReservationStatus
+	Room             Room
+}
+
+// Nights returns the number of nights of the stay
+func (r Reservation) Nights() int {
+	if !r.EndDate.After(r.StartDate) {
+		return 0
+	}
+	return int(r.EndDate.Sub(r.StartDate).Round(24*time.Hour) / (24 * time.Hour))
+}
+
+// ReservationStatusChange is a record of reservation status history
+type ReservationStatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    ReservationStatus
+	ToStatus      ReservationStatus
+	UserID        int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	User          User
+	// Reservation is filled in by RecentStatusChanges only, with the guest's name and the room
+	Reservation Reservation
 }
 
 // RoomRestriction is a room restriction model
@@ -56,6 +99,7 @@ type RoomRestriction struct {
 	RoomID        int
 	ReservationID int
 	RestrictionID int
+	Note          string
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
@@ -71,3 +115,25 @@ type MailData struct {
 	Content  string
 	Template string
 }
+
+// MailStatus is the delivery state of a message in the mail outbox
+type MailStatus string
+
+const (
+	MailQueued MailStatus = "queued"
+	MailSent   MailStatus = "sent"
+	MailFailed MailStatus = "failed"
+)
+
+// OutboxMessage is an email message in the mail outbox. Failed messages have run out of delivery attempts
+type OutboxMessage struct {
+	ID int
+	MailData
+	Status        MailStatus
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+// ReportGrouping is the length of the periods a report is broken down into
+type ReportGrouping string
+
+const (
+	GroupByDay   ReportGrouping = "day"
+	GroupByWeek  ReportGrouping = "week"
+	GroupByMonth ReportGrouping = "month"
+)
+
+// ReportGroupings returns all groupings from the shortest period to the longest one
+func ReportGroupings() []ReportGrouping {
+	return []ReportGrouping{GroupByDay, GroupByWeek, GroupByMonth}
+}
+
+// Valid returns true if g is one of the known groupings
+func (g ReportGrouping) Valid() bool {
+	for _, grouping := range ReportGroupings() {
+		if g == grouping {
+			return true
+		}
+	}
+	return false
+}
+
+// PeriodStart returns the first day of the period the day falls in. Weeks start on Monday, as in Postgres
+func (g ReportGrouping) PeriodStart(day time.Time) time.Time {
+	switch g {
+	case GroupByWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case GroupByMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// ReportRow holds the figures of one room over one period. Nights are counted in the period, while
+// bookings, stays and their lengths and lead times are counted for the reservations arriving in it.
+// Rows summed up over all rooms have RoomID 0, rows summed up over all periods have a zero Period
+type ReportRow struct {
+	Period   time.Time
+	RoomID   int
+	RoomName string
+	// RoomNights is the number of nights times the number of rooms
+	RoomNights int
+	// BlockedNights are the room nights blocked by the owners or for maintenance, which cannot be sold
+	BlockedNights int
+	// NightsSold are the room nights of reservations that have been neither cancelled nor missed
+	NightsSold int
+	Bookings   int
+	Cancelled  int
+	NoShows    int
+	// StayNights and LeadDays sum up the length and the number of days booked in advance of the stays,
+	// i.e. of the bookings that have been neither cancelled nor missed
+	StayNights int
+	LeadDays   int
+	// LeadStays counts the stays LeadDays are summed up for: those booked on or before the day of arrival.
+	// Reservations imported without the day they were booked seem booked later and are left out
+	LeadStays int
+}
+
+// Add adds up the figures of other to the row
+func (r *ReportRow) Add(other ReportRow) {
+	r.RoomNights += other.RoomNights
+	r.BlockedNights += other.BlockedNights
+	r.NightsSold += other.NightsSold
+	r.Bookings += other.Bookings
+	r.Cancelled += other.Cancelled
+	r.NoShows += other.NoShows
+	r.StayNights += other.StayNights
+	r.LeadDays += other.LeadDays
+	r.LeadStays += other.LeadStays
+}
+
+// Stays returns the number of bookings that have been neither cancelled nor missed
+func (r ReportRow) Stays() int {
+	return r.Bookings - r.Cancelled - r.NoShows
+}
+
+// Occupancy returns the percentage of the room nights that could be sold and have been sold
+func (r ReportRow) Occupancy() float64 {
+	return ratio(r.NightsSold*100, r.RoomNights-r.BlockedNights)
+}
+
+// AverageStay returns the average length of stay in nights
+func (r ReportRow) AverageStay() float64 {
+	return ratio(r.StayNights, r.Stays())
+}
+
+// AverageLeadTime returns the average number of days the stays have been booked in advance
+func (r ReportRow) AverageLeadTime() float64 {
+	return ratio(r.LeadDays, r.LeadStays)
+}
+
+// CancellationRate returns the percentage of the bookings that have been cancelled
+func (r ReportRow) CancellationRate() float64 {
+	return ratio(r.Cancelled*100, r.Bookings)
+}
+
+// NoShowRate returns the percentage of the bookings whose guests have not shown up
+func (r ReportRow) NoShowRate() float64 {
+	return ratio(r.NoShows*100, r.Bookings)
+}
+
+func ratio(a, b int) float64 {
+	if b <= 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// Report holds the figures of every room over every period, ordered by period and room
+type Report struct {
+	Grouping ReportGrouping
+	From     time.Time
+	// To is exclusive
+	To   time.Time
+	Rows []ReportRow
+}
+
+// ByPeriod returns the figures of each period summed up over all rooms
+func (r Report) ByPeriod() []ReportRow {
+	return r.sum(func(row ReportRow) ReportRow { return ReportRow{Period: row.Period} })
+}
+
+// ByRoom returns the figures of each room summed up over all periods
+func (r Report) ByRoom() []ReportRow {
+	return r.sum(func(row ReportRow) ReportRow { return ReportRow{RoomID: row.RoomID, RoomName: row.RoomName} })
+}
+
+// Total returns the figures summed up over all rooms and periods
+func (r Report) Total() ReportRow {
+	var total ReportRow
+	for _, row := range r.Rows {
+		total.Add(row)
+	}
+	return total
+}
+
+// sum adds up the rows for which group returns the same period and room, ordered by period and room
+func (r Report) sum(group func(ReportRow) ReportRow) []ReportRow {
+	type key struct {
+		period int64
+		roomID int
+	}
+	var sums []ReportRow
+	index := map[key]int{}
+	for _, row := range r.Rows {
+		g := group(row)
+		k := key{g.Period.Unix(), g.RoomID}
+		i, ok := index[k]
+		if !ok {
+			i = len(sums)
+			index[k] = i
+			sums = append(sums, g)
+		}
+		sums[i].Add(row)
+	}
+	sort.SliceStable(sums, func(i, j int) bool {
+		if !sums[i].Period.Equal(sums[j].Period) {
+			return sums[i].Period.Before(sums[j].Period)
+		}
+		return sums[i].RoomID < sums[j].RoomID
+	})
+	return sums
+}
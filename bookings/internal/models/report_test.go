@@ -0,0 +1,67 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReportGrouping_PeriodStart(t *testing.T) {
+	// Sunday, 2026-03-15
+	day := time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		grouping ReportGrouping
+		expected time.Time
+	}{
+		{GroupByDay, day},
+		{GroupByWeek, time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)},
+		{GroupByMonth, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, e := range tests {
+		if actual := e.grouping.PeriodStart(day); !actual.Equal(e.expected) {
+			t.Errorf("%s: expected %s but got %s", e.grouping, e.expected, actual)
+		}
+	}
+	if monday := GroupByWeek.PeriodStart(day.AddDate(0, 0, 1)); !monday.Equal(day.AddDate(0, 0, 1)) {
+		t.Errorf("expected Monday to start the week but got %s", monday)
+	}
+}
+
+func TestReport_Sums(t *testing.T) {
+	jan := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := jan.AddDate(0, 1, 0)
+	report := Report{Rows: []ReportRow{
+		{Period: jan, RoomID: 1, RoomNights: 31, NightsSold: 10, Bookings: 3, Cancelled: 1, StayNights: 10, LeadDays: 8, LeadStays: 2},
+		{Period: jan, RoomID: 2, RoomNights: 31, BlockedNights: 12, NightsSold: 5, Bookings: 2, NoShows: 1, StayNights: 5, LeadDays: 0, LeadStays: 0},
+		{Period: feb, RoomID: 1, RoomNights: 28, NightsSold: 28, Bookings: 1, StayNights: 28, LeadDays: 30, LeadStays: 1},
+	}}
+
+	byPeriod := report.ByPeriod()
+	if len(byPeriod) != 2 || byPeriod[0].RoomNights != 62 || byPeriod[0].NightsSold != 15 || byPeriod[1].RoomID != 0 {
+		t.Errorf("unexpected sums by period %+v", byPeriod)
+	}
+	byRoom := report.ByRoom()
+	if len(byRoom) != 2 || byRoom[0].NightsSold != 38 || !byRoom[0].Period.IsZero() {
+		t.Errorf("unexpected sums by room %+v", byRoom)
+	}
+
+	total := report.Total()
+	if total.Stays() != 4 {
+		t.Errorf("expected 4 stays but got %d", total.Stays())
+	}
+	if occupancy := byPeriod[0].Occupancy(); occupancy != 30 {
+		t.Errorf("expected 15 of 50 room nights to be 30%% occupancy but got %v", occupancy)
+	}
+	if stay := total.AverageStay(); stay != 43.0/4 {
+		t.Errorf("expected average stay of %v but got %v", 43.0/4, stay)
+	}
+	// the stay in room 2 has been imported without the day it was booked
+	if lead := total.AverageLeadTime(); lead != 38.0/3 {
+		t.Errorf("expected average lead time of %v but got %v", 38.0/3, lead)
+	}
+	if rate := total.CancellationRate(); rate != 100.0/6 {
+		t.Errorf("expected cancellation rate of %v but got %v", 100.0/6, rate)
+	}
+	if rate := (ReportRow{}).NoShowRate(); rate != 0 {
+		t.Errorf("expected no rate without bookings but got %v", rate)
+	}
+}
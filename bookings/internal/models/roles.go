@@ -0,0 +1,112 @@
+package models
+
+// Role is the access level of a back office user, as stored in User.AccessLevel
+type Role int
+
+const (
+	RoleViewer    Role = 1
+	RoleFrontDesk Role = 2
+	RoleManager   Role = 3
+	RoleOwner     Role = 4
+)
+
+// Permission is an action in the back office that is granted to some of the roles
+type Permission string
+
+const (
+	PermViewReservations   Permission = "reservations.view"
+	PermEditReservations   Permission = "reservations.edit"
+	PermDeleteReservations Permission = "reservations.delete"
+	PermBlockRooms         Permission = "rooms.block"
+	PermViewRooms          Permission = "rooms.view"
+	PermManageRooms        Permission = "rooms.manage"
+	PermViewMail           Permission = "mail.view"
+	PermViewReports        Permission = "reports.view"
+	PermExportReservations Permission = "reservations.export"
+	PermImportReservations Permission = "reservations.import"
+	PermResendMail         Permission = "mail.resend"
+	PermManageUsers        Permission = "users.manage"
+	PermViewAudit          Permission = "audit.view"
+)
+
+// rolePermissions holds permissions of each role. Every role has the permissions of the roles below it
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:    {PermViewReservations, PermViewRooms},
+	RoleFrontDesk: {PermEditReservations, PermBlockRooms, PermViewMail, PermResendMail},
+	RoleManager:   {PermDeleteReservations, PermManageRooms, PermViewReports, PermExportReservations, PermImportReservations},
+	RoleOwner:     {PermManageUsers, PermViewAudit},
+}
+
+var roleNames = map[Role]string{
+	RoleViewer:    "viewer",
+	RoleFrontDesk: "front_desk",
+	RoleManager:   "manager",
+	RoleOwner:     "owner",
+}
+
+var roleTitles = map[Role]string{
+	RoleViewer:    "Viewer",
+	RoleFrontDesk: "Front desk",
+	RoleManager:   "Manager",
+	RoleOwner:     "Owner",
+}
+
+// AllRoles returns all roles from the least to the most powerful one
+func AllRoles() []Role {
+	return []Role{RoleViewer, RoleFrontDesk, RoleManager, RoleOwner}
+}
+
+// RoleByName returns the role with the name returned by String
+func RoleByName(name string) (Role, bool) {
+	for role, n := range roleNames {
+		if n == name {
+			return role, true
+		}
+	}
+	return 0, false
+}
+
+// Valid returns true if r is one of the known roles
+func (r Role) Valid() bool {
+	_, ok := roleNames[r]
+	return ok
+}
+
+// Can returns true if the role is granted the permission
+func (r Role) Can(p Permission) bool {
+	if !r.Valid() {
+		return false
+	}
+	for _, role := range AllRoles() {
+		if role > r {
+			break
+		}
+		for _, granted := range rolePermissions[role] {
+			if granted == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String returns the name of the role
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return "none"
+}
+
+// Title returns the human readable name of the role
+func (r Role) Title() string {
+	if title, ok := roleTitles[r]; ok {
+		return title
+	}
+	return "No access"
+}
+
+// Role returns the role given to the user by the access level
+func (u User) Role() Role {
+	return Role(u.AccessLevel)
+}
@@ -0,0 +1,56 @@
+package models
+
+import "testing"
+
+func TestRole_Can(t *testing.T) {
+	tests := []struct {
+		role     Role
+		perm     Permission
+		expected bool
+	}{
+		{RoleViewer, PermViewReservations, true},
+		{RoleViewer, PermEditReservations, false},
+		{RoleFrontDesk, PermViewReservations, true},
+		{RoleFrontDesk, PermBlockRooms, true},
+		{RoleFrontDesk, PermDeleteReservations, false},
+		{RoleManager, PermEditReservations, true},
+		{RoleManager, PermDeleteReservations, true},
+		{RoleManager, PermManageUsers, false},
+		{RoleFrontDesk, PermViewReports, false},
+		{RoleManager, PermViewReports, true},
+		{RoleFrontDesk, PermExportReservations, false},
+		{RoleManager, PermExportReservations, true},
+		{RoleFrontDesk, PermImportReservations, false},
+		{RoleManager, PermImportReservations, true},
+		{RoleOwner, PermManageUsers, true},
+		{RoleOwner, PermViewMail, true},
+		{Role(0), PermViewReservations, false},
+		{Role(99), PermViewReservations, false},
+	}
+
+	for _, e := range tests {
+		if actual := e.role.Can(e.perm); actual != e.expected {
+			t.Errorf("%s can %s: expected %t but got %t", e.role, e.perm, e.expected, actual)
+		}
+	}
+}
+
+func TestRole_String(t *testing.T) {
+	if RoleFrontDesk.String() != "front_desk" || RoleFrontDesk.Title() != "Front desk" {
+		t.Errorf("unexpected name %q or title %q", RoleFrontDesk.String(), RoleFrontDesk.Title())
+	}
+	if Role(0).String() != "none" || Role(0).Title() != "No access" {
+		t.Errorf("unexpected name %q or title %q of unknown role", Role(0).String(), Role(0).Title())
+	}
+}
+
+func TestRoleByName(t *testing.T) {
+	for _, role := range AllRoles() {
+		if found, ok := RoleByName(role.String()); !ok || found != role {
+			t.Errorf("%s: expected %d but got %d", role, role, found)
+		}
+	}
+	if _, ok := RoleByName("admin"); ok {
+		t.Error("unknown role name is found")
+	}
+}
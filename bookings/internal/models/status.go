@@ -0,0 +1,61 @@
+package models
+
+import "strings"
+
+// ReservationStatus is a stage of the reservation lifecycle
+type ReservationStatus string
+
+const (
+	StatusPending    ReservationStatus = "pending"
+	StatusConfirmed  ReservationStatus = "confirmed"
+	StatusCheckedIn  ReservationStatus = "checked_in"
+	StatusCheckedOut ReservationStatus = "checked_out"
+	StatusCancelled  ReservationStatus = "cancelled"
+	StatusNoShow     ReservationStatus = "no_show"
+)
+
+// reservationTransitions holds statuses a reservation is allowed to move to from each status
+var reservationTransitions = map[ReservationStatus][]ReservationStatus{
+	StatusPending:   {StatusConfirmed, StatusCancelled},
+	StatusConfirmed: {StatusCheckedIn, StatusCancelled, StatusNoShow},
+	StatusCheckedIn: {StatusCheckedOut},
+}
+
+// AllReservationStatuses returns all statuses in lifecycle order
+func AllReservationStatuses() []ReservationStatus {
+	return []ReservationStatus{StatusPending, StatusConfirmed, StatusCheckedIn, StatusCheckedOut, StatusCancelled, StatusNoShow}
+}
+
+// Valid returns true if s is one of the known statuses
+func (s ReservationStatus) Valid() bool {
+	for _, status := range AllReservationStatuses() {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Transitions returns statuses the reservation may be moved to from s
+func (s ReservationStatus) Transitions() []ReservationStatus {
+	return reservationTransitions[s]
+}
+
+// CanTransitionTo returns true if the reservation may be moved from s to the status to
+func (s ReservationStatus) CanTransitionTo(to ReservationStatus) bool {
+	for _, status := range reservationTransitions[s] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Title returns the status in a human readable form, e.g. "Checked in"
+func (s ReservationStatus) Title() string {
+	if s == "" {
+		return ""
+	}
+	title := strings.ReplaceAll(string(s), "_", " ")
+	return strings.ToUpper(title[:1]) + title[1:]
+}
@@ -0,0 +1,37 @@
+package models
+
+import "testing"
+
+func TestReservationStatus_CanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from     ReservationStatus
+		to       ReservationStatus
+		expected bool
+	}{
+		{StatusPending, StatusConfirmed, true},
+		{StatusPending, StatusCancelled, true},
+		{StatusPending, StatusCheckedIn, false},
+		{StatusConfirmed, StatusCheckedIn, true},
+		{StatusConfirmed, StatusNoShow, true},
+		{StatusCheckedIn, StatusCheckedOut, true},
+		{StatusCheckedIn, StatusCancelled, false},
+		{StatusCheckedOut, StatusCheckedIn, false},
+		{StatusCancelled, StatusConfirmed, false},
+		{StatusNoShow, StatusCheckedIn, false},
+	}
+
+	for _, e := range tests {
+		if actual := e.from.CanTransitionTo(e.to); actual != e.expected {
+			t.Errorf("%s -> %s: expected %t but got %t", e.from, e.to, e.expected, actual)
+		}
+	}
+}
+
+func TestReservationStatus_Title(t *testing.T) {
+	if title := StatusCheckedIn.Title(); title != "Checked in" {
+		t.Errorf("expected %q but got %q", "Checked in", title)
+	}
+	if ReservationStatus("unknown").Valid() {
+		t.Error("unknown status is reported as valid")
+	}
+}
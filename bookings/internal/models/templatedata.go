@@ -14,4 +14,12 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	// Role is the role of the authenticated user
+	Role Role
+}
+
+// Can returns true if the authenticated user is granted the permission. Templates use it to hide
+// the actions the user cannot perform
+func (td *TemplateData) Can(p Permission) bool {
+	return td.Role.Can(p)
 }
@@ -0,0 +1,84 @@
+// Package passwordreset creates and checks the tokens of the links sent to users who forgot their password.
+// A token carries the user id and the expiry time signed with HMAC-SHA256. The signature also covers the
+// password hash and the email of the user, so the token stops working as soon as the password is changed
+package passwordreset
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+)
+
+// ErrInvalidToken is returned when the token is malformed, forged or has already been used
+var ErrInvalidToken = errors.New("invalid password reset token")
+
+// ErrExpiredToken is returned when the token is genuine, but too old
+var ErrExpiredToken = errors.New("password reset token has expired")
+
+// NewToken returns the token letting the user set a new password until expires
+func NewToken(key []byte, u models.User, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d", u.ID, expires.Unix())
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(key, payload, u))
+}
+
+// UserID returns the id of the user the token has been issued for. The token is not checked,
+// this is done by Verify once the user is read
+func UserID(token string) (int, error) {
+	id, _, _, err := parse(token)
+	return id, err
+}
+
+// Verify checks that the token has been issued for the user in the current state and has not expired at now
+func Verify(key []byte, token string, u models.User, now time.Time) error {
+	id, expires, signature, err := parse(token)
+	if err != nil {
+		return err
+	}
+	payload := token[:strings.LastIndexByte(token, '.')]
+	if id != u.ID || !hmac.Equal(signature, sign(key, payload, u)) {
+		return ErrInvalidToken
+	}
+	if !now.Before(expires) {
+		return ErrExpiredToken
+	}
+	return nil
+}
+
+// parse splits the token into the user id, the expiry time and the signature
+func parse(token string) (int, time.Time, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, time.Time{}, nil, ErrInvalidToken
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, time.Time{}, nil, ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, time.Time{}, nil, ErrInvalidToken
+	}
+	return id, time.Unix(expires, 0), signature, nil
+}
+
+// sign returns the signature of the payload bound to the current password and email of the user
+func sign(key []byte, payload string, u models.User) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	mac.Write([]byte{0})
+	mac.Write([]byte(u.Password))
+	mac.Write([]byte{0})
+	mac.Write([]byte(u.Email))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,52 @@
+package passwordreset
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+)
+
+func TestVerify(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Date(2060, 1, 1, 12, 0, 0, 0, time.UTC)
+	user := models.User{ID: 7, Email: "jane@here.ca", Password: "$2a$10$hash"}
+	token := NewToken(key, user, now.Add(time.Hour))
+
+	if id, err := UserID(token); err != nil || id != 7 {
+		t.Errorf("expected user id 7, but got %d and error %v", id, err)
+	}
+	if err := Verify(key, token, user, now); err != nil {
+		t.Errorf("unexpected error: %q", err)
+	}
+
+	changed := user
+	changed.Password = "$2a$10$newhash"
+	other := user
+	other.ID = 8
+	tests := []struct {
+		name     string
+		key      []byte
+		token    string
+		user     models.User
+		now      time.Time
+		expected error
+	}{
+		{"expired", key, token, user, now.Add(time.Hour), ErrExpiredToken},
+		{"used", key, token, changed, now, ErrInvalidToken},
+		{"other user", key, token, other, now, ErrInvalidToken},
+		{"other key", []byte("fedcba9876543210fedcba9876543210"), token, user, now, ErrInvalidToken},
+		{"longer expiry", key, fmt.Sprintf("7.%d.%s", now.Add(48*time.Hour).Unix(), token[strings.LastIndexByte(token, '.')+1:]),
+			user, now.Add(2 * time.Hour), ErrInvalidToken},
+		{"malformed", key, "7.abc.def", user, now, ErrInvalidToken},
+		{"empty", key, "", user, now, ErrInvalidToken},
+	}
+	for _, e := range tests {
+		if err := Verify(e.key, e.token, e.user, e.now); !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, err)
+		}
+	}
+}
@@ -54,6 +54,7 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	td.Warning = app.Session.PopString(r.Context(), "warning")
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
+		td.Role = models.Role(app.Session.GetInt(r.Context(), "access_level"))
 	}
 	td.CSRFToken = nosurf.Token(r)
 	return td
@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+)
+
+type actorKey struct{}
+
+// WithActor returns the context of a back office request made by the actor. The changes made with it
+// are written to the audit log
+func WithActor(ctx context.Context, actor models.Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the back office user making the request. It returns false for guests,
+// API clients and the background jobs, whose changes are not audited
+func ActorFromContext(ctx context.Context) (models.Actor, bool) {
+	actor, ok := ctx.Value(actorKey{}).(models.Actor)
+	return actor, ok && actor.UserID != 0
+}
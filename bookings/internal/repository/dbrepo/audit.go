@@ -0,0 +1,134 @@
+package dbrepo
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository"
+)
+
+// auditReservation is the JSON of a reservation kept in the audit log
+type auditReservation struct {
+	FirstName string                   `json:"first_name"`
+	LastName  string                   `json:"last_name"`
+	Email     string                   `json:"email"`
+	Phone     string                   `json:"phone"`
+	StartDate string                   `json:"start_date"`
+	EndDate   string                   `json:"end_date"`
+	RoomID    int                      `json:"room_id"`
+	Status    models.ReservationStatus `json:"status,omitempty"`
+}
+
+func reservationSnapshot(r models.Reservation) auditReservation {
+	return auditReservation{
+		FirstName: r.FirstName,
+		LastName:  r.LastName,
+		Email:     r.Email,
+		Phone:     r.Phone,
+		StartDate: r.StartDate.Format("2006-01-02"),
+		EndDate:   r.EndDate.Format("2006-01-02"),
+		RoomID:    r.RoomId,
+		Status:    r.Status,
+	}
+}
+
+// auditStatus is the JSON of a reservation status change kept in the audit log
+type auditStatus struct {
+	Status models.ReservationStatus `json:"status"`
+}
+
+// auditRoom is the JSON of a room kept in the audit log
+type auditRoom struct {
+	RoomName    string   `json:"room_name"`
+	Slug        string   `json:"slug"`
+	Description string   `json:"description"`
+	Capacity    int      `json:"capacity"`
+	Amenities   []string `json:"amenities"`
+	BasePrice   int      `json:"base_price"`
+	Photos      []string `json:"photos"`
+}
+
+func roomSnapshot(r models.Room) auditRoom {
+	return auditRoom{
+		RoomName:    r.RoomName,
+		Slug:        r.Slug,
+		Description: r.Description,
+		Capacity:    r.Capacity,
+		Amenities:   r.Amenities,
+		BasePrice:   r.BasePrice,
+		Photos:      r.Photos,
+	}
+}
+
+// auditBlock is the JSON of a room block kept in the audit log
+type auditBlock struct {
+	RoomID        int    `json:"room_id"`
+	StartDate     string `json:"start_date"`
+	EndDate       string `json:"end_date"`
+	RestrictionID int    `json:"restriction_id"`
+	Note          string `json:"note"`
+}
+
+func blockSnapshot(b models.RoomRestriction) auditBlock {
+	return auditBlock{
+		RoomID:        b.RoomID,
+		StartDate:     b.StartDate.Format("2006-01-02"),
+		EndDate:       b.EndDate.Format("2006-01-02"),
+		RestrictionID: b.RestrictionID,
+		Note:          b.Note,
+	}
+}
+
+// auditUser is the JSON of a user kept in the audit log. The password and the TOTP secret are left out
+type auditUser struct {
+	ID          int    `json:"id"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	Email       string `json:"email"`
+	AccessLevel int    `json:"access_level"`
+	Active      bool   `json:"active"`
+}
+
+func userSnapshot(u models.User) auditUser {
+	return auditUser{
+		ID:          u.ID,
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		Email:       u.Email,
+		AccessLevel: u.AccessLevel,
+		Active:      u.Active,
+	}
+}
+
+// newAuditEntry returns the audit log entry of a change made by the actor of ctx. Snapshots before
+// and after the change are turned into JSON, nil ones are left empty. It returns false if the change
+// has not been made in the back office and is not audited
+func newAuditEntry(ctx context.Context, action, entity string, entityID int, before, after any) (models.AuditEntry, bool, error) {
+	actor, ok := repository.ActorFromContext(ctx)
+	if !ok {
+		return models.AuditEntry{}, false, nil
+	}
+	e := models.AuditEntry{
+		Actor:     actor,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		CreatedAt: time.Now(),
+	}
+	for _, s := range []struct {
+		snapshot any
+		json     *string
+	}{{before, &e.Before}, {after, &e.After}} {
+		if s.snapshot == nil {
+			continue
+		}
+		b, err := json.Marshal(s.snapshot)
+		if err != nil {
+			return e, false, err
+		}
+		*s.json = string(b)
+	}
+	return e, true, nil
+}
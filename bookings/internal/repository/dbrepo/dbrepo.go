@@ -1,12 +1,40 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/config"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository"
 )
 
+// reservationRestrictionID is the id of "Reservation" row in restrictions table
+const reservationRestrictionID = 1
+
+// defaultDBTimeout is used when no query timeout is set in the application config
+const defaultDBTimeout = 3 * time.Second
+
+// exportTimeout bounds the queries whose rows are streamed to a download, which lasts as long as
+// the client takes to receive it
+const exportTimeout = 5 * time.Minute
+
+// importTimeout bounds the transaction importing a file of reservations and blocks
+const importTimeout = time.Minute
+
+// exclusionViolationCode is the Postgres error code raised when an exclusion constraint is violated
+const exclusionViolationCode = "23P01"
+
+// uniqueViolationCode is the Postgres error code raised when a unique constraint is violated
+const uniqueViolationCode = "23505"
+
+// dummyPasswordHash is checked against the password given with an unknown email, so that Authenticate takes
+// as long as with a wrong password and the timing does not tell which emails have an account
+var dummyPasswordHash = []byte("$2a$10$SoK1Z/wwrPcu1gO.5D6us.96K9bnfkkEJmYAESif2Dc8eqm.P44Aq")
+
 type postgresDBRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
@@ -18,6 +46,26 @@ type testDBRepo struct {
 	FetchError *bool
 }
 
+// memoryDBRepo keeps all the data in memory, so the application can run without Postgres
+type memoryDBRepo struct {
+	App              *config.AppConfig
+	mu               sync.RWMutex
+	lastID           map[string]int
+	rooms            map[int]models.Room
+	restrictions     map[int]models.Restriction
+	users            map[int]models.User
+	reservations     map[int]models.Reservation
+	roomRestrictions map[int]models.RoomRestriction
+	statusHistory    map[int]models.ReservationStatusChange
+	mail             map[int]models.OutboxMessage
+	loginAttempts    map[int]models.LoginAttempt
+	// totpLastSteps and recoveryCodes are kept by user id; a recovery code maps to true once used
+	totpLastSteps map[int]int64
+	recoveryCodes map[int]map[string]bool
+	// auditLog is only ever appended to, oldest entry first
+	auditLog []models.AuditEntry
+}
+
 func NewPostresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
 	return &postgresDBRepo{
 		App: a,
@@ -25,6 +73,26 @@ func NewPostresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
 	}
 }
 
+// NewMemoryRepo creates an in-memory database seeded with rooms, restrictions and an administrator
+func NewMemoryRepo(a *config.AppConfig) repository.DatabaseRepo {
+	m := &memoryDBRepo{
+		App:              a,
+		lastID:           map[string]int{},
+		rooms:            map[int]models.Room{},
+		restrictions:     map[int]models.Restriction{},
+		users:            map[int]models.User{},
+		reservations:     map[int]models.Reservation{},
+		roomRestrictions: map[int]models.RoomRestriction{},
+		statusHistory:    map[int]models.ReservationStatusChange{},
+		mail:             map[int]models.OutboxMessage{},
+		loginAttempts:    map[int]models.LoginAttempt{},
+		totpLastSteps:    map[int]int64{},
+		recoveryCodes:    map[int]map[string]bool{},
+	}
+	m.seed()
+	return m
+}
+
 func NewTestingRepo(a *config.AppConfig, fetchError *bool) repository.DatabaseRepo {
 	return &testDBRepo{
 		App:        a,
@@ -32,3 +100,40 @@ func NewTestingRepo(a *config.AppConfig, fetchError *bool) repository.DatabaseRe
 		FetchError: fetchError,
 	}
 }
+
+// withTimeout derives the context of a single query from the request context
+// using the query timeout set in the application config
+func (m *postgresDBRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := m.App.DBTimeout
+	if timeout <= 0 {
+		timeout = defaultDBTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// likeEscaper escapes the characters that have a special meaning in LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// splitLines splits a newline separated text column into a slice skipping empty lines
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// joinLines is the reverse of splitLines
+func joinLines(lines []string) string {
+	return strings.Join(lines, "\n")
+}
+
+// nights returns the number of nights from start up to (not including) end, or 0 if end is not after start
+func nights(start, end time.Time) int {
+	if !end.After(start) {
+		return 0
+	}
+	return int(end.Sub(start).Round(24*time.Hour) / (24 * time.Hour))
+}
@@ -0,0 +1,1559 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// roomDescription is the description the rooms are seeded with
+const roomDescription = "You home away from home set to majestic water of Atlantic Ocean. " +
+	"That will be vacation to remember for a long time."
+
+// seed fills the in-memory database with the same rooms and restrictions as the migrations do
+// plus one administrator (me@here.ca / password) so that the admin tool can be used as well
+func (m *memoryDBRepo) seed() {
+	seeded := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+	m.rooms[1] = models.Room{
+		ID:          1,
+		RoomName:    "General's Quoters",
+		Slug:        "generals-quoters",
+		Description: roomDescription,
+		Capacity:    2,
+		Amenities:   []string{"Ocean view", "Queen bed", "Free Wi-Fi", "Breakfast included"},
+		BasePrice:   12900,
+		Photos:      []string{"generals-quarters.png"},
+		CreatedAt:   seeded,
+		UpdatedAt:   seeded,
+	}
+	m.rooms[2] = models.Room{
+		ID:          2,
+		RoomName:    "Major's Suite",
+		Slug:        "majors-suite",
+		Description: roomDescription,
+		Capacity:    4,
+		Amenities:   []string{"Ocean view", "King bed", "Sofa bed", "Free Wi-Fi", "Breakfast included"},
+		BasePrice:   18900,
+		Photos:      []string{"marjors-suite.png"},
+		CreatedAt:   seeded,
+		UpdatedAt:   seeded,
+	}
+	m.restrictions[1] = models.Restriction{ID: 1, RestrictionName: "Reservation", CreatedAt: seeded, UpdatedAt: seeded}
+	m.restrictions[2] = models.Restriction{ID: 2, RestrictionName: "Owners' Block", CreatedAt: seeded, UpdatedAt: seeded}
+	m.restrictions[3] = models.Restriction{ID: 3, RestrictionName: "Maintenance", CreatedAt: seeded, UpdatedAt: seeded}
+	m.restrictions[4] = models.Restriction{ID: 4, RestrictionName: "Other", CreatedAt: seeded, UpdatedAt: seeded}
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	m.users[1] = models.User{
+		ID:          1,
+		FirstName:   "Admin",
+		LastName:    "Admin",
+		Email:       "me@here.ca",
+		Password:    string(hashedPassword),
+		AccessLevel: int(models.RoleOwner),
+		Active:      true,
+		CreatedAt:   seeded,
+		UpdatedAt:   seeded,
+	}
+	m.lastID["rooms"] = 2
+	m.lastID["restrictions"] = 4
+	m.lastID["users"] = 1
+}
+
+// nextID returns the next value of the table's id sequence
+func (m *memoryDBRepo) nextID(table string) int {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+// isRoomAvailable returns true if there are no room restrictions overlapping the dates.
+// It must be called with the lock held
+func (m *memoryDBRepo) isRoomAvailable(roomID int, start, end time.Time) bool {
+	return m.isRoomAvailableExcept(roomID, start, end, 0)
+}
+
+// isRoomAvailableExcept is isRoomAvailable ignoring the restriction of reservation exceptID
+func (m *memoryDBRepo) isRoomAvailableExcept(roomID int, start, end time.Time, exceptID int) bool {
+	for _, rr := range m.roomRestrictions {
+		if exceptID != 0 && rr.ReservationID == exceptID {
+			continue
+		}
+		if rr.RoomID == roomID && start.Before(rr.EndDate) && end.After(rr.StartDate) {
+			return false
+		}
+	}
+	return true
+}
+
+// withRoom fills in the room of the reservation. It must be called with the lock held
+func (m *memoryDBRepo) withRoom(r models.Reservation) models.Reservation {
+	r.Room = models.Room{ID: r.RoomId, RoomName: m.rooms[r.RoomId].RoomName}
+	return r
+}
+
+// CreateReservation re-checks availability of the room and inserts the reservation together
+// with its room restriction and notification mails in one transaction. It returns repository.ErrRoomNotAvailable
+// if the room has been taken for (some of) the dates in the meantime
+func (m *memoryDBRepo) CreateReservation(ctx context.Context, res models.Reservation, mails []models.MailData) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[res.RoomId]; !ok {
+		return 0, sql.ErrNoRows
+	}
+	if !m.isRoomAvailable(res.RoomId, res.StartDate, res.EndDate) {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	now := time.Now()
+	res.ID = m.nextID("reservations")
+	res.CreatedAt = now
+	res.UpdatedAt = now
+	res.Status = models.StatusPending
+	res.Room = models.Room{}
+	m.reservations[res.ID] = res
+	m.insertStatusChange(res.ID, "", models.StatusPending, 0)
+
+	rr := models.RoomRestriction{
+		ID:            m.nextID("room_restrictions"),
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomId,
+		ReservationID: res.ID,
+		RestrictionID: reservationRestrictionID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	m.roomRestrictions[rr.ID] = rr
+	for _, msg := range mails {
+		m.insertMail(msg, now)
+	}
+	return res.ID, nil
+}
+
+// SearchAvailabilityByDatesAndRoomID returns true if room is available for the called period of time
+// and false otherwise
+func (m *memoryDBRepo) SearchAvailabilityByDatesAndRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.isRoomAvailable(roomID, start, end), nil
+}
+
+// SearchAvailabilityForAllRooms returns a slice of available rooms for a range of dates,
+// if any
+func (m *memoryDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var result []models.Room
+	for _, room := range m.rooms {
+		if m.isRoomAvailable(room.ID, start, end) {
+			result = append(result, room)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+// GetRoomByID gets a room from DB by id
+func (m *memoryDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Room{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	room, ok := m.rooms[id]
+	if !ok {
+		return room, fmt.Errorf("room with id %d is not found in DB", id)
+	}
+	return room, nil
+}
+
+// GetRoomBySlug gets a room from DB by its slug. It returns repository.ErrRoomNotFound
+// if there is no such room
+func (m *memoryDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Room{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, room := range m.rooms {
+		if room.Slug == slug {
+			return room, nil
+		}
+	}
+	return models.Room{}, repository.ErrRoomNotFound
+}
+
+// GetUserById returns a user by id. It returns repository.ErrUserNotFound if there is no such user
+func (m *memoryDBRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return u, repository.ErrUserNotFound
+	}
+	return u, nil
+}
+
+// GetUserByEmail returns a user by email
+func (m *memoryDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return models.User{}, repository.ErrUserNotFound
+}
+
+// AllUsers returns all users ordered by name
+func (m *memoryDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := []models.User{}
+	for _, u := range m.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].LastName != users[j].LastName {
+			return users[i].LastName < users[j].LastName
+		}
+		if users[i].FirstName != users[j].FirstName {
+			return users[i].FirstName < users[j].FirstName
+		}
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+// emailTaken returns true if a user other than exceptID has the email. It must be called with the lock held
+func (m *memoryDBRepo) emailTaken(email string, exceptID int) bool {
+	for _, u := range m.users {
+		if u.Email == email && u.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertUser inserts a new user with the bcrypt hash of the password and returns the user's id. It returns
+// repository.ErrDuplicateEmail if another user already has the same email
+func (m *memoryDBRepo) InsertUser(ctx context.Context, u models.User, password string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(u.Email, 0) {
+		return 0, repository.ErrDuplicateEmail
+	}
+	u.ID = m.nextID("users")
+	u.Password = string(hashedPassword)
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = u.CreatedAt
+	m.users[u.ID] = u
+	return u.ID, m.audit(ctx, models.AuditCreate, models.AuditUser, u.ID, nil, userSnapshot(u))
+}
+
+// UpdateUser updates a user in the database, except for the password. It returns repository.ErrDuplicateEmail
+// if another user already has the same email and repository.ErrUserNotFound if there is no such user
+func (m *memoryDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.users[u.ID]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	if m.emailTaken(u.Email, u.ID) {
+		return repository.ErrDuplicateEmail
+	}
+	before := userSnapshot(old)
+	old.FirstName = u.FirstName
+	old.LastName = u.LastName
+	old.Email = u.Email
+	old.AccessLevel = u.AccessLevel
+	old.Active = u.Active
+	old.UpdatedAt = time.Now()
+	m.users[u.ID] = old
+	return m.audit(ctx, models.AuditUpdate, models.AuditUser, u.ID, before, userSnapshot(old))
+}
+
+// DeleteUser deletes a user. The status changes the user made stay in the history without the author
+func (m *memoryDBRepo) DeleteUser(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	delete(m.users, id)
+	delete(m.totpLastSteps, id)
+	delete(m.recoveryCodes, id)
+	for hID, h := range m.statusHistory {
+		if h.UserID == id {
+			h.UserID = 0
+			m.statusHistory[hID] = h
+		}
+	}
+	return m.audit(ctx, models.AuditDelete, models.AuditUser, id, userSnapshot(u), nil)
+}
+
+// ForcePasswordReset makes the user set a new password before logging in again
+func (m *memoryDBRepo) ForcePasswordReset(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	u.MustResetPassword = true
+	u.UpdatedAt = time.Now()
+	m.users[id] = u
+	return m.audit(ctx, models.AuditForcePasswordReset, models.AuditUser, id, nil, nil)
+}
+
+// UpdatePassword sets a new password of the user and lifts the forced password reset
+func (m *memoryDBRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	u.Password = string(hashedPassword)
+	u.MustResetPassword = false
+	u.UpdatedAt = time.Now()
+	m.users[id] = u
+	return m.audit(ctx, models.AuditChangePassword, models.AuditUser, id, nil, nil)
+}
+
+// Authenticate checks the password of the user with the email. It returns repository.ErrInvalidCredentials
+// if there is no such user or the password is wrong, repository.ErrAccountLocked while the user is locked
+// out, and repository.ErrUserInactive or repository.ErrPasswordResetRequired if the password is right,
+// but the user cannot log in
+func (m *memoryDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
+	m.mu.RLock()
+	var user *models.User
+	for _, u := range m.users {
+		if u.Email == email {
+			user = &u
+			break
+		}
+	}
+	m.mu.RUnlock()
+	if user == nil {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(testPassword))
+		return 0, "", repository.ErrInvalidCredentials
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(testPassword))
+	if user.Locked() {
+		return 0, "", repository.ErrAccountLocked
+	}
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", repository.ErrInvalidCredentials
+	} else if err != nil {
+		return 0, "", err
+	}
+	if !user.Active {
+		return 0, "", repository.ErrUserInactive
+	}
+	if user.MustResetPassword {
+		return 0, "", repository.ErrPasswordResetRequired
+	}
+
+	return user.ID, user.Password, nil
+}
+
+// RecordFailedLogin keeps the failed login for the audit. If the password or the one-time password of
+// an existing user was wrong, the user's failed logins are counted and the user is locked out for as long
+// as the policy says
+func (m *memoryDBRepo) RecordFailedLogin(ctx context.Context, a models.LoginAttempt, policy models.LoginPolicy) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a.ID = m.nextID("login_attempts")
+	m.loginAttempts[a.ID] = a
+	if a.Reason != models.LoginFailedCredentials && a.Reason != models.LoginFailedSecondStep {
+		return nil
+	}
+	for id, u := range m.users {
+		if u.Email == a.Email {
+			u.FailedLogins++
+			if wait := policy.Wait(u.FailedLogins); wait > 0 {
+				u.LockedUntil = a.CreatedAt.Add(wait)
+			}
+			m.users[id] = u
+			break
+		}
+	}
+	return nil
+}
+
+// LoginFailuresFromIP returns the number of failed logins from the IP address since the time and when
+// the last one happened
+func (m *memoryDBRepo) LoginFailuresFromIP(ctx context.Context, ip string, since time.Time) (int, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, time.Time{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var count int
+	var last time.Time
+	for _, a := range m.loginAttempts {
+		if a.IP == ip && a.CreatedAt.After(since) {
+			count++
+			if a.CreatedAt.After(last) {
+				last = a.CreatedAt
+			}
+		}
+	}
+	return count, last, nil
+}
+
+// UnlockUser forgets the failed logins of the user and lifts the lockout
+func (m *memoryDBRepo) UnlockUser(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	u.FailedLogins = 0
+	u.LockedUntil = time.Time{}
+	u.UpdatedAt = time.Now()
+	m.users[id] = u
+	return m.audit(ctx, models.AuditUnlock, models.AuditUser, id, nil, nil)
+}
+
+// EnableTwoFactor sets the TOTP secret of the user and replaces the recovery codes with the new ones
+func (m *memoryDBRepo) EnableTwoFactor(ctx context.Context, id int, secret string, recoveryCodeHashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	u.TwoFactorSecret = secret
+	u.UpdatedAt = time.Now()
+	m.users[id] = u
+	m.totpLastSteps[id] = 0
+	m.recoveryCodes[id] = map[string]bool{}
+	for _, hash := range recoveryCodeHashes {
+		m.recoveryCodes[id][hash] = false
+	}
+	return m.audit(ctx, models.AuditEnableTwoFactor, models.AuditUser, id, nil, nil)
+}
+
+// DisableTwoFactor removes the TOTP secret and the recovery codes of the user
+func (m *memoryDBRepo) DisableTwoFactor(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	u.TwoFactorSecret = ""
+	u.UpdatedAt = time.Now()
+	m.users[id] = u
+	delete(m.totpLastSteps, id)
+	delete(m.recoveryCodes, id)
+	return m.audit(ctx, models.AuditDisableTwoFactor, models.AuditUser, id, nil, nil)
+}
+
+// UseTOTPStep remembers the time step of the one-time password the user has logged in with. It returns
+// repository.ErrCodeAlreadyUsed if the step is not after the last one used, so a code works only once
+func (m *memoryDBRepo) UseTOTPStep(ctx context.Context, id int, step int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; !ok || m.totpLastSteps[id] >= step {
+		return repository.ErrCodeAlreadyUsed
+	}
+	m.totpLastSteps[id] = step
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code of the user as used. It returns repository.ErrInvalidRecoveryCode
+// if the user has no such unused code
+func (m *memoryDBRepo) UseRecoveryCode(ctx context.Context, id int, codeHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	used, ok := m.recoveryCodes[id][codeHash]
+	if !ok || used {
+		return repository.ErrInvalidRecoveryCode
+	}
+	m.recoveryCodes[id][codeHash] = true
+	return nil
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes of the user
+func (m *memoryDBRepo) RecoveryCodesLeft(ctx context.Context, id int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var count int
+	for _, used := range m.recoveryCodes[id] {
+		if !used {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// SearchReservations returns the page of the reservations selected by the filter together with
+// the number of reservations selected on all pages
+func (m *memoryDBRepo) SearchReservations(ctx context.Context, filter models.ReservationFilter) (models.ReservationPage, error) {
+	if err := ctx.Err(); err != nil {
+		return models.ReservationPage{}, err
+	}
+	page := models.ReservationPage{Filter: filter}
+	reservations := m.filterReservations(filter)
+	page.Total = len(reservations)
+	if offset := filter.Offset(); offset < len(reservations) {
+		reservations = reservations[offset:]
+	} else {
+		reservations = nil
+	}
+	if filter.PageSize > 0 && len(reservations) > filter.PageSize {
+		reservations = reservations[:filter.PageSize]
+	}
+	page.Reservations = reservations
+	return page, nil
+}
+
+// ExportReservations calls fn with each of the reservations selected by the filter, in its order and
+// ignoring paging. An error returned by fn stops the export and is returned
+func (m *memoryDBRepo) ExportReservations(ctx context.Context, filter models.ReservationFilter, fn func(models.Reservation) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, r := range m.filterReservations(filter) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterReservations returns all reservations selected by the filter, sorted by it
+func (m *memoryDBRepo) filterReservations(filter models.ReservationFilter) []models.Reservation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var reservations []models.Reservation
+	for _, r := range m.reservations {
+		if filter.Matches(r) {
+			reservations = append(reservations, m.withRoom(r))
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		a, b := reservations[i], reservations[j]
+		if filter.Desc {
+			a, b = b, a
+		}
+		if c := compareReservations(a, b, filter.Sort); c != 0 {
+			return c < 0
+		}
+		return a.ID < b.ID
+	})
+	return reservations
+}
+
+// compareReservations compares two reservations by the sort column, as the Postgres repository orders them
+func compareReservations(a, b models.Reservation, column string) int {
+	compareDates := func(x, y time.Time) int {
+		switch {
+		case x.Before(y):
+			return -1
+		case x.After(y):
+			return 1
+		}
+		return 0
+	}
+	switch column {
+	case models.SortByID:
+		return a.ID - b.ID
+	case models.SortByName:
+		if c := strings.Compare(a.LastName, b.LastName); c != 0 {
+			return c
+		}
+		return strings.Compare(a.FirstName, b.FirstName)
+	case models.SortByRoom:
+		if c := strings.Compare(a.Room.RoomName, b.Room.RoomName); c != 0 {
+			return c
+		}
+	case models.SortByDeparture:
+		return compareDates(a.EndDate, b.EndDate)
+	case models.SortByStatus:
+		if c := strings.Compare(string(a.Status), string(b.Status)); c != 0 {
+			return c
+		}
+	case models.SortByCreated:
+		return compareDates(a.CreatedAt, b.CreatedAt)
+	}
+	return compareDates(a.StartDate, b.StartDate)
+}
+
+// GetReservationByID gets reservation from the DB by ID
+func (m *memoryDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Reservation{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.reservations[id]
+	if !ok {
+		return r, sql.ErrNoRows
+	}
+	return m.withRoom(r), nil
+}
+
+// GetReservationByConfirmationCode returns the reservation a guest is looking for. Both the confirmation
+// code and the email must match, otherwise ErrReservationNotFound is returned
+func (m *memoryDBRepo) GetReservationByConfirmationCode(ctx context.Context, code, email string) (models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Reservation{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, r := range m.reservations {
+		if r.ConfirmationCode == code && strings.EqualFold(r.Email, email) {
+			return m.withRoom(r), nil
+		}
+	}
+	return models.Reservation{}, repository.ErrReservationNotFound
+}
+
+// GetReservationByReference returns the reservation an API client refers to by its reference token.
+// It returns ErrReservationNotFound if there is no such reservation
+func (m *memoryDBRepo) GetReservationByReference(ctx context.Context, reference string) (models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Reservation{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, r := range m.reservations {
+		if r.Reference != "" && r.Reference == reference {
+			return m.withRoom(r), nil
+		}
+	}
+	return models.Reservation{}, repository.ErrReservationNotFound
+}
+
+// UpdateReservation updates reservation in the database. If the dates or the room have changed,
+// availability is re-checked (ignoring the reservation's own restriction) and its room restriction
+// is moved as well
+func (m *memoryDBRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.reservations[r.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	before := reservationSnapshot(old)
+	now := time.Now()
+	stayChanged := !old.StartDate.Equal(r.StartDate) || !old.EndDate.Equal(r.EndDate) || old.RoomId != r.RoomId
+	if stayChanged {
+		if _, ok := m.rooms[r.RoomId]; !ok {
+			return sql.ErrNoRows
+		}
+		if !m.isRoomAvailableExcept(r.RoomId, r.StartDate, r.EndDate, r.ID) {
+			return repository.ErrRoomNotAvailable
+		}
+		for id, rr := range m.roomRestrictions {
+			if rr.ReservationID == r.ID {
+				rr.StartDate = r.StartDate
+				rr.EndDate = r.EndDate
+				rr.RoomID = r.RoomId
+				rr.UpdatedAt = now
+				m.roomRestrictions[id] = rr
+			}
+		}
+	}
+
+	old.FirstName = r.FirstName
+	old.LastName = r.LastName
+	old.Email = r.Email
+	old.Phone = r.Phone
+	old.StartDate = r.StartDate
+	old.EndDate = r.EndDate
+	old.RoomId = r.RoomId
+	old.UpdatedAt = now
+	m.reservations[r.ID] = old
+	return m.audit(ctx, models.AuditUpdate, models.AuditReservation, r.ID, before, reservationSnapshot(old))
+}
+
+// DeleteReservation deletes one reservation from the DB by id
+func (m *memoryDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.reservations[id]
+	if !ok {
+		return nil
+	}
+	delete(m.reservations, id)
+	// room restrictions and status history of the reservation are deleted by cascade
+	for rrID, rr := range m.roomRestrictions {
+		if rr.ReservationID == id {
+			delete(m.roomRestrictions, rrID)
+		}
+	}
+	for hID, h := range m.statusHistory {
+		if h.ReservationID == id {
+			delete(m.statusHistory, hID)
+		}
+	}
+	return m.audit(ctx, models.AuditDelete, models.AuditReservation, id, reservationSnapshot(r), nil)
+}
+
+// UpdateReservationStatus moves reservation to the new status if the lifecycle allows it and
+// records the change in the status history. Cancelled reservations release their room restrictions.
+// The mails are queued at once, so they are only sent if the status has changed
+func (m *memoryDBRepo) UpdateReservationStatus(ctx context.Context, id int, status models.ReservationStatus, userID int, mails ...models.MailData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.reservations[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if !r.Status.CanTransitionTo(status) {
+		return fmt.Errorf("%w: from %q to %q", repository.ErrInvalidStatusTransition, r.Status, status)
+	}
+
+	m.insertStatusChange(id, r.Status, status, userID)
+	before := auditStatus{r.Status}
+	r.Status = status
+	r.UpdatedAt = time.Now()
+	m.reservations[id] = r
+	for _, msg := range mails {
+		m.insertMail(msg, r.UpdatedAt)
+	}
+
+	if status == models.StatusCancelled {
+		for rrID, rr := range m.roomRestrictions {
+			if rr.ReservationID == id {
+				delete(m.roomRestrictions, rrID)
+			}
+		}
+	}
+	return m.audit(ctx, models.AuditChangeStatus, models.AuditReservation, id, before, auditStatus{status})
+}
+
+// insertStatusChange adds a record to reservation status history. It must be called with the lock held
+func (m *memoryDBRepo) insertStatusChange(reservationID int, from, to models.ReservationStatus, userID int) {
+	now := time.Now()
+	h := models.ReservationStatusChange{
+		ID:            m.nextID("reservation_status_history"),
+		ReservationID: reservationID,
+		FromStatus:    from,
+		ToStatus:      to,
+		UserID:        userID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	m.statusHistory[h.ID] = h
+}
+
+// GetReservationStatusHistory returns status changes of the reservation, oldest first
+func (m *memoryDBRepo) GetReservationStatusHistory(ctx context.Context, id int) ([]models.ReservationStatusChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var history []models.ReservationStatusChange
+	for _, h := range m.statusHistory {
+		if h.ReservationID == id {
+			if u, ok := m.users[h.UserID]; ok {
+				h.User = models.User{ID: u.ID, FirstName: u.FirstName, LastName: u.LastName}
+			}
+			history = append(history, h)
+		}
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].ID < history[j].ID })
+	return history, nil
+}
+
+// RecentStatusChanges returns the latest status changes of all reservations, newest first
+func (m *memoryDBRepo) RecentStatusChanges(ctx context.Context, limit int) ([]models.ReservationStatusChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var history []models.ReservationStatusChange
+	for _, h := range m.statusHistory {
+		if u, ok := m.users[h.UserID]; ok {
+			h.User = models.User{ID: u.ID, FirstName: u.FirstName, LastName: u.LastName}
+		}
+		h.Reservation = m.withRoom(m.reservations[h.ReservationID])
+		history = append(history, h)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].ID > history[j].ID })
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	return history, nil
+}
+
+// DashboardCounts counts the arrivals and departures of the day, the guests in house and the pending reservations
+func (m *memoryDBRepo) DashboardCounts(ctx context.Context, day time.Time) (models.DashboardCounts, error) {
+	if err := ctx.Err(); err != nil {
+		return models.DashboardCounts{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var c models.DashboardCounts
+	for _, r := range m.reservations {
+		expected := r.Status != models.StatusCancelled && r.Status != models.StatusNoShow
+		if expected && r.StartDate.Equal(day) {
+			c.Arrivals++
+		}
+		if expected && r.EndDate.Equal(day) {
+			c.Departures++
+		}
+		switch r.Status {
+		case models.StatusCheckedIn:
+			c.InHouse++
+		case models.StatusPending:
+			c.Unprocessed++
+		}
+	}
+	return c, nil
+}
+
+// Occupancy sums up the room nights booked and blocked from start up to, but not including, end
+func (m *memoryDBRepo) Occupancy(ctx context.Context, start, end time.Time) (models.Occupancy, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Occupancy{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	o := models.Occupancy{Nights: nights(start, end)}
+	o.RoomNights = len(m.rooms) * o.Nights
+	for _, rr := range m.roomRestrictions {
+		from, to := rr.StartDate, rr.EndDate
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if rr.ReservationID != 0 {
+			o.BookedNights += nights(from, to)
+		} else {
+			o.BlockedNights += nights(from, to)
+		}
+	}
+	return o, nil
+}
+
+// Report computes the figures of every room over every period from up to (not including) to. Nights are
+// counted day by day, so a stay spanning two periods counts in both
+func (m *memoryDBRepo) Report(ctx context.Context, from, to time.Time, grouping models.ReportGrouping) (models.Report, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Report{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	report := models.Report{Grouping: grouping, From: from, To: to}
+	var roomIDs []int
+	for id := range m.rooms {
+		roomIDs = append(roomIDs, id)
+	}
+	sort.Ints(roomIDs)
+
+	type key struct {
+		period int64
+		roomID int
+	}
+	index := map[key]int{}
+	row := func(day time.Time, roomID int) *models.ReportRow {
+		period := grouping.PeriodStart(day)
+		k := key{period.Unix(), roomID}
+		i, ok := index[k]
+		if !ok {
+			i = len(report.Rows)
+			index[k] = i
+			report.Rows = append(report.Rows, models.ReportRow{Period: period, RoomID: roomID, RoomName: m.rooms[roomID].RoomName})
+		}
+		return &report.Rows[i]
+	}
+	covers := func(start, end, night time.Time) bool { return !start.After(night) && end.After(night) }
+	for night := from; night.Before(to); night = night.AddDate(0, 0, 1) {
+		for _, roomID := range roomIDs {
+			r := row(night, roomID)
+			r.RoomNights++
+			for _, rr := range m.roomRestrictions {
+				if rr.RoomID == roomID && rr.ReservationID == 0 && covers(rr.StartDate, rr.EndDate, night) {
+					r.BlockedNights++
+				}
+			}
+			for _, res := range m.reservations {
+				if res.RoomId == roomID && res.Status != models.StatusCancelled && res.Status != models.StatusNoShow &&
+					covers(res.StartDate, res.EndDate, night) {
+					r.NightsSold++
+				}
+			}
+		}
+	}
+
+	for _, res := range m.reservations {
+		if res.StartDate.Before(from) || !res.StartDate.Before(to) {
+			continue
+		}
+		r := row(res.StartDate, res.RoomId)
+		r.Bookings++
+		switch res.Status {
+		case models.StatusCancelled:
+			r.Cancelled++
+		case models.StatusNoShow:
+			r.NoShows++
+		default:
+			year, month, day := res.CreatedAt.Date()
+			booked := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+			r.StayNights += nights(res.StartDate, res.EndDate)
+			if !booked.After(res.StartDate) {
+				r.LeadStays++
+				r.LeadDays += nights(booked, res.StartDate)
+			}
+		}
+	}
+	return report, nil
+}
+
+// AllRooms returns all rooms from the database
+func (m *memoryDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rooms := []models.Room{}
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+	return rooms, nil
+}
+
+// slugTaken returns true if a room other than exceptID has the slug. It must be called with the lock held
+func (m *memoryDBRepo) slugTaken(slug string, exceptID int) bool {
+	for _, room := range m.rooms {
+		if room.Slug == slug && room.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertRoom inserts a new room into the database and returns its id. It returns
+// repository.ErrDuplicateRoomSlug if another room already has the same slug
+func (m *memoryDBRepo) InsertRoom(ctx context.Context, r models.Room) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.slugTaken(r.Slug, 0) {
+		return 0, repository.ErrDuplicateRoomSlug
+	}
+	r.ID = m.nextID("rooms")
+	r.Amenities = append([]string(nil), r.Amenities...)
+	r.Photos = append([]string(nil), r.Photos...)
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = r.CreatedAt
+	m.rooms[r.ID] = r
+	return r.ID, m.audit(ctx, models.AuditCreate, models.AuditRoom, r.ID, nil, roomSnapshot(r))
+}
+
+// UpdateRoom updates a room in the database. It returns repository.ErrDuplicateRoomSlug
+// if another room already has the same slug
+func (m *memoryDBRepo) UpdateRoom(ctx context.Context, r models.Room) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.rooms[r.ID]
+	if !ok {
+		return repository.ErrRoomNotFound
+	}
+	if m.slugTaken(r.Slug, r.ID) {
+		return repository.ErrDuplicateRoomSlug
+	}
+	r.Amenities = append([]string(nil), r.Amenities...)
+	r.Photos = append([]string(nil), r.Photos...)
+	r.CreatedAt = old.CreatedAt
+	r.UpdatedAt = time.Now()
+	m.rooms[r.ID] = r
+	return m.audit(ctx, models.AuditUpdate, models.AuditRoom, r.ID, roomSnapshot(old), roomSnapshot(r))
+}
+
+// DeleteRoom deletes a room from the database together with its owner's blocks. Rooms that have
+// reservations are not deleted and repository.ErrRoomInUse is returned
+func (m *memoryDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.rooms[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	for _, r := range m.reservations {
+		if r.RoomId == id {
+			return repository.ErrRoomInUse
+		}
+	}
+	delete(m.rooms, id)
+	for rrID, rr := range m.roomRestrictions {
+		if rr.RoomID == id {
+			delete(m.roomRestrictions, rrID)
+		}
+	}
+	return m.audit(ctx, models.AuditDelete, models.AuditRoom, id, roomSnapshot(room), nil)
+}
+
+// GetRestrictionsForRoomByDates returns restrictions for a room by room id and dates range
+func (m *memoryDBRepo) GetRestrictionsForRoomByDates(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var restrictions []models.RoomRestriction
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == roomID && !rr.StartDate.After(end) && !rr.EndDate.Before(start) {
+			rr.Restriction = m.restrictions[rr.RestrictionID]
+			restrictions = append(restrictions, rr)
+		}
+	}
+	sort.Slice(restrictions, func(i, j int) bool { return restrictions[i].StartDate.Before(restrictions[j].StartDate) })
+	return restrictions, nil
+}
+
+// BlockRestrictions returns restriction types rooms can be blocked with, i.e. all but reservation
+func (m *memoryDBRepo) BlockRestrictions(ctx context.Context) ([]models.Restriction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var restrictions []models.Restriction
+	for _, r := range m.restrictions {
+		if r.ID != reservationRestrictionID {
+			restrictions = append(restrictions, r)
+		}
+	}
+	sort.Slice(restrictions, func(i, j int) bool { return restrictions[i].ID < restrictions[j].ID })
+	return restrictions, nil
+}
+
+// InsertBlocks adds blocks to DB at once. Blocks, like reservations, cover the nights
+// from StartDate up to (not including) EndDate. If any of the rooms is already reserved or blocked
+// for some of the dates, nothing is inserted and repository.ErrRoomNotAvailable is returned
+func (m *memoryDBRepo) InsertBlocks(ctx context.Context, blocks []models.RoomRestriction) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, b := range blocks {
+		if _, ok := m.rooms[b.RoomID]; !ok {
+			return sql.ErrNoRows
+		}
+		if !m.isRoomAvailable(b.RoomID, b.StartDate, b.EndDate) {
+			return repository.ErrRoomNotAvailable
+		}
+		// blocks being inserted must not overlap each other either
+		for _, other := range blocks[:i] {
+			if other.RoomID == b.RoomID && b.StartDate.Before(other.EndDate) && b.EndDate.After(other.StartDate) {
+				return repository.ErrRoomNotAvailable
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, b := range blocks {
+		rr := m.insertBlock(b.RoomID, b.StartDate, b.EndDate, b.RestrictionID, b.Note, now)
+		if err := m.audit(ctx, models.AuditCreate, models.AuditBlock, rr.ID, nil, blockSnapshot(rr)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportBookings inserts the reservations, in their own statuses, and the blocks at once, so that either
+// all or none of them are. Reservations are inserted without mails to the guests. If any of them overlap
+// each other or what the rooms are already taken for, repository.ErrRoomNotAvailable is returned.
+// Reservations keep their CreatedAt, the day they were booked, if it is set
+func (m *memoryDBRepo) ImportBookings(ctx context.Context, reservations []models.Reservation, blocks []models.RoomRestriction, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the nights each row takes, to check them against the rooms and each other before inserting anything
+	type stay struct {
+		roomID     int
+		start, end time.Time
+	}
+	var stays []stay
+	for _, r := range reservations {
+		if _, ok := m.rooms[r.RoomId]; !ok {
+			return sql.ErrNoRows
+		}
+		if r.Status != models.StatusCancelled {
+			stays = append(stays, stay{r.RoomId, r.StartDate, r.EndDate})
+		}
+	}
+	for _, b := range blocks {
+		if _, ok := m.rooms[b.RoomID]; !ok {
+			return sql.ErrNoRows
+		}
+		stays = append(stays, stay{b.RoomID, b.StartDate, b.EndDate})
+	}
+	for i, s := range stays {
+		if !m.isRoomAvailable(s.roomID, s.start, s.end) {
+			return repository.ErrRoomNotAvailable
+		}
+		for _, other := range stays[:i] {
+			if other.roomID == s.roomID && s.start.Before(other.end) && s.end.After(other.start) {
+				return repository.ErrRoomNotAvailable
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, res := range reservations {
+		res.ID = m.nextID("reservations")
+		if res.CreatedAt.IsZero() {
+			res.CreatedAt = now
+		}
+		res.UpdatedAt = now
+		res.Room = models.Room{}
+		m.reservations[res.ID] = res
+		m.insertStatusChange(res.ID, "", res.Status, userID)
+		if res.Status != models.StatusCancelled {
+			rr := models.RoomRestriction{
+				ID:            m.nextID("room_restrictions"),
+				StartDate:     res.StartDate,
+				EndDate:       res.EndDate,
+				RoomID:        res.RoomId,
+				ReservationID: res.ID,
+				RestrictionID: reservationRestrictionID,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			}
+			m.roomRestrictions[rr.ID] = rr
+		}
+		if err := m.audit(ctx, models.AuditCreate, models.AuditReservation, res.ID, nil, reservationSnapshot(res)); err != nil {
+			return err
+		}
+	}
+	for _, b := range blocks {
+		rr := m.insertBlock(b.RoomID, b.StartDate, b.EndDate, b.RestrictionID, b.Note, now)
+		if err := m.audit(ctx, models.AuditCreate, models.AuditBlock, rr.ID, nil, blockSnapshot(rr)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertBlock adds one block and returns it. It must be called with the lock held
+func (m *memoryDBRepo) insertBlock(roomID int, start, end time.Time, restrictionID int, note string, now time.Time) models.RoomRestriction {
+	rr := models.RoomRestriction{
+		ID:            m.nextID("room_restrictions"),
+		StartDate:     start,
+		EndDate:       end,
+		RoomID:        roomID,
+		RestrictionID: restrictionID,
+		Note:          note,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	m.roomRestrictions[rr.ID] = rr
+	return rr
+}
+
+// RemoveBlocks frees the rooms from blocks for the nights from start up to (not including) end,
+// all at once. Blocks lying within the range are deleted, blocks crossing its boundaries are shortened
+// and a block covering the whole range is split in two. A legacy block ending on the day it starts
+// covers that one night
+func (m *memoryDBRepo) RemoveBlocks(ctx context.Context, roomIDs []int, start, end time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, roomID := range roomIDs {
+		if err := m.removeBlocks(ctx, roomID, start, end, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeBlocks frees the room from blocks for the range of nights and audits every block it deletes,
+// shortens or splits. It must be called with the lock held
+func (m *memoryDBRepo) removeBlocks(ctx context.Context, roomID int, start, end, now time.Time) error {
+	for id, b := range m.roomRestrictions {
+		last := b.EndDate
+		if !last.After(b.StartDate) {
+			last = b.StartDate.AddDate(0, 0, 1)
+		}
+		if b.RoomID != roomID || b.ReservationID != 0 || !start.Before(last) || !end.After(b.StartDate) {
+			continue
+		}
+		before := blockSnapshot(b)
+		switch {
+		case !b.StartDate.Before(start) && !b.EndDate.After(end):
+			delete(m.roomRestrictions, id)
+			if err := m.audit(ctx, models.AuditDelete, models.AuditBlock, id, before, nil); err != nil {
+				return err
+			}
+			continue
+		case b.StartDate.Before(start) && b.EndDate.After(end):
+			rest := m.insertBlock(roomID, end, b.EndDate, b.RestrictionID, b.Note, now)
+			if err := m.audit(ctx, models.AuditCreate, models.AuditBlock, rest.ID, nil, blockSnapshot(rest)); err != nil {
+				return err
+			}
+			b.EndDate = start
+		case b.StartDate.Before(start):
+			b.EndDate = start
+		default:
+			b.StartDate = end
+		}
+		b.UpdatedAt = now
+		m.roomRestrictions[id] = b
+		if err := m.audit(ctx, models.AuditUpdate, models.AuditBlock, id, before, blockSnapshot(b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteBlockByID removes block (room restriction) from the DB by ID
+func (m *memoryDBRepo) DeleteBlockByID(ctx context.Context, restrictionID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rr, ok := m.roomRestrictions[restrictionID]
+	if !ok || rr.ReservationID != 0 {
+		return nil
+	}
+	delete(m.roomRestrictions, restrictionID)
+	return m.audit(ctx, models.AuditDelete, models.AuditBlock, restrictionID, blockSnapshot(rr), nil)
+}
+
+// insertMail adds the message to the mail outbox. It must be called with the lock held
+func (m *memoryDBRepo) insertMail(msg models.MailData, now time.Time) {
+	id := m.nextID("mail_outbox")
+	m.mail[id] = models.OutboxMessage{
+		ID:            id,
+		MailData:      msg,
+		Status:        models.MailQueued,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// QueueMail adds the messages to the mail outbox for delivery
+func (m *memoryDBRepo) QueueMail(ctx context.Context, mails ...models.MailData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, msg := range mails {
+		m.insertMail(msg, now)
+	}
+	return nil
+}
+
+// ClaimMail picks up to limit queued messages that are due for delivery. Claimed messages count one more
+// attempt and are not due again until the lease expires
+func (m *memoryDBRepo) ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var due []models.OutboxMessage
+	for _, msg := range m.mail {
+		if msg.Status == models.MailQueued && !msg.NextAttemptAt.After(now) {
+			due = append(due, msg)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].ID < due[j].ID
+		}
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		due[i].Attempts++
+		due[i].NextAttemptAt = now.Add(lease)
+		due[i].UpdatedAt = now
+		m.mail[due[i].ID] = due[i]
+	}
+	return due, nil
+}
+
+// MarkMailSent records the successful delivery of the message
+func (m *memoryDBRepo) MarkMailSent(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if msg, ok := m.mail[id]; ok {
+		msg.Status = models.MailSent
+		msg.LastError = ""
+		msg.SentAt = time.Now()
+		msg.UpdatedAt = msg.SentAt
+		m.mail[id] = msg
+	}
+	return nil
+}
+
+// RescheduleMail records the failed delivery attempt and schedules the next one
+func (m *memoryDBRepo) RescheduleMail(ctx context.Context, id int, lastError string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if msg, ok := m.mail[id]; ok {
+		msg.LastError = lastError
+		msg.NextAttemptAt = at
+		msg.UpdatedAt = time.Now()
+		m.mail[id] = msg
+	}
+	return nil
+}
+
+// MarkMailFailed records the failed delivery attempt and gives up on the message
+func (m *memoryDBRepo) MarkMailFailed(ctx context.Context, id int, lastError string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if msg, ok := m.mail[id]; ok {
+		msg.Status = models.MailFailed
+		msg.LastError = lastError
+		msg.UpdatedAt = time.Now()
+		m.mail[id] = msg
+	}
+	return nil
+}
+
+// PendingMail returns queued and failed messages of the mail outbox, newest first
+func (m *memoryDBRepo) PendingMail(ctx context.Context) ([]models.OutboxMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var messages []models.OutboxMessage
+	for _, msg := range m.mail {
+		if msg.Status != models.MailSent {
+			messages = append(messages, msg)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID > messages[j].ID })
+	return messages, nil
+}
+
+// ResendMail queues the unsent message for immediate delivery with a fresh number of attempts.
+// It returns ErrMailNotFound if there is no such message or it has already been sent
+func (m *memoryDBRepo) ResendMail(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.mail[id]
+	if !ok || msg.Status == models.MailSent {
+		return repository.ErrMailNotFound
+	}
+	msg.Status = models.MailQueued
+	msg.Attempts = 0
+	msg.NextAttemptAt = time.Now()
+	msg.UpdatedAt = msg.NextAttemptAt
+	m.mail[id] = msg
+	return m.audit(ctx, models.AuditResend, models.AuditMail, id, nil, nil)
+}
+
+// audit appends the audit log entry of a change made by the actor of ctx. It must be called with the lock held
+func (m *memoryDBRepo) audit(ctx context.Context, action, entity string, entityID int, before, after any) error {
+	e, ok, err := newAuditEntry(ctx, action, entity, entityID, before, after)
+	if err != nil || !ok {
+		return err
+	}
+	e.ID = m.nextID("audit_log")
+	m.auditLog = append(m.auditLog, e)
+	return nil
+}
+
+// AuditLog returns the audit log entries selected by the filter, newest first
+func (m *memoryDBRepo) AuditLog(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []models.AuditEntry
+	for i := len(m.auditLog) - 1; i >= 0 && (filter.Limit == 0 || len(entries) < filter.Limit); i-- {
+		e := m.auditLog[i]
+		if !filter.Matches(e) {
+			continue
+		}
+		if u, ok := m.users[e.Actor.UserID]; ok {
+			e.User = u
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,794 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/config"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository"
+)
+
+func date(day int) time.Time {
+	return time.Date(2060, 1, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestMemoryRepo_CreateReservation(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+
+	id, err := repo.CreateReservation(ctx, models.Reservation{RoomId: 1, StartDate: date(10), EndDate: date(15)}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating reservation: %q", err)
+	}
+
+	tests := []struct {
+		name          string
+		roomID        int
+		start         int
+		end           int
+		expectedError error
+	}{
+		{"overlaps-start", 1, 8, 11, repository.ErrRoomNotAvailable},
+		{"overlaps-end", 1, 14, 20, repository.ErrRoomNotAvailable},
+		{"inside", 1, 11, 12, repository.ErrRoomNotAvailable},
+		{"check-in-on-check-out-day", 1, 15, 17, nil},
+		{"check-out-on-check-in-day", 1, 5, 10, nil},
+		{"other-room", 2, 10, 15, nil},
+	}
+	for _, e := range tests {
+		_, err := repo.CreateReservation(ctx, models.Reservation{RoomId: e.roomID, StartDate: date(e.start), EndDate: date(e.end)}, nil)
+		if !errors.Is(err, e.expectedError) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedError, err)
+		}
+	}
+
+	restrictions, _ := repo.GetRestrictionsForRoomByDates(ctx, 1, date(12), date(12))
+	if len(restrictions) != 1 || restrictions[0].ReservationID != id {
+		t.Errorf("expected the room restriction of reservation %d but got %v", id, restrictions)
+	}
+
+	_, err = repo.CreateReservation(ctx, models.Reservation{RoomId: 100, StartDate: date(1), EndDate: date(2)}, nil)
+	if err == nil {
+		t.Error("reservation of non-existent room was created without an error")
+	}
+}
+
+func TestMemoryRepo_SearchAvailability(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	_, _ = repo.CreateReservation(ctx, models.Reservation{RoomId: 1, StartDate: date(10), EndDate: date(15)}, nil)
+	_ = repo.InsertBlocks(ctx, []models.RoomRestriction{{RoomID: 2, StartDate: date(12), EndDate: date(13), RestrictionID: 2}})
+
+	tests := []struct {
+		name          string
+		start         int
+		end           int
+		expectedRooms []int
+	}{
+		{"before", 1, 5, []int{1, 2}},
+		{"reservation-and-block", 11, 13, []int{}},
+		{"reservation-only", 10, 12, []int{2}},
+		{"after-check-out", 15, 16, []int{1, 2}},
+	}
+	for _, e := range tests {
+		rooms, err := repo.SearchAvailabilityForAllRooms(ctx, date(e.start), date(e.end))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %q", e.name, err)
+		}
+		if len(rooms) != len(e.expectedRooms) {
+			t.Errorf("%s: expected rooms %v but got %v", e.name, e.expectedRooms, rooms)
+			continue
+		}
+		for i, room := range rooms {
+			if room.ID != e.expectedRooms[i] {
+				t.Errorf("%s: expected rooms %v but got %v", e.name, e.expectedRooms, rooms)
+			}
+			available, _ := repo.SearchAvailabilityByDatesAndRoomID(ctx, date(e.start), date(e.end), room.ID)
+			if !available {
+				t.Errorf("%s: room %d is expected to be available", e.name, room.ID)
+			}
+		}
+	}
+}
+
+func TestMemoryRepo_DeleteReservation(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	id, _ := repo.CreateReservation(ctx, models.Reservation{RoomId: 1, StartDate: date(10), EndDate: date(15)}, nil)
+
+	if err := repo.DeleteReservation(ctx, id); err != nil {
+		t.Fatalf("unexpected error deleting reservation: %q", err)
+	}
+	if _, err := repo.GetReservationByID(ctx, id); err == nil {
+		t.Error("deleted reservation is still found")
+	}
+	available, _ := repo.SearchAvailabilityByDatesAndRoomID(ctx, date(10), date(15), 1)
+	if !available {
+		t.Error("room restriction of deleted reservation has not been deleted")
+	}
+}
+
+func TestMemoryRepo_Authenticate(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+
+	if id, _, err := repo.Authenticate(ctx, "me@here.ca", "password"); err != nil || id != 1 {
+		t.Errorf("expected seeded administrator to log in, but got id %d and error %v", id, err)
+	}
+	if _, _, err := repo.Authenticate(ctx, "me@here.ca", "wrong"); err == nil {
+		t.Error("logged in with a wrong password")
+	}
+	if _, _, err := repo.Authenticate(ctx, "nobody@here.ca", "password"); err == nil {
+		t.Error("logged in with a non-existent email")
+	}
+}
+
+func TestMemoryRepo_Users(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	user := models.User{FirstName: "Jane", LastName: "Doe", Email: "jane@here.ca", AccessLevel: int(models.RoleFrontDesk), Active: true}
+
+	id, err := repo.InsertUser(ctx, user, "secret-password")
+	if err != nil {
+		t.Fatalf("unexpected error inserting user: %q", err)
+	}
+	if _, _, err := repo.Authenticate(ctx, "jane@here.ca", "secret-password"); err != nil {
+		t.Errorf("new user cannot log in: %q", err)
+	}
+	if _, err := repo.InsertUser(ctx, user, "secret-password"); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected %v but got %v", repository.ErrDuplicateEmail, err)
+	}
+	user.ID = id
+	user.Email = "me@here.ca"
+	if err := repo.UpdateUser(ctx, user); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected %v but got %v", repository.ErrDuplicateEmail, err)
+	}
+	if users, _ := repo.AllUsers(ctx); len(users) != 2 || users[1].ID != id {
+		t.Errorf("expected users ordered by last name, but got %+v", users)
+	}
+
+	user.Email = "jane@here.ca"
+	user.Active = false
+	if err := repo.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("unexpected error updating user: %q", err)
+	}
+	if _, _, err := repo.Authenticate(ctx, "jane@here.ca", "secret-password"); !errors.Is(err, repository.ErrUserInactive) {
+		t.Errorf("expected %v but got %v", repository.ErrUserInactive, err)
+	}
+	if err := repo.ForcePasswordReset(ctx, 1); err != nil {
+		t.Fatalf("unexpected error forcing password reset: %q", err)
+	}
+	if _, _, err := repo.Authenticate(ctx, "me@here.ca", "password"); !errors.Is(err, repository.ErrPasswordResetRequired) {
+		t.Errorf("expected %v but got %v", repository.ErrPasswordResetRequired, err)
+	}
+	if err := repo.UpdatePassword(ctx, 1, "Correct-Horse"); err != nil {
+		t.Fatalf("unexpected error updating password: %q", err)
+	}
+	if _, _, err := repo.Authenticate(ctx, "me@here.ca", "password"); !errors.Is(err, repository.ErrInvalidCredentials) {
+		t.Errorf("expected %v but got %v", repository.ErrInvalidCredentials, err)
+	}
+	if id, _, err := repo.Authenticate(ctx, "me@here.ca", "Correct-Horse"); err != nil || id != 1 {
+		t.Errorf("cannot log in with the new password: %v", err)
+	}
+	if u, err := repo.GetUserByEmail(ctx, "me@here.ca"); err != nil || u.ID != 1 || u.MustResetPassword {
+		t.Errorf("unexpected user %+v and error %v", u, err)
+	}
+
+	if err := repo.DeleteUser(ctx, id); err != nil {
+		t.Fatalf("unexpected error deleting user: %q", err)
+	}
+	if _, err := repo.GetUserById(ctx, id); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("expected %v but got %v", repository.ErrUserNotFound, err)
+	}
+	if err := repo.DeleteUser(ctx, id); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("expected %v but got %v", repository.ErrUserNotFound, err)
+	}
+}
+
+func TestMemoryRepo_LoginLockout(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	policy := models.LoginPolicy{FreeAttempts: 1, LockoutAttempts: 3, Lockout: 15 * time.Minute}
+	now := time.Now()
+
+	failed := models.LoginAttempt{Email: "me@here.ca", IP: "198.51.100.7", Reason: models.LoginFailedCredentials, CreatedAt: now}
+	if err := repo.RecordFailedLogin(ctx, failed, policy); err != nil {
+		t.Fatalf("unexpected error recording failed login: %q", err)
+	}
+	if _, _, err := repo.Authenticate(ctx, "me@here.ca", "password"); err != nil {
+		t.Errorf("locked out before using the free attempts: %v", err)
+	}
+	unknown := models.LoginAttempt{Email: "nobody@here.ca", IP: "198.51.100.7", Reason: models.LoginFailedCredentials, CreatedAt: now}
+	for _, a := range []models.LoginAttempt{unknown, failed, failed} {
+		if err := repo.RecordFailedLogin(ctx, a, policy); err != nil {
+			t.Fatalf("unexpected error recording failed login: %q", err)
+		}
+	}
+	// the right password does not help while the account is locked
+	if _, _, err := repo.Authenticate(ctx, "me@here.ca", "password"); !errors.Is(err, repository.ErrAccountLocked) {
+		t.Errorf("expected %v but got %v", repository.ErrAccountLocked, err)
+	}
+	if u, _ := repo.GetUserById(ctx, 1); u.FailedLogins != 3 || !u.LockedUntil.Equal(now.Add(policy.Lockout)) {
+		t.Errorf("expected 3 failed logins and lockout until %s, but got %d and %s", now.Add(policy.Lockout), u.FailedLogins, u.LockedUntil)
+	}
+	if count, last, err := repo.LoginFailuresFromIP(ctx, "198.51.100.7", now.Add(-time.Minute)); err != nil || count != 4 || !last.Equal(now) {
+		t.Errorf("expected 4 failures from the IP address, the last at %s, but got %d, %s and %v", now, count, last, err)
+	}
+
+	if err := repo.UnlockUser(ctx, 1); err != nil {
+		t.Fatalf("unexpected error unlocking user: %q", err)
+	}
+	if _, _, err := repo.Authenticate(ctx, "me@here.ca", "password"); err != nil {
+		t.Errorf("cannot log in after unlocking: %v", err)
+	}
+}
+
+func TestMemoryRepo_TwoFactor(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+
+	if err := repo.EnableTwoFactor(ctx, 1, "JBSWY3DPEHPK3PXP", []string{"hash1", "hash2"}); err != nil {
+		t.Fatalf("unexpected error enabling two-factor authentication: %q", err)
+	}
+	if u, _ := repo.GetUserById(ctx, 1); !u.TwoFactorEnabled() {
+		t.Error("two-factor authentication is not enabled")
+	}
+	if err := repo.UseTOTPStep(ctx, 1, 100); err != nil {
+		t.Errorf("unexpected error using step: %q", err)
+	}
+	for _, step := range []int64{99, 100} {
+		if err := repo.UseTOTPStep(ctx, 1, step); !errors.Is(err, repository.ErrCodeAlreadyUsed) {
+			t.Errorf("step %d: expected %v but got %v", step, repository.ErrCodeAlreadyUsed, err)
+		}
+	}
+	if err := repo.UseRecoveryCode(ctx, 1, "hash1"); err != nil {
+		t.Errorf("unexpected error using recovery code: %q", err)
+	}
+	for _, hash := range []string{"hash1", "unknown"} {
+		if err := repo.UseRecoveryCode(ctx, 1, hash); !errors.Is(err, repository.ErrInvalidRecoveryCode) {
+			t.Errorf("%s: expected %v but got %v", hash, repository.ErrInvalidRecoveryCode, err)
+		}
+	}
+	if left, err := repo.RecoveryCodesLeft(ctx, 1); err != nil || left != 1 {
+		t.Errorf("expected 1 recovery code left, but got %d and %v", left, err)
+	}
+
+	if err := repo.DisableTwoFactor(ctx, 1); err != nil {
+		t.Fatalf("unexpected error disabling two-factor authentication: %q", err)
+	}
+	if u, _ := repo.GetUserById(ctx, 1); u.TwoFactorEnabled() {
+		t.Error("two-factor authentication is still enabled")
+	}
+	if left, _ := repo.RecoveryCodesLeft(ctx, 1); left != 0 {
+		t.Errorf("expected the recovery codes to be removed, but %d are left", left)
+	}
+	if err := repo.EnableTwoFactor(ctx, 404, "JBSWY3DPEHPK3PXP", nil); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("expected %v but got %v", repository.ErrUserNotFound, err)
+	}
+}
+
+func TestMemoryRepo_CancelledContext(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := repo.AllRooms(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v but got %v", context.Canceled, err)
+	}
+}
+
+func TestMemoryRepo_UpdateReservationStatus(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	id, _ := repo.CreateReservation(ctx, models.Reservation{RoomId: 1, StartDate: date(10), EndDate: date(15)}, nil)
+
+	if err := repo.UpdateReservationStatus(ctx, id, models.StatusCheckedOut, 1); !errors.Is(err, repository.ErrInvalidStatusTransition) {
+		t.Errorf("expected %v but got %v", repository.ErrInvalidStatusTransition, err)
+	}
+	if err := repo.UpdateReservationStatus(ctx, id, models.StatusConfirmed, 1); err != nil {
+		t.Fatalf("unexpected error confirming reservation: %q", err)
+	}
+	// mails are queued with the change, and not if it fails
+	_ = repo.UpdateReservationStatus(ctx, id, models.StatusCheckedOut, 0, models.MailData{To: "nobody@here.ca"})
+	if err := repo.UpdateReservationStatus(ctx, id, models.StatusCancelled, 0, models.MailData{To: "guest@here.ca"}); err != nil {
+		t.Fatalf("unexpected error cancelling reservation: %q", err)
+	}
+	if pending, _ := repo.PendingMail(ctx); len(pending) != 1 || pending[0].To != "guest@here.ca" {
+		t.Errorf("expected the mail of the cancellation to be queued but got %+v", pending)
+	}
+
+	available, _ := repo.SearchAvailabilityByDatesAndRoomID(ctx, date(10), date(15), 1)
+	if !available {
+		t.Error("cancelled reservation has not released its room restriction")
+	}
+
+	history, _ := repo.GetReservationStatusHistory(ctx, id)
+	expected := []models.ReservationStatus{models.StatusPending, models.StatusConfirmed, models.StatusCancelled}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d status changes but got %d", len(expected), len(history))
+	}
+	for i, h := range history {
+		if h.ToStatus != expected[i] {
+			t.Errorf("status change %d: expected %q but got %q", i, expected[i], h.ToStatus)
+		}
+	}
+	if history[1].UserID != 1 || history[1].User.FirstName != "Admin" {
+		t.Errorf("expected status change made by the administrator but got %v", history[1].User)
+	}
+}
+
+func TestMemoryRepo_GetReservationByConfirmationCode(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	id, _ := repo.CreateReservation(ctx, models.Reservation{ConfirmationCode: "ABCDE12345", Email: "John@Smith.com",
+		RoomId: 1, StartDate: date(10), EndDate: date(15)}, nil)
+
+	res, err := repo.GetReservationByConfirmationCode(ctx, "ABCDE12345", "john@smith.com")
+	if err != nil || res.ID != id {
+		t.Errorf("expected reservation %d but got %d and error %v", id, res.ID, err)
+	}
+	if _, err := repo.GetReservationByConfirmationCode(ctx, "ABCDE12345", "jane@smith.com"); !errors.Is(err, repository.ErrReservationNotFound) {
+		t.Errorf("expected %v for a wrong email but got %v", repository.ErrReservationNotFound, err)
+	}
+	if _, err := repo.GetReservationByConfirmationCode(ctx, "ZZZZZ12345", "john@smith.com"); !errors.Is(err, repository.ErrReservationNotFound) {
+		t.Errorf("expected %v for a wrong code but got %v", repository.ErrReservationNotFound, err)
+	}
+}
+
+func TestMemoryRepo_UpdateReservation(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	id, _ := repo.CreateReservation(ctx, models.Reservation{RoomId: 1, StartDate: date(10), EndDate: date(15)}, nil)
+	_, _ = repo.CreateReservation(ctx, models.Reservation{RoomId: 1, StartDate: date(20), EndDate: date(25)}, nil)
+
+	tests := []struct {
+		name          string
+		roomID        int
+		start         int
+		end           int
+		expectedError error
+	}{
+		{"overlaps-own-stay", 1, 12, 17, nil},
+		{"overlaps-other-reservation", 1, 18, 21, repository.ErrRoomNotAvailable},
+		{"other-room", 2, 20, 25, nil},
+	}
+	for _, e := range tests {
+		res, _ := repo.GetReservationByID(ctx, id)
+		res.RoomId, res.StartDate, res.EndDate = e.roomID, date(e.start), date(e.end)
+		if err := repo.UpdateReservation(ctx, res); !errors.Is(err, e.expectedError) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedError, err)
+		}
+	}
+
+	restrictions, _ := repo.GetRestrictionsForRoomByDates(ctx, 2, date(1), date(31))
+	if len(restrictions) != 1 || restrictions[0].ReservationID != id || !restrictions[0].StartDate.Equal(date(20)) {
+		t.Errorf("expected the room restriction to be moved with the reservation but got %v", restrictions)
+	}
+	if available, _ := repo.SearchAvailabilityByDatesAndRoomID(ctx, date(10), date(17), 1); !available {
+		t.Error("room restriction of the former stay has not been released")
+	}
+}
+
+func TestMemoryRepo_UpdateRoom(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	rooms, _ := repo.AllRooms(ctx)
+
+	room := rooms[0]
+	room.RoomName = "Colonel's Cabin"
+	if err := repo.UpdateRoom(ctx, room); err != nil {
+		t.Errorf("expected the room to be updated but got %v", err)
+	}
+	room.ID = 404
+	if err := repo.UpdateRoom(ctx, room); !errors.Is(err, repository.ErrRoomNotFound) {
+		t.Errorf("expected %v but got %v", repository.ErrRoomNotFound, err)
+	}
+	if rooms, _ := repo.AllRooms(ctx); len(rooms) != 2 {
+		t.Errorf("expected the rooms to stay as they were but got %v", rooms)
+	}
+}
+
+func TestMemoryRepo_Blocks(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	_, _ = repo.CreateReservation(ctx, models.Reservation{RoomId: 1, StartDate: date(10), EndDate: date(15)}, nil)
+
+	err := repo.InsertBlocks(ctx, []models.RoomRestriction{
+		{RoomID: 2, StartDate: date(10), EndDate: date(20), RestrictionID: 3, Note: "Painting"},
+		{RoomID: 1, StartDate: date(14), EndDate: date(16), RestrictionID: 3, Note: "Painting"},
+	})
+	if !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("expected %v for a block overlapping a reservation but got %v", repository.ErrRoomNotAvailable, err)
+	}
+	if available, _ := repo.SearchAvailabilityByDatesAndRoomID(ctx, date(10), date(20), 2); !available {
+		t.Error("blocks have been partly inserted despite the error")
+	}
+
+	err = repo.InsertBlocks(ctx, []models.RoomRestriction{{RoomID: 2, StartDate: date(10), EndDate: date(20), RestrictionID: 3, Note: "Painting"}})
+	if err != nil {
+		t.Fatalf("unexpected error inserting block: %q", err)
+	}
+
+	// free the nights 12-13 in the middle of the block, then the night 19 at its end
+	_ = repo.RemoveBlocks(ctx, []int{2}, date(12), date(14))
+	_ = repo.RemoveBlocks(ctx, []int{2}, date(19), date(25))
+
+	restrictions, _ := repo.GetRestrictionsForRoomByDates(ctx, 2, date(1), date(31))
+	expected := [][2]int{{10, 12}, {14, 19}}
+	if len(restrictions) != len(expected) {
+		t.Fatalf("expected %d blocks but got %v", len(expected), restrictions)
+	}
+	for i, rr := range restrictions {
+		if !rr.StartDate.Equal(date(expected[i][0])) || !rr.EndDate.Equal(date(expected[i][1])) {
+			t.Errorf("block %d: expected nights %v but got %s - %s", i, expected[i], rr.StartDate, rr.EndDate)
+		}
+		if rr.Note != "Painting" || rr.Restriction.RestrictionName != "Maintenance" {
+			t.Errorf("block %d: expected maintenance block with a note but got %v", i, rr)
+		}
+	}
+
+	// blocks of several rooms are removed at once, a legacy block ending on the day it starts with its one night
+	repo.(*memoryDBRepo).insertBlock(1, date(25), date(25), 3, "", time.Now())
+	_ = repo.InsertBlocks(ctx, []models.RoomRestriction{{RoomID: 2, StartDate: date(25), EndDate: date(27), RestrictionID: 3}})
+	_ = repo.RemoveBlocks(ctx, []int{1, 2}, date(25), date(27))
+	for _, roomID := range []int{1, 2} {
+		if restrictions, _ = repo.GetRestrictionsForRoomByDates(ctx, roomID, date(25), date(26)); len(restrictions) != 0 {
+			t.Errorf("room %d: expected the blocks to be removed but got %v", roomID, restrictions)
+		}
+	}
+
+	// reservations are never removed as blocks
+	_ = repo.RemoveBlocks(ctx, []int{1}, date(1), date(31))
+	if available, _ := repo.SearchAvailabilityByDatesAndRoomID(ctx, date(10), date(15), 1); available {
+		t.Error("reservation restriction has been removed as a block")
+	}
+}
+
+func TestMemoryRepo_MailOutbox(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+
+	_, err := repo.CreateReservation(ctx, models.Reservation{RoomId: 1, StartDate: date(10), EndDate: date(15)},
+		[]models.MailData{{To: "guest@here.ca"}, {To: "owner@here.ca"}})
+	if err != nil {
+		t.Fatalf("unexpected error creating reservation: %q", err)
+	}
+	// mails are not queued if the reservation fails
+	_, _ = repo.CreateReservation(ctx, models.Reservation{RoomId: 1, StartDate: date(10), EndDate: date(15)},
+		[]models.MailData{{To: "nobody@here.ca"}})
+	_ = repo.QueueMail(ctx, models.MailData{To: "third@here.ca"})
+
+	claimed, _ := repo.ClaimMail(ctx, 2, time.Minute)
+	if len(claimed) != 2 || claimed[0].To != "guest@here.ca" || claimed[1].To != "owner@here.ca" || claimed[0].Attempts != 1 {
+		t.Fatalf("unexpected claimed messages %+v", claimed)
+	}
+	// claimed messages are leased to the worker
+	claimed, _ = repo.ClaimMail(ctx, 10, time.Minute)
+	if len(claimed) != 1 || claimed[0].To != "third@here.ca" {
+		t.Fatalf("unexpected claimed messages %+v", claimed)
+	}
+	third := claimed[0].ID
+
+	_ = repo.MarkMailSent(ctx, 1)
+	_ = repo.RescheduleMail(ctx, 2, "connection refused", time.Now().Add(-time.Second))
+	_ = repo.MarkMailFailed(ctx, third, "mailbox unavailable")
+
+	claimed, _ = repo.ClaimMail(ctx, 10, time.Minute)
+	if len(claimed) != 1 || claimed[0].ID != 2 || claimed[0].Attempts != 2 || claimed[0].LastError != "connection refused" {
+		t.Fatalf("expected rescheduled message to be claimed again, got %+v", claimed)
+	}
+
+	pending, _ := repo.PendingMail(ctx)
+	if len(pending) != 2 || pending[0].ID != third || pending[0].Status != models.MailFailed {
+		t.Fatalf("unexpected pending messages %+v", pending)
+	}
+
+	if err := repo.ResendMail(ctx, 1); !errors.Is(err, repository.ErrMailNotFound) {
+		t.Errorf("expected ErrMailNotFound resending sent message, got %v", err)
+	}
+	if err := repo.ResendMail(ctx, third); err != nil {
+		t.Fatalf("unexpected error resending message: %q", err)
+	}
+	claimed, _ = repo.ClaimMail(ctx, 10, time.Minute)
+	if len(claimed) != 1 || claimed[0].ID != third || claimed[0].Attempts != 1 || claimed[0].Status != models.MailQueued {
+		t.Errorf("expected resent message to be claimed with fresh attempts, got %+v", claimed)
+	}
+}
+
+func TestMemoryRepo_AuditLog(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	id, _ := repo.CreateReservation(context.Background(), models.Reservation{RoomId: 1, StartDate: date(10), EndDate: date(15)}, nil)
+
+	ctx := repository.WithActor(context.Background(), models.Actor{UserID: 1, IP: "10.0.0.1", Method: "POST", Path: "/admin/reservations"})
+	if err := repo.UpdateReservationStatus(ctx, id, models.StatusConfirmed, 1); err != nil {
+		t.Fatalf("unexpected error updating status: %q", err)
+	}
+	if err := repo.DeleteReservation(ctx, id); err != nil {
+		t.Fatalf("unexpected error deleting reservation: %q", err)
+	}
+	if _, err := repo.InsertRoom(ctx, models.Room{RoomName: "Attic", Slug: "attic"}); err != nil {
+		t.Fatalf("unexpected error inserting room: %q", err)
+	}
+	// changes made without a back office user are not audited
+	_, _ = repo.InsertRoom(context.Background(), models.Room{RoomName: "Cellar", Slug: "cellar"})
+
+	entries, err := repo.AuditLog(ctx, models.AuditFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error getting audit log: %q", err)
+	}
+	expected := []string{"create room", "delete reservation", "change_status reservation"}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries but got %v", len(expected), entries)
+	}
+	for i, e := range entries {
+		if e.Action+" "+e.Entity != expected[i] {
+			t.Errorf("entry %d: expected %q but got %q", i, expected[i], e.Action+" "+e.Entity)
+		}
+		if e.Actor.IP != "10.0.0.1" || e.User.ID != 1 {
+			t.Errorf("entry %d: expected to be made by user 1 from 10.0.0.1 but got %v", i, e.Actor)
+		}
+	}
+	if entries[2].Before != `{"status":"pending"}` || entries[2].After != `{"status":"confirmed"}` {
+		t.Errorf("unexpected status change %s -> %s", entries[2].Before, entries[2].After)
+	}
+	if entries[1].Before == "" || entries[1].After != "" {
+		t.Errorf("expected deleted reservation only before the change but got %q -> %q", entries[1].Before, entries[1].After)
+	}
+
+	tests := []struct {
+		name     string
+		filter   models.AuditFilter
+		expected int
+	}{
+		{"entity", models.AuditFilter{Entity: models.AuditReservation}, 2},
+		{"other-user", models.AuditFilter{UserID: 2}, 0},
+		{"limit", models.AuditFilter{Limit: 1}, 1},
+		{"future", models.AuditFilter{From: time.Now().Add(time.Hour)}, 0},
+		{"past", models.AuditFilter{To: time.Now().Add(-time.Hour)}, 0},
+	}
+	for _, e := range tests {
+		entries, _ := repo.AuditLog(ctx, e.filter)
+		if len(entries) != e.expected {
+			t.Errorf("%s: expected %d entries but got %d", e.name, e.expected, len(entries))
+		}
+	}
+}
+
+func TestMemoryRepo_AuditLogUser(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := repository.WithActor(context.Background(), models.Actor{UserID: 1, IP: "10.0.0.1", Method: "POST", Path: "/admin/users/new"})
+	id, err := repo.InsertUser(ctx, models.User{FirstName: "Ann", LastName: "Lee", Email: "ann@here.ca"}, "password")
+	if err != nil {
+		t.Fatalf("unexpected error inserting user: %q", err)
+	}
+
+	entries, _ := repo.AuditLog(ctx, models.AuditFilter{Entity: models.AuditUser})
+	if len(entries) != 1 || entries[0].EntityID != id || !strings.Contains(entries[0].After, fmt.Sprintf(`"id":%d,`, id)) {
+		t.Errorf("expected the new user %d in the audit log but got %v", id, entries)
+	}
+}
+
+func TestMemoryRepo_Dashboard(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	_, _ = repo.CreateReservation(ctx, models.Reservation{RoomId: 1, StartDate: date(10), EndDate: date(13)}, nil)
+	inHouse, _ := repo.CreateReservation(ctx, models.Reservation{RoomId: 2, StartDate: date(8), EndDate: date(10)}, nil)
+	cancelled, _ := repo.CreateReservation(ctx, models.Reservation{RoomId: 2, StartDate: date(10), EndDate: date(12)}, nil)
+	_ = repo.UpdateReservationStatus(ctx, inHouse, models.StatusConfirmed, 1)
+	_ = repo.UpdateReservationStatus(ctx, inHouse, models.StatusCheckedIn, 1)
+	_ = repo.UpdateReservationStatus(ctx, cancelled, models.StatusCancelled, 1)
+	_ = repo.InsertBlocks(ctx, []models.RoomRestriction{{RoomID: 2, StartDate: date(15), EndDate: date(25), RestrictionID: 3}})
+
+	counts, err := repo.DashboardCounts(ctx, date(10))
+	if err != nil {
+		t.Fatalf("unexpected error counting reservations: %q", err)
+	}
+	expected := models.DashboardCounts{Arrivals: 1, Departures: 1, InHouse: 1, Unprocessed: 1}
+	if counts != expected {
+		t.Errorf("expected %+v but got %+v", expected, counts)
+	}
+
+	// two rooms for the nights 10-16: room 1 is booked for 3 of them and room 2 is blocked for the last 2
+	occupancy, err := repo.Occupancy(ctx, date(10), date(17))
+	if err != nil {
+		t.Fatalf("unexpected error computing occupancy: %q", err)
+	}
+	if o := (models.Occupancy{Nights: 7, RoomNights: 14, BookedNights: 3, BlockedNights: 2}); occupancy != o {
+		t.Errorf("expected %+v but got %+v", o, occupancy)
+	}
+	if occupancy.Percent() != 25 {
+		t.Errorf("expected occupancy of 25%% but got %d%%", occupancy.Percent())
+	}
+
+	activity, err := repo.RecentStatusChanges(ctx, 2)
+	if err != nil {
+		t.Fatalf("unexpected error getting recent activity: %q", err)
+	}
+	if len(activity) != 2 || activity[0].ReservationID != cancelled || activity[0].ToStatus != models.StatusCancelled ||
+		activity[1].ToStatus != models.StatusCheckedIn {
+		t.Errorf("expected the latest changes to cancel and check in reservations but got %+v", activity)
+	}
+	if activity[0].Reservation.Room.RoomName != "Major's Suite" || activity[0].User.FirstName != "Admin" {
+		t.Errorf("expected the room and the user of the change but got %+v", activity[0])
+	}
+}
+
+func TestMemoryRepo_Report(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	// a stay spanning two weeks, a cancelled booking and a no-show, and a block
+	stay, _ := repo.CreateReservation(ctx, models.Reservation{RoomId: 1, StartDate: date(3), EndDate: date(7)}, nil)
+	cancelled, _ := repo.CreateReservation(ctx, models.Reservation{RoomId: 2, StartDate: date(5), EndDate: date(6)}, nil)
+	noShow, _ := repo.CreateReservation(ctx, models.Reservation{RoomId: 2, StartDate: date(7), EndDate: date(9)}, nil)
+	_ = repo.UpdateReservationStatus(ctx, stay, models.StatusConfirmed, 1)
+	_ = repo.UpdateReservationStatus(ctx, cancelled, models.StatusCancelled, 1)
+	_ = repo.UpdateReservationStatus(ctx, noShow, models.StatusConfirmed, 1)
+	_ = repo.UpdateReservationStatus(ctx, noShow, models.StatusNoShow, 1)
+	_ = repo.InsertBlocks(ctx, []models.RoomRestriction{{RoomID: 2, StartDate: date(1), EndDate: date(3), RestrictionID: 3}})
+	// an imported stay that seems booked after the arrival has no lead time
+	_ = repo.ImportBookings(ctx, []models.Reservation{{RoomId: 1, StartDate: date(8), EndDate: date(10),
+		Status: models.StatusCheckedOut, CreatedAt: date(11)}}, nil, 1)
+
+	// 2060-01-05 is a Monday, so the nights 1-11 fall into two weeks
+	report, err := repo.Report(ctx, date(1), date(12), models.GroupByWeek)
+	if err != nil {
+		t.Fatalf("unexpected error computing report: %q", err)
+	}
+	if len(report.Rows) != 4 {
+		t.Fatalf("expected rows for 2 rooms over 2 weeks but got %+v", report.Rows)
+	}
+	byPeriod := report.ByPeriod()
+	expected := []models.ReportRow{
+		{Period: date(1).AddDate(0, 0, -3), RoomNights: 8, BlockedNights: 2, NightsSold: 2, Bookings: 1, StayNights: 4, LeadStays: 1},
+		{Period: date(5), RoomNights: 14, NightsSold: 4, Bookings: 3, Cancelled: 1, NoShows: 1, StayNights: 2},
+	}
+	for i, e := range expected {
+		actual := byPeriod[i]
+		if actual.LeadDays < 0 || (actual.LeadStays > 0) != (actual.LeadDays > 0) {
+			t.Errorf("week %d: unexpected lead time of %d days", i, actual.LeadDays)
+		}
+		actual.LeadDays = 0
+		if actual != e {
+			t.Errorf("week %d: expected %+v but got %+v", i, e, actual)
+		}
+	}
+}
+
+func TestMemoryRepo_SearchReservations(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	for _, r := range []models.Reservation{
+		{FirstName: "John", LastName: "Smith", Email: "john@smith.com", Phone: "555-0101", RoomId: 1, StartDate: date(10), EndDate: date(12)},
+		{FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", Phone: "555-0102", RoomId: 2, StartDate: date(10), EndDate: date(15)},
+		{FirstName: "Ann", LastName: "Smith", Email: "ann@example.com", Phone: "555-0103", RoomId: 1, StartDate: date(20), EndDate: date(21)},
+	} {
+		if _, err := repo.CreateReservation(ctx, r, nil); err != nil {
+			t.Fatalf("unexpected error creating reservation: %q", err)
+		}
+	}
+	_ = repo.UpdateReservationStatus(ctx, 2, models.StatusConfirmed, 1)
+
+	tests := []struct {
+		name     string
+		filter   models.ReservationFilter
+		expected []int
+		total    int
+	}{
+		{"newest-first", models.ReservationFilter{Sort: models.SortByArrival, Desc: true}, []int{3, 2, 1}, 3},
+		{"name", models.ReservationFilter{Sort: models.SortByName}, []int{2, 3, 1}, 3},
+		{"search", models.ReservationFilter{Search: "SMITH"}, []int{1, 3}, 2},
+		{"search-full-name", models.ReservationFilter{Search: "jane doe"}, []int{2}, 1},
+		{"search-phone", models.ReservationFilter{Search: "0103"}, []int{3}, 1},
+		{"room", models.ReservationFilter{RoomID: 1}, []int{1, 3}, 2},
+		{"dates", models.ReservationFilter{From: date(12), To: date(20)}, []int{2}, 1},
+		{"status", models.ReservationFilter{Status: models.StatusPending, Sort: models.SortByID, Desc: true}, []int{3, 1}, 2},
+		{"first-page", models.ReservationFilter{Sort: models.SortByID, Page: 1, PageSize: 2}, []int{1, 2}, 3},
+		{"last-page", models.ReservationFilter{Sort: models.SortByID, Page: 2, PageSize: 2}, []int{3}, 3},
+		{"past-last-page", models.ReservationFilter{Sort: models.SortByID, Page: 3, PageSize: 2}, nil, 3},
+	}
+	for _, e := range tests {
+		page, err := repo.SearchReservations(ctx, e.filter)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %q", e.name, err)
+		}
+		var ids []int
+		for _, r := range page.Reservations {
+			ids = append(ids, r.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(e.expected) || page.Total != e.total {
+			t.Errorf("%s: expected %v of %d but got %v of %d", e.name, e.expected, e.total, ids, page.Total)
+		}
+	}
+}
+
+func TestMemoryRepo_ExportReservations(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		r := models.Reservation{FirstName: "Guest", LastName: fmt.Sprint(i), RoomId: 1, StartDate: date(i * 2), EndDate: date(i*2 + 1)}
+		if _, err := repo.CreateReservation(ctx, r, nil); err != nil {
+			t.Fatalf("unexpected error creating reservation: %q", err)
+		}
+	}
+
+	// paging is ignored
+	var ids []int
+	filter := models.ReservationFilter{Sort: models.SortByArrival, Desc: true, Page: 2, PageSize: 1}
+	err := repo.ExportReservations(ctx, filter, func(r models.Reservation) error {
+		if r.Room.RoomName == "" {
+			t.Errorf("reservation %d is exported without its room", r.ID)
+		}
+		ids = append(ids, r.ID)
+		return nil
+	})
+	if err != nil || fmt.Sprint(ids) != "[3 2 1]" {
+		t.Errorf("expected [3 2 1] but got %v and %v", ids, err)
+	}
+
+	stop := errors.New("stop")
+	ids = nil
+	err = repo.ExportReservations(ctx, models.ReservationFilter{Sort: models.SortByID}, func(r models.Reservation) error {
+		ids = append(ids, r.ID)
+		return stop
+	})
+	if err != stop || len(ids) != 1 {
+		t.Errorf("expected the export to stop after one reservation with the error of the callback but got %v and %v", ids, err)
+	}
+}
+
+func TestMemoryRepo_ImportBookings(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	ctx := repository.WithActor(context.Background(), models.Actor{UserID: 1, Method: "POST", Path: "/admin/import"})
+	if _, err := repo.CreateReservation(ctx, models.Reservation{FirstName: "John", RoomId: 1, StartDate: date(10), EndDate: date(12)}, nil); err != nil {
+		t.Fatalf("unexpected error creating reservation: %q", err)
+	}
+
+	// the second reservation overlaps the first one, so nothing is imported
+	reservations := []models.Reservation{
+		{FirstName: "Ann", RoomId: 2, StartDate: date(1), EndDate: date(5), Status: models.StatusCheckedOut, CreatedAt: date(1).AddDate(0, -1, 0)},
+		{FirstName: "Bob", RoomId: 2, StartDate: date(4), EndDate: date(6), Status: models.StatusConfirmed},
+	}
+	blocks := []models.RoomRestriction{{RoomID: 1, StartDate: date(12), EndDate: date(14), RestrictionID: 3}}
+	if err := repo.ImportBookings(ctx, reservations, blocks, 1); !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Fatalf("expected %v but got %v", repository.ErrRoomNotAvailable, err)
+	}
+	if page, _ := repo.SearchReservations(ctx, models.ReservationFilter{}); page.Total != 1 {
+		t.Fatalf("expected nothing to be imported but there are %d reservations", page.Total)
+	}
+
+	// a cancelled reservation does not hold the room
+	reservations[1].Status = models.StatusCancelled
+	reservations = append(reservations, models.Reservation{FirstName: "Cy", RoomId: 1, StartDate: date(11), EndDate: date(12), Status: models.StatusCancelled})
+	if err := repo.ImportBookings(ctx, reservations, blocks, 1); err != nil {
+		t.Fatalf("unexpected error importing: %q", err)
+	}
+	page, _ := repo.SearchReservations(ctx, models.ReservationFilter{Sort: models.SortByID})
+	if page.Total != 4 || page.Reservations[1].Status != models.StatusCheckedOut || page.Reservations[2].Status != models.StatusCancelled {
+		t.Fatalf("expected the imported reservations in their statuses but got %+v", page.Reservations)
+	}
+	if !page.Reservations[1].CreatedAt.Equal(date(1).AddDate(0, -1, 0)) || page.Reservations[2].CreatedAt.IsZero() {
+		t.Errorf("expected the imported reservations booked when they say or now but got %+v", page.Reservations)
+	}
+	if available, _ := repo.SearchAvailabilityByDatesAndRoomID(ctx, date(4), date(5), 2); available {
+		t.Error("expected Ann's stay to take the room")
+	}
+	if available, _ := repo.SearchAvailabilityByDatesAndRoomID(ctx, date(5), date(6), 2); !available {
+		t.Error("expected Bob's cancelled stay to leave the room free")
+	}
+	if available, _ := repo.SearchAvailabilityByDatesAndRoomID(ctx, date(13), date(14), 1); available {
+		t.Error("expected the block to take the room")
+	}
+	history, _ := repo.GetReservationStatusHistory(ctx, page.Reservations[1].ID)
+	if len(history) != 1 || history[0].ToStatus != models.StatusCheckedOut || history[0].UserID != 1 {
+		t.Errorf("expected the import to be the status history but got %+v", history)
+	}
+	entries, _ := repo.AuditLog(ctx, models.AuditFilter{Entity: models.AuditReservation})
+	if len(entries) != 3 {
+		t.Errorf("expected the 3 imported reservations to be audited but got %d entries", len(entries))
+	}
+}
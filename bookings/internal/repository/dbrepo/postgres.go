@@ -2,24 +2,88 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository"
+	"github.com/jackc/pgx/v5/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// InsertReservation inserts reservation into database
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// CreateReservation re-checks availability of the room and inserts the reservation together
+// with its room restriction and notification mails in one transaction. It returns repository.ErrRoomNotAvailable
+// if the room has been taken for (some of) the dates in the meantime
+func (m *postgresDBRepo) CreateReservation(ctx context.Context, res models.Reservation, mails []models.MailData) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	newId, err := insertReservation(ctx, tx, res, models.StatusPending, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range mails {
+		if err = insertMail(ctx, tx, msg); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+// insertReservation inserts the reservation in the status within the transaction, together with its status
+// history and, unless it is cancelled, its room restriction. The room is locked and re-checked first;
+// repository.ErrRoomNotAvailable is returned if it is taken for (some of) the dates. The reservation is
+// booked now, unless res.CreatedAt says when, as for the reservations imported from elsewhere
+func insertReservation(ctx context.Context, tx *sql.Tx, res models.Reservation, status models.ReservationStatus, userID int) (int, error) {
+	// lock the room row so that concurrent bookings of the same room are serialized
+	var roomID int
+	err := tx.QueryRowContext(ctx, "select id from rooms where id = $1 for update", res.RoomId).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	if status != models.StatusCancelled {
+		var numRows int
+		query := `
+			select  count(id)
+			  from  room_restrictions rr
+			 where  room_id = $1 and $2 < rr.end_date and $3 > start_date
+		`
+		err = tx.QueryRowContext(ctx, query, res.RoomId, res.StartDate, res.EndDate).Scan(&numRows)
+		if err != nil {
+			return 0, err
+		}
+		if numRows > 0 {
+			return 0, repository.ErrRoomNotAvailable
+		}
+	}
+
+	now := time.Now()
+	createdAt := res.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now
+	}
 	var newId int
 	stmt := `
 		insert into reservations(first_name, last_name, email, phone,
-			start_date, end_date, room_id, created_at, updated_at)
-			values($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
-	err := m.DB.QueryRowContext(ctx, stmt,
+			start_date, end_date, room_id, created_at, updated_at, status, confirmation_code, reference)
+			values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -27,43 +91,61 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomId,
-		time.Now(),
-		time.Now(),
+		createdAt,
+		now,
+		status,
+		res.ConfirmationCode,
+		res.Reference,
 	).Scan(&newId)
-
 	if err != nil {
 		return 0, err
 	}
-	return newId, nil
-}
 
-// InsertRoomRestriction inserts a room restriction into the database
-func (m postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	err = insertStatusChange(ctx, tx, newId, "", status, userID)
+	if err != nil {
+		return 0, err
+	}
+	if status == models.StatusCancelled {
+		return newId, nil
+	}
 
-	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+	stmt = `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
 		created_at, updated_at, restriction_id) values ($1, $2, $3, $4, $5, $6, $7)`
-	_, err := m.DB.ExecContext(ctx, stmt,
-		r.StartDate,
-		r.EndDate,
-		r.RoomID,
-		r.ReservationID,
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomId,
+		newId,
 		time.Now(),
 		time.Now(),
-		r.RestrictionID,
+		reservationRestrictionID,
 	)
 	if err != nil {
-		return err
+		if isExclusionViolation(err) {
+			return 0, repository.ErrRoomNotAvailable
+		}
+		return 0, err
 	}
+	return newId, nil
+}
 
-	return nil
+// isExclusionViolation reports whether err is caused by the constraint
+// that prevents overlapping room restrictions for the same room
+func isExclusionViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == exclusionViolationCode
+}
+
+// isUniqueViolation reports whether err is caused by a unique constraint
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
 }
 
 // SearchAvailabilityByDatesAndRoomID returns true if room is available for the called period of time
 // and false otherwise
-func (m *postgresDBRepo) SearchAvailabilityByDatesAndRoomID(start, end time.Time, roomID int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) SearchAvailabilityByDatesAndRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 	query := `
 		select  count(id)
@@ -83,17 +165,19 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesAndRoomID(start, end time.Time
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms for a range of dates,
 // if any
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 	query := `
-		select  r.id, r.room_name
-		  from  rooms r
-		 where  r.id not in (
+		select  ` + roomColumns + `
+		  from  rooms
+		 where  id not in (
 			select  rr.room_id
 			  from  room_restrictions rr
 			 where  $1 < rr.end_date and $2 > rr.start_date
-		 )	
+		 )
+		 order  by
+		 		id asc
 	`
 	rows, err := m.DB.QueryContext(ctx, query, start, end)
 	if err != nil {
@@ -102,8 +186,7 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 	defer rows.Close()
 	var result []models.Room
 	for rows.Next() {
-		var room models.Room
-		err = rows.Scan(&room.ID, &room.RoomName)
+		room, err := scanRoom(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -117,322 +200,1830 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 	return result, nil
 }
 
+// roomColumns are the columns of rooms table in the order scanRoom expects them
+const roomColumns = "id, room_name, slug, description, capacity, amenities, base_price, photos, created_at, updated_at"
+
+// scanRoom scans a row of roomColumns into a room
+func scanRoom(row interface{ Scan(dest ...any) error }) (models.Room, error) {
+	var room models.Room
+	var amenities, photos string
+	err := row.Scan(&room.ID, &room.RoomName, &room.Slug, &room.Description, &room.Capacity,
+		&amenities, &room.BasePrice, &photos, &room.CreatedAt, &room.UpdatedAt)
+	room.Amenities = splitLines(amenities)
+	room.Photos = splitLines(photos)
+	return room, err
+}
+
 // GetRoomByID gets a room from DB by id
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	var room models.Room
 	query := `
-		select  id, room_name, created_at, updated_at
+		select  ` + roomColumns + `
 		  from  rooms
 		 where  id = $1
 	`
-	row, err := m.DB.QueryContext(ctx, query, id)
-	if err != nil {
-		return room, err
-	}
-	if row.Next() {
-		err = row.Scan(&room.ID, &room.RoomName, &room.CreatedAt, &room.UpdatedAt)
-		return room, err
+	room, err := scanRoom(m.DB.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return room, fmt.Errorf("room with id %d is not found in DB", id)
 	}
-	return room, fmt.Errorf("room with id %d is not found in DB", id)
+	return room, err
 }
 
-// GetUserById returns a user by id
-func (m *postgresDBRepo) GetUserById(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetRoomBySlug gets a room from DB by its slug. It returns repository.ErrRoomNotFound
+// if there is no such room
+func (m *postgresDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := `
-		select  id, first_name, last_name, email, password, access_level, created_at, updated_at
-		  from  users u
-		 where  u.id = $1
+		select  ` + roomColumns + `
+		  from  rooms
+		 where  slug = $1
 	`
-	row := m.DB.QueryRowContext(ctx, query, id)
-	var u models.User
-	err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.AccessLevel, &u.CreatedAt, &u.UpdatedAt)
-	if err != nil {
-		return u, err
+	room, err := scanRoom(m.DB.QueryRowContext(ctx, query, slug))
+	if errors.Is(err, sql.ErrNoRows) {
+		return room, repository.ErrRoomNotFound
 	}
-	return u, nil
+	return room, err
 }
 
-// UpdateUser updates a user in the database
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// userColumns are the columns scanned by scanUser
+const userColumns = `id, first_name, last_name, email, password, access_level, active, must_reset_password,
+	failed_logins, locked_until, totp_secret, created_at, updated_at`
 
-	query := `
-		update  users
-		   set  first_name = $1
-		   		last_name = $2
-				email = $3
-				access_level = $4
-				updated_at = $5
-		 where  id = $6
-	`
-	_, err := m.DB.ExecContext(ctx, query, u.FirstName, u.LastName, u.Email, u.AccessLevel, time.Now(), u.ID)
-	return err
+// scanUser scans a row of userColumns
+func scanUser(row interface{ Scan(...any) error }) (models.User, error) {
+	var u models.User
+	var lockedUntil sql.NullTime
+	err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.AccessLevel, &u.Active,
+		&u.MustResetPassword, &u.FailedLogins, &lockedUntil, &u.TwoFactorSecret, &u.CreatedAt, &u.UpdatedAt)
+	u.LockedUntil = lockedUntil.Time
+	return u, err
 }
 
-// Authenticate authenticates the user
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetUserById returns a user by id. It returns repository.ErrUserNotFound if there is no such user
+func (m *postgresDBRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	var id int
-	var hashedPassword string
-
-	row := m.DB.QueryRowContext(ctx, "select id, password from users where email = $1", email)
-	err := row.Scan(&id, &hashedPassword)
-	if err != nil {
-		return 0, "", err
+	query := `
+		select  ` + userColumns + `
+		  from  users u
+		 where  u.id = $1
+	`
+	u, err := scanUser(m.DB.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return u, repository.ErrUserNotFound
 	}
+	return u, err
+}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
-	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return 0, "", errors.New("incorrect password")
-	} else if err != nil {
-		return 0, "", err
-	}
+// GetUserByEmail returns a user by email. It returns repository.ErrUserNotFound if there is no such user
+func (m *postgresDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 
-	return id, hashedPassword, nil
+	query := `
+		select  ` + userColumns + `
+		  from  users u
+		 where  u.email = $1
+	`
+	u, err := scanUser(m.DB.QueryRowContext(ctx, query, email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return u, repository.ErrUserNotFound
+	}
+	return u, err
 }
 
-// AllReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// AllUsers returns all users ordered by name
+func (m *postgresDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	var reservations []models.Reservation
 	query := `
-		select  r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
-				r.room_id, r.created_at, r.updated_at, r.processed, rm.room_name
-		  from  reservations r
-		  left
-		  join  rooms rm
-		    on  r.room_id = rm.id
-		 order  by
-		        r.start_date desc
-`
+		select  ` + userColumns + `
+		  from  users
+		 order  by last_name, first_name, id
+	`
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
-		return reservations, err
+		return nil, err
 	}
 	defer rows.Close()
 
+	var users []models.User
 	for rows.Next() {
-		var r models.Reservation
-		err = rows.Scan(&r.ID, &r.FirstName, &r.LastName, &r.Email, &r.Phone, &r.StartDate, &r.EndDate,
-			&r.RoomId, &r.CreatedAt, &r.UpdatedAt, &r.Processed, &r.Room.RoomName)
+		u, err := scanUser(rows)
 		if err != nil {
-			return reservations, err
+			return nil, err
 		}
-		r.Room.ID = r.RoomId
-		reservations = append(reservations, r)
+		users = append(users, u)
 	}
-	if err = rows.Err(); err != nil {
-		return reservations, err
-	}
-	return reservations, nil
+	return users, rows.Err()
 }
 
-// NewReservations returns a slice of new reservations
-func (m *postgresDBRepo) NewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertUser inserts a new user with the bcrypt hash of the password and returns the user's id. It returns
+// repository.ErrDuplicateEmail if another user already has the same email
+func (m *postgresDBRepo) InsertUser(ctx context.Context, u models.User, password string) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	var reservations []models.Reservation
-	query := `
-		select  r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
-				r.room_id, r.created_at, r.updated_at, rm.room_name
-		  from  reservations r
-		  left
-		  join  rooms rm
-		    on  r.room_id = rm.id
-		 where  r.processed = 0
-		 order  by
-		        r.start_date desc
-`
-	rows, err := m.DB.QueryContext(ctx, query)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return reservations, err
+		return 0, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var r models.Reservation
-		err = rows.Scan(&r.ID, &r.FirstName, &r.LastName, &r.Email, &r.Phone, &r.StartDate, &r.EndDate,
-			&r.RoomId, &r.CreatedAt, &r.UpdatedAt, &r.Room.RoomName)
-		if err != nil {
-			return reservations, err
-		}
-		r.Room.ID = r.RoomId
-		reservations = append(reservations, r)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
 	}
-	if err = rows.Err(); err != nil {
-		return reservations, err
+	defer tx.Rollback()
+
+	stmt := `
+		insert into users (first_name, last_name, email, password, access_level, active, must_reset_password,
+			created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	err = tx.QueryRowContext(ctx, stmt, u.FirstName, u.LastName, u.Email, string(hashedPassword), u.AccessLevel,
+		u.Active, u.MustResetPassword, time.Now(), time.Now()).Scan(&u.ID)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrDuplicateEmail
+	}
+	if err != nil {
+		return 0, err
 	}
-	return reservations, nil
+	err = insertAudit(ctx, tx, models.AuditCreate, models.AuditUser, u.ID, nil, userSnapshot(u))
+	if err != nil {
+		return 0, err
+	}
+	return u.ID, tx.Commit()
 }
 
-// GetReservationByID gets reservation from the DB by ID
-func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdateUser updates a user in the database, except for the password. It returns repository.ErrDuplicateEmail
+// if another user already has the same email and repository.ErrUserNotFound if there is no such user
+func (m *postgresDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	var r models.Reservation
-	query := `
-		select  r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
-				r.room_id, r.created_at, r.updated_at, r.processed, rm.room_name
-		  from  reservations r
-		  left
-		  join  rooms rm
-		    on  r.room_id = rm.id
-		 where  r.id = $1
-		 order  by
-		        r.start_date desc
-`
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&r.ID, &r.FirstName, &r.LastName, &r.Email, &r.Phone, &r.StartDate, &r.EndDate,
-		&r.RoomId, &r.CreatedAt, &r.UpdatedAt, &r.Processed, &r.Room.RoomName)
-	r.Room.ID = r.RoomId
-	return r, err
-}
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-// UpdateReservation updates reservation in the database
-func (m *postgresDBRepo) UpdateReservation(r models.Reservation) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	current, err := scanUser(tx.QueryRowContext(ctx, "select "+userColumns+" from users where id = $1 for update", u.ID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
 
 	query := `
-		update  reservations
+		update  users
 		   set  first_name = $1,
-		   		last_name = $2,
+				last_name = $2,
 				email = $3,
-				phone = $4,
-				updated_at = $5
-		 where  id = $6
+				access_level = $4,
+				active = $5,
+				updated_at = $6
+		 where  id = $7
 	`
-	_, err := m.DB.ExecContext(ctx, query, r.FirstName, r.LastName, r.Email, r.Phone, time.Now(), r.ID)
-	return err
+	_, err = tx.ExecContext(ctx, query, u.FirstName, u.LastName, u.Email, u.AccessLevel, u.Active,
+		time.Now(), u.ID)
+	if isUniqueViolation(err) {
+		return repository.ErrDuplicateEmail
+	}
+	if err != nil {
+		return err
+	}
+	err = insertAudit(ctx, tx, models.AuditUpdate, models.AuditUser, u.ID, userSnapshot(current), userSnapshot(u))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// DeleteReservation deletes one reservation from the DB by id
-func (m *postgresDBRepo) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// DeleteUser deletes a user. The status changes the user made stay in the history without the author
+func (m *postgresDBRepo) DeleteUser(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `
-		delete  
-		  from  reservations
-		 where  id = $1
-	`
-	_, err := m.DB.ExecContext(ctx, query, id)
-	return err
-}
-
-// UpdateProcessedForReservation updates the processed field (status) of reservation by ID
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	query := `
-		update  reservations
-		   set  processed = $2
-		 where  id = $1
-	`
-	_, err := m.DB.ExecContext(ctx, query, id, processed)
-	return err
+	current, err := scanUser(tx.QueryRowContext(ctx, "select "+userColumns+" from users where id = $1 for update", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "delete from users where id = $1", id); err != nil {
+		return err
+	}
+	err = insertAudit(ctx, tx, models.AuditDelete, models.AuditUser, id, userSnapshot(current), nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// AllRooms returns all rooms from the database
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// changeUser runs the statement changing the user and audits the change as the action, within one
+// transaction. It returns repository.ErrUserNotFound if the statement has changed no user
+func (m *postgresDBRepo) changeUser(ctx context.Context, id int, action, stmt string, args ...any) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	rooms := []models.Room{}
-	query := `
-		select  r.id, r.room_name, r.created_at, r.updated_at
-		  from  rooms r
-		 order  by
-		 		id asc`
-
-	rows, err := m.DB.QueryContext(ctx, query)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return rooms, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	for rows.Next() {
-		var r models.Room
-		err = rows.Scan(&r.ID, &r.RoomName, &r.CreatedAt, &r.UpdatedAt)
-		if err != nil {
-			return rooms, err
-		}
-		rooms = append(rooms, r)
+	result, err := tx.ExecContext(ctx, stmt, args...)
+	if err := userAffected(result, err); err != nil {
+		return err
 	}
-	if err = rows.Err(); err != nil {
-		return rooms, err
+	if err := insertAudit(ctx, tx, action, models.AuditUser, id, nil, nil); err != nil {
+		return err
 	}
-	return rooms, nil
+	return tx.Commit()
 }
 
-// GetRestrictionsForRoomByDates returns restrictions for a room by room id and dates range
-func (m *postgresDBRepo) GetRestrictionsForRoomByDates(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// ForcePasswordReset makes the user set a new password before logging in again
+func (m *postgresDBRepo) ForcePasswordReset(ctx context.Context, id int) error {
+	return m.changeUser(ctx, id, models.AuditForcePasswordReset,
+		"update users set must_reset_password = true, updated_at = $1 where id = $2", time.Now(), id)
+}
 
-	var restrictions []models.RoomRestriction
-	query := `
-		select  rr.id, rr.start_date, rr.end_date, rr.room_id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.created_at, rr.updated_at
-		  from  room_restrictions rr
-		 where  rr.room_id = $1
-		   and  rr.start_date <= $3
-		   and  rr.end_date >= $2;
-	`
-	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end)
+// UpdatePassword sets a new password of the user and lifts the forced password reset
+func (m *postgresDBRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return restrictions, err
+		return err
 	}
-	defer rows.Close()
+	return m.changeUser(ctx, id, models.AuditChangePassword,
+		"update users set password = $1, must_reset_password = false, updated_at = $2 where id = $3",
+		string(hashedPassword), time.Now(), id)
+}
 
-	for rows.Next() {
-		var rr models.RoomRestriction
-		err := rows.Scan(&rr.ID, &rr.StartDate, &rr.EndDate, &rr.RoomID, &rr.ReservationID, &rr.RestrictionID,
-			&rr.CreatedAt, &rr.UpdatedAt)
-		if err != nil {
-			return restrictions, err
-		}
-		restrictions = append(restrictions, rr)
+// userAffected turns the result of a statement changing one user into repository.ErrUserNotFound
+// if no user has been changed
+func userAffected(result sql.Result, err error) error {
+	if err != nil {
+		return err
 	}
-	return restrictions, rows.Err()
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrUserNotFound
+	}
+	return nil
 }
 
-// InsertBlockForRoom adds block to DB for the room on the date
-func (m *postgresDBRepo) InsertBlockForRoom(roomID int, startDate time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// Authenticate checks the password of the user with the email. It returns repository.ErrInvalidCredentials
+// if there is no such user or the password is wrong, repository.ErrAccountLocked while the user is locked
+// out, and repository.ErrUserInactive or repository.ErrPasswordResetRequired if the password is right,
+// but the user cannot log in
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `
-		insert into room_restrictions
-			(start_date, end_date, room_id, restriction_id, created_at, updated_at)
-				values($2, $2, $1, 2, $3, $3);
-	`
-	_, err := m.DB.ExecContext(ctx, query, roomID, startDate, time.Now())
-	return err
-}
+	var id int
+	var hashedPassword string
+	var active, mustResetPassword bool
+	var lockedUntil sql.NullTime
 
-// DeleteBlockByID removes block (room restriction) from the DB by ID
-func (m *postgresDBRepo) DeleteBlockByID(restrictionID int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	row := m.DB.QueryRowContext(ctx,
+		"select id, password, active, must_reset_password, locked_until from users where email = $1", email)
+	err := row.Scan(&id, &hashedPassword, &active, &mustResetPassword, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(testPassword))
+		return 0, "", repository.ErrInvalidCredentials
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if lockedUntil.Time.After(time.Now()) {
+		return 0, "", repository.ErrAccountLocked
+	}
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", repository.ErrInvalidCredentials
+	} else if err != nil {
+		return 0, "", err
+	}
+	if !active {
+		return 0, "", repository.ErrUserInactive
+	}
+	if mustResetPassword {
+		return 0, "", repository.ErrPasswordResetRequired
+	}
+
+	return id, hashedPassword, nil
+}
+
+// RecordFailedLogin keeps the failed login for the audit. If the password or the one-time password of
+// an existing user was wrong, the user's failed logins are counted and the user is locked out for as long
+// as the policy says
+func (m *postgresDBRepo) RecordFailedLogin(ctx context.Context, a models.LoginAttempt, policy models.LoginPolicy) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		insert  into login_attempts (email, ip, reason, created_at, updated_at)
+		values  ($1, $2, $3, $4, $4)
+	`, a.Email, a.IP, a.Reason, a.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if a.Reason == models.LoginFailedCredentials || a.Reason == models.LoginFailedSecondStep {
+		var id, failures int
+		err = tx.QueryRowContext(ctx,
+			"update users set failed_logins = failed_logins + 1 where email = $1 returning id, failed_logins", a.Email).
+			Scan(&id, &failures)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if wait := policy.Wait(failures); err == nil && wait > 0 {
+			_, err = tx.ExecContext(ctx, "update users set locked_until = $1 where id = $2", a.CreatedAt.Add(wait), id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LoginFailuresFromIP returns the number of failed logins from the IP address since the time and when
+// the last one happened
+func (m *postgresDBRepo) LoginFailuresFromIP(ctx context.Context, ip string, since time.Time) (int, time.Time, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	var last sql.NullTime
+	err := m.DB.QueryRowContext(ctx,
+		"select count(*), max(created_at) from login_attempts where ip = $1 and created_at > $2", ip, since).
+		Scan(&count, &last)
+	return count, last.Time, err
+}
+
+// UnlockUser forgets the failed logins of the user and lifts the lockout
+func (m *postgresDBRepo) UnlockUser(ctx context.Context, id int) error {
+	return m.changeUser(ctx, id, models.AuditUnlock,
+		"update users set failed_logins = 0, locked_until = null, updated_at = $1 where id = $2", time.Now(), id)
+}
+
+// EnableTwoFactor sets the TOTP secret of the user and replaces the recovery codes with the new ones
+func (m *postgresDBRepo) EnableTwoFactor(ctx context.Context, id int, secret string, recoveryCodeHashes []string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		"update users set totp_secret = $1, totp_last_step = 0, updated_at = $2 where id = $3", secret, now, id)
+	if err := userAffected(result, err); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "delete from recovery_codes where user_id = $1", id); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err := tx.ExecContext(ctx, `
+			insert  into recovery_codes (user_id, code_hash, created_at, updated_at)
+			values  ($1, $2, $3, $3)
+		`, id, hash, now)
+		if err != nil {
+			return err
+		}
+	}
+	if err := insertAudit(ctx, tx, models.AuditEnableTwoFactor, models.AuditUser, id, nil, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTwoFactor removes the TOTP secret and the recovery codes of the user
+func (m *postgresDBRepo) DisableTwoFactor(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		"update users set totp_secret = '', totp_last_step = 0, updated_at = $1 where id = $2", time.Now(), id)
+	if err := userAffected(result, err); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "delete from recovery_codes where user_id = $1", id); err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, models.AuditDisableTwoFactor, models.AuditUser, id, nil, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep remembers the time step of the one-time password the user has logged in with. It returns
+// repository.ErrCodeAlreadyUsed if the step is not after the last one used, so a code works only once
+func (m *postgresDBRepo) UseTOTPStep(ctx context.Context, id int, step int64) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		"update users set totp_last_step = $1 where id = $2 and totp_last_step < $1", step, id)
+	if err := userAffected(result, err); errors.Is(err, repository.ErrUserNotFound) {
+		return repository.ErrCodeAlreadyUsed
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code of the user as used. It returns repository.ErrInvalidRecoveryCode
+// if the user has no such unused code
+func (m *postgresDBRepo) UseRecoveryCode(ctx context.Context, id int, codeHash string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `
+		update  recovery_codes
+		   set  used_at = $1, updated_at = $1
+		 where  user_id = $2 and code_hash = $3 and used_at is null
+	`, time.Now(), id, codeHash)
+	if err := userAffected(result, err); errors.Is(err, repository.ErrUserNotFound) {
+		return repository.ErrInvalidRecoveryCode
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes of the user
+func (m *postgresDBRepo) RecoveryCodesLeft(ctx context.Context, id int) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx,
+		"select count(*) from recovery_codes where user_id = $1 and used_at is null", id).Scan(&count)
+	return count, err
+}
+
+// reservationSortColumns holds the columns of the reservations query each sort column stands for
+var reservationSortColumns = map[string][]string{
+	models.SortByID:        {"r.id"},
+	models.SortByName:      {"r.last_name", "r.first_name"},
+	models.SortByRoom:      {"rm.room_name", "r.start_date"},
+	models.SortByArrival:   {"r.start_date"},
+	models.SortByDeparture: {"r.end_date"},
+	models.SortByStatus:    {"r.status", "r.start_date"},
+	models.SortByCreated:   {"r.created_at"},
+}
+
+// reservationConditions returns the where and order by clauses of a query of the reservations r joined
+// with their rooms rm, selecting and sorting them by the filter, and the arguments $1 to $5 of the clauses
+func reservationConditions(filter models.ReservationFilter) (where, orderBy string, args []any) {
+	var search string
+	if filter.Search != "" {
+		search = "%" + likeEscaper.Replace(filter.Search) + "%"
+	}
+	var from, to sql.NullTime
+	if !filter.From.IsZero() {
+		from = sql.NullTime{Time: filter.From, Valid: true}
+	}
+	if !filter.To.IsZero() {
+		to = sql.NullTime{Time: filter.To, Valid: true}
+	}
+	where = `
+		 where  ($1 = '' or concat_ws(' ', r.first_name, r.last_name, r.email, r.phone) ilike $1)
+		   and  ($2 = 0 or r.room_id = $2)
+		   and  ($3::date is null or r.end_date > $3)
+		   and  ($4::date is null or r.start_date < $4)
+		   and  ($5 = '' or r.status = $5)
+	`
+	args = []any{search, filter.RoomID, from, to, filter.Status}
+
+	columns, ok := reservationSortColumns[filter.Sort]
+	if !ok {
+		columns = reservationSortColumns[models.SortByArrival]
+	}
+	var order []string
+	for _, column := range append(append([]string{}, columns...), "r.id") {
+		if filter.Desc {
+			column += " desc"
+		}
+		order = append(order, column)
+	}
+	orderBy = `
+		 order  by
+		        ` + strings.Join(order, ", ") + `
+	`
+	return where, orderBy, args
+}
+
+// SearchReservations returns the page of the reservations selected by the filter together with
+// the number of reservations selected on all pages
+func (m *postgresDBRepo) SearchReservations(ctx context.Context, filter models.ReservationFilter) (models.ReservationPage, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	page := models.ReservationPage{Filter: filter}
+	where, orderBy, args := reservationConditions(filter)
+	err := m.DB.QueryRowContext(ctx, "select count(*) from reservations r"+where, args...).Scan(&page.Total)
+	if err != nil {
+		return page, err
+	}
+
+	query := `
+		select  r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+				r.room_id, r.created_at, r.updated_at, r.status, rm.room_name
+		  from  reservations r
+		  left
+		  join  rooms rm
+		    on  r.room_id = rm.id
+	` + where + orderBy + `
+		 limit  nullif($6, 0) offset $7
+	`
+	rows, err := m.DB.QueryContext(ctx, query, append(args, filter.PageSize, filter.Offset())...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reservation
+		err = rows.Scan(&r.ID, &r.FirstName, &r.LastName, &r.Email, &r.Phone, &r.StartDate, &r.EndDate,
+			&r.RoomId, &r.CreatedAt, &r.UpdatedAt, &r.Status, &r.Room.RoomName)
+		if err != nil {
+			return page, err
+		}
+		r.Room.ID = r.RoomId
+		page.Reservations = append(page.Reservations, r)
+	}
+	return page, rows.Err()
+}
+
+// ExportReservations calls fn with each of the reservations selected by the filter, in its order and
+// ignoring paging. The rows are read as fn handles them, so the query runs with the longer export timeout.
+// An error returned by fn stops the export and is returned
+func (m *postgresDBRepo) ExportReservations(ctx context.Context, filter models.ReservationFilter, fn func(models.Reservation) error) error {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	where, orderBy, args := reservationConditions(filter)
+	query := `
+		select  r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+				r.room_id, r.created_at, r.updated_at, r.status, rm.room_name
+		  from  reservations r
+		  left
+		  join  rooms rm
+		    on  r.room_id = rm.id
+	` + where + orderBy
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reservation
+		err = rows.Scan(&r.ID, &r.ConfirmationCode, &r.FirstName, &r.LastName, &r.Email, &r.Phone, &r.StartDate,
+			&r.EndDate, &r.RoomId, &r.CreatedAt, &r.UpdatedAt, &r.Status, &r.Room.RoomName)
+		if err != nil {
+			return err
+		}
+		r.Room.ID = r.RoomId
+		if err = fn(r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetReservationByID gets reservation from the DB by ID
+func (m *postgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var r models.Reservation
+	query := `
+		select  r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+				r.room_id, r.created_at, r.updated_at, r.status, rm.room_name
+		  from  reservations r
+		  left
+		  join  rooms rm
+		    on  r.room_id = rm.id
+		 where  r.id = $1
+		 order  by
+		        r.start_date desc
+`
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(&r.ID, &r.ConfirmationCode, &r.FirstName, &r.LastName, &r.Email, &r.Phone, &r.StartDate, &r.EndDate,
+		&r.RoomId, &r.CreatedAt, &r.UpdatedAt, &r.Status, &r.Room.RoomName)
+	r.Room.ID = r.RoomId
+	return r, err
+}
+
+// GetReservationByConfirmationCode returns the reservation a guest is looking for. Both the confirmation
+// code and the email must match, otherwise ErrReservationNotFound is returned
+func (m *postgresDBRepo) GetReservationByConfirmationCode(ctx context.Context, code, email string) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var r models.Reservation
+	query := `
+		select  r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+				r.room_id, r.created_at, r.updated_at, r.status, rm.room_name
+		  from  reservations r
+		  left
+		  join  rooms rm
+		    on  r.room_id = rm.id
+		 where  r.confirmation_code = $1 and lower(r.email) = lower($2)
+`
+	row := m.DB.QueryRowContext(ctx, query, code, email)
+	err := row.Scan(&r.ID, &r.ConfirmationCode, &r.FirstName, &r.LastName, &r.Email, &r.Phone, &r.StartDate, &r.EndDate,
+		&r.RoomId, &r.CreatedAt, &r.UpdatedAt, &r.Status, &r.Room.RoomName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, repository.ErrReservationNotFound
+	}
+	r.Room.ID = r.RoomId
+	return r, err
+}
+
+// GetReservationByReference returns the reservation an API client refers to by its reference token.
+// It returns ErrReservationNotFound if there is no such reservation
+func (m *postgresDBRepo) GetReservationByReference(ctx context.Context, reference string) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var r models.Reservation
+	query := `
+		select  r.id, r.confirmation_code, r.reference, r.first_name, r.last_name, r.email, r.phone,
+				r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at, r.status, rm.room_name
+		  from  reservations r
+		  left
+		  join  rooms rm
+		    on  r.room_id = rm.id
+		 where  r.reference = $1
+`
+	row := m.DB.QueryRowContext(ctx, query, reference)
+	err := row.Scan(&r.ID, &r.ConfirmationCode, &r.Reference, &r.FirstName, &r.LastName, &r.Email, &r.Phone,
+		&r.StartDate, &r.EndDate, &r.RoomId, &r.CreatedAt, &r.UpdatedAt, &r.Status, &r.Room.RoomName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, repository.ErrReservationNotFound
+	}
+	r.Room.ID = r.RoomId
+	return r, err
+}
+
+// UpdateReservation updates reservation in the database. If the dates or the room have changed,
+// availability is re-checked (ignoring the reservation's own restriction) and its room restriction
+// is moved within the same transaction. repository.ErrRoomNotAvailable is returned if the new
+// stay overlaps another reservation or block
+func (m *postgresDBRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := selectReservationForUpdate(ctx, tx, r.ID)
+	if err != nil {
+		return err
+	}
+
+	stayChanged := !current.StartDate.Equal(r.StartDate) || !current.EndDate.Equal(r.EndDate) || current.RoomId != r.RoomId
+	if stayChanged {
+		// lock the room row so that concurrent bookings of the same room are serialized
+		var roomID int
+		err = tx.QueryRowContext(ctx, "select id from rooms where id = $1 for update", r.RoomId).Scan(&roomID)
+		if err != nil {
+			return err
+		}
+
+		var numRows int
+		query := `
+			select  count(id)
+			  from  room_restrictions rr
+			 where  room_id = $1 and $2 < rr.end_date and $3 > start_date
+			   and  (reservation_id is null or reservation_id <> $4)
+		`
+		err = tx.QueryRowContext(ctx, query, r.RoomId, r.StartDate, r.EndDate, r.ID).Scan(&numRows)
+		if err != nil {
+			return err
+		}
+		if numRows > 0 {
+			return repository.ErrRoomNotAvailable
+		}
+	}
+
+	query := `
+		update  reservations
+		   set  first_name = $1,
+		   		last_name = $2,
+				email = $3,
+				phone = $4,
+				start_date = $5,
+				end_date = $6,
+				room_id = $7,
+				updated_at = $8
+		 where  id = $9
+	`
+	_, err = tx.ExecContext(ctx, query, r.FirstName, r.LastName, r.Email, r.Phone,
+		r.StartDate, r.EndDate, r.RoomId, time.Now(), r.ID)
+	if err != nil {
+		return err
+	}
+
+	if stayChanged {
+		stmt := `
+			update  room_restrictions
+			   set  start_date = $1,
+			        end_date = $2,
+			        room_id = $3,
+			        updated_at = $4
+			 where  reservation_id = $5
+		`
+		_, err = tx.ExecContext(ctx, stmt, r.StartDate, r.EndDate, r.RoomId, time.Now(), r.ID)
+		if err != nil {
+			if isExclusionViolation(err) {
+				return repository.ErrRoomNotAvailable
+			}
+			return err
+		}
+	}
+
+	r.Status = current.Status
+	err = insertAudit(ctx, tx, models.AuditUpdate, models.AuditReservation, r.ID,
+		reservationSnapshot(current), reservationSnapshot(r))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// selectReservationForUpdate reads the reservation within the transaction and locks its row
+func selectReservationForUpdate(ctx context.Context, tx *sql.Tx, id int) (models.Reservation, error) {
+	r := models.Reservation{ID: id}
+	query := `
+		select  first_name, last_name, email, phone, start_date, end_date, room_id, status
+		  from  reservations
+		 where  id = $1
+		   for  update
+	`
+	err := tx.QueryRowContext(ctx, query, id).Scan(&r.FirstName, &r.LastName, &r.Email, &r.Phone,
+		&r.StartDate, &r.EndDate, &r.RoomId, &r.Status)
+	return r, err
+}
+
+// DeleteReservation deletes one reservation from the DB by id
+func (m *postgresDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	r, err := selectReservationForUpdate(ctx, tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	query := `
+		delete  
+		  from  reservations
+		 where  id = $1
+	`
+	_, err = tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	err = insertAudit(ctx, tx, models.AuditDelete, models.AuditReservation, id, reservationSnapshot(r), nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateReservationStatus moves reservation to the new status if the lifecycle allows it and
+// records the change in the status history. Cancelled reservations release their room restrictions.
+// The mails are queued in the same transaction, so they are only sent if the status has changed
+func (m *postgresDBRepo) UpdateReservationStatus(ctx context.Context, id int, status models.ReservationStatus, userID int, mails ...models.MailData) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current models.ReservationStatus
+	err = tx.QueryRowContext(ctx, "select status from reservations where id = $1 for update", id).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if !current.CanTransitionTo(status) {
+		return fmt.Errorf("%w: from %q to %q", repository.ErrInvalidStatusTransition, current, status)
+	}
+
+	query := `
+		update  reservations
+		   set  status = $2,
+		        updated_at = $3
+		 where  id = $1
+	`
+	_, err = tx.ExecContext(ctx, query, id, status, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = insertStatusChange(ctx, tx, id, current, status, userID)
+	if err != nil {
+		return err
+	}
+
+	if status == models.StatusCancelled {
+		_, err = tx.ExecContext(ctx, "delete from room_restrictions where reservation_id = $1", id)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertAudit(ctx, tx, models.AuditChangeStatus, models.AuditReservation, id,
+		auditStatus{current}, auditStatus{status})
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range mails {
+		if err = insertMail(ctx, tx, msg); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// insertStatusChange adds a record to reservation status history within the transaction;
+// zero userID means the change has not been made by a user (e.g. by the guest)
+func insertStatusChange(ctx context.Context, tx *sql.Tx, reservationID int, from, to models.ReservationStatus, userID int) error {
+	stmt := `
+		insert into reservation_status_history (reservation_id, from_status, to_status, user_id, created_at, updated_at)
+			values ($1, nullif($2, ''), $3, nullif($4, 0), $5, $5)
+	`
+	_, err := tx.ExecContext(ctx, stmt, reservationID, string(from), to, userID, time.Now())
+	return err
+}
+
+// GetReservationStatusHistory returns status changes of the reservation, oldest first
+func (m *postgresDBRepo) GetReservationStatusHistory(ctx context.Context, id int) ([]models.ReservationStatusChange, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var history []models.ReservationStatusChange
+	query := `
+		select  h.id, h.reservation_id, coalesce(h.from_status, ''), h.to_status, coalesce(h.user_id, 0),
+		        h.created_at, h.updated_at, coalesce(u.first_name, ''), coalesce(u.last_name, '')
+		  from  reservation_status_history h
+		  left
+		  join  users u
+		    on  h.user_id = u.id
+		 where  h.reservation_id = $1
+		 order  by
+		        h.created_at, h.id
+	`
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h models.ReservationStatusChange
+		err = rows.Scan(&h.ID, &h.ReservationID, &h.FromStatus, &h.ToStatus, &h.UserID,
+			&h.CreatedAt, &h.UpdatedAt, &h.User.FirstName, &h.User.LastName)
+		if err != nil {
+			return history, err
+		}
+		h.User.ID = h.UserID
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+
+// RecentStatusChanges returns the latest status changes of all reservations, newest first
+func (m *postgresDBRepo) RecentStatusChanges(ctx context.Context, limit int) ([]models.ReservationStatusChange, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var history []models.ReservationStatusChange
+	query := `
+		select  h.id, h.reservation_id, coalesce(h.from_status, ''), h.to_status, coalesce(h.user_id, 0),
+		        h.created_at, h.updated_at, coalesce(u.first_name, ''), coalesce(u.last_name, ''),
+		        r.first_name, r.last_name, r.start_date, r.end_date, r.room_id, rm.room_name
+		  from  reservation_status_history h
+		  join  reservations r
+		    on  h.reservation_id = r.id
+		  left
+		  join  rooms rm
+		    on  r.room_id = rm.id
+		  left
+		  join  users u
+		    on  h.user_id = u.id
+		 order  by
+		        h.created_at desc, h.id desc
+		 limit  $1
+	`
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h models.ReservationStatusChange
+		err = rows.Scan(&h.ID, &h.ReservationID, &h.FromStatus, &h.ToStatus, &h.UserID,
+			&h.CreatedAt, &h.UpdatedAt, &h.User.FirstName, &h.User.LastName,
+			&h.Reservation.FirstName, &h.Reservation.LastName, &h.Reservation.StartDate, &h.Reservation.EndDate,
+			&h.Reservation.RoomId, &h.Reservation.Room.RoomName)
+		if err != nil {
+			return history, err
+		}
+		h.User.ID = h.UserID
+		h.Reservation.ID = h.ReservationID
+		h.Reservation.Room.ID = h.Reservation.RoomId
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+
+// DashboardCounts counts the arrivals and departures of the day, the guests in house and the pending
+// reservations in one pass over the reservations table
+func (m *postgresDBRepo) DashboardCounts(ctx context.Context, day time.Time) (models.DashboardCounts, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var c models.DashboardCounts
+	query := `
+		select  count(*) filter (where start_date = $1 and status not in ($2, $3)),
+		        count(*) filter (where end_date = $1 and status not in ($2, $3)),
+		        count(*) filter (where status = $4),
+		        count(*) filter (where status = $5)
+		  from  reservations
+	`
+	err := m.DB.QueryRowContext(ctx, query, day, models.StatusCancelled, models.StatusNoShow,
+		models.StatusCheckedIn, models.StatusPending).
+		Scan(&c.Arrivals, &c.Departures, &c.InHouse, &c.Unprocessed)
+	return c, err
+}
+
+// Occupancy sums up the room nights booked and blocked from start up to, but not including, end
+func (m *postgresDBRepo) Occupancy(ctx context.Context, start, end time.Time) (models.Occupancy, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	o := models.Occupancy{Nights: nights(start, end)}
+	query := `
+		select  (select count(*) from rooms) * $3::int,
+		        coalesce(sum(least(end_date, $2::date) - greatest(start_date, $1::date))
+		                     filter (where reservation_id is not null), 0),
+		        coalesce(sum(least(end_date, $2::date) - greatest(start_date, $1::date))
+		                     filter (where reservation_id is null), 0)
+		  from  room_restrictions
+		 where  start_date < $2 and end_date > $1
+	`
+	err := m.DB.QueryRowContext(ctx, query, start, end, o.Nights).
+		Scan(&o.RoomNights, &o.BookedNights, &o.BlockedNights)
+	return o, err
+}
+
+// Report computes the figures of every room over every period from up to (not including) to. Nights are
+// counted day by day, so a stay spanning two periods counts in both
+func (m *postgresDBRepo) Report(ctx context.Context, from, to time.Time, grouping models.ReportGrouping) (models.Report, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	report := models.Report{Grouping: grouping, From: from, To: to}
+	query := `
+		select  date_trunc($3::text, n.night::timestamp)::date, rm.id, rm.room_name, count(*), count(b.id), count(r.id)
+		  from  (select d::date as night from generate_series($1::date, $2::date - 1, interval '1 day') d) n
+		 cross
+		  join  rooms rm
+		  left
+		  join  room_restrictions b
+		    on  b.room_id = rm.id and b.reservation_id is null
+		   and  b.start_date <= n.night and b.end_date > n.night
+		  left
+		  join  reservations r
+		    on  r.room_id = rm.id and r.status not in ($4, $5)
+		   and  r.start_date <= n.night and r.end_date > n.night
+		 group  by
+		        1, 2, 3
+		 order  by
+		        1, 2
+	`
+	rows, err := m.DB.QueryContext(ctx, query, from, to, grouping, models.StatusCancelled, models.StatusNoShow)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	type key struct {
+		period int64
+		roomID int
+	}
+	index := map[key]int{}
+	for rows.Next() {
+		var row models.ReportRow
+		err = rows.Scan(&row.Period, &row.RoomID, &row.RoomName, &row.RoomNights, &row.BlockedNights, &row.NightsSold)
+		if err != nil {
+			return report, err
+		}
+		index[key{row.Period.Unix(), row.RoomID}] = len(report.Rows)
+		report.Rows = append(report.Rows, row)
+	}
+	if err = rows.Err(); err != nil {
+		return report, err
+	}
+
+	query = `
+		select  date_trunc($3::text, start_date::timestamp)::date, room_id, count(*),
+		        count(*) filter (where status = $4),
+		        count(*) filter (where status = $5),
+		        coalesce(sum(end_date - start_date) filter (where status not in ($4, $5)), 0),
+		        count(*) filter (where status not in ($4, $5) and created_at::date <= start_date),
+		        coalesce(sum(start_date - created_at::date) filter (where status not in ($4, $5) and created_at::date <= start_date), 0)
+		  from  reservations
+		 where  start_date >= $1 and start_date < $2
+		 group  by
+		        1, 2
+	`
+	rows, err = m.DB.QueryContext(ctx, query, from, to, grouping, models.StatusCancelled, models.StatusNoShow)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var period time.Time
+		var roomID int
+		var b models.ReportRow
+		err = rows.Scan(&period, &roomID, &b.Bookings, &b.Cancelled, &b.NoShows, &b.StayNights, &b.LeadStays, &b.LeadDays)
+		if err != nil {
+			return report, err
+		}
+		// every room has a row for every period of the range, and arrivals are within the range
+		if i, ok := index[key{period.Unix(), roomID}]; ok {
+			report.Rows[i].Add(b)
+		}
+	}
+	return report, rows.Err()
+}
+
+// AllRooms returns all rooms from the database
+func (m *postgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rooms := []models.Room{}
+	query := `
+		select  ` + roomColumns + `
+		  from  rooms
+		 order  by
+		 		id asc`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanRoom(rows)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, r)
+	}
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+	return rooms, nil
+}
+
+// InsertRoom inserts a new room into the database and returns its id. It returns
+// repository.ErrDuplicateRoomSlug if another room already has the same slug
+func (m *postgresDBRepo) InsertRoom(ctx context.Context, r models.Room) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+	stmt := `
+		insert into rooms (room_name, slug, description, capacity, amenities, base_price, photos,
+			created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	err = tx.QueryRowContext(ctx, stmt, r.RoomName, r.Slug, r.Description, r.Capacity,
+		joinLines(r.Amenities), r.BasePrice, joinLines(r.Photos), time.Now(), time.Now()).Scan(&newID)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrDuplicateRoomSlug
+	}
+	if err != nil {
+		return 0, err
+	}
+	err = insertAudit(ctx, tx, models.AuditCreate, models.AuditRoom, newID, nil, roomSnapshot(r))
+	if err != nil {
+		return 0, err
+	}
+	return newID, tx.Commit()
+}
+
+// UpdateRoom updates a room in the database. It returns repository.ErrDuplicateRoomSlug
+// if another room already has the same slug
+func (m *postgresDBRepo) UpdateRoom(ctx context.Context, r models.Room) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := scanRoom(tx.QueryRowContext(ctx, "select "+roomColumns+" from rooms where id = $1 for update", r.ID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrRoomNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	query := `
+		update  rooms
+		   set  room_name = $1,
+				slug = $2,
+				description = $3,
+				capacity = $4,
+				amenities = $5,
+				base_price = $6,
+				photos = $7,
+				updated_at = $8
+		 where  id = $9
+	`
+	result, err := tx.ExecContext(ctx, query, r.RoomName, r.Slug, r.Description, r.Capacity,
+		joinLines(r.Amenities), r.BasePrice, joinLines(r.Photos), time.Now(), r.ID)
+	if isUniqueViolation(err) {
+		return repository.ErrDuplicateRoomSlug
+	}
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrRoomNotFound
+	}
+	err = insertAudit(ctx, tx, models.AuditUpdate, models.AuditRoom, r.ID, roomSnapshot(current), roomSnapshot(r))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteRoom deletes a room from the database together with its owner's blocks. Rooms that have
+// reservations are not deleted (reservations would go by cascade) and repository.ErrRoomInUse is returned
+func (m *postgresDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the room row so that no reservation can be made while it is being deleted
+	room, err := scanRoom(tx.QueryRowContext(ctx, "select "+roomColumns+" from rooms where id = $1 for update", id))
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	err = tx.QueryRowContext(ctx, "select count(id) from reservations where room_id = $1", id).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomInUse
+	}
+
+	_, err = tx.ExecContext(ctx, "delete from rooms where id = $1", id)
+	if err != nil {
+		return err
+	}
+	err = insertAudit(ctx, tx, models.AuditDelete, models.AuditRoom, id, roomSnapshot(room), nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRestrictionsForRoomByDates returns restrictions for a room by room id and dates range
+func (m *postgresDBRepo) GetRestrictionsForRoomByDates(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+	query := `
+		select  rr.id, rr.start_date, rr.end_date, rr.room_id, coalesce(rr.reservation_id, 0), rr.restriction_id,
+				rr.note, rr.created_at, rr.updated_at, r.restriction_name
+		  from  room_restrictions rr
+		  left
+		  join  restrictions r
+		    on  rr.restriction_id = r.id
+		 where  rr.room_id = $1
+		   and  rr.start_date <= $3
+		   and  rr.end_date >= $2
+		 order  by
+		 		rr.start_date asc;
+	`
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err := rows.Scan(&rr.ID, &rr.StartDate, &rr.EndDate, &rr.RoomID, &rr.ReservationID, &rr.RestrictionID,
+			&rr.Note, &rr.CreatedAt, &rr.UpdatedAt, &rr.Restriction.RestrictionName)
+		if err != nil {
+			return restrictions, err
+		}
+		rr.Restriction.ID = rr.RestrictionID
+		restrictions = append(restrictions, rr)
+	}
+	return restrictions, rows.Err()
+}
+
+// BlockRestrictions returns restriction types rooms can be blocked with, i.e. all but reservation
+func (m *postgresDBRepo) BlockRestrictions(ctx context.Context) ([]models.Restriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var restrictions []models.Restriction
+	query := `
+		select  id, restriction_name, created_at, updated_at
+		  from  restrictions
+		 where  id <> $1
+		 order  by
+		 		id asc
+	`
+	rows, err := m.DB.QueryContext(ctx, query, reservationRestrictionID)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Restriction
+		err := rows.Scan(&r.ID, &r.RestrictionName, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+	return restrictions, rows.Err()
+}
+
+// InsertBlocks adds blocks to DB in one transaction. Blocks, like reservations, cover the nights
+// from StartDate up to (not including) EndDate. If any of the rooms is already reserved or blocked
+// for some of the dates, nothing is inserted and repository.ErrRoomNotAvailable is returned
+func (m *postgresDBRepo) InsertBlocks(ctx context.Context, blocks []models.RoomRestriction) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, b := range blocks {
+		if err = insertBlock(ctx, tx, b); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertBlock inserts the block within the transaction and audits it. The room is locked and re-checked
+// first; repository.ErrRoomNotAvailable is returned if it is taken for (some of) the dates
+func insertBlock(ctx context.Context, tx *sql.Tx, b models.RoomRestriction) error {
+	// lock the room row so that concurrent bookings of the same room are serialized
+	var roomID int
+	err := tx.QueryRowContext(ctx, "select id from rooms where id = $1 for update", b.RoomID).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query := `
+		select  count(id)
+		  from  room_restrictions rr
+		 where  room_id = $1 and $2 < rr.end_date and $3 > start_date
+	`
+	err = tx.QueryRowContext(ctx, query, b.RoomID, b.StartDate, b.EndDate).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomNotAvailable
+	}
+
+	var newID int
+	stmt := `
+		insert into room_restrictions
+			(start_date, end_date, room_id, restriction_id, note, created_at, updated_at)
+				values($1, $2, $3, $4, $5, $6, $6) returning id
+	`
+	err = tx.QueryRowContext(ctx, stmt, b.StartDate, b.EndDate, b.RoomID, b.RestrictionID, b.Note, time.Now()).
+		Scan(&newID)
+	if err != nil {
+		if isExclusionViolation(err) {
+			return repository.ErrRoomNotAvailable
+		}
+		return err
+	}
+	return insertAudit(ctx, tx, models.AuditCreate, models.AuditBlock, newID, nil, blockSnapshot(b))
+}
+
+// ImportBookings inserts the reservations, in their own statuses, and the blocks in one transaction, so
+// that either all or none of them are. Reservations are inserted without mails to the guests. If any of
+// them overlap each other or what the rooms are already taken for, repository.ErrRoomNotAvailable is returned.
+// Reservations keep their CreatedAt, the day they were booked, if it is set
+func (m *postgresDBRepo) ImportBookings(ctx context.Context, reservations []models.Reservation, blocks []models.RoomRestriction, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, importTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the rooms in the order of their ids, so that imports running at once cannot deadlock
+	var roomIDs []int
+	seen := map[int]bool{}
+	for _, r := range reservations {
+		if !seen[r.RoomId] {
+			seen[r.RoomId] = true
+			roomIDs = append(roomIDs, r.RoomId)
+		}
+	}
+	for _, b := range blocks {
+		if !seen[b.RoomID] {
+			seen[b.RoomID] = true
+			roomIDs = append(roomIDs, b.RoomID)
+		}
+	}
+	sort.Ints(roomIDs)
+	for _, id := range roomIDs {
+		var roomID int
+		err = tx.QueryRowContext(ctx, "select id from rooms where id = $1 for update", id).Scan(&roomID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, res := range reservations {
+		id, err := insertReservation(ctx, tx, res, res.Status, userID)
+		if err != nil {
+			return err
+		}
+		err = insertAudit(ctx, tx, models.AuditCreate, models.AuditReservation, id, nil, reservationSnapshot(res))
+		if err != nil {
+			return err
+		}
+	}
+	for _, b := range blocks {
+		if err = insertBlock(ctx, tx, b); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveBlocks frees the rooms from blocks for the nights from start up to (not including) end,
+// all in one transaction. Blocks lying within the range are deleted, blocks crossing its boundaries
+// are shortened and a block covering the whole range is split in two. A legacy block ending on the
+// day it starts covers that one night
+func (m *postgresDBRepo) RemoveBlocks(ctx context.Context, roomIDs []int, start, end time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, roomID := range roomIDs {
+		if err = removeBlocks(ctx, tx, roomID, start, end, now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// removeBlocks frees the room from blocks for the range of nights within the transaction
+// and audits every block it deletes, shortens or splits
+func removeBlocks(ctx context.Context, tx *sql.Tx, roomID int, start, end, now time.Time) error {
+	query := `
+		select  id, start_date, end_date, restriction_id, note
+		  from  room_restrictions
+		 where  room_id = $1 and reservation_id is null
+		   and  $3 > start_date and $2 < greatest(end_date, start_date + 1)
+		   for  update
+	`
+	rows, err := tx.QueryContext(ctx, query, roomID, start, end)
+	if err != nil {
+		return err
+	}
+	var blocks []models.RoomRestriction
+	for rows.Next() {
+		b := models.RoomRestriction{RoomID: roomID}
+		err = rows.Scan(&b.ID, &b.StartDate, &b.EndDate, &b.RestrictionID, &b.Note)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		blocks = append(blocks, b)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, b := range blocks {
+		// after is what is left of the block, the part after the range if the block is split
+		after, rest := b, b
+		switch {
+		case !b.StartDate.Before(start) && !b.EndDate.After(end):
+			_, err = tx.ExecContext(ctx, "delete from room_restrictions where id = $1", b.ID)
+			if err == nil {
+				err = insertAudit(ctx, tx, models.AuditDelete, models.AuditBlock, b.ID, blockSnapshot(b), nil)
+			}
+		case b.StartDate.Before(start) && b.EndDate.After(end):
+			after.EndDate, rest.StartDate = start, end
+			_, err = tx.ExecContext(ctx, "update room_restrictions set end_date = $2, updated_at = $3 where id = $1",
+				b.ID, start, now)
+			if err == nil {
+				err = insertAudit(ctx, tx, models.AuditUpdate, models.AuditBlock, b.ID, blockSnapshot(b), blockSnapshot(after))
+			}
+			if err == nil {
+				var newID int
+				stmt := `
+					insert into room_restrictions
+						(start_date, end_date, room_id, restriction_id, note, created_at, updated_at)
+							values($1, $2, $3, $4, $5, $6, $6) returning id
+				`
+				err = tx.QueryRowContext(ctx, stmt, end, b.EndDate, roomID, b.RestrictionID, b.Note, now).Scan(&newID)
+				if err == nil {
+					err = insertAudit(ctx, tx, models.AuditCreate, models.AuditBlock, newID, nil, blockSnapshot(rest))
+				}
+			}
+		case b.StartDate.Before(start):
+			after.EndDate = start
+			_, err = tx.ExecContext(ctx, "update room_restrictions set end_date = $2, updated_at = $3 where id = $1",
+				b.ID, start, now)
+			if err == nil {
+				err = insertAudit(ctx, tx, models.AuditUpdate, models.AuditBlock, b.ID, blockSnapshot(b), blockSnapshot(after))
+			}
+		default:
+			after.StartDate = end
+			_, err = tx.ExecContext(ctx, "update room_restrictions set start_date = $2, updated_at = $3 where id = $1",
+				b.ID, end, now)
+			if err == nil {
+				err = insertAudit(ctx, tx, models.AuditUpdate, models.AuditBlock, b.ID, blockSnapshot(b), blockSnapshot(after))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteBlockByID removes block (room restriction) from the DB by ID
+func (m *postgresDBRepo) DeleteBlockByID(ctx context.Context, restrictionID int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := models.RoomRestriction{ID: restrictionID}
+	query := `
+		select  start_date, end_date, room_id, restriction_id, note
+		  from  room_restrictions
+		 where  id = $1 and reservation_id is null
+		   for  update
+	`
+	err = tx.QueryRowContext(ctx, query, restrictionID).Scan(&b.StartDate, &b.EndDate, &b.RoomID, &b.RestrictionID, &b.Note)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "delete from room_restrictions where id = $1", restrictionID)
+	if err != nil {
+		return err
+	}
+	err = insertAudit(ctx, tx, models.AuditDelete, models.AuditBlock, restrictionID, blockSnapshot(b), nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertMail adds the message to the mail outbox within the transaction
+func insertMail(ctx context.Context, tx *sql.Tx, msg models.MailData) error {
+	stmt := `
+		insert into mail_outbox (to_address, from_address, subject, content, template, status,
+			attempts, next_attempt_at, last_error, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, 0, $7, '', $7, $7)
+	`
+	_, err := tx.ExecContext(ctx, stmt, msg.To, msg.From, msg.Subject, msg.Content, msg.Template,
+		models.MailQueued, time.Now())
+	return err
+}
+
+// QueueMail adds the messages to the mail outbox for delivery
+func (m *postgresDBRepo) QueueMail(ctx context.Context, mails ...models.MailData) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, msg := range mails {
+		if err = insertMail(ctx, tx, msg); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ClaimMail picks up to limit queued messages that are due for delivery. Claimed messages count one more
+// attempt and are not due again until the lease expires, so messages of a crashed worker are retried later
+// and several workers never deliver the same message
+func (m *postgresDBRepo) ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+	query := `
+		update  mail_outbox
+		   set  attempts = attempts + 1, next_attempt_at = $2, updated_at = $3
+		 where  id in (
+		        select  id
+		          from  mail_outbox
+		         where  status = $4 and next_attempt_at <= $3
+		         order  by next_attempt_at
+		         limit  $1
+		           for  update skip locked)
+		returning id, to_address, from_address, subject, content, template, status, attempts,
+		        next_attempt_at, last_error, created_at, updated_at
+	`
+	rows, err := m.DB.QueryContext(ctx, query, limit, now.Add(lease), now, models.MailQueued)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.OutboxMessage
+	for rows.Next() {
+		var msg models.OutboxMessage
+		err = rows.Scan(&msg.ID, &msg.To, &msg.From, &msg.Subject, &msg.Content, &msg.Template, &msg.Status,
+			&msg.Attempts, &msg.NextAttemptAt, &msg.LastError, &msg.CreatedAt, &msg.UpdatedAt)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// MarkMailSent records the successful delivery of the message
+func (m *postgresDBRepo) MarkMailSent(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update mail_outbox set status = $1, last_error = '', sent_at = $2, updated_at = $2 where id = $3`
+	_, err := m.DB.ExecContext(ctx, stmt, models.MailSent, time.Now(), id)
+	return err
+}
+
+// RescheduleMail records the failed delivery attempt and schedules the next one
+func (m *postgresDBRepo) RescheduleMail(ctx context.Context, id int, lastError string, at time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update mail_outbox set last_error = $1, next_attempt_at = $2, updated_at = $3 where id = $4`
+	_, err := m.DB.ExecContext(ctx, stmt, lastError, at, time.Now(), id)
+	return err
+}
+
+// MarkMailFailed records the failed delivery attempt and gives up on the message
+func (m *postgresDBRepo) MarkMailFailed(ctx context.Context, id int, lastError string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update mail_outbox set status = $1, last_error = $2, updated_at = $3 where id = $4`
+	_, err := m.DB.ExecContext(ctx, stmt, models.MailFailed, lastError, time.Now(), id)
+	return err
+}
+
+// PendingMail returns queued and failed messages of the mail outbox, newest first
+func (m *postgresDBRepo) PendingMail(ctx context.Context) ([]models.OutboxMessage, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 
 	query := `
-		delete  from room_restrictions
-		 where  id = $1;
+		select  id, to_address, from_address, subject, content, template, status, attempts,
+		        next_attempt_at, last_error, created_at, updated_at
+		  from  mail_outbox
+		 where  status <> $1
+		 order  by created_at desc
+	`
+	rows, err := m.DB.QueryContext(ctx, query, models.MailSent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.OutboxMessage
+	for rows.Next() {
+		var msg models.OutboxMessage
+		err = rows.Scan(&msg.ID, &msg.To, &msg.From, &msg.Subject, &msg.Content, &msg.Template, &msg.Status,
+			&msg.Attempts, &msg.NextAttemptAt, &msg.LastError, &msg.CreatedAt, &msg.UpdatedAt)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// ResendMail queues the unsent message for immediate delivery with a fresh number of attempts.
+// It returns ErrMailNotFound if there is no such message or it has already been sent
+func (m *postgresDBRepo) ResendMail(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+		update  mail_outbox
+		   set  status = $1, attempts = 0, next_attempt_at = $2, updated_at = $2
+		 where  id = $3 and status <> $4
+	`
+	result, err := tx.ExecContext(ctx, stmt, models.MailQueued, time.Now(), id, models.MailSent)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrMailNotFound
+	}
+	if err := insertAudit(ctx, tx, models.AuditResend, models.AuditMail, id, nil, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertAudit writes the audit log entry of a change within the transaction making it, so the change
+// and its record are committed together. Changes not made in the back office are not audited
+func insertAudit(ctx context.Context, tx *sql.Tx, action, entity string, entityID int, before, after any) error {
+	e, ok, err := newAuditEntry(ctx, action, entity, entityID, before, after)
+	if err != nil || !ok {
+		return err
+	}
+	stmt := `
+		insert into audit_log (user_id, action, entity, entity_id, before_data, after_data, ip, user_agent,
+			method, path, created_at)
+			values ($1, $2, $3, $4, nullif($5, '')::jsonb, nullif($6, '')::jsonb, $7, $8, $9, $10, $11)
 	`
-	_, err := m.DB.ExecContext(ctx, query, restrictionID)
+	_, err = tx.ExecContext(ctx, stmt, e.Actor.UserID, e.Action, e.Entity, e.EntityID, e.Before, e.After,
+		e.Actor.IP, e.Actor.UserAgent, e.Actor.Method, e.Actor.Path, e.CreatedAt)
 	return err
 }
+
+// AuditLog returns the audit log entries selected by the filter, newest first
+func (m *postgresDBRepo) AuditLog(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select  a.id, a.user_id, a.action, a.entity, a.entity_id, coalesce(a.before_data::text, ''),
+				coalesce(a.after_data::text, ''), a.ip, a.user_agent, a.method, a.path, a.created_at,
+				coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(u.email, '')
+		  from  audit_log a
+		  left
+		  join  users u
+		    on  a.user_id = u.id
+		 where  ($1 = 0 or a.user_id = $1)
+		   and  ($2 = '' or a.entity = $2)
+		   and  ($3::timestamp is null or a.created_at >= $3)
+		   and  ($4::timestamp is null or a.created_at < $4)
+		 order  by
+				a.created_at desc, a.id desc
+		 limit  nullif($5, 0)
+	`
+	from := sql.NullTime{Time: filter.From, Valid: !filter.From.IsZero()}
+	to := sql.NullTime{Time: filter.To, Valid: !filter.To.IsZero()}
+	rows, err := m.DB.QueryContext(ctx, query, filter.UserID, filter.Entity, from, to, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(&e.ID, &e.Actor.UserID, &e.Action, &e.Entity, &e.EntityID, &e.Before, &e.After,
+			&e.Actor.IP, &e.Actor.UserAgent, &e.Actor.Method, &e.Actor.Path, &e.CreatedAt,
+			&e.User.FirstName, &e.User.LastName, &e.User.Email)
+		if err != nil {
+			return nil, err
+		}
+		if e.User.Email != "" {
+			e.User.ID = e.Actor.UserID
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
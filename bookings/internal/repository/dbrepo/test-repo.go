@@ -1,32 +1,38 @@
 package dbrepo
 
 import (
+	"context"
 	"errors"
+	"net/http"
 	"time"
 
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/repository"
+	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/twofactor"
 )
 
-// InsertReservation inserts reservation into database
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
-	// if the room_id is 2 then fail, otherwise pass
+// CreateReservation re-checks availability of the room and inserts the reservation together
+// with its room restriction in one transaction
+func (m *testDBRepo) CreateReservation(ctx context.Context, res models.Reservation, mails []models.MailData) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	// if the room_id is 2 then fail, if it is 1000 or the guest is "Taken" then the room is taken, otherwise pass
 	if res.RoomId == 2 {
 		return 0, errors.New("test DB error")
 	}
-	return 1, nil
-}
-
-// InsertRoomRestriction inserts a room restriction into the database
-func (m testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	if r.RoomID == 1000 {
-		return errors.New("test DB error")
+	if res.RoomId == 1000 || res.LastName == "Taken" {
+		return 0, repository.ErrRoomNotAvailable
 	}
-	return nil
+	return 1, nil
 }
 
 // SearchAvailabilityByDatesAndRoomID returns true if room is available for the called period of time
 // and false otherwise
-func (m *testDBRepo) SearchAvailabilityByDatesAndRoomID(start, end time.Time, roomID int) (bool, error) {
+func (m *testDBRepo) SearchAvailabilityByDatesAndRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	if roomID > 2 {
 		return false, errors.New("test DB error")
 	}
@@ -38,7 +44,10 @@ func (m *testDBRepo) SearchAvailabilityByDatesAndRoomID(start, end time.Time, ro
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms for a range of dates,
 // if any
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	var result []models.Room
 	if start == time.Date(2023, 01, 01, 0, 0, 0, 0, time.UTC) {
 		return result, errors.New("test DB error")
@@ -50,7 +59,10 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 }
 
 // GetRoomByID gets a room from DB by id
-func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
+func (m *testDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Room{}, err
+	}
 	var room models.Room
 	if id > 2 {
 		return room, errors.New("test DB error")
@@ -58,97 +70,740 @@ func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 	return room, nil
 }
 
-// GetUserById returns a user by id
-func (m *testDBRepo) GetUserById(id int) (models.User, error) {
-	var u models.User
-	return u, nil
+// GetRoomBySlug gets a room from DB by its slug
+func (m *testDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Room{}, err
+	}
+	switch slug {
+	case "missing":
+		return models.Room{}, repository.ErrRoomNotFound
+	case "error":
+		return models.Room{}, errors.New("test DB error")
+	}
+	return models.Room{ID: 1, RoomName: "General's Quoters", Slug: slug, Capacity: 2, BasePrice: 12900}, nil
+}
+
+// TestTwoFactorSecret is the TOTP secret of test user 3, who has two-factor authentication enabled
+const TestTwoFactorSecret = "JBSWY3DPEHPK3PXP"
+
+// TestRecoveryCode is the only unused recovery code of test user 3
+const TestRecoveryCode = "ABCDE-FGHJK"
+
+// GetUserById returns a user by id. User 1 is the owner, user 3 is a manager with two-factor authentication,
+// 423 is locked out, 404 is not found and 500 fails
+func (m *testDBRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	switch id {
+	case 1:
+		return models.User{ID: 1, FirstName: "Admin", LastName: "Admin", Email: "me@here.ca",
+			AccessLevel: int(models.RoleOwner), Active: true}, nil
+	case 3:
+		return models.User{ID: 3, FirstName: "Jane", LastName: "Doe", Email: "2fa@here.ca",
+			AccessLevel: int(models.RoleManager), Active: true, TwoFactorSecret: TestTwoFactorSecret}, nil
+	case 404:
+		return models.User{}, repository.ErrUserNotFound
+	case 423:
+		return models.User{ID: 423, FirstName: "Jack", LastName: "Locked", Email: "locked@here.ca",
+			AccessLevel: int(models.RoleViewer), Active: true}, nil
+	case 500:
+		return models.User{}, errors.New("error fetching user")
+	}
+	return models.User{ID: id, FirstName: "John", LastName: "Smith", Email: "john@smith.com",
+		AccessLevel: int(models.RoleViewer), Active: true}, nil
+}
+
+// GetUserByEmail returns a user by email. Email "me@here.ca" is user 1, "john@smith.com" is user 2,
+// "2fa@here.ca" is user 3 and "error@here.ca" fails
+func (m *testDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	switch email {
+	case "me@here.ca":
+		return m.GetUserById(ctx, 1)
+	case "john@smith.com":
+		return m.GetUserById(ctx, 2)
+	case "2fa@here.ca":
+		return m.GetUserById(ctx, 3)
+	case "error@here.ca":
+		return models.User{}, errors.New("error fetching user")
+	}
+	return models.User{}, repository.ErrUserNotFound
+}
+
+// AllUsers returns all users
+func (m *testDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if *m.FetchError {
+		return nil, errors.New("error fetching users")
+	}
+	owner, _ := m.GetUserById(ctx, 1)
+	viewer, _ := m.GetUserById(ctx, 2)
+	return []models.User{owner, viewer}, nil
+}
+
+// InsertUser inserts a new user. Email "taken@here.ca" is a duplicate and first name "error" fails
+func (m *testDBRepo) InsertUser(ctx context.Context, u models.User, password string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if u.Email == "taken@here.ca" {
+		return 0, repository.ErrDuplicateEmail
+	}
+	if u.FirstName == "error" {
+		return 0, errors.New("error inserting user")
+	}
+	return 3, nil
 }
 
-// UpdateUser updates a user in the database
-func (m *testDBRepo) UpdateUser(u models.User) error {
+// UpdateUser updates a user in the database. Email "taken@here.ca" is a duplicate, first name "error" fails
+// and user 404 is not found
+func (m *testDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if u.Email == "taken@here.ca" {
+		return repository.ErrDuplicateEmail
+	}
+	if u.FirstName == "error" {
+		return errors.New("error updating user")
+	}
+	if u.ID == 404 {
+		return repository.ErrUserNotFound
+	}
 	return nil
 }
 
-// Authenticate authenticates the user
-func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	if email == "me@here.ca" {
-		return 1, "", nil
+// DeleteUser deletes a user. User 404 is not found and 500 fails
+func (m *testDBRepo) DeleteUser(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return 0, "", errors.New("invalid login")
+	return testUserResult(id)
 }
 
-// AllReservations returns a slice of all reservations
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
-	var reservations []models.Reservation
-	if !*m.FetchError {
-		return reservations, nil
+// ForcePasswordReset makes the user set a new password. User 404 is not found and 500 fails
+func (m *testDBRepo) ForcePasswordReset(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return reservations, errors.New("error fetching reservations")
+	return testUserResult(id)
 }
 
-// NewReservations returns a slice of new reservations
-func (m *testDBRepo) NewReservations() ([]models.Reservation, error) {
-	var reservations []models.Reservation
-	if !*m.FetchError {
-		return reservations, nil
+// UpdatePassword sets a new password of the user. User 404 is not found and 500 fails
+func (m *testDBRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return testUserResult(id)
+}
+
+func testUserResult(id int) error {
+	switch id {
+	case 404:
+		return repository.ErrUserNotFound
+	case 500:
+		return errors.New("error changing user")
+	}
+	return nil
+}
+
+// Authenticate authenticates the user. Only "me@here.ca" and "2fa@here.ca" can log in, with any password
+// except "wrong". User "locked@here.ca" is locked out
+func (m *testDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
+	if email == "locked@here.ca" {
+		return 0, "", repository.ErrAccountLocked
+	}
+	if testPassword != "wrong" {
+		switch email {
+		case "me@here.ca":
+			return 1, "", nil
+		case "2fa@here.ca":
+			return 3, "", nil
+		}
+	}
+	return 0, "", repository.ErrInvalidCredentials
+}
+
+// RecordFailedLogin records the failed login. Email "error@here.ca" fails
+func (m *testDBRepo) RecordFailedLogin(ctx context.Context, a models.LoginAttempt, policy models.LoginPolicy) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if a.Email == "error@here.ca" {
+		return errors.New("error recording login")
+	}
+	return nil
+}
+
+// LoginFailuresFromIP returns the failed logins from the IP address. Address "203.0.113.1" has just been
+// locked out, "203.0.113.2" fails and the others have none
+func (m *testDBRepo) LoginFailuresFromIP(ctx context.Context, ip string, since time.Time) (int, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, time.Time{}, err
+	}
+	switch ip {
+	case "203.0.113.1":
+		return 1000, time.Now(), nil
+	case "203.0.113.2":
+		return 0, time.Time{}, errors.New("error fetching login attempts")
+	}
+	return 0, time.Time{}, nil
+}
+
+// UnlockUser lifts the lockout of the user. User 404 is not found and 500 fails
+func (m *testDBRepo) UnlockUser(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return testUserResult(id)
+}
+
+// EnableTwoFactor enables two-factor authentication of the user. User 404 is not found and 500 fails
+func (m *testDBRepo) EnableTwoFactor(ctx context.Context, id int, secret string, recoveryCodeHashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return testUserResult(id)
+}
+
+// DisableTwoFactor disables two-factor authentication of the user. User 404 is not found and 500 fails
+func (m *testDBRepo) DisableTwoFactor(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return testUserResult(id)
+}
+
+// UseTOTPStep remembers the time step of the one-time password. Steps before the current one have been used
+func (m *testDBRepo) UseTOTPStep(ctx context.Context, id int, step int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if step < twofactor.Step(time.Now()) {
+		return repository.ErrCodeAlreadyUsed
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code as used. Only TestRecoveryCode is valid
+func (m *testDBRepo) UseRecoveryCode(ctx context.Context, id int, codeHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if codeHash != twofactor.HashRecoveryCode(TestRecoveryCode) {
+		return repository.ErrInvalidRecoveryCode
+	}
+	return nil
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes. User 500 fails
+func (m *testDBRepo) RecoveryCodesLeft(ctx context.Context, id int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if id == 500 {
+		return 0, errors.New("error counting recovery codes")
+	}
+	return 1, nil
+}
+
+// SearchReservations returns a page of the two reservations of John and Jane Smith that match the filter
+func (m *testDBRepo) SearchReservations(ctx context.Context, filter models.ReservationFilter) (models.ReservationPage, error) {
+	if err := ctx.Err(); err != nil {
+		return models.ReservationPage{}, err
+	}
+	page := models.ReservationPage{Filter: filter}
+	if *m.FetchError {
+		return page, errors.New("error fetching reservations")
+	}
+	for _, r := range testSmithReservations() {
+		if filter.Matches(r) {
+			page.Total++
+			if page.Total > filter.Offset() && (filter.PageSize == 0 || len(page.Reservations) < filter.PageSize) {
+				page.Reservations = append(page.Reservations, r)
+			}
+		}
+	}
+	return page, nil
+}
+
+// ExportReservations calls fn with the reservations of John and Jane Smith that match the filter
+func (m *testDBRepo) ExportReservations(ctx context.Context, filter models.ReservationFilter, fn func(models.Reservation) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if *m.FetchError {
+		return errors.New("error exporting reservations")
+	}
+	for _, r := range testSmithReservations() {
+		if filter.Matches(r) {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// testSmithReservations returns the reservations of Jane and John Smith, in this order
+func testSmithReservations() []models.Reservation {
+	return []models.Reservation{
+		{ID: 2, ConfirmationCode: "JANE2060", FirstName: "Jane", LastName: "Smith", Email: "jane@smith.com", Phone: "555-0102",
+			StartDate: time.Date(2060, 1, 20, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2060, 1, 22, 0, 0, 0, 0, time.UTC),
+			RoomId: 2, Status: models.StatusConfirmed, Room: models.Room{ID: 2, RoomName: "Major's Suite"},
+			CreatedAt: time.Date(2059, 12, 1, 9, 30, 0, 0, time.UTC)},
+		{ID: 1, ConfirmationCode: "JOHN2060", FirstName: "John", LastName: "Smith", Email: "john@smith.com", Phone: "555-0101",
+			StartDate: time.Date(2060, 1, 10, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2060, 1, 12, 0, 0, 0, 0, time.UTC),
+			RoomId: 1, Status: models.StatusPending, Room: models.Room{ID: 1, RoomName: "General's Quoters"},
+			CreatedAt: time.Date(2059, 12, 2, 14, 0, 0, 0, time.UTC)},
 	}
-	return reservations, errors.New("error fetching reservations")
 }
 
 // GetReservationByID gets reservation from the DB by ID
-func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+func (m *testDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Reservation{}, err
+	}
 	var reservation models.Reservation
 	if *m.FetchError {
 		return reservation, errors.New("error fetching reservation")
 	}
-	return reservation, nil
+	return testReservation(id), nil
+}
+
+// testReservation returns a confirmed reservation starting in a month. Reservation 3 starts
+// tomorrow and reservation 4 is already cancelled
+func testReservation(id int) models.Reservation {
+	start := time.Now().AddDate(0, 0, 30)
+	status := models.StatusConfirmed
+	switch id {
+	case 3:
+		start = time.Now().AddDate(0, 0, 1)
+	case 4:
+		status = models.StatusCancelled
+	}
+	return models.Reservation{
+		ID:               id,
+		ConfirmationCode: "ABCDE12345",
+		FirstName:        "John",
+		LastName:         "Smith",
+		Email:            "john@smith.com",
+		Phone:            "1234567890",
+		StartDate:        start,
+		EndDate:          start.AddDate(0, 0, 2),
+		RoomId:           1,
+		Status:           status,
+	}
+}
+
+// GetReservationByConfirmationCode returns the reservation a guest is looking for
+func (m *testDBRepo) GetReservationByConfirmationCode(ctx context.Context, code, email string) (models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Reservation{}, err
+	}
+	switch code {
+	case "NOTFOUND00":
+		return models.Reservation{}, repository.ErrReservationNotFound
+	case "ERROR00000":
+		return models.Reservation{}, errors.New("error fetching reservation")
+	}
+	return testReservation(1), nil
+}
+
+// GetReservationByReference returns the reservation an API client refers to. Reference "tomorrow"
+// returns reservation 3 and "cancelled" returns reservation 4
+func (m *testDBRepo) GetReservationByReference(ctx context.Context, reference string) (models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Reservation{}, err
+	}
+	var res models.Reservation
+	switch reference {
+	case "missing":
+		return res, repository.ErrReservationNotFound
+	case "error":
+		return res, errors.New("error fetching reservation")
+	case "tomorrow":
+		res = testReservation(3)
+	case "cancelled":
+		res = testReservation(4)
+	case "invalid-transition":
+		res = testReservation(200)
+	case "cancel-error":
+		res = testReservation(100)
+	default:
+		res = testReservation(1)
+	}
+	res.Reference = reference
+	return res, nil
 }
 
 // UpdateReservation updates reservation in the database
-func (m *testDBRepo) UpdateReservation(u models.Reservation) error {
+func (m *testDBRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if u.FirstName == "error" {
 		return errors.New("error updating reservation")
 	}
+	if u.RoomId == 1000 {
+		return repository.ErrRoomNotAvailable
+	}
 	return nil
 }
 
 // DeleteReservation deletes one reservation from the DB by id
-func (m *testDBRepo) DeleteReservation(id int) error {
+func (m *testDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if id == 100 {
 		return errors.New("error deleting reservation")
 	}
 	return nil
 }
 
-// UpdateProcessedForReservation updates the processed field (status) of reservation by ID
-func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
+// UpdateReservationStatus moves reservation to the new status if the lifecycle allows it and
+// records the change in the status history
+func (m *testDBRepo) UpdateReservationStatus(ctx context.Context, id int, status models.ReservationStatus, userID int, mails ...models.MailData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if id == 100 {
 		return errors.New("error updating reservation")
 	}
+	if id == 200 {
+		return repository.ErrInvalidStatusTransition
+	}
 	return nil
 }
 
+// GetReservationStatusHistory returns status changes of the reservation, oldest first
+func (m *testDBRepo) GetReservationStatusHistory(ctx context.Context, id int) ([]models.ReservationStatusChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var history []models.ReservationStatusChange
+	if *m.FetchError {
+		return history, errors.New("error fetching status history")
+	}
+	return history, nil
+}
+
+// RecentStatusChanges returns one reservation made by a guest and confirmed by the owner
+func (m *testDBRepo) RecentStatusChanges(ctx context.Context, limit int) ([]models.ReservationStatusChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if *m.FetchError {
+		return nil, errors.New("error fetching status history")
+	}
+	res := models.Reservation{ID: 1, FirstName: "John", LastName: "Smith", RoomId: 1,
+		Room: models.Room{ID: 1, RoomName: "General's Quoters"}}
+	now := time.Now()
+	history := []models.ReservationStatusChange{
+		{ID: 2, ReservationID: 1, FromStatus: models.StatusPending, ToStatus: models.StatusConfirmed, UserID: 1,
+			CreatedAt: now, UpdatedAt: now, User: models.User{ID: 1, FirstName: "Admin", LastName: "Admin"}, Reservation: res},
+		{ID: 1, ReservationID: 1, ToStatus: models.StatusPending, CreatedAt: now, UpdatedAt: now, Reservation: res},
+	}
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	return history, nil
+}
+
+// DashboardCounts returns 2 arrivals, 1 departure, 3 reservations in house and 4 pending ones
+func (m *testDBRepo) DashboardCounts(ctx context.Context, day time.Time) (models.DashboardCounts, error) {
+	if err := ctx.Err(); err != nil {
+		return models.DashboardCounts{}, err
+	}
+	if *m.FetchError {
+		return models.DashboardCounts{}, errors.New("error counting reservations")
+	}
+	return models.DashboardCounts{Arrivals: 2, Departures: 1, InHouse: 3, Unprocessed: 4}, nil
+}
+
+// Occupancy returns two rooms, one of them booked for all the nights
+func (m *testDBRepo) Occupancy(ctx context.Context, start, end time.Time) (models.Occupancy, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Occupancy{}, err
+	}
+	if *m.FetchError {
+		return models.Occupancy{}, errors.New("error computing occupancy")
+	}
+	n := nights(start, end)
+	return models.Occupancy{Nights: n, RoomNights: 2 * n, BookedNights: n}, nil
+}
+
+// Report returns the figures of both rooms over the first period of the range
+func (m *testDBRepo) Report(ctx context.Context, from, to time.Time, grouping models.ReportGrouping) (models.Report, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Report{}, err
+	}
+	if *m.FetchError {
+		return models.Report{}, errors.New("error computing report")
+	}
+	period := grouping.PeriodStart(from)
+	return models.Report{Grouping: grouping, From: from, To: to, Rows: []models.ReportRow{
+		{Period: period, RoomID: 1, RoomName: "General's Quoters", RoomNights: 10, NightsSold: 5,
+			Bookings: 4, Cancelled: 1, NoShows: 1, StayNights: 5, LeadDays: 20, LeadStays: 2},
+		{Period: period, RoomID: 2, RoomName: "Major's Suite", RoomNights: 10, BlockedNights: 2, NightsSold: 2,
+			Bookings: 1, StayNights: 2, LeadDays: 3, LeadStays: 1},
+	}}, nil
+}
+
 // AllRooms returns all rooms from the database
-func (m *testDBRepo) AllRooms() ([]models.Room, error) {
+func (m *testDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	var rooms []models.Room
 	if *m.FetchError {
 		return rooms, errors.New("error fetching rooms")
 	}
+	rooms = append(rooms,
+		models.Room{ID: 1, RoomName: "General's Quoters", Slug: "generals-quoters"},
+		models.Room{ID: 2, RoomName: "Major's Suite", Slug: "majors-suite"},
+	)
 	return rooms, nil
 }
 
-// GetRestrictionsForRoomByDates returns restrictions for a room by room id and dates range
-func (m *testDBRepo) GetRestrictionsForRoomByDates(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+// InsertRoom inserts a new room into the database and returns its id
+func (m *testDBRepo) InsertRoom(ctx context.Context, r models.Room) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if r.RoomName == "error" {
+		return 0, errors.New("error inserting room")
+	}
+	if r.Slug == "duplicate" {
+		return 0, repository.ErrDuplicateRoomSlug
+	}
+	return 3, nil
+}
+
+// UpdateRoom updates a room in the database
+func (m *testDBRepo) UpdateRoom(ctx context.Context, r models.Room) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r.RoomName == "error" {
+		return errors.New("error updating room")
+	}
+	if r.Slug == "duplicate" {
+		return repository.ErrDuplicateRoomSlug
+	}
+	if r.ID == 404 {
+		return repository.ErrRoomNotFound
+	}
+	return nil
+}
+
+// DeleteRoom deletes a room from the database
+func (m *testDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	switch id {
+	case 100:
+		return errors.New("error deleting room")
+	case 200:
+		return repository.ErrRoomInUse
+	}
+	return nil
+}
+
+// GetRestrictionsForRoomByDates returns restrictions for a room by room id and dates range.
+// Room 1 has a reservation for the first three nights of the range and a block for the next two
+func (m *testDBRepo) GetRestrictionsForRoomByDates(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	var restrictions []models.RoomRestriction
+	if roomID == 1 {
+		restrictions = append(restrictions,
+			models.RoomRestriction{ID: 1, RoomID: 1, ReservationID: 10, RestrictionID: 1,
+				StartDate: start, EndDate: start.AddDate(0, 0, 3)},
+			models.RoomRestriction{ID: 2, RoomID: 1, RestrictionID: 3, Note: "Broken boiler",
+				StartDate: start.AddDate(0, 0, 3), EndDate: start.AddDate(0, 0, 5),
+				Restriction: models.Restriction{ID: 3, RestrictionName: "Maintenance"}},
+		)
+	}
 	return restrictions, nil
 }
 
-// InsertBlockForRoom adds block to DB for the room on the date
-func (m *testDBRepo) InsertBlockForRoom(roomID int, startDate time.Time) error {
+// BlockRestrictions returns restriction types rooms can be blocked with
+func (m *testDBRepo) BlockRestrictions(ctx context.Context) ([]models.Restriction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if *m.FetchError {
+		return nil, errors.New("error fetching restrictions")
+	}
+	return []models.Restriction{{ID: 2, RestrictionName: "Owners' Block"}, {ID: 3, RestrictionName: "Maintenance"}}, nil
+}
+
+// InsertBlocks adds blocks to DB
+func (m *testDBRepo) InsertBlocks(ctx context.Context, blocks []models.RoomRestriction) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		if b.Note == "error" {
+			return errors.New("error inserting blocks")
+		}
+		if b.RoomID == 2 && b.Note == "taken" {
+			return repository.ErrRoomNotAvailable
+		}
+	}
+	return nil
+}
+
+// ImportBookings imports the reservations and blocks. A reservation of the guest named "error" fails
+// and one named "taken" finds its room taken
+func (m *testDBRepo) ImportBookings(ctx context.Context, reservations []models.Reservation, blocks []models.RoomRestriction, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, r := range reservations {
+		if r.LastName == "error" {
+			return errors.New("error importing reservations")
+		}
+		if r.LastName == "taken" {
+			return repository.ErrRoomNotAvailable
+		}
+	}
+	return nil
+}
+
+// RemoveBlocks frees the rooms from blocks for the range of nights
+func (m *testDBRepo) RemoveBlocks(ctx context.Context, roomIDs []int, start, end time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, roomID := range roomIDs {
+		if roomID == 100 {
+			return errors.New("error removing blocks")
+		}
+	}
 	return nil
 }
 
 // DeleteBlockByID removes block (room restriction) from the DB by ID
-func (m *testDBRepo) DeleteBlockByID(restrictionID int) error {
+func (m *testDBRepo) DeleteBlockByID(ctx context.Context, restrictionID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if restrictionID == 100 {
+		return errors.New("error deleting block")
+	}
 	return nil
 }
+
+// QueueMail adds the messages to the mail outbox for delivery
+func (m *testDBRepo) QueueMail(ctx context.Context, mails ...models.MailData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ClaimMail picks up to limit queued messages that are due for delivery
+func (m *testDBRepo) ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// MarkMailSent records the successful delivery of the message
+func (m *testDBRepo) MarkMailSent(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RescheduleMail records the failed delivery attempt and schedules the next one
+func (m *testDBRepo) RescheduleMail(ctx context.Context, id int, lastError string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// MarkMailFailed records the failed delivery attempt and gives up on the message
+func (m *testDBRepo) MarkMailFailed(ctx context.Context, id int, lastError string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// PendingMail returns queued and failed messages of the mail outbox
+func (m *testDBRepo) PendingMail(ctx context.Context) ([]models.OutboxMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if *m.FetchError {
+		return nil, errors.New("error fetching mail")
+	}
+	now := time.Now()
+	return []models.OutboxMessage{
+		{ID: 2, MailData: models.MailData{To: "john@smith.com", Subject: "Room reservation confirmation"},
+			Status: models.MailQueued, Attempts: 1, NextAttemptAt: now.Add(time.Minute), LastError: "connection refused",
+			CreatedAt: now, UpdatedAt: now},
+		{ID: 1, MailData: models.MailData{To: "jane@smith.com", Subject: "Room reservation has been cancelled"},
+			Status: models.MailFailed, Attempts: 5, NextAttemptAt: now, LastError: "mailbox unavailable",
+			CreatedAt: now, UpdatedAt: now},
+	}, nil
+}
+
+// ResendMail queues the unsent message for immediate delivery. Message 100 fails and message 200 is not found
+func (m *testDBRepo) ResendMail(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	switch id {
+	case 100:
+		return errors.New("error resending mail")
+	case 200:
+		return repository.ErrMailNotFound
+	}
+	return nil
+}
+
+// AuditLog returns two entries made by the owner, newest first, that match the filter
+func (m *testDBRepo) AuditLog(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if *m.FetchError {
+		return nil, errors.New("error fetching audit log")
+	}
+	owner, _ := m.GetUserById(ctx, 1)
+	actor := models.Actor{UserID: owner.ID, IP: "127.0.0.1", UserAgent: "test", Method: http.MethodPost}
+	now := time.Now()
+	var entries []models.AuditEntry
+	for _, e := range []models.AuditEntry{
+		{ID: 2, Action: models.AuditChangeStatus, Entity: models.AuditReservation, EntityID: 1,
+			Before: `{"status":"pending"}`, After: `{"status":"confirmed"}`, CreatedAt: now},
+		{ID: 1, Action: models.AuditDelete, Entity: models.AuditRoom, EntityID: 3,
+			Before: `{"room_name":"Test Room"}`, CreatedAt: now.Add(-time.Hour)},
+	} {
+		e.Actor, e.User = actor, owner
+		e.Actor.Path = "/admin/" + e.Entity
+		if filter.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
@@ -1,29 +1,120 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/AlexL70/BuildingModernWebApplicationsWithGo_Trevor/bookings/internal/models"
 )
 
+// ErrRoomNotAvailable is returned when the room has been booked or blocked
+// for (some of) the requested dates in the meantime
+var ErrRoomNotAvailable = errors.New("room is no longer available for the requested dates")
+
+// ErrInvalidStatusTransition is returned when a reservation cannot be moved
+// from its current status to the requested one
+var ErrInvalidStatusTransition = errors.New("reservation status change is not allowed")
+
+// ErrReservationNotFound is returned when there is no reservation matching
+// the confirmation code and email given by a guest
+var ErrReservationNotFound = errors.New("reservation not found")
+
+// ErrRoomNotFound is returned when there is no room with the requested slug
+var ErrRoomNotFound = errors.New("room not found")
+
+// ErrDuplicateRoomSlug is returned when another room already has the same slug
+var ErrDuplicateRoomSlug = errors.New("room with this slug already exists")
+
+// ErrRoomInUse is returned when a room that has reservations is being deleted
+var ErrRoomInUse = errors.New("room has reservations")
+
+// ErrUserNotFound is returned when there is no user with the requested id
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrDuplicateEmail is returned when another user already has the same email
+var ErrDuplicateEmail = errors.New("user with this email already exists")
+
+// ErrInvalidCredentials is returned by Authenticate when there is no user with the email or the password is wrong
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+// ErrUserInactive is returned by Authenticate when the password is right, but the user has been deactivated
+var ErrUserInactive = errors.New("user is deactivated")
+
+// ErrPasswordResetRequired is returned by Authenticate when the password is right, but it has to be reset
+var ErrPasswordResetRequired = errors.New("password has to be reset")
+
+// ErrAccountLocked is returned by Authenticate, whatever the password, while the user is locked out
+// because of too many failed logins
+var ErrAccountLocked = errors.New("account is locked")
+
+// ErrCodeAlreadyUsed is returned when the one-time password has already been used to log in
+var ErrCodeAlreadyUsed = errors.New("one-time password has already been used")
+
+// ErrInvalidRecoveryCode is returned when the user has no such unused recovery code
+var ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+
+// ErrMailNotFound is returned when there is no unsent message with the requested id in the mail outbox
+var ErrMailNotFound = errors.New("mail message not found")
+
+// DatabaseRepo is the storage used by the handlers. Every method takes the request's context,
+// so a query is cancelled as soon as the client goes away. Changes made with the context of a back
+// office request (see WithActor) are written to the audit log together with the change
 type DatabaseRepo interface {
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesAndRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
-
-	GetUserById(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
-	AllReservations() ([]models.Reservation, error)
-	NewReservations() ([]models.Reservation, error)
-	GetReservationByID(id int) (models.Reservation, error)
-	UpdateReservation(u models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDates(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(roomID int, startDate time.Time) error
-	DeleteBlockByID(restrictionID int) error
+	CreateReservation(ctx context.Context, res models.Reservation, mails []models.MailData) (int, error)
+	SearchAvailabilityByDatesAndRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+
+	GetUserById(ctx context.Context, id int) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	AllUsers(ctx context.Context) ([]models.User, error)
+	InsertUser(ctx context.Context, u models.User, password string) (int, error)
+	UpdateUser(ctx context.Context, u models.User) error
+	DeleteUser(ctx context.Context, id int) error
+	ForcePasswordReset(ctx context.Context, id int) error
+	UpdatePassword(ctx context.Context, id int, password string) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
+	RecordFailedLogin(ctx context.Context, a models.LoginAttempt, policy models.LoginPolicy) error
+	LoginFailuresFromIP(ctx context.Context, ip string, since time.Time) (int, time.Time, error)
+	UnlockUser(ctx context.Context, id int) error
+	EnableTwoFactor(ctx context.Context, id int, secret string, recoveryCodeHashes []string) error
+	DisableTwoFactor(ctx context.Context, id int) error
+	UseTOTPStep(ctx context.Context, id int, step int64) error
+	UseRecoveryCode(ctx context.Context, id int, codeHash string) error
+	RecoveryCodesLeft(ctx context.Context, id int) (int, error)
+	SearchReservations(ctx context.Context, filter models.ReservationFilter) (models.ReservationPage, error)
+	ExportReservations(ctx context.Context, filter models.ReservationFilter, fn func(models.Reservation) error) error
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	GetReservationByConfirmationCode(ctx context.Context, code, email string) (models.Reservation, error)
+	GetReservationByReference(ctx context.Context, reference string) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, u models.Reservation) error
+	DeleteReservation(ctx context.Context, id int) error
+	UpdateReservationStatus(ctx context.Context, id int, status models.ReservationStatus, userID int, mails ...models.MailData) error
+	GetReservationStatusHistory(ctx context.Context, id int) ([]models.ReservationStatusChange, error)
+	RecentStatusChanges(ctx context.Context, limit int) ([]models.ReservationStatusChange, error)
+	DashboardCounts(ctx context.Context, day time.Time) (models.DashboardCounts, error)
+	Occupancy(ctx context.Context, start, end time.Time) (models.Occupancy, error)
+	Report(ctx context.Context, from, to time.Time, grouping models.ReportGrouping) (models.Report, error)
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	InsertRoom(ctx context.Context, r models.Room) (int, error)
+	UpdateRoom(ctx context.Context, r models.Room) error
+	DeleteRoom(ctx context.Context, id int) error
+	GetRestrictionsForRoomByDates(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	BlockRestrictions(ctx context.Context) ([]models.Restriction, error)
+	InsertBlocks(ctx context.Context, blocks []models.RoomRestriction) error
+	RemoveBlocks(ctx context.Context, roomIDs []int, start, end time.Time) error
+	DeleteBlockByID(ctx context.Context, restrictionID int) error
+	ImportBookings(ctx context.Context, reservations []models.Reservation, blocks []models.RoomRestriction, userID int) error
+
+	QueueMail(ctx context.Context, mails ...models.MailData) error
+	ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	MarkMailSent(ctx context.Context, id int) error
+	RescheduleMail(ctx context.Context, id int, lastError string, at time.Time) error
+	MarkMailFailed(ctx context.Context, id int, lastError string) error
+	PendingMail(ctx context.Context) ([]models.OutboxMessage, error)
+	ResendMail(ctx context.Context, id int) error
+
+	AuditLog(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error)
 }
@@ -0,0 +1,119 @@
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+var _ scs.CtxStore = (*PostgresStore)(nil)
+var _ scs.IterableCtxStore = (*PostgresStore)(nil)
+
+// PostgresStore keeps scs sessions in the sessions table, so they survive restarts and are shared
+// by all the instances of the application
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+// NewPostgres creates the session store on top of the database pool
+func NewPostgres(db *sql.DB) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+// FindCtx returns the data of the session. Expired sessions are not found
+func (p *PostgresStore) FindCtx(ctx context.Context, token string) ([]byte, bool, error) {
+	var b []byte
+	err := p.DB.QueryRowContext(ctx,
+		"select data from sessions where token = $1 and current_timestamp < expiry", token).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// CommitCtx inserts the session or overwrites its data and expiry
+func (p *PostgresStore) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	query := `
+		insert into sessions (token, data, expiry) values ($1, $2, $3)
+		on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`
+	_, err := p.DB.ExecContext(ctx, query, token, b, expiry)
+	return err
+}
+
+// DeleteCtx removes the session
+func (p *PostgresStore) DeleteCtx(ctx context.Context, token string) error {
+	_, err := p.DB.ExecContext(ctx, "delete from sessions where token = $1", token)
+	return err
+}
+
+// AllCtx returns the data of all the active sessions
+func (p *PostgresStore) AllCtx(ctx context.Context) (map[string][]byte, error) {
+	rows, err := p.DB.QueryContext(ctx, "select token, data from sessions where current_timestamp < expiry")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := map[string][]byte{}
+	for rows.Next() {
+		var token string
+		var b []byte
+		if err := rows.Scan(&token, &b); err != nil {
+			return nil, err
+		}
+		sessions[token] = b
+	}
+	return sessions, rows.Err()
+}
+
+// Find is FindCtx with the background context
+func (p *PostgresStore) Find(token string) ([]byte, bool, error) {
+	return p.FindCtx(context.Background(), token)
+}
+
+// Commit is CommitCtx with the background context
+func (p *PostgresStore) Commit(token string, b []byte, expiry time.Time) error {
+	return p.CommitCtx(context.Background(), token, b, expiry)
+}
+
+// Delete is DeleteCtx with the background context
+func (p *PostgresStore) Delete(token string) error {
+	return p.DeleteCtx(context.Background(), token)
+}
+
+// All is AllCtx with the background context
+func (p *PostgresStore) All() (map[string][]byte, error) {
+	return p.AllCtx(context.Background())
+}
+
+// DeleteExpired removes the expired sessions and returns how many there were
+func (p *PostgresStore) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := p.DB.ExecContext(ctx, "delete from sessions where expiry < current_timestamp")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Cleanup deletes the expired sessions every interval until ctx is done
+func (p *PostgresStore) Cleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := p.DeleteExpired(ctx); err != nil && ctx.Err() == nil {
+				log.Println("error deleting expired sessions:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Package twofactor implements the second login step: the time-based one-time passwords of RFC 6238
+// shown by authenticator apps and the one-time recovery codes used when the phone is lost
+package twofactor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the length of a one-time password
+	Digits = 6
+	// Period is how long a one-time password is valid
+	Period = 30 * time.Second
+	// RecoveryCodes is the number of recovery codes given to the user on enrollment
+	RecoveryCodes = 10
+)
+
+// recoveryCodeAlphabet is Crockford's base32 alphabet, as in reservation confirmation codes
+const recoveryCodeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random 160 bit secret in base32, the way authenticator apps expect it
+func NewSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return secretEncoding.EncodeToString(secret), nil
+}
+
+// URL returns the otpauth URL put into the QR code scanned by authenticator apps
+func URL(secret, issuer, account string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period.Seconds())))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + v.Encode()
+}
+
+// Step returns the number of the time step at t
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the one-time password of the time step
+func Code(secret string, step int64) (string, error) {
+	key, err := secretEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid secret: %w", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000), nil
+}
+
+// Verify returns the time step of the code if it is the one of now or of the step before or after it,
+// which allows for the clock of the phone being a little off
+func Verify(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+	current := Step(now)
+	for _, step := range []int64{current - 1, current, current + 1} {
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// NewRecoveryCodes returns RecoveryCodes random codes formatted as XXXXX-XXXXX
+func NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodes)
+	max := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	for i := range codes {
+		var b strings.Builder
+		for j := 0; j < 10; j++ {
+			if j == 5 {
+				b.WriteByte('-')
+			}
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteByte(recoveryCodeAlphabet[n.Int64()])
+		}
+		codes[i] = b.String()
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the hash of the recovery code kept in the database. The codes are random
+// and long enough for a plain SHA-256 hash. Case, spaces and hyphens do not matter
+func HashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// LooksLikeRecoveryCode tells recovery codes from one-time passwords typed into the same field
+func LooksLikeRecoveryCode(code string) bool {
+	return len(strings.NewReplacer("-", "", " ", "").Replace(code)) == 10
+}
@@ -0,0 +1,86 @@
+package twofactor
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// the RFC 6238 test vectors, truncated to 6 digits
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, e := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(e.unix, 0)))
+		if err != nil || code != e.expected {
+			t.Errorf("%d: expected %s but got %s, %v", e.unix, e.expected, code, err)
+		}
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("invalid secret is accepted")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	for _, offset := range []time.Duration{-Period, 0, Period} {
+		code, _ := Code(secret, Step(now.Add(offset)))
+		if step, ok := Verify(secret, code[:3]+" "+code[3:], now); !ok || step != Step(now.Add(offset)) {
+			t.Errorf("code of %s is not accepted", offset)
+		}
+	}
+	code, _ := Code(secret, Step(now.Add(2*Period)))
+	if _, ok := Verify(secret, code, now); ok {
+		t.Error("code from the future is accepted")
+	}
+	if _, ok := Verify(secret, "12345", now); ok {
+		t.Error("short code is accepted")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || !LooksLikeRecoveryCode(code) {
+			t.Errorf("unexpected recovery code %q", code)
+		}
+		seen[HashRecoveryCode(code)] = true
+	}
+	if len(seen) != RecoveryCodes {
+		t.Errorf("expected %d different codes, but got %d", RecoveryCodes, len(seen))
+	}
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(strings.ToLower(strings.ReplaceAll(codes[0], "-", " "))) {
+		t.Error("recovery code hash depends on case and separators")
+	}
+	if LooksLikeRecoveryCode("123456") {
+		t.Error("one-time password looks like a recovery code")
+	}
+}
+
+func TestURL(t *testing.T) {
+	u := URL("ABC", "Bookings", "me@here.ca")
+	if !strings.HasPrefix(u, "otpauth://totp/Bookings:me@here.ca?") || !strings.Contains(u, "secret=ABC") {
+		t.Errorf("unexpected URL %q", u)
+	}
+}
@@ -0,0 +1,81 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "Guests & rooms")
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %q", err)
+	}
+	_ = w.WriteHeader("Name", "Nights", "Arrival")
+	_ = w.WriteRow("<Smith> & Sons", 3, time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), nil, 1.5)
+	if err = w.Close(); err != nil {
+		t.Fatalf("unexpected error closing writer: %q", err)
+	}
+	if err = w.WriteRow("late"); err != ErrClosed {
+		t.Errorf("expected %v writing to a closed writer but got %v", ErrClosed, err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %q", err)
+	}
+	parts := map[string]string{}
+	for _, f := range zr.File {
+		r, _ := f.Open()
+		b, _ := io.ReadAll(r)
+		parts[f.Name] = string(b)
+		// every part must be well-formed XML
+		d := xml.NewDecoder(bytes.NewReader(b))
+		for {
+			if _, err := d.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: invalid XML: %q", f.Name, err)
+			}
+		}
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels",
+		"xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("missing part %s", name)
+		}
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `name="Guests &amp; rooms"`) {
+		t.Errorf("sheet name is not escaped: %s", parts["xl/workbook.xml"])
+	}
+
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, expected := range []string{
+		`<c r="A1" t="inlineStr" s="2"><is><t xml:space="preserve">Name</t></is></c>`,
+		`<c r="A2" t="inlineStr"><is><t xml:space="preserve">&lt;Smith&gt; &amp; Sons</t></is></c>`,
+		`<c r="B2"><v>3</v></c>`,
+		// 2026-01-02 is day 46024 of spreadsheet dates
+		`<c r="C2" s="1"><v>46024</v></c>`,
+		`<c r="E2"><v>1.5</v></c>`,
+	} {
+		if !strings.Contains(sheet, expected) {
+			t.Errorf("expected %s in the sheet %s", expected, sheet)
+		}
+	}
+	if strings.Contains(sheet, `r="D2"`) {
+		t.Error("nil has been written as a cell")
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for index, expected := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if actual := columnName(index); actual != expected {
+			t.Errorf("column %d: expected %s but got %s", index, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,2 @@
+UPDATE public.rooms SET slug = 'room-' || id, description = '', capacity = 2, amenities = '', base_price = 0, photos = ''
+WHERE id IN (1, 2);
@@ -0,0 +1,16 @@
+UPDATE public.rooms SET
+	slug = 'generals-quoters',
+	description = 'You home away from home set to majestic water of Atlantic Ocean. That will be vacation to remember for a long time.',
+	capacity = 2,
+	amenities = E'Ocean view\nQueen bed\nFree Wi-Fi\nBreakfast included',
+	base_price = 12900,
+	photos = 'generals-quarters.png'
+WHERE id = 1;
+UPDATE public.rooms SET
+	slug = 'majors-suite',
+	description = 'You home away from home set to majestic water of Atlantic Ocean. That will be vacation to remember for a long time.',
+	capacity = 4,
+	amenities = E'Ocean view\nKing bed\nSofa bed\nFree Wi-Fi\nBreakfast included',
+	base_price = 18900,
+	photos = 'marjors-suite.png'
+WHERE id = 2;
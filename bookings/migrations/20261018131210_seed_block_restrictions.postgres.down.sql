@@ -0,0 +1 @@
+delete from public.restrictions where id in (3, 4);
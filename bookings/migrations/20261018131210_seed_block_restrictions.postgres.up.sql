@@ -0,0 +1,6 @@
+INSERT INTO public.restrictions
+    (id, restriction_name,created_at,updated_at)
+VALUES
+    (3, 'Maintenance', '2026-10-18 00:00:00.000', '2026-10-18 00:00:00.000'),
+    (4, 'Other', '2026-10-18 00:00:00.000', '2026-10-18 00:00:00.000');
+SELECT setval(pg_get_serial_sequence('public.restrictions', 'id'), (SELECT max(id) FROM public.restrictions));
@@ -0,0 +1,6 @@
+CREATE TABLE public.sessions (
+    token TEXT PRIMARY KEY,
+    data BYTEA NOT NULL,
+    expiry TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX sessions_expiry_idx ON public.sessions (expiry);
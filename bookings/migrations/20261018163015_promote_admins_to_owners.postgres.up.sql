@@ -0,0 +1,2 @@
+-- access level 3 used to be the administrator with full access, which is the owner role now
+UPDATE public.users SET access_level = 4 WHERE access_level = 3;